@@ -2,8 +2,9 @@ module github.com/alexisbouchez/phpgo
 
 go 1.25.0
 
+require github.com/go-sql-driver/mysql v1.9.3
+
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
-	github.com/go-sql-driver/mysql v1.9.3 // indirect
 	golang.org/x/image v0.34.0 // indirect
 )
@@ -1,7 +1,9 @@
 package interpreter
 
 import (
+	"bufio"
 	"bytes"
+	"compress/bzip2"
 	"compress/flate"
 	"compress/gzip"
 	"compress/zlib"
@@ -10,11 +12,13 @@ import (
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
 	"math"
@@ -31,6 +35,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	goruntime "runtime"
@@ -38,6 +43,9 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/alexisbouchez/phpgo/ast"
 	"github.com/alexisbouchez/phpgo/runtime"
@@ -53,10 +61,17 @@ func (i *Interpreter) registerBuiltins() {
 	i.registerSPLIterators()
 	// Register SPL data structure classes
 	i.registerSPLDataStructures()
+	// Register SPL file classes
+	i.registerSplFileClasses()
+	// Register XML classes
+	i.registerXMLReader()
+	i.registerDOMClasses()
 	// Register predefined constants
 	i.registerPredefinedConstants()
 	// Register database constants
 	i.registerDatabaseConstants()
+	// Register ZipArchive
+	i.registerZipArchive()
 }
 
 func (i *Interpreter) registerPredefinedConstants() {
@@ -178,6 +193,18 @@ func (i *Interpreter) registerPredefinedConstants() {
 	i.env.DefineConstant("STR_PAD_RIGHT", runtime.NewInt(1))
 	i.env.DefineConstant("STR_PAD_BOTH", runtime.NewInt(2))
 
+	// HTML entity constants
+	i.env.DefineConstant("ENT_COMPAT", runtime.NewInt(entCompat))
+	i.env.DefineConstant("ENT_QUOTES", runtime.NewInt(entQuotes))
+	i.env.DefineConstant("ENT_NOQUOTES", runtime.NewInt(entNoQuotes))
+	i.env.DefineConstant("ENT_IGNORE", runtime.NewInt(entIgnore))
+	i.env.DefineConstant("ENT_SUBSTITUTE", runtime.NewInt(entSubstitute))
+	i.env.DefineConstant("ENT_DISALLOWED", runtime.NewInt(entDisallowed))
+	i.env.DefineConstant("ENT_HTML401", runtime.NewInt(entHTML401))
+	i.env.DefineConstant("ENT_XML1", runtime.NewInt(entXML1))
+	i.env.DefineConstant("ENT_XHTML", runtime.NewInt(entXHTML))
+	i.env.DefineConstant("ENT_HTML5", runtime.NewInt(entHTML5))
+
 	// JSON constants
 	i.env.DefineConstant("JSON_ERROR_NONE", runtime.NewInt(0))
 	i.env.DefineConstant("JSON_ERROR_DEPTH", runtime.NewInt(1))
@@ -185,6 +212,8 @@ func (i *Interpreter) registerPredefinedConstants() {
 	i.env.DefineConstant("JSON_ERROR_CTRL_CHAR", runtime.NewInt(3))
 	i.env.DefineConstant("JSON_ERROR_SYNTAX", runtime.NewInt(4))
 	i.env.DefineConstant("JSON_ERROR_UTF8", runtime.NewInt(5))
+	i.env.DefineConstant("JSON_ERROR_RECURSION", runtime.NewInt(6))
+	i.env.DefineConstant("JSON_ERROR_INF_OR_NAN", runtime.NewInt(7))
 	i.env.DefineConstant("JSON_HEX_TAG", runtime.NewInt(1))
 	i.env.DefineConstant("JSON_HEX_AMP", runtime.NewInt(2))
 	i.env.DefineConstant("JSON_HEX_APOS", runtime.NewInt(4))
@@ -196,6 +225,14 @@ func (i *Interpreter) registerPredefinedConstants() {
 	i.env.DefineConstant("JSON_UNESCAPED_UNICODE", runtime.NewInt(256))
 	i.env.DefineConstant("JSON_THROW_ON_ERROR", runtime.NewInt(4194304))
 
+	// OpenSSL signature algorithms
+	i.env.DefineConstant("OPENSSL_ALGO_SHA1", runtime.NewInt(opensslAlgoSHA1))
+	i.env.DefineConstant("OPENSSL_ALGO_MD5", runtime.NewInt(opensslAlgoMD5))
+	i.env.DefineConstant("OPENSSL_ALGO_SHA224", runtime.NewInt(opensslAlgoSHA224))
+	i.env.DefineConstant("OPENSSL_ALGO_SHA256", runtime.NewInt(opensslAlgoSHA256))
+	i.env.DefineConstant("OPENSSL_ALGO_SHA384", runtime.NewInt(opensslAlgoSHA384))
+	i.env.DefineConstant("OPENSSL_ALGO_SHA512", runtime.NewInt(opensslAlgoSHA512))
+
 	// File constants
 	i.env.DefineConstant("FILE_USE_INCLUDE_PATH", runtime.NewInt(1))
 	i.env.DefineConstant("FILE_IGNORE_NEW_LINES", runtime.NewInt(2))
@@ -221,6 +258,10 @@ func (i *Interpreter) registerPredefinedConstants() {
 	i.env.DefineConstant("GLOB_NOESCAPE", runtime.NewInt(8))
 	i.env.DefineConstant("GLOB_BRACE", runtime.NewInt(16))
 	i.env.DefineConstant("GLOB_ONLYDIR", runtime.NewInt(32))
+	i.env.DefineConstant("FNM_PATHNAME", runtime.NewInt(fnmPathname))
+	i.env.DefineConstant("FNM_NOESCAPE", runtime.NewInt(fnmNoescape))
+	i.env.DefineConstant("FNM_PERIOD", runtime.NewInt(fnmPeriod))
+	i.env.DefineConstant("FNM_CASEFOLD", runtime.NewInt(fnmCasefold))
 
 	// Pathinfo constants
 	i.env.DefineConstant("PATHINFO_DIRNAME", runtime.NewInt(1))
@@ -236,6 +277,7 @@ func (i *Interpreter) registerPredefinedConstants() {
 	i.env.DefineConstant("PREG_SPLIT_NO_EMPTY", runtime.NewInt(1))
 	i.env.DefineConstant("PREG_SPLIT_DELIM_CAPTURE", runtime.NewInt(2))
 	i.env.DefineConstant("PREG_SPLIT_OFFSET_CAPTURE", runtime.NewInt(4))
+	i.env.DefineConstant("PREG_GREP_INVERT", runtime.NewInt(1))
 
 	// Math constants
 	i.env.DefineConstant("M_PI", runtime.NewFloat(3.14159265358979323846))
@@ -445,6 +487,59 @@ func (i *Interpreter) registerSPLExceptions() {
 	}
 	errorException.Properties["severity"] = &runtime.PropertyDef{Name: "severity", Default: runtime.NewInt(1)}
 	i.env.DefineClass("ErrorException", errorException)
+
+	// Engine errors, thrown by builtins for invalid arguments
+	errorClass := &runtime.Class{
+		Name:        "Error",
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	for name, prop := range exception.Properties {
+		errorClass.Properties[name] = prop
+	}
+	i.env.DefineClass("Error", errorClass)
+
+	valueError := &runtime.Class{
+		Name:        "ValueError",
+		Parent:      errorClass,
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	i.env.DefineClass("ValueError", valueError)
+
+	typeError := &runtime.Class{
+		Name:        "TypeError",
+		Parent:      errorClass,
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	i.env.DefineClass("TypeError", typeError)
+
+	argumentCountError := &runtime.Class{
+		Name:        "ArgumentCountError",
+		Parent:      typeError,
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	i.env.DefineClass("ArgumentCountError", argumentCountError)
+
+	jsonException := &runtime.Class{
+		Name:        "JsonException",
+		Parent:      exception,
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	i.env.DefineClass("JsonException", jsonException)
 }
 
 func (i *Interpreter) registerArrayAccessInterface() {
@@ -487,7 +582,8 @@ func (i *Interpreter) registerIteratorInterfaces() {
 
 	// Iterator interface extends Traversable
 	iterator := &runtime.Interface{
-		Name: "Iterator",
+		Name:    "Iterator",
+		Extends: []*runtime.Interface{traversable},
 		Methods: map[string]*runtime.Method{
 			"current": {
 				Name:     "current",
@@ -620,7 +716,8 @@ func (i *Interpreter) registerIteratorInterfaces() {
 
 	// IteratorAggregate interface (extends Traversable)
 	iteratorAggregate := &runtime.Interface{
-		Name: "IteratorAggregate",
+		Name:    "IteratorAggregate",
+		Extends: []*runtime.Interface{traversable},
 		Methods: map[string]*runtime.Method{
 			"getIterator": {Name: "getIterator", Params: []string{}, IsPublic: true},
 		},
@@ -929,7 +1026,7 @@ func (i *Interpreter) registerSPLIterators() {
 	filesystemIterator.Constants["CURRENT_MODE_MASK"] = runtime.NewInt(240)
 	filesystemIterator.Constants["KEY_AS_PATHNAME"] = runtime.NewInt(0)
 	filesystemIterator.Constants["KEY_AS_FILENAME"] = runtime.NewInt(256)
-	filesystemIterator.Constants["FOLLOW_SYMLINKS"] = runtime.NewInt(512)
+	filesystemIterator.Constants["FOLLOW_SYMLINKS"] = runtime.NewInt(16384)
 	filesystemIterator.Constants["KEY_MODE_MASK"] = runtime.NewInt(3840)
 	filesystemIterator.Constants["NEW_CURRENT_AND_KEY"] = runtime.NewInt(256)
 	filesystemIterator.Constants["SKIP_DOTS"] = runtime.NewInt(4096)
@@ -1032,6 +1129,12 @@ func (i *Interpreter) registerSPLDataStructures() {
 		Methods:     make(map[string]*runtime.Method),
 		Constants:   make(map[string]runtime.Value),
 	}
+	// compare has no body: the native heap implements it
+	splMaxHeap.Methods["compare"] = &runtime.Method{
+		Name:        "compare",
+		Params:      []string{"value1", "value2"},
+		IsProtected: true,
+	}
 	i.env.DefineClass("SplMaxHeap", splMaxHeap)
 
 	// SplMinHeap - min heap (smallest element first)
@@ -1043,6 +1146,11 @@ func (i *Interpreter) registerSPLDataStructures() {
 		Methods:     make(map[string]*runtime.Method),
 		Constants:   make(map[string]runtime.Value),
 	}
+	splMinHeap.Methods["compare"] = &runtime.Method{
+		Name:        "compare",
+		Params:      []string{"value1", "value2"},
+		IsProtected: true,
+	}
 	i.env.DefineClass("SplMinHeap", splMinHeap)
 
 	// SplPriorityQueue - priority queue
@@ -1080,6 +1188,31 @@ func (i *Interpreter) registerSPLDataStructures() {
 		Constants:   make(map[string]runtime.Value),
 	}
 	i.env.DefineClass("SplObjectStorage", splObjectStorage)
+
+	// ArrayObject - object wrapper around an array
+	iteratorAggregate, _ := i.env.GetInterface("IteratorAggregate")
+	arrayObject := &runtime.Class{
+		Name:        "ArrayObject",
+		Interfaces:  []*runtime.Interface{iteratorAggregate, arrayAccess, countable, serializable},
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	arrayObject.Constants["STD_PROP_LIST"] = runtime.NewInt(1)
+	arrayObject.Constants["ARRAY_AS_PROPS"] = runtime.NewInt(2)
+	i.env.DefineClass("ArrayObject", arrayObject)
+}
+
+// builtinOutParams maps builtins with a scalar by-reference output
+// parameter to that parameter's index. The builtin receives it as a
+// *runtime.Reference and sets the value to assign back.
+var builtinOutParams = map[string]int{
+	"str_replace":                 3,
+	"str_ireplace":                3,
+	"openssl_sign":                1,
+	"preg_replace_callback":       4,
+	"preg_replace_callback_array": 3,
 }
 
 func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
@@ -1112,11 +1245,11 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 	case "rtrim":
 		return builtinRtrim
 	case "explode":
-		return builtinExplode
+		return i.builtinExplode
 	case "implode", "join":
 		return builtinImplode
 	case "sprintf":
-		return builtinSprintf
+		return i.builtinSprintf
 	case "printf":
 		return i.builtinPrintf
 	case "fprintf":
@@ -1124,7 +1257,7 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 	case "vprintf":
 		return i.builtinVprintf
 	case "vsprintf":
-		return builtinVsprintf
+		return i.builtinVsprintf
 	case "flush":
 		return i.builtinFlush
 	case "str_repeat":
@@ -1166,7 +1299,7 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 
 	// Array functions
 	case "count", "sizeof":
-		return builtinCount
+		return i.builtinCount
 	case "array_push":
 		return builtinArrayPush
 	case "array_pop":
@@ -1177,6 +1310,8 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinArrayUnshift
 	case "array_merge":
 		return builtinArrayMerge
+	case "array_merge_recursive":
+		return builtinArrayMergeRecursive
 	case "array_keys":
 		return builtinArrayKeys
 	case "array_values":
@@ -1223,12 +1358,18 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinEnd
 	case "key":
 		return builtinKey
+	case "each":
+		return builtinEach
 	case "range":
 		return builtinRange
 	case "sort":
 		return builtinSort
 	case "rsort":
 		return builtinRsort
+	case "strnatcmp":
+		return builtinStrnatcmp
+	case "strnatcasecmp":
+		return builtinStrnatcasecmp
 	case "natsort":
 		return builtinNatsort
 	case "natcasesort":
@@ -1264,9 +1405,15 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 	case "is_infinite":
 		return builtinIsInfinite
 	case "rand":
-		return builtinRand
+		return i.builtinRand
 	case "mt_rand":
-		return builtinMtRand
+		return i.builtinMtRand
+	case "srand":
+		return i.builtinSrand
+	case "mt_srand":
+		return i.builtinMtSrand
+	case "getrandmax", "mt_getrandmax":
+		return builtinGetrandmax
 	case "lcg_value":
 		return builtinLcgValue
 
@@ -1293,6 +1440,10 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinIsNumeric
 	case "is_callable":
 		return i.builtinIsCallable
+	case "is_iterable":
+		return i.builtinIsIterable
+	case "is_countable":
+		return i.builtinIsCountable
 	case "filter_var":
 		return builtinFilterVar
 	case "filter_input":
@@ -1371,6 +1522,8 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return i.builtinIniGet
 	case "ini_set":
 		return i.builtinIniSet
+	case "set_time_limit":
+		return i.builtinSetTimeLimit
 	case "version_compare":
 		return builtinVersionCompare
 	case "phpversion":
@@ -1378,9 +1531,9 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 	case "extension_loaded":
 		return builtinExtensionLoaded
 	case "memory_get_usage":
-		return builtinMemoryGetUsage
+		return i.builtinMemoryGetUsage
 	case "memory_get_peak_usage":
-		return builtinMemoryGetPeakUsage
+		return i.builtinMemoryGetPeakUsage
 	case "getmypid":
 		return builtinGetmypid
 	case "getmyuid":
@@ -1401,6 +1554,12 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return i.builtinClassAlias
 	case "spl_autoload_register":
 		return i.builtinSplAutoloadRegister
+	case "spl_autoload_unregister":
+		return i.builtinSplAutoloadUnregister
+	case "spl_autoload_functions":
+		return i.builtinSplAutoloadFunctions
+	case "spl_autoload":
+		return i.builtinSplAutoload
 	case "call_user_func":
 		return i.builtinCallUserFunc
 	case "call_user_func_array":
@@ -1417,14 +1576,24 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinPregMatchAll
 	case "preg_replace":
 		return builtinPregReplace
+	case "preg_replace_callback":
+		return i.builtinPregReplaceCallback
+	case "preg_replace_callback_array":
+		return i.builtinPregReplaceCallbackArray
 	case "preg_split":
 		return builtinPregSplit
+	case "preg_grep":
+		return builtinPregGrep
 
 	// JSON functions
 	case "json_encode":
-		return builtinJsonEncode
+		return i.builtinJsonEncode
 	case "json_decode":
-		return builtinJsonDecode
+		return i.builtinJsonDecode
+	case "json_last_error":
+		return i.builtinJsonLastError
+	case "json_last_error_msg":
+		return i.builtinJsonLastErrorMsg
 	case "serialize":
 		return i.builtinSerialize
 	case "unserialize":
@@ -1439,6 +1608,14 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinFileExists
 	case "is_file":
 		return builtinIsFile
+	case "stat":
+		return i.builtinStat
+	case "lstat":
+		return i.builtinLstat
+	case "fstat":
+		return builtinFstat
+	case "filesize", "filemtime", "fileatime", "filectime", "fileperms", "fileowner", "filegroup", "fileinode":
+		return i.fileStatFunc(strings.ToLower(name))
 	case "is_dir":
 		return builtinIsDir
 	case "is_readable":
@@ -1457,6 +1634,8 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinRealpath
 	case "glob":
 		return builtinGlob
+	case "fnmatch":
+		return builtinFnmatch
 	case "getenv":
 		return builtinGetenv
 	case "putenv":
@@ -1543,6 +1722,8 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinCrc32
 	case "hash_hmac":
 		return builtinHashHmac
+	case "hash_file":
+		return builtinHashFile
 	case "hash_equals":
 		return builtinHashEquals
 	case "password_hash":
@@ -1551,6 +1732,14 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinPasswordVerify
 	case "openssl_random_pseudo_bytes":
 		return builtinOpensslRandomPseudoBytes
+	case "openssl_sign":
+		return builtinOpensslSign
+	case "openssl_verify":
+		return builtinOpensslVerify
+	case "openssl_pkey_get_private":
+		return i.builtinOpensslPkeyGetPrivate
+	case "openssl_pkey_get_public":
+		return i.builtinOpensslPkeyGetPublic
 	case "random_bytes":
 		return builtinRandomBytes
 	case "base64_encode":
@@ -1583,6 +1772,36 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinGzdeflate
 	case "gzinflate":
 		return builtinGzinflate
+	case "gzopen":
+		return i.builtinGzopen
+	case "gzread":
+		return builtinGzread
+	case "gzwrite", "gzputs":
+		return builtinGzwrite
+	case "gzgets":
+		return builtinGzgets
+	case "gzeof":
+		return builtinGzeof
+	case "gzrewind":
+		return builtinGzrewind
+	case "gzclose":
+		return builtinGzclose
+	case "gzfile":
+		return builtinGzfile
+	case "readgzfile":
+		return i.builtinReadgzfile
+	case "bzdecompress":
+		return builtinBzdecompress
+	case "bzcompress":
+		return i.builtinBzcompress
+	case "bzopen":
+		return i.builtinBzopen
+	case "bzread":
+		return builtinBzread
+	case "bzwrite":
+		return i.builtinBzwrite
+	case "bzclose":
+		return builtinBzclose
 
 	// Ctype functions
 	case "ctype_alnum":
@@ -1654,7 +1873,7 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 
 	// Additional array functions
 	case "array_combine":
-		return builtinArrayCombine
+		return i.builtinArrayCombine
 	case "array_fill":
 		return builtinArrayFill
 	case "array_fill_keys":
@@ -1677,6 +1896,10 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinArrayIntersect
 	case "array_intersect_key":
 		return builtinArrayIntersectKey
+	case "array_diff_ukey":
+		return i.builtinArrayDiffUkey
+	case "array_intersect_ukey":
+		return i.builtinArrayIntersectUkey
 	case "array_intersect_assoc":
 		return builtinArrayIntersectAssoc
 	case "usort":
@@ -1690,7 +1913,7 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 	case "array_walk_recursive":
 		return i.builtinArrayWalkRecursive
 	case "array_rand":
-		return builtinArrayRand
+		return i.builtinArrayRand
 	case "shuffle":
 		return builtinShuffle
 
@@ -1807,6 +2030,10 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinMbDetectEncoding
 	case "mb_internal_encoding":
 		return builtinMbInternalEncoding
+	case "mb_ord":
+		return builtinMbOrd
+	case "mb_chr":
+		return builtinMbChr
 	case "iconv":
 		return builtinIconv
 	case "iconv_strlen":
@@ -1850,7 +2077,7 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 	case "fopen":
 		return i.builtinFopen
 	case "fclose":
-		return builtinFclose
+		return i.builtinFclose
 	case "fread":
 		return builtinFread
 	case "fwrite", "fputs":
@@ -1863,6 +2090,8 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinFseek
 	case "ftell":
 		return builtinFtell
+	case "fflush":
+		return builtinFflush
 	case "rewind":
 		return builtinRewind
 	case "readfile":
@@ -1881,6 +2110,14 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinCopy
 	case "rename":
 		return builtinRename
+	case "symlink":
+		return builtinSymlink
+	case "link":
+		return builtinLink
+	case "readlink":
+		return builtinReadlink
+	case "is_link":
+		return builtinIsLink
 	case "chmod":
 		return builtinChmod
 	case "chown":
@@ -1893,6 +2130,14 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return builtinSysGetTempDir
 	case "tempnam":
 		return builtinTempnam
+	case "tmpfile":
+		return i.builtinTmpfile
+	case "popen":
+		return i.builtinPopen
+	case "pclose":
+		return i.builtinPclose
+	case "stream_select":
+		return builtinStreamSelect
 
 	// Stream context functions
 	case "stream_context_create":
@@ -2025,6 +2270,10 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 		return i.builtinXMLReaderRead
 	case "xmlreader_close":
 		return i.builtinXMLReaderClose
+	case "xmlreader_xml":
+		return i.builtinXMLReaderXML
+	case "xmlreader_get_attribute":
+		return i.builtinXMLReaderGetAttribute
 	
 	// SAX parsing functions
 	case "xml_parser_create":
@@ -2050,6 +2299,12 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 	case "domdocument_savexml":
 		return i.builtinDOMDocumentSaveXML
 
+	// SimpleXML functions
+	case "simplexml_load_string":
+		return i.builtinSimpleXMLLoadString
+	case "simplexml_load_file":
+		return i.builtinSimpleXMLLoadFile
+
 	// Gettext functions
 	case "gettext", "_":
 		return builtinGettext
@@ -2074,6 +2329,14 @@ func (i *Interpreter) getBuiltin(name string) runtime.BuiltinFunc {
 	case "timezone_version_get":
 		return builtinTimezoneVersionGet
 
+	// SPL iterator functions
+	case "iterator_to_array":
+		return i.builtinIteratorToArray
+	case "iterator_count":
+		return i.builtinIteratorCount
+	case "iterator_apply":
+		return i.builtinIteratorApply
+
 	// MySQLi functions (procedural interface)
 	case "mysqli_connect":
 		return i.builtinMysqliConnect
@@ -2153,8 +2416,11 @@ func builtinSubstr(args ...runtime.Value) runtime.Value {
 	start := int(args[1].ToInt())
 	length := len(str)
 
+	// A null length means "to the end of the string"
 	if len(args) >= 3 {
-		length = int(args[2].ToInt())
+		if _, isNull := args[2].(*runtime.Null); !isNull {
+			length = int(args[2].ToInt())
+		}
 	}
 
 	if start < 0 {
@@ -2167,12 +2433,12 @@ func builtinSubstr(args ...runtime.Value) runtime.Value {
 		return runtime.NewString("")
 	}
 
-	end := start + length
+	// Clamp before adding so that a huge length cannot overflow
+	end := len(str)
 	if length < 0 {
 		end = len(str) + length
-	}
-	if end > len(str) {
-		end = len(str)
+	} else if length < len(str)-start {
+		end = start + length
 	}
 	if end < start {
 		return runtime.NewString("")
@@ -2185,72 +2451,82 @@ func builtinStrpos(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.FALSE
 	}
-	haystack := args[0].ToString()
-	needle := args[1].ToString()
-	offset := 0
-	if len(args) >= 3 {
-		offset = int(args[2].ToInt())
+	return strposSearch(args[0].ToString(), args[1].ToString(), args[2:])
+}
+
+func builtinStripos(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.FALSE
 	}
+	return strposSearch(strings.ToLower(args[0].ToString()), strings.ToLower(args[1].ToString()), args[2:])
+}
 
-	pos := strings.Index(haystack[offset:], needle)
-	if pos == -1 {
+func builtinStrrpos(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
 		return runtime.FALSE
 	}
-	return runtime.NewInt(int64(pos + offset))
+	return strrposSearch(args[0].ToString(), args[1].ToString(), args[2:])
 }
 
-func builtinStripos(args ...runtime.Value) runtime.Value {
+func builtinStrripos(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.FALSE
 	}
-	haystack := strings.ToLower(args[0].ToString())
-	needle := strings.ToLower(args[1].ToString())
+	return strrposSearch(strings.ToLower(args[0].ToString()), strings.ToLower(args[1].ToString()), args[2:])
+}
+
+// strposOffset reads the optional offset argument of the strpos family. A
+// negative offset counts from the end of the haystack; an offset outside
+// the haystack is reported as invalid.
+func strposOffset(haystackLen int, rest []runtime.Value) (int, bool) {
 	offset := 0
-	if len(args) >= 3 {
-		offset = int(args[2].ToInt())
+	if len(rest) >= 1 {
+		offset = int(rest[0].ToInt())
 	}
-
-	pos := strings.Index(haystack[offset:], needle)
-	if pos == -1 {
-		return runtime.FALSE
+	if offset > haystackLen || offset < -haystackLen {
+		return 0, false
 	}
-	return runtime.NewInt(int64(pos + offset))
+	return offset, true
 }
 
-func builtinStrrpos(args ...runtime.Value) runtime.Value {
-	if len(args) < 2 {
+// strposSearch finds the first occurrence of needle at or after the offset
+func strposSearch(haystack, needle string, rest []runtime.Value) runtime.Value {
+	offset, ok := strposOffset(len(haystack), rest)
+	if !ok {
 		return runtime.FALSE
 	}
-	haystack := args[0].ToString()
-	needle := args[1].ToString()
-	offset := 0
-	if len(args) >= 3 {
-		offset = int(args[2].ToInt())
+	if offset < 0 {
+		offset += len(haystack)
 	}
 
-	pos := strings.LastIndex(haystack[offset:], needle)
+	pos := strings.Index(haystack[offset:], needle)
 	if pos == -1 {
 		return runtime.FALSE
 	}
 	return runtime.NewInt(int64(pos + offset))
 }
 
-func builtinStrripos(args ...runtime.Value) runtime.Value {
-	if len(args) < 2 {
+// strrposSearch finds the last occurrence of needle. A positive offset
+// skips that many bytes from the start; a negative one stops the search
+// that many bytes from the end, so a match must begin at or before it.
+func strrposSearch(haystack, needle string, rest []runtime.Value) runtime.Value {
+	offset, ok := strposOffset(len(haystack), rest)
+	if !ok {
 		return runtime.FALSE
 	}
-	haystack := strings.ToLower(args[0].ToString())
-	needle := strings.ToLower(args[1].ToString())
-	offset := 0
-	if len(args) >= 3 {
-		offset = int(args[2].ToInt())
+
+	start, end := 0, len(haystack)
+	if offset >= 0 {
+		start = offset
+	} else if -offset >= len(needle) {
+		end = len(haystack) + offset + len(needle)
 	}
 
-	pos := strings.LastIndex(haystack[offset:], needle)
+	pos := strings.LastIndex(haystack[start:end], needle)
 	if pos == -1 {
 		return runtime.FALSE
 	}
-	return runtime.NewInt(int64(pos + offset))
+	return runtime.NewInt(int64(pos + start))
 }
 
 func builtinStristr(args ...runtime.Value) runtime.Value {
@@ -2279,13 +2555,9 @@ func builtinStristr(args ...runtime.Value) runtime.Value {
 }
 
 func builtinStrReplace(args ...runtime.Value) runtime.Value {
-	if len(args) < 3 {
-		return runtime.NewString("")
-	}
-	search := args[0].ToString()
-	replace := args[1].ToString()
-	subject := args[2].ToString()
-	return runtime.NewString(strings.ReplaceAll(subject, search, replace))
+	return strReplace(args, func(subject, search, replace string) (string, int) {
+		return strings.ReplaceAll(subject, search, replace), strings.Count(subject, search)
+	})
 }
 
 func builtinStrtoupper(args ...runtime.Value) runtime.Value {
@@ -2335,22 +2607,32 @@ func builtinRtrim(args ...runtime.Value) runtime.Value {
 	return runtime.NewString(strings.TrimRight(s, " \t\n\r\x00\x0B"))
 }
 
-func builtinExplode(args ...runtime.Value) runtime.Value {
+func (i *Interpreter) builtinExplode(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.FALSE
 	}
 	delimiter := args[0].ToString()
 	str := args[1].ToString()
-	limit := -1
+	if delimiter == "" {
+		i.writeOutput("PHP Warning: explode(): Empty delimiter\n")
+		return runtime.FALSE
+	}
+	limit := math.MaxInt
 	if len(args) >= 3 {
 		limit = int(args[2].ToInt())
 	}
 
 	var parts []string
-	if limit > 0 {
+	switch {
+	case limit > 0:
 		parts = strings.SplitN(str, delimiter, limit)
-	} else {
+	case limit == 0:
+		// A zero limit is treated as 1
+		parts = []string{str}
+	default:
+		// A negative limit drops that many elements from the end
 		parts = strings.Split(str, delimiter)
+		parts = parts[:max(len(parts)+limit, 0)]
 	}
 
 	arr := runtime.NewArray()
@@ -2375,15 +2657,18 @@ func builtinImplode(args ...runtime.Value) runtime.Value {
 		} else {
 			return runtime.NewString("")
 		}
-	} else {
+	} else if a, ok := args[1].(*runtime.Array); ok {
 		glue = args[0].ToString()
-		if a, ok := args[1].(*runtime.Array); ok {
-			arr = a
-		} else {
-			return runtime.NewString("")
-		}
+		arr = a
+	} else if a, ok := args[0].(*runtime.Array); ok {
+		// Legacy (array, glue) order
+		glue = args[1].ToString()
+		arr = a
+	} else {
+		return runtime.NewString("")
 	}
 
+	// Objects are stringified through their __toString callback
 	parts := make([]string, 0, len(arr.Keys))
 	for _, key := range arr.Keys {
 		parts = append(parts, arr.Elements[key].ToString())
@@ -2391,54 +2676,30 @@ func builtinImplode(args ...runtime.Value) runtime.Value {
 	return runtime.NewString(strings.Join(parts, glue))
 }
 
-func builtinSprintf(args ...runtime.Value) runtime.Value {
+func (i *Interpreter) builtinSprintf(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NewString("")
 	}
-	format := args[0].ToString()
-	fmtArgs := make([]interface{}, len(args)-1)
-	for i := 1; i < len(args); i++ {
-		switch v := args[i].(type) {
-		case *runtime.Int:
-			fmtArgs[i-1] = v.Value
-		case *runtime.Float:
-			fmtArgs[i-1] = v.Value
-		case *runtime.String:
-			fmtArgs[i-1] = v.Value
-		default:
-			fmtArgs[i-1] = args[i].ToString()
-		}
+	output, err := phpSprintf(args[0].ToString(), args[1:], 1)
+	if err != nil {
+		return i.splException(err.class, err.msg)
 	}
-	return runtime.NewString(fmt.Sprintf(format, fmtArgs...))
+	return runtime.NewString(output)
 }
 
 func (i *Interpreter) builtinVprintf(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.NewInt(0)
 	}
-	format := args[0].ToString()
 	argsArray, ok := args[1].(*runtime.Array)
 	if !ok {
 		return runtime.NewInt(0)
 	}
 
-	// Convert array to interface slice
-	fmtArgs := make([]interface{}, 0, len(argsArray.Keys))
-	for _, key := range argsArray.Keys {
-		val := argsArray.Elements[key]
-		switch v := val.(type) {
-		case *runtime.Int:
-			fmtArgs = append(fmtArgs, v.Value)
-		case *runtime.Float:
-			fmtArgs = append(fmtArgs, v.Value)
-		case *runtime.String:
-			fmtArgs = append(fmtArgs, v.Value)
-		default:
-			fmtArgs = append(fmtArgs, val.ToString())
-		}
+	output, err := phpSprintf(args[0].ToString(), arrayValues(argsArray), -1)
+	if err != nil {
+		return i.splException(err.class, err.msg)
 	}
-
-	output := fmt.Sprintf(format, fmtArgs...)
 	i.writeOutput(output)
 	return runtime.NewInt(int64(len(output)))
 }
@@ -2447,21 +2708,10 @@ func (i *Interpreter) builtinPrintf(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NewInt(0)
 	}
-	format := args[0].ToString()
-	fmtArgs := make([]interface{}, len(args)-1)
-	for j := 1; j < len(args); j++ {
-		switch v := args[j].(type) {
-		case *runtime.Int:
-			fmtArgs[j-1] = v.Value
-		case *runtime.Float:
-			fmtArgs[j-1] = v.Value
-		case *runtime.String:
-			fmtArgs[j-1] = v.Value
-		default:
-			fmtArgs[j-1] = args[j].ToString()
-		}
+	output, err := phpSprintf(args[0].ToString(), args[1:], 1)
+	if err != nil {
+		return i.splException(err.class, err.msg)
 	}
-	output := fmt.Sprintf(format, fmtArgs...)
 	i.writeOutput(output)
 	return runtime.NewInt(int64(len(output)))
 }
@@ -2472,52 +2722,240 @@ func (i *Interpreter) builtinFprintf(args ...runtime.Value) runtime.Value {
 	}
 	// First argument is the file handle (not fully supported, we'll just write to output)
 	// In a full implementation, we'd write to the file handle
-	format := args[1].ToString()
-	fmtArgs := make([]interface{}, len(args)-2)
-	for j := 2; j < len(args); j++ {
-		switch v := args[j].(type) {
-		case *runtime.Int:
-			fmtArgs[j-2] = v.Value
-		case *runtime.Float:
-			fmtArgs[j-2] = v.Value
-		case *runtime.String:
-			fmtArgs[j-2] = v.Value
-		default:
-			fmtArgs[j-2] = args[j].ToString()
-		}
+	output, err := phpSprintf(args[1].ToString(), args[2:], 2)
+	if err != nil {
+		return i.splException(err.class, err.msg)
 	}
-	output := fmt.Sprintf(format, fmtArgs...)
 	i.writeOutput(output)
 	return runtime.NewInt(int64(len(output)))
 }
 
-func builtinVsprintf(args ...runtime.Value) runtime.Value {
+func (i *Interpreter) builtinVsprintf(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.NewString("")
 	}
-	format := args[0].ToString()
 	argsArray, ok := args[1].(*runtime.Array)
 	if !ok {
 		return runtime.NewString("")
 	}
 
-	// Convert array to interface slice
-	fmtArgs := make([]interface{}, 0, len(argsArray.Keys))
-	for _, key := range argsArray.Keys {
-		val := argsArray.Elements[key]
-		switch v := val.(type) {
-		case *runtime.Int:
-			fmtArgs = append(fmtArgs, v.Value)
-		case *runtime.Float:
-			fmtArgs = append(fmtArgs, v.Value)
-		case *runtime.String:
-			fmtArgs = append(fmtArgs, v.Value)
+	output, err := phpSprintf(args[0].ToString(), arrayValues(argsArray), -1)
+	if err != nil {
+		return i.splException(err.class, err.msg)
+	}
+	return runtime.NewString(output)
+}
+
+// arrayValues returns the values of an array in order
+func arrayValues(arr *runtime.Array) []runtime.Value {
+	values := make([]runtime.Value, 0, len(arr.Keys))
+	for _, key := range arr.Keys {
+		values = append(values, arr.Elements[key])
+	}
+	return values
+}
+
+// sprintfError is a malformed format or missing argument, thrown as an
+// exception of class
+type sprintfError struct {
+	class string
+	msg   string
+}
+
+// phpSprintf formats args according to a PHP printf-style format string.
+// Each conversion has the form %[argnum$][flags][width][.precision]specifier,
+// where flags are '-', '+', ' ', '0' or a custom pad character prefixed by '.
+// extra is the number of parameters the caller takes before args, used to
+// report missing arguments, or -1 when args came from an array.
+func phpSprintf(format string, args []runtime.Value, extra int) (string, *sprintfError) {
+	var sb strings.Builder
+	argIdx := 0
+	maxMissing := -1
+	for pos := 0; pos < len(format); pos++ {
+		if format[pos] != '%' {
+			sb.WriteByte(format[pos])
+			continue
+		}
+		pos++
+		if pos >= len(format) {
+			return "", &sprintfError{"ValueError", "Missing format specifier at end of string"}
+		}
+		if format[pos] == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+
+		// Explicit argument number (e.g. %1$s)
+		argNum := -1
+		end := pos
+		for end < len(format) && format[end] >= '0' && format[end] <= '9' {
+			end++
+		}
+		if end > pos && end < len(format) && format[end] == '$' {
+			n, _ := strconv.Atoi(format[pos:end])
+			if n <= 0 {
+				return "", &sprintfError{"ValueError", "Argument number specifier must be greater than zero and less than 2147483647"}
+			}
+			argNum = n - 1
+			pos = end + 1
+		}
+
+		// Flags
+		padChar := byte(' ')
+		leftAlign := false
+		plusSign := false
+	flags:
+		for pos < len(format) {
+			switch format[pos] {
+			case '-':
+				leftAlign = true
+			case '+':
+				plusSign = true
+			case '0':
+				padChar = '0'
+			case ' ':
+				padChar = ' '
+			case '\'':
+				if pos+1 < len(format) {
+					pos++
+					padChar = format[pos]
+				}
+			default:
+				break flags
+			}
+			pos++
+		}
+
+		// Width and precision
+		width := 0
+		for pos < len(format) && format[pos] >= '0' && format[pos] <= '9' {
+			width = width*10 + int(format[pos]-'0')
+			pos++
+		}
+		precision := -1
+		if pos < len(format) && format[pos] == '.' {
+			pos++
+			precision = 0
+			for pos < len(format) && format[pos] >= '0' && format[pos] <= '9' {
+				precision = precision*10 + int(format[pos]-'0')
+				pos++
+			}
+		}
+		if pos >= len(format) {
+			return "", &sprintfError{"ValueError", "Missing format specifier at end of string"}
+		}
+
+		if argNum < 0 {
+			argNum = argIdx
+			argIdx++
+		}
+		if argNum >= len(args) {
+			maxMissing = max(maxMissing, argNum)
+			continue
+		}
+		arg := args[argNum]
+
+		var str string
+		numeric := true
+		switch format[pos] {
+		case 's':
+			str = arg.ToString()
+			if precision >= 0 && precision < len(str) {
+				str = str[:precision]
+			}
+			numeric = false
+		case 'd', 'i':
+			n := arg.ToInt()
+			str = strconv.FormatInt(n, 10)
+			if plusSign && n >= 0 {
+				str = "+" + str
+			}
+		case 'u':
+			str = strconv.FormatUint(uint64(arg.ToInt()), 10)
+		case 'f', 'F':
+			if precision < 0 {
+				precision = 6
+			}
+			f := arg.ToFloat()
+			str = strconv.FormatFloat(f, 'f', precision, 64)
+			if plusSign && f >= 0 {
+				str = "+" + str
+			}
+		case 'e', 'E', 'g', 'G':
+			if precision < 0 {
+				precision = 6
+			}
+			f := arg.ToFloat()
+			str = phpExponentFloat(f, format[pos], precision)
+			if plusSign && f >= 0 {
+				str = "+" + str
+			}
+		case 'b':
+			str = strconv.FormatUint(uint64(arg.ToInt()), 2)
+		case 'o':
+			str = strconv.FormatUint(uint64(arg.ToInt()), 8)
+		case 'x':
+			str = strconv.FormatUint(uint64(arg.ToInt()), 16)
+		case 'X':
+			str = strings.ToUpper(strconv.FormatUint(uint64(arg.ToInt()), 16))
+		case 'c':
+			sb.WriteByte(byte(arg.ToInt()))
+			continue
 		default:
-			fmtArgs = append(fmtArgs, val.ToString())
+			return "", &sprintfError{"ValueError", fmt.Sprintf("Unknown format specifier \"%c\"", format[pos])}
 		}
+
+		sb.WriteString(padFormatted(str, width, padChar, leftAlign, numeric))
+	}
+	if maxMissing >= 0 {
+		if extra < 0 {
+			return "", &sprintfError{"ValueError", fmt.Sprintf("The arguments array must contain %d items, %d given", maxMissing+1, len(args))}
+		}
+		return "", &sprintfError{"ArgumentCountError", fmt.Sprintf("%d arguments are required, %d given", maxMissing+extra+1, len(args)+extra)}
+	}
+	return sb.String(), nil
+}
+
+// phpExponentFloat formats a float for the e/E/g/G conversions, using PHP's
+// exponent notation which omits leading zeros in the exponent (1.5e+3).
+func phpExponentFloat(f float64, verb byte, precision int) string {
+	goVerb := byte('e')
+	if verb == 'g' || verb == 'G' {
+		goVerb = 'g'
+		if precision == 0 {
+			precision = 1
+		}
+	}
+	str := strconv.FormatFloat(f, goVerb, precision, 64)
+	if idx := strings.IndexByte(str, 'e'); idx >= 0 {
+		mantissa, exp := str[:idx], str[idx+1:]
+		sign := exp[:1]
+		digits := strings.TrimLeft(exp[1:], "0")
+		if digits == "" {
+			digits = "0"
+		}
+		str = mantissa + "e" + sign + digits
+	}
+	if verb == 'E' || verb == 'G' {
+		str = strings.ToUpper(str)
 	}
+	return str
+}
 
-	return runtime.NewString(fmt.Sprintf(format, fmtArgs...))
+// padFormatted pads a formatted conversion to width. Zero padding of a
+// right-aligned number is inserted after its sign, as PHP does.
+func padFormatted(str string, width int, padChar byte, leftAlign, numeric bool) string {
+	if len(str) >= width {
+		return str
+	}
+	padding := strings.Repeat(string(padChar), width-len(str))
+	if leftAlign {
+		return str + padding
+	}
+	if numeric && padChar == '0' && len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		return str[:1] + padding + str[1:]
+	}
+	return padding + str
 }
 
 func (i *Interpreter) builtinFlush(args ...runtime.Value) runtime.Value {
@@ -2717,7 +3155,21 @@ func builtinUcwords(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NewString("")
 	}
-	return runtime.NewString(strings.Title(args[0].ToString()))
+	delimiters := " \t\r\n\f\v"
+	if len(args) > 1 {
+		delimiters = args[1].ToString()
+	}
+
+	// Uppercase the first byte and every byte following a delimiter
+	b := []byte(args[0].ToString())
+	atWordStart := true
+	for idx, c := range b {
+		if atWordStart && c >= 'a' && c <= 'z' {
+			b[idx] = c - ('a' - 'A')
+		}
+		atWordStart = strings.IndexByte(delimiters, c) >= 0
+	}
+	return runtime.NewString(string(b))
 }
 
 func builtinStrPad(args ...runtime.Value) runtime.Value {
@@ -2736,21 +3188,33 @@ func builtinStrPad(args ...runtime.Value) runtime.Value {
 		padType = int(args[3].ToInt())
 	}
 
-	if len(s) >= length {
+	// Lengths are counted in characters so multibyte pads aren't split
+	strLen := utf8.RuneCountInString(s)
+	if padStr == "" || strLen >= length {
 		return runtime.NewString(s)
 	}
 
-	padLen := length - len(s)
+	padLen := length - strLen
 	switch padType {
 	case 0: // STR_PAD_LEFT
-		return runtime.NewString(strings.Repeat(padStr, padLen/len(padStr)+1)[:padLen] + s)
+		return runtime.NewString(padRunes(padStr, padLen) + s)
 	case 2: // STR_PAD_BOTH
 		left := padLen / 2
-		right := padLen - left
-		return runtime.NewString(strings.Repeat(padStr, left/len(padStr)+1)[:left] + s + strings.Repeat(padStr, right/len(padStr)+1)[:right])
+		return runtime.NewString(padRunes(padStr, left) + s + padRunes(padStr, padLen-left))
 	default: // STR_PAD_RIGHT
-		return runtime.NewString(s + strings.Repeat(padStr, padLen/len(padStr)+1)[:padLen])
+		return runtime.NewString(s + padRunes(padStr, padLen))
+	}
+}
+
+// padRunes repeats pad until it is n characters long, truncating the last
+// repetition at a character boundary
+func padRunes(pad string, n int) string {
+	runes := []rune(pad)
+	out := make([]rune, n)
+	for idx := range out {
+		out[idx] = runes[idx%len(runes)]
 	}
+	return string(out)
 }
 
 func builtinStrSplit(args ...runtime.Value) runtime.Value {
@@ -2819,37 +3283,56 @@ func builtinWordwrap(args ...runtime.Value) runtime.Value {
 		cut = args[3].ToBool()
 	}
 
-	if !cut {
-		// Simple word wrap
-		words := strings.Fields(s)
-		var result strings.Builder
-		lineLen := 0
-		for i, word := range words {
-			if i > 0 {
-				if lineLen+1+len(word) > width {
-					result.WriteString(breakStr)
-					lineLen = 0
-				} else {
-					result.WriteString(" ")
-					lineLen++
-				}
-			}
-			result.WriteString(word)
-			lineLen += len(word)
-		}
-		return runtime.NewString(result.String())
+	if cut && width < 1 {
+		return runtime.FALSE
+	}
+
+	if breakStr == "" {
+		return runtime.FALSE
 	}
 
+	// Walk the bytes the way PHP does, tracking where the current line
+	// started and the last space seen on it. Text is copied through
+	// unchanged apart from the breaks inserted.
 	var result strings.Builder
-	for i := 0; i < len(s); i += width {
-		end := i + width
-		if end > len(s) {
-			end = len(s)
-		}
-		if i > 0 {
+	lineStart, lastSpace := 0, 0
+	for pos := 0; pos < len(s); pos++ {
+		switch {
+		case strings.HasPrefix(s[pos:], breakStr) && pos+len(breakStr) < len(s):
+			// An existing break starts a fresh line
+			result.WriteString(s[lineStart : pos+len(breakStr)])
+			pos += len(breakStr) - 1
+			lineStart, lastSpace = pos+1, pos+1
+		case s[pos] == ' ':
+			if pos-lineStart >= width {
+				result.WriteString(s[lineStart:pos])
+				result.WriteString(breakStr)
+				lineStart = pos + 1
+			}
+			lastSpace = pos
+		case cut && pos-lineStart >= width && lineStart >= lastSpace:
+			// No space to break at, so split the word without splitting
+			// a UTF-8 character
+			at := pos
+			for at > lineStart && !utf8.RuneStart(s[at]) {
+				at--
+			}
+			if at == lineStart {
+				_, n := utf8.DecodeRuneInString(s[lineStart:])
+				at = lineStart + n
+			}
+			result.WriteString(s[lineStart:at])
 			result.WriteString(breakStr)
+			lineStart, lastSpace = at, at
+		case pos-lineStart >= width && lineStart < lastSpace:
+			// Break at the last space, which the break replaces
+			result.WriteString(s[lineStart:lastSpace])
+			result.WriteString(breakStr)
+			lineStart, lastSpace = lastSpace+1, lastSpace+1
 		}
-		result.WriteString(s[i:end])
+	}
+	if lineStart < len(s) {
+		result.WriteString(s[lineStart:])
 	}
 	return runtime.NewString(result.String())
 }
@@ -2866,6 +3349,7 @@ func builtinNl2br(args ...runtime.Value) runtime.Value {
 }
 
 func builtinStrWordCount(args ...runtime.Value) runtime.Value {
+	// str_word_count(string $string, int $format = 0, ?string $characters = null) : array|int
 	if len(args) < 1 {
 		return runtime.NewInt(0)
 	}
@@ -2876,45 +3360,61 @@ func builtinStrWordCount(args ...runtime.Value) runtime.Value {
 	if len(args) >= 2 {
 		format = args[1].ToInt()
 	}
+	extra := ""
+	if len(args) >= 3 {
+		extra = args[2].ToString()
+	}
 
-	// Split by whitespace and punctuation
-	words := strings.FieldsFunc(str, func(r rune) bool {
-		return !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '\'' || r == '-')
-	})
-
-	// Filter empty strings
-	var validWords []string
-	for _, word := range words {
-		if len(word) > 0 {
-			validWords = append(validWords, word)
+	// Words are runs of letters (including accented ones), apostrophes and
+	// hyphens, plus any extra characters. A word may not start with ' or -
+	// or end with -, unless those are listed as extra characters.
+	isWordRune := func(r rune) bool {
+		return unicode.IsLetter(r) || r == '\'' || r == '-' || strings.ContainsRune(extra, r)
+	}
+	type word struct {
+		pos  int
+		text string
+	}
+	var words []word
+	for pos := 0; pos < len(str); {
+		r, size := utf8.DecodeRuneInString(str[pos:])
+		if !isWordRune(r) || ((r == '\'' || r == '-') && !strings.ContainsRune(extra, r)) {
+			pos += size
+			continue
+		}
+		end := pos
+		for end < len(str) {
+			r, size := utf8.DecodeRuneInString(str[end:])
+			if !isWordRune(r) {
+				break
+			}
+			end += size
 		}
+		text := str[pos:end]
+		if !strings.ContainsRune(extra, '-') {
+			text = strings.TrimRight(text, "-")
+		}
+		if text != "" {
+			words = append(words, word{pos: pos, text: text})
+		}
+		pos = end
 	}
 
 	switch format {
-	case 0: // Return word count
-		return runtime.NewInt(int64(len(validWords)))
 	case 1: // Return array of words
 		result := runtime.NewArray()
-		for _, word := range validWords {
-			result.Set(nil, runtime.NewString(word))
+		for _, w := range words {
+			result.Set(nil, runtime.NewString(w.text))
 		}
 		return result
 	case 2: // Return associative array (position => word)
 		result := runtime.NewArray()
-		pos := 0
-		currentPos := 0
-		for _, word := range validWords {
-			// Find position in original string
-			idx := strings.Index(str[currentPos:], word)
-			if idx >= 0 {
-				pos = currentPos + idx
-				result.Set(runtime.NewInt(int64(pos)), runtime.NewString(word))
-				currentPos = pos + len(word)
-			}
+		for _, w := range words {
+			result.Set(runtime.NewInt(int64(w.pos)), runtime.NewString(w.text))
 		}
 		return result
-	default:
-		return runtime.NewInt(int64(len(validWords)))
+	default: // Return word count
+		return runtime.NewInt(int64(len(words)))
 	}
 }
 
@@ -3032,7 +3532,7 @@ func builtinChr(args ...runtime.Value) runtime.Value {
 // ----------------------------------------------------------------------------
 // Array functions
 
-func builtinCount(args ...runtime.Value) runtime.Value {
+func (i *Interpreter) builtinCount(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NewInt(0)
 	}
@@ -3042,24 +3542,59 @@ func builtinCount(args ...runtime.Value) runtime.Value {
 	if _, ok := args[0].(*runtime.Null); ok {
 		return runtime.NewInt(0)
 	}
-	// Handle SPL data structures with Countable interface
-	switch o := args[0].(type) {
+	if n, ok := nativeCount(args[0]); ok {
+		return runtime.NewInt(n)
+	}
+	if obj, ok := args[0].(*runtime.Object); ok {
+		if method, _ := i.findMethod(obj.Class, "count"); method != nil {
+			return runtime.NewInt(i.callArrayAccessMethod(obj, "count", nil).ToInt())
+		}
+		if heap := i.userSplHeap(obj); heap != nil {
+			return runtime.NewInt(int64(len(heap.elements)))
+		}
+		if native, ok := obj.Internal.(runtime.Value); ok {
+			if n, ok := nativeCount(native); ok {
+				return runtime.NewInt(n)
+			}
+		}
+	}
+	return runtime.NewInt(1)
+}
+
+// nativeCount returns the element count of a natively implemented
+// Countable object, reporting false for any other value
+func nativeCount(v runtime.Value) (int64, bool) {
+	switch o := v.(type) {
 	case *SplFixedArrayObject:
-		return runtime.NewInt(o.size)
+		return o.size, true
 	case *SplDoublyLinkedListObject:
-		return runtime.NewInt(int64(len(o.elements)))
+		return int64(len(o.elements)), true
 	case *SplStackObject:
-		return runtime.NewInt(int64(len(o.elements)))
+		return int64(len(o.elements)), true
 	case *SplQueueObject:
-		return runtime.NewInt(int64(len(o.elements)))
+		return int64(len(o.elements)), true
 	case *SplHeapObject:
-		return runtime.NewInt(int64(len(o.elements)))
+		return int64(len(o.elements)), true
 	case *SplPriorityQueueObject:
-		return runtime.NewInt(int64(len(o.elements)))
+		return int64(len(o.elements)), true
 	case *SplObjectStorageObject:
-		return runtime.NewInt(int64(len(o.objects)))
+		return int64(len(o.objects)), true
+	case *ArrayIteratorObject:
+		return int64(o.array.Len()), true
+	case *ZipArchiveObject:
+		return int64(len(o.entries)), true
+	case *DirectoryIteratorObject:
+		if o.className == "GlobIterator" {
+			return int64(len(o.entries)), true
+		}
+	case *SimpleXMLObject:
+		return int64(o.count()), true
+	case *DOMNodeListObject:
+		return int64(len(o.nodes)), true
+	case *ArrayObjectObject:
+		return int64(o.array.Len()), true
 	}
-	return runtime.NewInt(1)
+	return 0, false
 }
 
 func builtinArrayPush(args ...runtime.Value) runtime.Value {
@@ -3179,11 +3714,56 @@ func builtinArrayMerge(args ...runtime.Value) runtime.Value {
 	return result
 }
 
-func builtinArrayKeys(args ...runtime.Value) runtime.Value {
+func builtinArrayMergeRecursive(args ...runtime.Value) runtime.Value {
+	// array_merge_recursive(array ...$arrays) : array
+	result := runtime.NewArray()
+	for _, arg := range args {
+		if arr, ok := arg.(*runtime.Array); ok {
+			mergeRecursive(result, arr)
+		}
+	}
+	return result
+}
+
+// mergeRecursive merges src into dst the way array_merge_recursive does:
+// integer keys are appended, and a string key present in both gathers the
+// values into an array, merging recursively when they are arrays. Nested
+// arrays are copied before being merged into so the inputs are untouched.
+func mergeRecursive(dst, src *runtime.Array) {
+	for _, key := range src.Keys {
+		val := src.Elements[key]
+		if _, isInt := key.(*runtime.Int); isInt {
+			dst.Set(nil, val)
+			continue
+		}
+		if !dst.Has(key) {
+			dst.Set(key, val)
+			continue
+		}
+
+		// The existing value keeps its own keys; only src is merged into it
+		existing := dst.Get(key)
+		var merged *runtime.Array
+		if existingArr, ok := existing.(*runtime.Array); ok {
+			merged = copyArray(existingArr)
+		} else {
+			merged = runtime.NewArray()
+			merged.Set(nil, existing)
+		}
+		if valArr, ok := val.(*runtime.Array); ok {
+			mergeRecursive(merged, valArr)
+		} else {
+			merged.Set(nil, val)
+		}
+		dst.Set(key, merged)
+	}
+}
+
+func builtinArrayKeys(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NewArray()
 	}
-	arr, ok := args[0].(*runtime.Array)
+	arr, ok := arrayArg(args[0])
 	if !ok {
 		return runtime.NewArray()
 	}
@@ -3199,7 +3779,7 @@ func builtinArrayValues(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NewArray()
 	}
-	arr, ok := args[0].(*runtime.Array)
+	arr, ok := arrayArg(args[0])
 	if !ok {
 		return runtime.NewArray()
 	}
@@ -3296,7 +3876,7 @@ func builtinInArray(args ...runtime.Value) runtime.Value {
 		return runtime.FALSE
 	}
 	needle := args[0]
-	arr, ok := args[1].(*runtime.Array)
+	arr, ok := arrayArg(args[1])
 	if !ok {
 		return runtime.FALSE
 	}
@@ -3394,24 +3974,58 @@ func builtinArrayIsList(args ...runtime.Value) runtime.Value {
 }
 
 func (i *Interpreter) builtinArrayMap(args ...runtime.Value) runtime.Value {
+	// array_map(?callable $callback, array $array, array ...$arrays) : array
 	if len(args) < 2 {
 		return runtime.NewArray()
 	}
 
-	callback, ok := args[0].(*runtime.Function)
-	if !ok {
-		return runtime.NewArray()
+	arrays := make([]*runtime.Array, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		arr, ok := arg.(*runtime.Array)
+		if !ok {
+			return runtime.NewArray()
+		}
+		arrays = append(arrays, arr)
 	}
-	arr, ok := args[1].(*runtime.Array)
-	if !ok {
-		return runtime.NewArray()
+	_, noCallback := args[0].(*runtime.Null)
+
+	// With a single array the keys are preserved
+	if len(arrays) == 1 {
+		result := runtime.NewArray()
+		for _, key := range arrays[0].Keys {
+			val := arrays[0].Elements[key]
+			if !noCallback {
+				val = i.callCallback(args[0], []runtime.Value{val})
+			}
+			result.Set(key, val)
+		}
+		return result
 	}
 
+	// With several arrays the elements are walked in parallel, shorter
+	// arrays padded with NULL, and the result is always a list
+	length := 0
+	for _, arr := range arrays {
+		length = max(length, len(arr.Keys))
+	}
 	result := runtime.NewArray()
-	for _, key := range arr.Keys {
-		val := arr.Elements[key]
-		mapped := i.callFunctionWithArgs(callback, []runtime.Value{val})
-		result.Set(nil, mapped)
+	for idx := 0; idx < length; idx++ {
+		vals := make([]runtime.Value, len(arrays))
+		for n, arr := range arrays {
+			vals[n] = runtime.NULL
+			if idx < len(arr.Keys) {
+				vals[n] = arr.Elements[arr.Keys[idx]]
+			}
+		}
+		if noCallback {
+			tuple := runtime.NewArray()
+			for _, v := range vals {
+				tuple.Set(nil, v)
+			}
+			result.Set(nil, tuple)
+		} else {
+			result.Set(nil, i.callCallback(args[0], vals))
+		}
 	}
 	return result
 }
@@ -3478,6 +4092,7 @@ func (i *Interpreter) builtinArrayReduce(args ...runtime.Value) runtime.Value {
 
 func (i *Interpreter) callFunctionWithArgs(fn *runtime.Function, args []runtime.Value) runtime.Value {
 	env := runtime.NewEnclosedEnvironment(fn.Env)
+	defer i.leaveScope(env, i.enterScope())
 	oldEnv := i.env
 	i.env = env
 
@@ -3514,14 +4129,44 @@ func builtinArrayUnique(args ...runtime.Value) runtime.Value {
 		return runtime.NewArray()
 	}
 
-	seen := make(map[string]bool)
+	flags := int64(2) // SORT_STRING
+	if len(args) >= 2 {
+		flags = args[1].ToInt()
+	}
+
 	result := runtime.NewArray()
-	for _, key := range arr.Keys {
-		val := arr.Elements[key]
-		strVal := val.ToString()
-		if !seen[strVal] {
-			seen[strVal] = true
-			result.Set(key, val)
+	if flags == 2 || flags == 5 { // SORT_STRING, SORT_LOCALE_STRING
+		seen := make(map[string]bool)
+		for _, key := range arr.Keys {
+			val := arr.Elements[key]
+			strVal := val.ToString()
+			if !seen[strVal] {
+				seen[strVal] = true
+				result.Set(key, val)
+			}
+		}
+		return result
+	}
+
+	// Other flags stable-sort the positions by value and keep the first
+	// element of each run of equal values, so the earliest key wins
+	cmp := sortFlagComparator(flags)
+	order := make([]int, len(arr.Keys))
+	for idx := range order {
+		order[idx] = idx
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return cmp(arr.Elements[arr.Keys[order[a]]], arr.Elements[arr.Keys[order[b]]]) < 0
+	})
+	keep := make([]bool, len(arr.Keys))
+	for idx, pos := range order {
+		if idx == 0 || cmp(arr.Elements[arr.Keys[order[idx-1]]], arr.Elements[arr.Keys[pos]]) != 0 {
+			keep[pos] = true
+		}
+	}
+	for pos, key := range arr.Keys {
+		if keep[pos] {
+			result.Set(key, arr.Elements[key])
 		}
 	}
 	return result
@@ -3538,8 +4183,11 @@ func builtinArrayFlip(args ...runtime.Value) runtime.Value {
 
 	result := runtime.NewArray()
 	for _, key := range arr.Keys {
-		val := arr.Elements[key]
-		result.Set(val, key)
+		// Only string and integer values can become keys
+		switch val := arr.Elements[key].(type) {
+		case *runtime.String, *runtime.Int:
+			result.Set(val, key)
+		}
 	}
 	return result
 }
@@ -3553,19 +4201,52 @@ func builtinArraySum(args ...runtime.Value) runtime.Value {
 		return runtime.NewInt(0)
 	}
 
-	var sum float64
+	// Integers are summed exactly and only fall back to float once a float
+	// operand shows up or the int64 sum would overflow
+	var intSum int64
+	var floatSum float64
 	isFloat := false
 	for _, key := range arr.Keys {
-		val := arr.Elements[key]
-		if _, ok := val.(*runtime.Float); ok {
+		n, f, operandIsFloat := numericOperand(arr.Elements[key])
+		if !isFloat && !operandIsFloat {
+			if sum := intSum + n; (n > 0 && sum < intSum) || (n < 0 && sum > intSum) {
+				isFloat = true
+				floatSum = float64(intSum)
+			} else {
+				intSum = sum
+				continue
+			}
+		}
+		if !isFloat {
 			isFloat = true
+			floatSum = float64(intSum)
 		}
-		sum += val.ToFloat()
+		floatSum += f
 	}
 	if isFloat {
-		return runtime.NewFloat(sum)
+		return runtime.NewFloat(floatSum)
+	}
+	return runtime.NewInt(intSum)
+}
+
+// numericOperand converts v the way arithmetic does, reporting whether it
+// is a float. Strings are read up to their leading number: integer
+// prefixes stay integers, fractions and exponents are floats.
+func numericOperand(v runtime.Value) (int64, float64, bool) {
+	switch val := v.(type) {
+	case *runtime.Float:
+		return 0, val.Value, true
+	case *runtime.String:
+		prefix := runtime.NumericPrefix(val.Value)
+		if n, err := strconv.ParseInt(prefix, 10, 64); err == nil {
+			return n, float64(n), false
+		}
+		if f, err := strconv.ParseFloat(prefix, 64); err == nil {
+			return 0, f, true
+		}
 	}
-	return runtime.NewInt(int64(sum))
+	n := v.ToInt()
+	return n, float64(n), false
 }
 
 func builtinArrayProduct(args ...runtime.Value) runtime.Value {
@@ -3619,7 +4300,7 @@ func builtinCurrent(args ...runtime.Value) runtime.Value {
 	if !ok {
 		return runtime.FALSE
 	}
-	if arr.Pointer < 0 || arr.Pointer >= len(arr.Keys) {
+	if !arrayPointerValid(arr) {
 		return runtime.FALSE
 	}
 	return arr.Elements[arr.Keys[arr.Pointer]]
@@ -3633,6 +4314,9 @@ func builtinNext(args ...runtime.Value) runtime.Value {
 	if !ok {
 		return runtime.FALSE
 	}
+	if !arrayPointerValid(arr) {
+		return runtime.FALSE
+	}
 	arr.Pointer++
 	if arr.Pointer >= len(arr.Keys) {
 		return runtime.FALSE
@@ -3648,8 +4332,14 @@ func builtinPrev(args ...runtime.Value) runtime.Value {
 	if !ok {
 		return runtime.FALSE
 	}
+	if !arrayPointerValid(arr) {
+		return runtime.FALSE
+	}
 	arr.Pointer--
 	if arr.Pointer < 0 {
+		// Moving before the first element leaves the pointer past the end,
+		// like PHP, so a following next() doesn't revive it
+		arr.Pointer = len(arr.Keys)
 		return runtime.FALSE
 	}
 	return arr.Elements[arr.Keys[arr.Pointer]]
@@ -3678,12 +4368,44 @@ func builtinKey(args ...runtime.Value) runtime.Value {
 	if !ok {
 		return runtime.NULL
 	}
-	if arr.Pointer < 0 || arr.Pointer >= len(arr.Keys) {
+	if !arrayPointerValid(arr) {
 		return runtime.NULL
 	}
 	return arr.Keys[arr.Pointer]
 }
 
+// builtinEach returns the key/value pair at the internal pointer and advances
+// it. It was removed in PHP 8 but is kept for legacy scripts.
+func builtinEach(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	arr, ok := args[0].(*runtime.Array)
+	if !ok {
+		return runtime.FALSE
+	}
+	if !arrayPointerValid(arr) {
+		return runtime.FALSE
+	}
+
+	key := arr.Keys[arr.Pointer]
+	val := arr.Elements[key]
+	arr.Pointer++
+
+	result := runtime.NewArray()
+	result.Set(runtime.NewInt(1), val)
+	result.Set(runtime.NewString("value"), val)
+	result.Set(runtime.NewInt(0), key)
+	result.Set(runtime.NewString("key"), key)
+	return result
+}
+
+// arrayPointerValid reports whether the internal pointer of arr refers to an
+// element.
+func arrayPointerValid(arr *runtime.Array) bool {
+	return arr.Pointer >= 0 && arr.Pointer < len(arr.Keys)
+}
+
 func builtinRange(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.NewArray()
@@ -3730,8 +4452,13 @@ func builtinSort(args ...runtime.Value) runtime.Value {
 		vals = append(vals, arr.Elements[key])
 	}
 
-	sort.Slice(vals, func(i, j int) bool {
-		return runtime.Compare(vals[i], vals[j]) < 0
+	var flags int64
+	if len(args) >= 2 {
+		flags = args[1].ToInt()
+	}
+	compare := sortFlagComparator(flags)
+	sort.SliceStable(vals, func(i, j int) bool {
+		return compare(vals[i], vals[j]) < 0
 	})
 
 	arr.Elements = make(map[runtime.Value]runtime.Value)
@@ -3761,8 +4488,13 @@ func builtinRsort(args ...runtime.Value) runtime.Value {
 		vals = append(vals, arr.Elements[key])
 	}
 
-	sort.Slice(vals, func(i, j int) bool {
-		return runtime.Compare(vals[i], vals[j]) > 0
+	var flags int64
+	if len(args) >= 2 {
+		flags = args[1].ToInt()
+	}
+	compare := sortFlagComparator(flags)
+	sort.SliceStable(vals, func(i, j int) bool {
+		return compare(vals[i], vals[j]) > 0
 	})
 
 	arr.Elements = make(map[runtime.Value]runtime.Value)
@@ -3799,7 +4531,7 @@ func builtinNatsort(args ...runtime.Value) runtime.Value {
 
 	// Natural sort by value
 	sort.Slice(pairs, func(i, j int) bool {
-		return naturalCompare(pairs[i].val.ToString(), pairs[j].val.ToString(), false) < 0
+		return naturalCompareValues(pairs[i].val, pairs[j].val, false) < 0
 	})
 
 	// Rebuild array maintaining original keys
@@ -3834,7 +4566,7 @@ func builtinNatcasesort(args ...runtime.Value) runtime.Value {
 
 	// Natural sort by value (case-insensitive)
 	sort.Slice(pairs, func(i, j int) bool {
-		return naturalCompare(pairs[i].val.ToString(), pairs[j].val.ToString(), true) < 0
+		return naturalCompareValues(pairs[i].val, pairs[j].val, true) < 0
 	})
 
 	// Rebuild array maintaining original keys
@@ -3848,40 +4580,195 @@ func builtinNatcasesort(args ...runtime.Value) runtime.Value {
 	return runtime.TRUE
 }
 
+// sortFlagComparator returns the comparison used by the sort functions for
+// the given SORT_* flags.
+func sortFlagComparator(flags int64) func(a, b runtime.Value) int {
+	caseInsensitive := flags&8 != 0 // SORT_FLAG_CASE
+	switch flags &^ 8 {
+	case 1: // SORT_NUMERIC
+		return func(a, b runtime.Value) int {
+			fa, fb := a.ToFloat(), b.ToFloat()
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	case 2: // SORT_STRING
+		return func(a, b runtime.Value) int {
+			sa, sb := a.ToString(), b.ToString()
+			if caseInsensitive {
+				sa, sb = strings.ToLower(sa), strings.ToLower(sb)
+			}
+			return strings.Compare(sa, sb)
+		}
+	case 6: // SORT_NATURAL
+		return func(a, b runtime.Value) int {
+			return naturalCompareValues(a, b, caseInsensitive)
+		}
+	}
+	return runtime.Compare
+}
+
+// naturalCompareValues compares two values in natural order; it is shared by
+// natsort, natcasesort, SORT_NATURAL and strnatcmp so they always agree.
+func naturalCompareValues(a, b runtime.Value, caseInsensitive bool) int {
+	cmp := naturalCompare(a.ToString(), b.ToString(), caseInsensitive)
+	switch {
+	case cmp < 0:
+		return -1
+	case cmp > 0:
+		return 1
+	}
+	return 0
+}
+
+func builtinStrnatcmp(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.NULL
+	}
+	return runtime.NewInt(int64(naturalCompareValues(args[0], args[1], false)))
+}
+
+func builtinStrnatcasecmp(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.NULL
+	}
+	return runtime.NewInt(int64(naturalCompareValues(args[0], args[1], true)))
+}
+
+// naturalCompare compares strings the way PHP's strnatcmp does. Leading
+// zeros are skipped, runs of digits compare by magnitude, and a run that
+// starts with 0 is compared digit by digit like a fraction, so "02" sorts
+// before "1".
 func naturalCompare(a, b string, caseInsensitive bool) int {
-	if caseInsensitive {
-		a = strings.ToLower(a)
-		b = strings.ToLower(b)
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) - len(b)
+	}
+	at := func(s string, idx int) byte {
+		if idx < len(s) {
+			return s[idx]
+		}
+		return 0
 	}
 
 	ia, ib := 0, 0
-	for ia < len(a) && ib < len(b) {
-		// Check if both are at digit positions
-		if a[ia] >= '0' && a[ia] <= '9' && b[ib] >= '0' && b[ib] <= '9' {
-			// Extract numbers
-			numA, numB := 0, 0
-			for ia < len(a) && a[ia] >= '0' && a[ia] <= '9' {
-				numA = numA*10 + int(a[ia]-'0')
-				ia++
-			}
-			for ib < len(b) && b[ib] >= '0' && b[ib] <= '9' {
-				numB = numB*10 + int(b[ib]-'0')
-				ib++
-			}
-			if numA != numB {
-				return numA - numB
-			}
-		} else {
-			// Regular character comparison
-			if a[ia] != b[ib] {
-				return int(a[ia]) - int(b[ib])
-			}
+	leading := true
+	for {
+		// Skip leading zeros, keeping the last digit of a run of zeros
+		for leading && at(a, ia) == '0' && isDigitByte(at(a, ia+1)) {
+			ia++
+		}
+		for leading && at(b, ib) == '0' && isDigitByte(at(b, ib+1)) {
+			ib++
+		}
+		leading = false
+
+		for isSpaceByte(at(a, ia)) {
 			ia++
+		}
+		for isSpaceByte(at(b, ib)) {
 			ib++
 		}
+
+		if isDigitByte(at(a, ia)) && isDigitByte(at(b, ib)) {
+			var result int
+			if at(a, ia) == '0' || at(b, ib) == '0' {
+				result = naturalCompareLeft(a, &ia, b, &ib)
+			} else {
+				result = naturalCompareRight(a, &ia, b, &ib)
+			}
+			switch {
+			case result != 0:
+				return result
+			case ia >= len(a) && ib >= len(b):
+				return 0
+			case ia >= len(a):
+				return -1
+			case ib >= len(b):
+				return 1
+			}
+		}
+
+		ca, cb := at(a, ia), at(b, ib)
+		if caseInsensitive {
+			ca, cb = toUpperByte(ca), toUpperByte(cb)
+		}
+		if ca != cb {
+			return int(ca) - int(cb)
+		}
+
+		ia++
+		ib++
+		switch {
+		case ia >= len(a) && ib >= len(b):
+			return 0
+		case ia >= len(a):
+			return -1
+		case ib >= len(b):
+			return 1
+		}
+	}
+}
+
+// naturalCompareRight compares right-aligned runs of digits: the longer
+// run wins, and runs of equal length compare by their first differing digit
+func naturalCompareRight(a string, ia *int, b string, ib *int) int {
+	bias := 0
+	for ; ; *ia, *ib = *ia+1, *ib+1 {
+		endA := *ia >= len(a) || !isDigitByte(a[*ia])
+		endB := *ib >= len(b) || !isDigitByte(b[*ib])
+		switch {
+		case endA && endB:
+			return bias
+		case endA:
+			return -1
+		case endB:
+			return 1
+		case bias == 0 && a[*ia] < b[*ib]:
+			bias = -1
+		case bias == 0 && a[*ia] > b[*ib]:
+			bias = 1
+		}
+	}
+}
+
+func isDigitByte(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || (c >= '\t' && c <= '\r')
+}
+
+func toUpperByte(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
 	}
+	return c
+}
 
-	return len(a) - len(b)
+// naturalCompareLeft compares left-aligned runs of digits, such as
+// fractional parts: the first differing digit wins
+func naturalCompareLeft(a string, ia *int, b string, ib *int) int {
+	for ; ; *ia, *ib = *ia+1, *ib+1 {
+		endA := *ia >= len(a) || !isDigitByte(a[*ia])
+		endB := *ib >= len(b) || !isDigitByte(b[*ib])
+		switch {
+		case endA && endB:
+			return 0
+		case endA:
+			return -1
+		case endB:
+			return 1
+		case a[*ia] < b[*ib]:
+			return -1
+		case a[*ia] > b[*ib]:
+			return 1
+		}
+	}
 }
 
 // ----------------------------------------------------------------------------
@@ -3923,8 +4810,22 @@ func builtinRound(args ...runtime.Value) runtime.Value {
 	if len(args) >= 2 {
 		precision = int(args[1].ToInt())
 	}
-	multiplier := math.Pow(10, float64(precision))
-	return runtime.NewFloat(math.Round(args[0].ToFloat()*multiplier) / multiplier)
+	return runtime.NewFloat(phpRound(args[0].ToFloat(), precision))
+}
+
+// phpRound rounds half away from zero to the given number of decimal
+// places. Like PHP, the scaled value is first rounded to 15 significant
+// digits so that 1.005 rounds to 1.01 despite being stored as 1.00499...
+func phpRound(value float64, places int) float64 {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return value
+	}
+	multiplier := math.Pow(10, float64(places))
+	scaled := value * multiplier
+	if math.Abs(scaled) < 1e15 {
+		scaled, _ = strconv.ParseFloat(strconv.FormatFloat(scaled, 'g', 15, 64), 64)
+	}
+	return math.Round(scaled) / multiplier
 }
 
 func builtinMax(args ...runtime.Value) runtime.Value {
@@ -4073,19 +4974,139 @@ func builtinIsInfinite(args ...runtime.Value) runtime.Value {
 	return runtime.FALSE
 }
 
-func builtinRand(args ...runtime.Value) runtime.Value {
-	min := int64(0)
-	max := int64(32767)
-	if len(args) >= 2 {
-		min = args[0].ToInt()
-		max = args[1].ToInt()
+// randMax is the largest value rand() and mt_rand() return without bounds
+const randMax = 2147483647
+
+// rand() is an alias of mt_rand() that tolerates swapped bounds
+func (i *Interpreter) builtinRand(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.NewInt(int64(i.mtRand.next() >> 1))
+	}
+	min := args[0].ToInt()
+	max := args[1].ToInt()
+	if min > max {
+		min, max = max, min
+	}
+	return runtime.NewInt(i.mtRand.between(min, max))
+}
+
+func builtinGetrandmax(args ...runtime.Value) runtime.Value {
+	return runtime.NewInt(randMax)
+}
+
+// srand() is an alias of mt_srand()
+func (i *Interpreter) builtinSrand(args ...runtime.Value) runtime.Value {
+	return i.builtinMtSrand(args...)
+}
+
+// mtRand is a Mersenne Twister (MT19937) generator, the algorithm behind
+// PHP's mt_rand(), so a given mt_srand() seed yields PHP's sequence
+type mtRand struct {
+	state [624]uint32
+	index int
+}
+
+func newMtRand(seed uint32) *mtRand {
+	mt := &mtRand{}
+	mt.seed(seed)
+	return mt
+}
+
+func (mt *mtRand) seed(seed uint32) {
+	mt.state[0] = seed
+	for i := 1; i < len(mt.state); i++ {
+		prev := mt.state[i-1]
+		mt.state[i] = 1812433253*(prev^(prev>>30)) + uint32(i)
+	}
+	mt.index = len(mt.state)
+}
+
+func (mt *mtRand) next() uint32 {
+	if mt.index >= len(mt.state) {
+		// Regenerate the whole state block
+		for i := range mt.state {
+			y := (mt.state[i] & 0x80000000) | (mt.state[(i+1)%624] & 0x7fffffff)
+			next := mt.state[(i+397)%624] ^ (y >> 1)
+			if y&1 != 0 {
+				next ^= 0x9908b0df
+			}
+			mt.state[i] = next
+		}
+		mt.index = 0
+	}
+
+	y := mt.state[mt.index]
+	mt.index++
+	y ^= y >> 11
+	y ^= (y << 7) & 0x9d2c5680
+	y ^= (y << 15) & 0xefc60000
+	y ^= y >> 18
+	return y
+}
+
+// rangeUint32 returns a uniform value in [0, umax], discarding values that
+// would bias the modulo the way PHP does
+func (mt *mtRand) rangeUint32(umax uint32) uint32 {
+	result := mt.next()
+	if umax == math.MaxUint32 {
+		return result
+	}
+	umax++
+	if umax&(umax-1) == 0 {
+		return result & (umax - 1)
+	}
+	limit := math.MaxUint32 - (math.MaxUint32 % umax) - 1
+	for result > limit {
+		result = mt.next()
+	}
+	return result % umax
+}
+
+// rangeUint64 is rangeUint32 for spans wider than 32 bits
+func (mt *mtRand) rangeUint64(umax uint64) uint64 {
+	result := uint64(mt.next())<<32 | uint64(mt.next())
+	if umax == math.MaxUint64 {
+		return result
+	}
+	umax++
+	if umax&(umax-1) == 0 {
+		return result & (umax - 1)
+	}
+	limit := math.MaxUint64 - (math.MaxUint64 % umax) - 1
+	for result > limit {
+		result = uint64(mt.next())<<32 | uint64(mt.next())
+	}
+	return result % umax
+}
+
+// between returns a uniform value in [min, max], which must be ordered
+func (mt *mtRand) between(min, max int64) int64 {
+	umax := uint64(max) - uint64(min)
+	if umax > math.MaxUint32 {
+		return int64(uint64(min) + mt.rangeUint64(umax))
+	}
+	return int64(uint64(min) + uint64(mt.rangeUint32(uint32(umax))))
+}
+
+func (i *Interpreter) builtinMtRand(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.NewInt(int64(i.mtRand.next() >> 1))
 	}
-	// Simple pseudo-random (not cryptographically secure)
-	return runtime.NewInt(min + (max-min+1)/2)
+	min := args[0].ToInt()
+	max := args[1].ToInt()
+	if max < min {
+		return runtime.FALSE
+	}
+	return runtime.NewInt(i.mtRand.between(min, max))
 }
 
-func builtinMtRand(args ...runtime.Value) runtime.Value {
-	return builtinRand(args...)
+func (i *Interpreter) builtinMtSrand(args ...runtime.Value) runtime.Value {
+	seed := uint32(time.Now().UnixNano())
+	if len(args) >= 1 {
+		seed = uint32(args[0].ToInt())
+	}
+	i.mtRand.seed(seed)
+	return runtime.NULL
 }
 
 func builtinLcgValue(args ...runtime.Value) runtime.Value {
@@ -4215,6 +5236,41 @@ func builtinIsNumeric(args ...runtime.Value) runtime.Value {
 	return runtime.FALSE
 }
 
+// builtinIsIterable reports whether a value is an array or Traversable
+func (i *Interpreter) builtinIsIterable(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	switch v := args[0].(type) {
+	case *runtime.Array:
+		return runtime.TRUE
+	case *runtime.Object:
+		return runtime.NewBool(i.implementsInterface(v.Class, "Traversable") ||
+			i.implementsInterface(v.Class, "Iterator") ||
+			i.implementsInterface(v.Class, "IteratorAggregate"))
+	case *runtime.Reference:
+		return i.builtinIsIterable(v.Deref())
+	}
+	return runtime.NewBool(i.toIterator(args[0]) != nil)
+}
+
+// builtinIsCountable reports whether a value is an array or Countable
+func (i *Interpreter) builtinIsCountable(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	switch v := args[0].(type) {
+	case *runtime.Array:
+		return runtime.TRUE
+	case *runtime.Object:
+		return runtime.NewBool(i.implementsInterface(v.Class, "Countable"))
+	case *runtime.Reference:
+		return i.builtinIsCountable(v.Deref())
+	}
+	_, ok := nativeCount(args[0])
+	return runtime.NewBool(ok)
+}
+
 func (i *Interpreter) builtinIsCallable(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.FALSE
@@ -4592,14 +5648,50 @@ func (i *Interpreter) builtinVarExport(args ...runtime.Value) runtime.Value {
 	return runtime.NULL
 }
 
-func (i *Interpreter) exportValue(v runtime.Value, indent int) string {
-	switch val := v.(type) {
-	case *runtime.String:
-		return fmt.Sprintf("'%s'", strings.ReplaceAll(strings.ReplaceAll(val.Value, "\\", "\\\\"), "'", "\\'"))
-	case *runtime.Int:
-		return fmt.Sprintf("%d", val.Value)
+// phpFloatRepr formats a float the way var_export and serialize do: the
+// shortest digits that round-trip exactly, switching to exponent form
+// (1.0E+25) for very large or small magnitudes. zeroFrac appends ".0" to
+// integral values, as var_export does.
+func phpFloatRepr(f float64, zeroFrac bool) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "INF"
+	case math.IsInf(f, -1):
+		return "-INF"
+	case math.IsNaN(f):
+		return "NAN"
+	}
+
+	digits := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, expStr, _ := strings.Cut(digits, "e")
+	exp, _ := strconv.Atoi(expStr)
+	if decpt := exp + 1; decpt < -3 || decpt > 17 {
+		if !strings.Contains(mantissa, ".") {
+			mantissa += ".0"
+		}
+		sign := "+"
+		if exp < 0 {
+			sign = "-"
+			exp = -exp
+		}
+		return fmt.Sprintf("%sE%s%d", mantissa, sign, exp)
+	}
+
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if zeroFrac && !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+func (i *Interpreter) exportValue(v runtime.Value, indent int) string {
+	switch val := v.(type) {
+	case *runtime.String:
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(strings.ReplaceAll(val.Value, "\\", "\\\\"), "'", "\\'"))
+	case *runtime.Int:
+		return fmt.Sprintf("%d", val.Value)
 	case *runtime.Float:
-		return fmt.Sprintf("%g", val.Value)
+		return phpFloatRepr(val.Value, true)
 	case *runtime.Bool:
 		if val.Value {
 			return "true"
@@ -4848,16 +5940,45 @@ func (i *Interpreter) builtinDefine(args ...runtime.Value) runtime.Value {
 	name := args[0].ToString()
 	value := args[1]
 
+	// Case-insensitive constants are no longer supported; the flag is
+	// ignored and the constant is declared case-sensitively
+	if len(args) >= 3 && args[2].ToBool() {
+		i.writeOutput("PHP Warning: define(): Argument #3 ($case_insensitive) is ignored since declaration of case-insensitive constants is no longer supported\n")
+	}
+
+	if obj, ok := value.(*runtime.Object); ok {
+		return runtime.NewError("define(): Argument #2 ($value) cannot be an object, " + obj.Class.Name + " given")
+	}
+
 	// Check if constant already exists
 	if _, ok := i.env.GetConstant(name); ok {
 		return runtime.FALSE
 	}
 
+	// Arrays are stored as a snapshot so later writes to the source
+	// variable don't leak into the constant
+	if arr, ok := value.(*runtime.Array); ok {
+		value = snapshotArray(arr)
+	}
+
 	// Define the constant
 	i.env.DefineConstant(name, value)
 	return runtime.TRUE
 }
 
+// snapshotArray returns a recursive copy of arr.
+func snapshotArray(arr *runtime.Array) *runtime.Array {
+	result := runtime.NewArray()
+	for _, k := range arr.Keys {
+		v := arr.Elements[k]
+		if nested, ok := v.(*runtime.Array); ok {
+			v = snapshotArray(nested)
+		}
+		result.Set(k, v)
+	}
+	return result
+}
+
 func (i *Interpreter) builtinDefined(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.FALSE
@@ -4873,6 +5994,23 @@ func (i *Interpreter) builtinConstant(args ...runtime.Value) runtime.Value {
 	}
 
 	name := args[0].ToString()
+
+	// Class constant (e.g. "Foo::BAR")
+	if idx := strings.Index(name, "::"); idx > 0 {
+		className := i.resolveClassName(name[:idx])
+		constName := name[idx+2:]
+		if class, ok := i.env.GetClass(className); ok {
+			if value, ok := i.findClassConstant(class, constName); ok {
+				return value
+			}
+		} else if iface, ok := i.env.GetInterface(className); ok {
+			if value, ok := iface.Constants[constName]; ok {
+				return value
+			}
+		}
+		return runtime.NewError(fmt.Sprintf("Undefined constant %s", name))
+	}
+
 	value, ok := i.env.GetConstant(name)
 	if !ok {
 		return runtime.NULL
@@ -4881,6 +6019,31 @@ func (i *Interpreter) builtinConstant(args ...runtime.Value) runtime.Value {
 	return value
 }
 
+// defaultIniSettings holds the values ini_get reports for well-known
+// settings that have not been changed with ini_set
+var defaultIniSettings = map[string]string{
+	"date.timezone":          "",
+	"default_charset":        "UTF-8",
+	"default_socket_timeout": "60",
+	"display_errors":         "1",
+	"display_startup_errors": "1",
+	"error_log":              "",
+	"error_reporting":        "32767",
+	"html_errors":            "0",
+	"implicit_flush":         "1",
+	"include_path":           ".:/usr/share/php",
+	"log_errors":             "0",
+	"max_execution_time":     "0",
+	"max_input_time":         "-1",
+	"memory_limit":           "128M",
+	"output_buffering":       "0",
+	"post_max_size":          "8M",
+	"precision":              "14",
+	"serialize_precision":    "-1",
+	"short_open_tag":         "0",
+	"upload_max_filesize":    "2M",
+}
+
 func (i *Interpreter) builtinIniGet(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.FALSE
@@ -4903,21 +6066,35 @@ func (i *Interpreter) builtinIniSet(args ...runtime.Value) runtime.Value {
 	newValue := args[1].ToString()
 
 	// Get old value
-	oldValue := ""
-	if val, ok := i.iniSettings[name]; ok {
-		oldValue = val
-	}
+	oldValue, existed := i.iniSettings[name]
 
 	// Set new value
 	i.iniSettings[name] = newValue
+	switch name {
+	case "max_execution_time":
+		i.setTimeLimit(args[1].ToInt())
+	case "memory_limit":
+		i.memoryLimit = parseIniBytes(newValue)
+	}
 
 	// Return old value or false if it didn't exist
-	if oldValue != "" {
+	if existed {
 		return runtime.NewString(oldValue)
 	}
 	return runtime.FALSE
 }
 
+func (i *Interpreter) builtinSetTimeLimit(args ...runtime.Value) runtime.Value {
+	// set_time_limit(int $seconds) : bool
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	seconds := args[0].ToInt()
+	i.iniSettings["max_execution_time"] = strconv.FormatInt(seconds, 10)
+	i.setTimeLimit(seconds)
+	return runtime.TRUE
+}
+
 func builtinVersionCompare(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.NULL
@@ -5042,18 +6219,12 @@ func builtinExtensionLoaded(args ...runtime.Value) runtime.Value {
 	return runtime.FALSE
 }
 
-func builtinMemoryGetUsage(args ...runtime.Value) runtime.Value {
-	var m goruntime.MemStats
-	goruntime.ReadMemStats(&m)
-	// Return allocated memory in bytes
-	return runtime.NewInt(int64(m.Alloc))
+func (i *Interpreter) builtinMemoryGetUsage(args ...runtime.Value) runtime.Value {
+	return runtime.NewInt(i.memoryUsed)
 }
 
-func builtinMemoryGetPeakUsage(args ...runtime.Value) runtime.Value {
-	var m goruntime.MemStats
-	goruntime.ReadMemStats(&m)
-	// Return peak memory usage in bytes
-	return runtime.NewInt(int64(m.TotalAlloc))
+func (i *Interpreter) builtinMemoryGetPeakUsage(args ...runtime.Value) runtime.Value {
+	return runtime.NewInt(i.memoryPeak)
 }
 
 func builtinGetmypid(args ...runtime.Value) runtime.Value {
@@ -5132,9 +6303,19 @@ func (i *Interpreter) builtinClassExists(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.FALSE
 	}
-	name := args[0].ToString()
-	_, ok := i.env.GetClass(name)
-	return runtime.NewBool(ok)
+	name := strings.TrimPrefix(args[0].ToString(), "\\")
+	if _, ok := i.env.GetClass(name); ok {
+		return runtime.TRUE
+	}
+
+	// Run the autoloaders unless $autoload is false
+	if len(args) < 2 || args[1].ToBool() {
+		if i.autoloadClass(name) {
+			_, ok := i.env.GetClass(name)
+			return runtime.NewBool(ok)
+		}
+	}
+	return runtime.FALSE
 }
 
 func (i *Interpreter) builtinClassAlias(args ...runtime.Value) runtime.Value {
@@ -5158,7 +6339,8 @@ func (i *Interpreter) builtinClassAlias(args ...runtime.Value) runtime.Value {
 
 func (i *Interpreter) builtinSplAutoloadRegister(args ...runtime.Value) runtime.Value {
 	// If no callback provided, use default autoload
-	if len(args) == 0 {
+	if len(args) == 0 || args[0] == runtime.NULL {
+		i.autoloadFuncs = append(i.autoloadFuncs, runtime.NewString("spl_autoload"))
 		return runtime.TRUE
 	}
 
@@ -5182,6 +6364,99 @@ func (i *Interpreter) builtinSplAutoloadRegister(args ...runtime.Value) runtime.
 	return runtime.TRUE
 }
 
+// builtinSplAutoload is the default autoloader: it looks for the lowercased
+// class name with a .inc or .php extension relative to the current script.
+func (i *Interpreter) builtinSplAutoload(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.NULL
+	}
+
+	base := strings.ToLower(strings.TrimPrefix(args[0].ToString(), "\\"))
+	base = strings.ReplaceAll(base, "\\", string(filepath.Separator))
+
+	extensions := []string{".inc", ".php"}
+	if len(args) >= 2 && args[1] != runtime.NULL {
+		extensions = strings.Split(args[1].ToString(), ",")
+	}
+
+	for _, ext := range extensions {
+		path := filepath.Join(i.currentDir, base+strings.TrimSpace(ext))
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		i.includeFileOnce(path)
+		return runtime.NULL
+	}
+	return runtime.NULL
+}
+
+func (i *Interpreter) builtinSplAutoloadFunctions(args ...runtime.Value) runtime.Value {
+	result := runtime.NewArray()
+	for _, fn := range i.autoloadFuncs {
+		result.Set(nil, fn)
+	}
+	return result
+}
+
+func (i *Interpreter) builtinSplAutoloadUnregister(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	for idx, fn := range i.autoloadFuncs {
+		if fn == args[0] || (fn.Type() == "string" && args[0].Type() == "string" && fn.ToString() == args[0].ToString()) {
+			i.autoloadFuncs = append(i.autoloadFuncs[:idx], i.autoloadFuncs[idx+1:]...)
+			return runtime.TRUE
+		}
+	}
+	return runtime.FALSE
+}
+
+// autoloadClass runs the registered autoloaders for name until one of them
+// declares it as a class or interface, and reports whether it now exists.
+func (i *Interpreter) autoloadClass(name string) bool {
+	name = strings.TrimPrefix(name, "\\")
+	if len(i.autoloadFuncs) == 0 || i.autoloading[name] {
+		return false
+	}
+
+	i.autoloading[name] = true
+	defer delete(i.autoloading, name)
+
+	for _, fn := range i.autoloadFuncs {
+		i.callCallback(fn, []runtime.Value{runtime.NewString(name)})
+		if _, ok := i.env.GetClass(name); ok {
+			return true
+		}
+		if _, ok := i.env.GetInterface(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupClass resolves a class by name, falling back to the registered
+// autoloaders when it hasn't been declared yet.
+func (i *Interpreter) lookupClass(name string) (*runtime.Class, bool) {
+	if class, ok := i.env.GetClass(name); ok {
+		return class, true
+	}
+	if i.autoloadClass(name) {
+		return i.env.GetClass(strings.TrimPrefix(name, "\\"))
+	}
+	return nil, false
+}
+
+// lookupInterface resolves an interface by name, autoloading it if needed.
+func (i *Interpreter) lookupInterface(name string) (*runtime.Interface, bool) {
+	if iface, ok := i.env.GetInterface(name); ok {
+		return iface, true
+	}
+	if i.autoloadClass(name) {
+		return i.env.GetInterface(strings.TrimPrefix(name, "\\"))
+	}
+	return nil, false
+}
+
 func (i *Interpreter) builtinCallUserFunc(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NULL
@@ -5292,6 +6567,7 @@ func (i *Interpreter) callCallback(callback runtime.Value, args []runtime.Value)
 // invokeMethodWithArgs calls an object method with given args
 func (i *Interpreter) invokeMethodWithArgs(obj *runtime.Object, method *runtime.Method, foundClass *runtime.Class, args []runtime.Value) runtime.Value {
 	env := runtime.NewEnclosedEnvironment(i.env)
+	defer i.leaveScope(env, i.enterScope())
 	env.Set("this", obj)
 	oldEnv := i.env
 	oldClass := i.currentClass
@@ -5340,6 +6616,7 @@ func (i *Interpreter) invokeMethodWithArgs(obj *runtime.Object, method *runtime.
 // invokeStaticMethodWithArgs calls a static method with given args
 func (i *Interpreter) invokeStaticMethodWithArgs(class *runtime.Class, method *runtime.Method, foundClass *runtime.Class, args []runtime.Value) runtime.Value {
 	env := runtime.NewEnclosedEnvironment(i.env)
+	defer i.leaveScope(env, i.enterScope())
 	oldEnv := i.env
 	oldClass := i.currentClass
 	oldFuncArgs := i.currentFuncArgs
@@ -5435,6 +6712,18 @@ func builtinPregMatchAll(args ...runtime.Value) runtime.Value {
 			arr.Keys = make([]runtime.Value, 0)
 			arr.NextIndex = 0
 
+			// PREG_SET_ORDER lists each match's captures together
+			if len(args) >= 4 && args[3].ToInt()&2 != 0 {
+				for _, match := range matches {
+					setArr := runtime.NewArray()
+					for _, m := range match {
+						setArr.Set(nil, runtime.NewString(m))
+					}
+					arr.Set(nil, setArr)
+				}
+				return runtime.NewInt(int64(len(matches)))
+			}
+
 			// Group by capture index
 			numGroups := len(matches[0])
 			for g := 0; g < numGroups; g++ {
@@ -5471,6 +6760,151 @@ func builtinPregReplace(args ...runtime.Value) runtime.Value {
 	return runtime.NewString(result)
 }
 
+func (i *Interpreter) builtinPregReplaceCallback(args ...runtime.Value) runtime.Value {
+	// preg_replace_callback(string|array $pattern, callable $callback, string|array $subject, int $limit = -1, int &$count = null) : string|array|null
+	if len(args) < 3 {
+		return runtime.NULL
+	}
+	var patterns []string
+	if arr, ok := args[0].(*runtime.Array); ok {
+		for _, key := range arr.Keys {
+			patterns = append(patterns, arr.Elements[key].ToString())
+		}
+	} else {
+		patterns = []string{args[0].ToString()}
+	}
+	callbacks := make([]runtime.Value, len(patterns))
+	for idx := range callbacks {
+		callbacks[idx] = args[1]
+	}
+	return i.pregReplaceCallbacks("preg_replace_callback", patterns, callbacks, args[2], args[3:])
+}
+
+func (i *Interpreter) builtinPregReplaceCallbackArray(args ...runtime.Value) runtime.Value {
+	// preg_replace_callback_array(array $pattern, string|array $subject, int $limit = -1, int &$count = null) : string|array|null
+	if len(args) < 2 {
+		return runtime.NULL
+	}
+	arr, ok := args[0].(*runtime.Array)
+	if !ok {
+		return runtime.NULL
+	}
+	var patterns []string
+	var callbacks []runtime.Value
+	for _, key := range arr.Keys {
+		patterns = append(patterns, key.ToString())
+		callbacks = append(callbacks, arr.Elements[key])
+	}
+	return i.pregReplaceCallbacks("preg_replace_callback_array", patterns, callbacks, args[1], args[2:])
+}
+
+// pregReplaceCallbacks applies each pattern in turn to subject (a string or
+// an array of strings), replacing matches with what the paired callback
+// returns. name is the calling builtin, for warnings; rest holds the
+// optional limit and count reference. An exception thrown by a callback is
+// returned as is.
+func (i *Interpreter) pregReplaceCallbacks(name string, patterns []string, callbacks []runtime.Value, subject runtime.Value, rest []runtime.Value) runtime.Value {
+	limit := -1
+	if len(rest) > 0 {
+		if _, isNull := rest[0].(*runtime.Null); !isNull {
+			limit = int(rest[0].ToInt())
+		}
+	}
+
+	regexes := make([]*regexp.Regexp, len(patterns))
+	for idx, pattern := range patterns {
+		re, err := regexp.Compile(convertPHPRegex(pattern))
+		if err != nil {
+			i.writeOutput(fmt.Sprintf("PHP Warning: %s(): Compilation failed for pattern %s\n", name, pattern))
+			return runtime.NULL
+		}
+		regexes[idx] = re
+	}
+
+	total := 0
+	replace := func(str string) (runtime.Value, *runtime.Exception) {
+		for idx, re := range regexes {
+			var n int
+			var exc *runtime.Exception
+			str, n, exc = i.pregReplaceCallback(re, callbacks[idx], str, limit)
+			if exc != nil {
+				return nil, exc
+			}
+			total += n
+		}
+		return runtime.NewString(str), nil
+	}
+
+	var result runtime.Value
+	if arr, ok := subject.(*runtime.Array); ok {
+		out := runtime.NewArray()
+		for _, key := range arr.Keys {
+			val, exc := replace(arr.Elements[key].ToString())
+			if exc != nil {
+				return exc
+			}
+			out.Set(key, val)
+		}
+		result = out
+	} else {
+		val, exc := replace(subject.ToString())
+		if exc != nil {
+			return exc
+		}
+		result = val
+	}
+
+	if len(rest) > 1 {
+		if ref, ok := rest[1].(*runtime.Reference); ok {
+			ref.Set(runtime.NewInt(int64(total)))
+		}
+	}
+	return result
+}
+
+// pregReplaceCallback replaces up to limit matches of re in subject (all
+// when limit is negative) with the callback's result for the match array,
+// returning the new string and the number of replacements. It stops at the
+// first exception the callback throws and returns it.
+func (i *Interpreter) pregReplaceCallback(re *regexp.Regexp, callback runtime.Value, subject string, limit int) (string, int, *runtime.Exception) {
+	matches := re.FindAllStringSubmatchIndex(subject, limit)
+	if len(matches) == 0 {
+		return subject, 0, nil
+	}
+
+	names := re.SubexpNames()
+	var sb strings.Builder
+	last := 0
+	for _, loc := range matches {
+		// Trailing groups that did not participate are left out, as in PHP
+		groups := len(loc) / 2
+		for groups > 1 && loc[2*(groups-1)] < 0 {
+			groups--
+		}
+		m := runtime.NewArray()
+		for g := 0; g < groups; g++ {
+			text := ""
+			if loc[2*g] >= 0 {
+				text = subject[loc[2*g]:loc[2*g+1]]
+			}
+			if names[g] != "" {
+				m.Set(runtime.NewString(names[g]), runtime.NewString(text))
+			}
+			m.Set(runtime.NewInt(int64(g)), runtime.NewString(text))
+		}
+
+		replacement := i.callCallback(callback, []runtime.Value{m})
+		if exc, ok := replacement.(*runtime.Exception); ok {
+			return "", 0, exc
+		}
+		sb.WriteString(subject[last:loc[0]])
+		sb.WriteString(replacement.ToString())
+		last = loc[1]
+	}
+	sb.WriteString(subject[last:])
+	return sb.String(), len(matches), nil
+}
+
 func builtinPregSplit(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.FALSE
@@ -5493,6 +6927,35 @@ func builtinPregSplit(args ...runtime.Value) runtime.Value {
 	return arr
 }
 
+func builtinPregGrep(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+	pattern := convertPHPRegex(args[0].ToString())
+	arr, ok := args[1].(*runtime.Array)
+	if !ok {
+		return runtime.FALSE
+	}
+	invert := false
+	if len(args) >= 3 {
+		invert = args[2].ToInt()&1 != 0 // PREG_GREP_INVERT
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return runtime.FALSE
+	}
+
+	result := runtime.NewArray()
+	for _, key := range arr.Keys {
+		val := arr.Elements[key]
+		if re.MatchString(val.ToString()) != invert {
+			result.Set(key, val)
+		}
+	}
+	return result
+}
+
 func convertPHPRegex(pattern string) string {
 	// Remove PHP regex delimiters (e.g., /pattern/flags)
 	if len(pattern) >= 2 {
@@ -5500,50 +6963,330 @@ func convertPHPRegex(pattern string) string {
 		if delimiter == '/' || delimiter == '#' || delimiter == '~' {
 			lastDelim := strings.LastIndexByte(pattern, delimiter)
 			if lastDelim > 0 {
-				// Extract pattern without delimiters and flags
+				modifiers := pattern[lastDelim+1:]
 				pattern = pattern[1:lastDelim]
+
+				// Translate the modifiers Go supports into inline flags;
+				// others such as u are ignored
+				flags := ""
+				for _, m := range modifiers {
+					switch m {
+					case 'i', 'm', 's':
+						if !strings.ContainsRune(flags, m) {
+							flags += string(m)
+						}
+					case 'x':
+						pattern = stripExtendedRegex(pattern)
+					}
+				}
+				if flags != "" {
+					pattern = "(?" + flags + ")" + pattern
+				}
 			}
 		}
 	}
 	return pattern
 }
 
+// stripExtendedRegex removes the unescaped whitespace and # comments that
+// the x modifier allows, since Go's regexp has no extended mode
+func stripExtendedRegex(pattern string) string {
+	var sb strings.Builder
+	inClass := false
+	for pos := 0; pos < len(pattern); pos++ {
+		c := pattern[pos]
+		switch {
+		case c == '\\' && pos+1 < len(pattern):
+			sb.WriteByte(c)
+			sb.WriteByte(pattern[pos+1])
+			pos++
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+			sb.WriteByte(c)
+		case c == '[':
+			inClass = true
+			sb.WriteByte(c)
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+		case c == '#':
+			for pos < len(pattern) && pattern[pos] != '\n' {
+				pos++
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
 // ----------------------------------------------------------------------------
 // JSON functions
 
-func builtinJsonEncode(args ...runtime.Value) runtime.Value {
+// json_encode and json_decode option flags
+const (
+	jsonUnescapedSlashes = 64
+	jsonPrettyPrint      = 128
+	jsonUnescapedUnicode = 256
+	jsonThrowOnError     = 4194304
+)
+
+// JSON error codes reported by json_last_error
+const (
+	jsonErrorNone     = 0
+	jsonErrorDepth    = 1
+	jsonErrorSyntax   = 4
+	jsonErrorUTF8      = 5
+	jsonErrorRecursion = 6
+	jsonErrorInfOrNan  = 7
+)
+
+var jsonErrorMessages = map[int64]string{
+	jsonErrorNone:     "No error",
+	jsonErrorDepth:    "Maximum stack depth exceeded",
+	jsonErrorSyntax:   "Syntax error",
+	jsonErrorUTF8:     "Malformed UTF-8 characters, possibly incorrectly encoded",
+	jsonErrorRecursion: "Recursion detected",
+	jsonErrorInfOrNan: "Inf and NaN cannot be JSON encoded",
+}
+
+func (i *Interpreter) builtinJsonLastError(args ...runtime.Value) runtime.Value {
+	return runtime.NewInt(i.jsonLastError)
+}
+
+func (i *Interpreter) builtinJsonLastErrorMsg(args ...runtime.Value) runtime.Value {
+	return runtime.NewString(jsonErrorMessages[i.jsonLastError])
+}
+
+// jsonError records a json_encode/json_decode failure, or with
+// JSON_THROW_ON_ERROR returns the JsonException to throw instead. ok is
+// returned unchanged when no exception is thrown.
+func (i *Interpreter) jsonError(code, options int64, ok runtime.Value) runtime.Value {
+	if options&jsonThrowOnError != 0 {
+		exc := i.splException("JsonException", jsonErrorMessages[code])
+		exc.Code = code
+		return exc
+	}
+	i.jsonLastError = code
+	return ok
+}
+
+// jsonDepth returns the nesting depth of decoded JSON; scalars have depth 0
+func jsonDepth(data interface{}) int {
+	var children []interface{}
+	switch v := data.(type) {
+	case []interface{}:
+		children = v
+	case map[string]interface{}:
+		for _, child := range v {
+			children = append(children, child)
+		}
+	default:
+		return 0
+	}
+	depth := 0
+	for _, child := range children {
+		if d := jsonDepth(child); d > depth {
+			depth = d
+		}
+	}
+	return depth + 1
+}
+
+func (i *Interpreter) builtinJsonEncode(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.FALSE
 	}
+	var options int64
+	if len(args) >= 2 {
+		options = args[1].ToInt()
+	}
+
+	depth := 512
+	if len(args) >= 3 {
+		depth = int(args[2].ToInt())
+	}
 
-	data := valueToInterface(args[0])
-	result, err := json.Marshal(data)
+	if options&jsonThrowOnError == 0 {
+		i.jsonLastError = jsonErrorNone
+	}
+	st := &jsonEncodeState{
+		maxDepth:    depth,
+		active:      make(map[interface{}]bool),
+		serializing: make(map[*runtime.Object]bool),
+	}
+	data := i.valueToInterface(args[0], st)
+	if st.err != jsonErrorNone {
+		return i.jsonError(st.err, options, runtime.FALSE)
+	}
+	result, err := encodeJSON(data, options)
 	if err != nil {
-		return runtime.FALSE
+		// PHP values only fail to encode when a float is INF or NAN
+		return i.jsonError(jsonErrorInfOrNan, options, runtime.FALSE)
 	}
-	return runtime.NewString(string(result))
+	return runtime.NewString(result)
+}
+
+// encodeJSON marshals data the way json_encode formats it: slashes and
+// non-ASCII characters are escaped unless the options say otherwise, and
+// JSON_PRETTY_PRINT indents with four spaces
+func encodeJSON(data interface{}, options int64) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if options&jsonPrettyPrint != 0 {
+		enc.SetIndent("", "    ")
+	}
+	if err := enc.Encode(data); err != nil {
+		return "", err
+	}
+
+	// Slashes and non-ASCII characters only occur inside strings, so they
+	// can be escaped over the whole document
+	result := strings.TrimSuffix(buf.String(), "\n")
+	if options&jsonUnescapedSlashes == 0 {
+		result = strings.ReplaceAll(result, "/", `\/`)
+	}
+	if options&jsonUnescapedUnicode == 0 {
+		var sb strings.Builder
+		for _, r := range result {
+			switch {
+			case r < utf8.RuneSelf:
+				sb.WriteRune(r)
+			case r > 0xFFFF:
+				r1, r2 := utf16.EncodeRune(r)
+				fmt.Fprintf(&sb, "\\u%04x\\u%04x", r1, r2)
+			default:
+				fmt.Fprintf(&sb, "\\u%04x", r)
+			}
+		}
+		result = sb.String()
+	}
+	return result, nil
 }
 
-func builtinJsonDecode(args ...runtime.Value) runtime.Value {
+func (i *Interpreter) builtinJsonDecode(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NULL
 	}
 
+	// json_decode(string $json, ?bool $associative = null, int $depth = 512, int $flags = 0) : mixed
 	jsonStr := args[0].ToString()
 	assoc := false
 	if len(args) >= 2 {
 		assoc = args[1].ToBool()
 	}
-
-	var data interface{}
-	if err := json.Unmarshal([]byte(jsonStr), &data); err != nil {
-		return runtime.NULL
+	depth := 512
+	if len(args) >= 3 {
+		depth = int(args[2].ToInt())
 	}
-
+	var options int64
+	if len(args) >= 4 {
+		options = args[3].ToInt()
+	}
+	if depth <= 0 {
+		return i.splException("ValueError", "json_decode(): Argument #3 ($depth) must be greater than 0")
+	}
+
+	if options&jsonThrowOnError == 0 {
+		i.jsonLastError = jsonErrorNone
+	}
+	if !utf8.ValidString(jsonStr) {
+		return i.jsonError(jsonErrorUTF8, options, runtime.NULL)
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(jsonStr), &data); err != nil {
+		return i.jsonError(jsonErrorSyntax, options, runtime.NULL)
+	}
+	if jsonDepth(data) > depth {
+		return i.jsonError(jsonErrorDepth, options, runtime.NULL)
+	}
+
 	return interfaceToValue(data, assoc)
 }
 
-func valueToInterface(v runtime.Value) interface{} {
+// jsonEncodeState tracks how deep json_encode is and which arrays and
+// objects it is inside, so recursion and excess depth fail the encode
+// instead of overflowing the stack
+type jsonEncodeState struct {
+	maxDepth    int
+	depth       int
+	active      map[interface{}]bool
+	serializing map[*runtime.Object]bool // objects inside their jsonSerialize()
+	err         int64
+}
+
+// enter records that the array or object v is being encoded, failing on
+// recursion or when the depth limit is passed. leave must follow a
+// successful enter.
+func (st *jsonEncodeState) enter(v interface{}) bool {
+	switch {
+	case st.active[v]:
+		st.err = jsonErrorRecursion
+	case st.depth >= st.maxDepth:
+		st.err = jsonErrorDepth
+	default:
+		st.active[v] = true
+		st.depth++
+		return true
+	}
+	return false
+}
+
+func (st *jsonEncodeState) leave(v interface{}) {
+	delete(st.active, v)
+	st.depth--
+}
+
+// jsonObject is a JSON object that keeps its keys in PHP order rather
+// than the sorted order json.Marshal gives maps
+type jsonObject struct {
+	keys   []string
+	values []interface{}
+	index  map[string]int // Position of each key in keys
+}
+
+func (o *jsonObject) set(key string, value interface{}) {
+	if idx, ok := o.index[key]; ok {
+		o.values[idx] = value
+		return
+	}
+	if o.index == nil {
+		o.index = make(map[string]int)
+	}
+	o.index[key] = len(o.keys)
+	o.keys = append(o.keys, key)
+	o.values = append(o.values, value)
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for idx, key := range o.keys {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(key); err != nil {
+			return nil, err
+		}
+		buf.Truncate(buf.Len() - 1) // Encode's trailing newline
+		buf.WriteByte(':')
+		if err := enc.Encode(o.values[idx]); err != nil {
+			return nil, err
+		}
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// valueToInterface converts a PHP value into a Go value suitable for json.Marshal.
+// Objects implementing JsonSerializable are encoded via jsonSerialize(); other
+// objects are encoded as a JSON object of their public properties. On
+// failure st.err is set and nil returned.
+func (i *Interpreter) valueToInterface(v runtime.Value, st *jsonEncodeState) interface{} {
 	switch val := v.(type) {
 	case *runtime.Null:
 		return nil
@@ -5555,11 +7298,18 @@ func valueToInterface(v runtime.Value) interface{} {
 		return val.Value
 	case *runtime.String:
 		return val.Value
+	case *runtime.Reference:
+		return i.valueToInterface(val.Deref(), st)
 	case *runtime.Array:
+		if !st.enter(val) {
+			return nil
+		}
+		defer st.leave(val)
+
 		// Check if it's a sequential array or associative
 		isSequential := true
-		for i, key := range val.Keys {
-			if intKey, ok := key.(*runtime.Int); !ok || intKey.Value != int64(i) {
+		for idx, key := range val.Keys {
+			if intKey, ok := key.(*runtime.Int); !ok || intKey.Value != int64(idx) {
 				isSequential = false
 				break
 			}
@@ -5567,15 +7317,51 @@ func valueToInterface(v runtime.Value) interface{} {
 
 		if isSequential {
 			result := make([]interface{}, len(val.Keys))
-			for i, key := range val.Keys {
-				result[i] = valueToInterface(val.Elements[key])
+			for idx, key := range val.Keys {
+				result[idx] = i.valueToInterface(val.Elements[key], st)
 			}
 			return result
 		}
 
-		result := make(map[string]interface{})
+		result := &jsonObject{}
 		for _, key := range val.Keys {
-			result[key.ToString()] = valueToInterface(val.Elements[key])
+			result.set(key.ToString(), i.valueToInterface(val.Elements[key], st))
+		}
+		return result
+	case *runtime.Object:
+		if i.implementsInterface(val.Class, "JsonSerializable") {
+			// When jsonSerialize() returns $this it is encoded as a plain object
+			if !st.serializing[val] {
+				st.serializing[val] = true
+				defer delete(st.serializing, val)
+				return i.valueToInterface(i.callMethod(val, "jsonSerialize", nil), st)
+			}
+		}
+		if !st.enter(val) {
+			return nil
+		}
+		defer st.leave(val)
+
+		// Declared public properties come first in declaration order,
+		// then the rest in the order they were set
+		result := &jsonObject{}
+		for _, name := range val.Class.PropertyNames() {
+			propDef := val.Class.Properties[name]
+			if !propDef.IsPublic || propDef.IsStatic {
+				continue
+			}
+			// Declared properties without a default are null until assigned
+			var value interface{}
+			if prop, ok := val.Properties[name]; ok {
+				value = i.valueToInterface(prop, st)
+			}
+			result.set(name, value)
+		}
+		for _, name := range val.PropertyNames() {
+			if findPropertyDef(val.Class, name) != nil {
+				continue
+			}
+			result.set(name, i.valueToInterface(val.Properties[name], st))
 		}
 		return result
 	default:
@@ -5583,6 +7369,17 @@ func valueToInterface(v runtime.Value) interface{} {
 	}
 }
 
+// findPropertyDef looks up a declared property on a class or its ancestors.
+// It returns nil for dynamic (undeclared) properties.
+func findPropertyDef(class *runtime.Class, name string) *runtime.PropertyDef {
+	for c := class; c != nil; c = c.Parent {
+		if propDef, ok := c.Properties[name]; ok {
+			return propDef
+		}
+	}
+	return nil
+}
+
 func interfaceToValue(data interface{}, assoc bool) runtime.Value {
 	switch v := data.(type) {
 	case nil:
@@ -5623,38 +7420,77 @@ func (i *Interpreter) builtinSerialize(args ...runtime.Value) runtime.Value {
 	return runtime.NewString(i.serializeValue(args[0]))
 }
 
+// serializeState numbers serialized values the way PHP does so repeated
+// objects can be written as r:N; back-references
+type serializeState struct {
+	slots   int
+	objects map[*runtime.Object]int
+	arrays  map[*runtime.Array]bool // arrays currently being written
+}
+
 func (i *Interpreter) serializeValue(v runtime.Value) string {
+	st := &serializeState{
+		objects: make(map[*runtime.Object]int),
+		arrays:  make(map[*runtime.Array]bool),
+	}
+	var sb strings.Builder
+	i.writeSerialized(&sb, v, st)
+	return sb.String()
+}
+
+// writeSerialized appends v to sb; every value (but not array keys or
+// property names) takes up one back-reference slot
+func (i *Interpreter) writeSerialized(sb *strings.Builder, v runtime.Value, st *serializeState) {
+	st.slots++
 	switch val := v.(type) {
-	case *runtime.Null:
-		return "N;"
 	case *runtime.Bool:
 		if val.Value {
-			return "b:1;"
+			sb.WriteString("b:1;")
+		} else {
+			sb.WriteString("b:0;")
 		}
-		return "b:0;"
 	case *runtime.Int:
-		return fmt.Sprintf("i:%d;", val.Value)
+		fmt.Fprintf(sb, "i:%d;", val.Value)
 	case *runtime.Float:
-		return fmt.Sprintf("d:%s;", strconv.FormatFloat(val.Value, 'G', -1, 64))
+		fmt.Fprintf(sb, "d:%s;", phpFloatRepr(val.Value, false))
 	case *runtime.String:
-		return fmt.Sprintf("s:%d:\"%s\";", len(val.Value), val.Value)
+		writeSerializedString(sb, val.Value)
 	case *runtime.Array:
-		var sb strings.Builder
-		sb.WriteString(fmt.Sprintf("a:%d:{", val.Len()))
+		if st.arrays[val] {
+			// A cyclic array cannot be expressed without references
+			sb.WriteString("N;")
+			return
+		}
+		st.arrays[val] = true
+		defer delete(st.arrays, val)
+
+		fmt.Fprintf(sb, "a:%d:{", val.Len())
 		for _, key := range val.Keys {
-			sb.WriteString(i.serializeValue(key))
-			sb.WriteString(i.serializeValue(val.Elements[key]))
+			if k, ok := key.(*runtime.Int); ok {
+				fmt.Fprintf(sb, "i:%d;", k.Value)
+			} else {
+				writeSerializedString(sb, key.ToString())
+			}
+			i.writeSerialized(sb, val.Elements[key], st)
 		}
 		sb.WriteString("}")
-		return sb.String()
 	case *runtime.Object:
-		return i.serializeObject(val)
+		if slot, seen := st.objects[val]; seen {
+			fmt.Fprintf(sb, "r:%d;", slot)
+			return
+		}
+		st.objects[val] = st.slots
+		i.serializeObject(sb, val, st)
 	default:
-		return "N;"
+		sb.WriteString("N;")
 	}
 }
 
-func (i *Interpreter) serializeObject(obj *runtime.Object) string {
+func writeSerializedString(sb *strings.Builder, s string) {
+	fmt.Fprintf(sb, "s:%d:\"%s\";", len(s), s)
+}
+
+func (i *Interpreter) serializeObject(sb *strings.Builder, obj *runtime.Object, st *serializeState) {
 	className := obj.Class.Name
 
 	// Check for __sleep magic method
@@ -5673,18 +7509,17 @@ func (i *Interpreter) serializeObject(obj *runtime.Object) string {
 		}
 	}
 
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("O:%d:\"%s\":%d:{", len(className), className, len(propsToSerialize)))
+	fmt.Fprintf(sb, "O:%d:\"%s\":%d:{", len(className), className, len(propsToSerialize))
 	for _, propName := range propsToSerialize {
-		sb.WriteString(i.serializeValue(runtime.NewString(propName)))
+		writeSerializedString(sb, propName)
 		if val, ok := obj.Properties[propName]; ok {
-			sb.WriteString(i.serializeValue(val))
+			i.writeSerialized(sb, val, st)
 		} else {
+			st.slots++
 			sb.WriteString("N;")
 		}
 	}
 	sb.WriteString("}")
-	return sb.String()
 }
 
 func (i *Interpreter) builtinUnserialize(args ...runtime.Value) runtime.Value {
@@ -5692,116 +7527,224 @@ func (i *Interpreter) builtinUnserialize(args ...runtime.Value) runtime.Value {
 		return runtime.FALSE
 	}
 	data := args[0].ToString()
-	result, _ := i.unserializeValue(data, 0)
+	result, _, ok := i.unserializeValue(data, 0, &unserializeState{})
+	if !ok {
+		return runtime.FALSE
+	}
 	return result
 }
 
-func (i *Interpreter) unserializeValue(data string, pos int) (runtime.Value, int) {
+// unserializeState records every unserialized value by slot number so r:N;
+// and R:N; back-references can be resolved
+type unserializeState struct {
+	values []runtime.Value
+}
+
+// backReference resolves the 1-based slot of an r:/R: token
+func (st *unserializeState) backReference(slot int) (runtime.Value, bool) {
+	if st == nil || slot < 1 || slot > len(st.values) || st.values[slot-1] == nil {
+		return nil, false
+	}
+	return st.values[slot-1], true
+}
+
+// unserializeValue decodes the value at pos, reporting false on malformed
+// input. Array keys and property names are decoded with a nil state since
+// they don't take up a slot.
+func (i *Interpreter) unserializeValue(data string, pos int, st *unserializeState) (runtime.Value, int, bool) {
 	if pos >= len(data) {
-		return runtime.FALSE, pos
+		return nil, pos, false
+	}
+
+	slot := -1
+	if st != nil && data[pos] != 'R' {
+		slot = len(st.values)
+		st.values = append(st.values, nil)
+	}
+	result, pos, ok := i.unserializeToken(data, pos, st, slot)
+	if ok && slot >= 0 {
+		st.values[slot] = result
+	}
+	return result, pos, ok
+}
+
+// unserializeField reads the text between pos and the next occurrence of
+// sep, returning it along with the position just past sep
+func unserializeField(data string, pos int, sep byte) (string, int, bool) {
+	if pos > len(data) {
+		return "", pos, false
+	}
+	end := strings.IndexByte(data[pos:], sep)
+	if end == -1 {
+		return "", pos, false
+	}
+	return data[pos : pos+end], pos + end + 1, true
+}
+
+// unserializeLength reads a non-negative decimal length terminated by sep
+func unserializeLength(data string, pos int, sep byte) (int, int, bool) {
+	field, pos, ok := unserializeField(data, pos, sep)
+	if !ok {
+		return 0, pos, false
+	}
+	n, err := strconv.Atoi(field)
+	if err != nil || n < 0 {
+		return 0, pos, false
+	}
+	return n, pos, true
+}
+
+// unserializeQuoted reads a "..." string of exactly length bytes at pos
+func unserializeQuoted(data string, pos int, length int) (string, int, bool) {
+	if length > len(data) || pos+length+2 > len(data) || data[pos] != '"' || data[pos+length+1] != '"' {
+		return "", pos, false
+	}
+	return data[pos+1 : pos+1+length], pos + length + 2, true
+}
+
+// unserializeExpect checks that data has the literal s at pos
+func unserializeExpect(data string, pos int, s string) (int, bool) {
+	if !strings.HasPrefix(data[pos:], s) {
+		return pos, false
+	}
+	return pos + len(s), true
+}
+
+func (i *Interpreter) unserializeToken(data string, pos int, st *unserializeState, slot int) (runtime.Value, int, bool) {
+	if pos+1 >= len(data) || (data[pos] != 'N' && data[pos+1] != ':') {
+		return nil, pos, false
 	}
 
 	switch data[pos] {
 	case 'N':
 		// N;
-		return runtime.NULL, pos + 2
+		pos, ok := unserializeExpect(data, pos, "N;")
+		return runtime.NULL, pos, ok
 	case 'b':
 		// b:0; or b:1;
-		if pos+3 < len(data) {
-			val := data[pos+2] == '1'
-			return runtime.NewBool(val), pos + 4
+		if pos, ok := unserializeExpect(data, pos, "b:1;"); ok {
+			return runtime.TRUE, pos, true
 		}
-		return runtime.FALSE, pos
+		pos, ok := unserializeExpect(data, pos, "b:0;")
+		return runtime.FALSE, pos, ok
 	case 'i':
 		// i:123;
-		pos += 2 // skip "i:"
-		end := strings.Index(data[pos:], ";")
-		if end == -1 {
-			return runtime.FALSE, pos
+		field, pos, ok := unserializeField(data, pos+2, ';')
+		if !ok {
+			return nil, pos, false
+		}
+		num, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, pos, false
 		}
-		num, _ := strconv.ParseInt(data[pos:pos+end], 10, 64)
-		return runtime.NewInt(num), pos + end + 1
+		return runtime.NewInt(num), pos, true
 	case 'd':
 		// d:1.5;
-		pos += 2 // skip "d:"
-		end := strings.Index(data[pos:], ";")
-		if end == -1 {
-			return runtime.FALSE, pos
+		field, pos, ok := unserializeField(data, pos+2, ';')
+		if !ok {
+			return nil, pos, false
 		}
-		num, _ := strconv.ParseFloat(data[pos:pos+end], 64)
-		return runtime.NewFloat(num), pos + end + 1
+		num, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, pos, false
+		}
+		return runtime.NewFloat(num), pos, true
 	case 's':
 		// s:5:"hello";
-		pos += 2 // skip "s:"
-		colonPos := strings.Index(data[pos:], ":")
-		if colonPos == -1 {
-			return runtime.FALSE, pos
-		}
-		length, _ := strconv.Atoi(data[pos : pos+colonPos])
-		pos += colonPos + 2 // skip length, :, and opening "
-		str := data[pos : pos+length]
-		return runtime.NewString(str), pos + length + 2 // +2 for closing ";
+		length, pos, ok := unserializeLength(data, pos+2, ':')
+		if !ok {
+			return nil, pos, false
+		}
+		str, pos, ok := unserializeQuoted(data, pos, length)
+		if !ok {
+			return nil, pos, false
+		}
+		pos, ok = unserializeExpect(data, pos, ";")
+		return runtime.NewString(str), pos, ok
+	case 'r', 'R':
+		// r:1; or R:1;
+		ref, pos, ok := unserializeLength(data, pos+2, ';')
+		if !ok {
+			return nil, pos, false
+		}
+		val, ok := st.backReference(ref)
+		return val, pos, ok
 	case 'a':
 		// a:2:{...}
-		return i.unserializeArray(data, pos)
+		return i.unserializeArray(data, pos, st)
 	case 'O':
 		// O:8:"ClassName":2:{...}
-		return i.unserializeObject(data, pos)
+		return i.unserializeObject(data, pos, st, slot)
 	default:
-		return runtime.FALSE, pos + 1
+		return nil, pos, false
 	}
 }
 
-func (i *Interpreter) unserializeArray(data string, pos int) (runtime.Value, int) {
-	pos += 2 // skip "a:"
-	colonPos := strings.Index(data[pos:], ":")
-	if colonPos == -1 {
-		return runtime.FALSE, pos
+func (i *Interpreter) unserializeArray(data string, pos int, st *unserializeState) (runtime.Value, int, bool) {
+	count, pos, ok := unserializeLength(data, pos+2, ':')
+	if !ok {
+		return nil, pos, false
+	}
+	if pos, ok = unserializeExpect(data, pos, "{"); !ok {
+		return nil, pos, false
 	}
-	count, _ := strconv.Atoi(data[pos : pos+colonPos])
-	pos += colonPos + 2 // skip count, :, and {
 
 	arr := runtime.NewArray()
 	for idx := 0; idx < count; idx++ {
 		var key, val runtime.Value
-		key, pos = i.unserializeValue(data, pos)
-		val, pos = i.unserializeValue(data, pos)
+		key, pos, ok = i.unserializeValue(data, pos, nil)
+		if !ok {
+			return nil, pos, false
+		}
+		switch key.(type) {
+		case *runtime.Int, *runtime.String:
+		default:
+			return nil, pos, false
+		}
+		val, pos, ok = i.unserializeValue(data, pos, st)
+		if !ok {
+			return nil, pos, false
+		}
 		arr.Set(key, val)
 	}
-	return arr, pos + 1 // +1 for closing }
+	pos, ok = unserializeExpect(data, pos, "}")
+	return arr, pos, ok
 }
 
-func (i *Interpreter) unserializeObject(data string, pos int) (runtime.Value, int) {
-	pos += 2 // skip "O:"
-
-	// Get class name length
-	colonPos := strings.Index(data[pos:], ":")
-	if colonPos == -1 {
-		return runtime.FALSE, pos
+func (i *Interpreter) unserializeObject(data string, pos int, st *unserializeState, slot int) (runtime.Value, int, bool) {
+	nameLen, pos, ok := unserializeLength(data, pos+2, ':')
+	if !ok {
+		return nil, pos, false
 	}
-	nameLen, _ := strconv.Atoi(data[pos : pos+colonPos])
-	pos += colonPos + 2 // skip length, :, and opening "
-
-	className := data[pos : pos+nameLen]
-	pos += nameLen + 2 // skip name and closing "
-
-	// Get property count
-	colonPos = strings.Index(data[pos:], ":")
-	if colonPos == -1 {
-		return runtime.FALSE, pos
+	className, pos, ok := unserializeQuoted(data, pos, nameLen)
+	if !ok {
+		return nil, pos, false
+	}
+	if pos, ok = unserializeExpect(data, pos, ":"); !ok {
+		return nil, pos, false
+	}
+	propCount, pos, ok := unserializeLength(data, pos, ':')
+	if !ok {
+		return nil, pos, false
+	}
+	if pos, ok = unserializeExpect(data, pos, "{"); !ok {
+		return nil, pos, false
 	}
-	propCount, _ := strconv.Atoi(data[pos : pos+colonPos])
-	pos += colonPos + 2 // skip count, :, and {
 
 	// Get the class
 	class, ok := i.env.GetClass(className)
 	if !ok {
-		return runtime.FALSE, pos
+		return nil, pos, false
 	}
 
 	// Create object
 	obj := runtime.NewObject(class)
 
+	// Register the object before its properties so they can refer back to it
+	if st != nil && slot >= 0 {
+		st.values[slot] = obj
+	}
+
 	// Initialize default properties
 	for propName, propDef := range class.Properties {
 		if propDef.Default != nil {
@@ -5812,18 +7755,29 @@ func (i *Interpreter) unserializeObject(data string, pos int) (runtime.Value, in
 	// Read serialized properties
 	for idx := 0; idx < propCount; idx++ {
 		var propName, propVal runtime.Value
-		propName, pos = i.unserializeValue(data, pos)
-		propVal, pos = i.unserializeValue(data, pos)
+		propName, pos, ok = i.unserializeValue(data, pos, nil)
+		if !ok {
+			return nil, pos, false
+		}
+		if _, isString := propName.(*runtime.String); !isString {
+			return nil, pos, false
+		}
+		propVal, pos, ok = i.unserializeValue(data, pos, st)
+		if !ok {
+			return nil, pos, false
+		}
 		obj.Properties[propName.ToString()] = propVal
 	}
-	pos++ // skip closing }
+	if pos, ok = unserializeExpect(data, pos, "}"); !ok {
+		return nil, pos, false
+	}
 
 	// Call __wakeup if it exists
 	if wakeupMethod, _ := i.findMethod(class, "__wakeup"); wakeupMethod != nil {
 		i.callArrayAccessMethod(obj, "__wakeup", []runtime.Value{})
 	}
 
-	return obj, pos
+	return obj, pos, true
 }
 
 // ----------------------------------------------------------------------------
@@ -5961,6 +7915,137 @@ func builtinIsFile(args ...runtime.Value) runtime.Value {
 	return runtime.NewBool(!info.IsDir())
 }
 
+// fileStat holds the fields of a PHP stat() result
+type fileStat struct {
+	Dev, Ino, Mode, Nlink, UID, GID, Rdev int64
+	Size, Atime, Mtime, Ctime             int64
+	Blksize, Blocks                       int64
+}
+
+// newFileStat builds a fileStat from info, using the platform stat fields
+// when they are available.
+func newFileStat(info os.FileInfo) *fileStat {
+	mode := int64(info.Mode().Perm())
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		mode |= 0120000
+	case info.IsDir():
+		mode |= 0040000
+	default:
+		mode |= 0100000
+	}
+
+	st := &fileStat{
+		Mode:    mode,
+		Nlink:   1,
+		Size:    info.Size(),
+		Atime:   info.ModTime().Unix(),
+		Mtime:   info.ModTime().Unix(),
+		Ctime:   info.ModTime().Unix(),
+		Blksize: -1,
+		Blocks:  -1,
+	}
+	sysFileStat(info, st)
+	return st
+}
+
+// toArray returns the stat array with both numeric and named keys.
+func (st *fileStat) toArray() *runtime.Array {
+	names := []string{"dev", "ino", "mode", "nlink", "uid", "gid", "rdev", "size", "atime", "mtime", "ctime", "blksize", "blocks"}
+	values := []int64{st.Dev, st.Ino, st.Mode, st.Nlink, st.UID, st.GID, st.Rdev, st.Size, st.Atime, st.Mtime, st.Ctime, st.Blksize, st.Blocks}
+
+	result := runtime.NewArray()
+	for idx, v := range values {
+		result.Set(runtime.NewInt(int64(idx)), runtime.NewInt(v))
+	}
+	for idx, name := range names {
+		result.Set(runtime.NewString(name), runtime.NewInt(values[idx]))
+	}
+	return result
+}
+
+func (i *Interpreter) builtinStat(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	filename := args[0].ToString()
+	info, err := os.Stat(filename)
+	if err != nil {
+		i.writeOutput(fmt.Sprintf("PHP Warning: stat(): stat failed for %s\n", filename))
+		return runtime.FALSE
+	}
+	return newFileStat(info).toArray()
+}
+
+func (i *Interpreter) builtinLstat(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	filename := args[0].ToString()
+	info, err := os.Lstat(filename)
+	if err != nil {
+		i.writeOutput(fmt.Sprintf("PHP Warning: lstat(): Lstat failed for %s\n", filename))
+		return runtime.FALSE
+	}
+	return newFileStat(info).toArray()
+}
+
+// fileStatFunc returns the builtin for one of the single-field stat
+// functions such as filesize or filemtime.
+func (i *Interpreter) fileStatFunc(name string) runtime.BuiltinFunc {
+	return func(args ...runtime.Value) runtime.Value {
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		filename := args[0].ToString()
+		info, err := os.Stat(filename)
+		if err != nil {
+			i.writeOutput(fmt.Sprintf("PHP Warning: %s(): stat failed for %s\n", name, filename))
+			return runtime.FALSE
+		}
+
+		st := newFileStat(info)
+		switch name {
+		case "filesize":
+			return runtime.NewInt(st.Size)
+		case "filemtime":
+			return runtime.NewInt(st.Mtime)
+		case "fileatime":
+			return runtime.NewInt(st.Atime)
+		case "filectime":
+			return runtime.NewInt(st.Ctime)
+		case "fileperms":
+			return runtime.NewInt(st.Mode)
+		case "fileowner":
+			return runtime.NewInt(st.UID)
+		case "filegroup":
+			return runtime.NewInt(st.GID)
+		case "fileinode":
+			return runtime.NewInt(st.Ino)
+		}
+		return runtime.FALSE
+	}
+}
+
+func builtinFstat(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	res, ok := args[0].(*runtime.Resource)
+	if !ok {
+		return runtime.FALSE
+	}
+	file, ok := res.Handle.(*os.File)
+	if !ok {
+		return runtime.FALSE
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return runtime.FALSE
+	}
+	return newFileStat(info).toArray()
+}
+
 func builtinIsDir(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.FALSE
@@ -6121,6 +8206,146 @@ func builtinGlob(args ...runtime.Value) runtime.Value {
 	return arr
 }
 
+// fnmatch flags
+const (
+	fnmPathname = 1
+	fnmNoescape = 2
+	fnmPeriod   = 4
+	fnmCasefold = 16
+)
+
+func builtinFnmatch(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+	pattern := args[0].ToString()
+	name := args[1].ToString()
+	var flags int64
+	if len(args) >= 3 {
+		flags = args[2].ToInt()
+	}
+
+	if flags&fnmCasefold != 0 {
+		pattern = strings.ToLower(pattern)
+		name = strings.ToLower(name)
+	}
+	return runtime.NewBool(fnmatch(pattern, name, flags, true))
+}
+
+// fnmatch matches name against a shell wildcard pattern following POSIX
+// fnmatch(3). Unlike filepath.Match, wildcards only stop at '/' when
+// FNM_PATHNAME is set. atStart reports whether name begins a path component,
+// which matters for FNM_PERIOD.
+func fnmatch(pattern, name string, flags int64, atStart bool) bool {
+	pathname := flags&fnmPathname != 0
+	leadingPeriod := func(pos int) bool {
+		if flags&fnmPeriod == 0 || pos >= len(name) || name[pos] != '.' {
+			return false
+		}
+		return (pos == 0 && atStart) || (pathname && pos > 0 && name[pos-1] == '/')
+	}
+
+	p, n := 0, 0
+	for p < len(pattern) {
+		c := pattern[p]
+		switch c {
+		case '*':
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			if leadingPeriod(n) {
+				return false
+			}
+			// Try every possible length for the star
+			for k := n; k <= len(name); k++ {
+				if fnmatch(pattern[p:], name[k:], flags, (k == 0 && atStart) || (k > 0 && pathname && name[k-1] == '/')) {
+					return true
+				}
+				if k < len(name) && pathname && name[k] == '/' {
+					break
+				}
+			}
+			return false
+		case '?':
+			if n >= len(name) || (pathname && name[n] == '/') || leadingPeriod(n) {
+				return false
+			}
+			p++
+			n++
+		case '[':
+			if n >= len(name) || (pathname && name[n] == '/') || leadingPeriod(n) {
+				return false
+			}
+			matched, next, ok := fnmatchClass(pattern, p, name[n], flags)
+			if !ok {
+				// An unterminated bracket matches a literal '['
+				if name[n] != '[' {
+					return false
+				}
+				p++
+				n++
+				continue
+			}
+			if !matched {
+				return false
+			}
+			p = next
+			n++
+		default:
+			if c == '\\' && flags&fnmNoescape == 0 && p+1 < len(pattern) {
+				p++
+				c = pattern[p]
+			}
+			if n >= len(name) || name[n] != c {
+				return false
+			}
+			p++
+			n++
+		}
+	}
+	return n == len(name)
+}
+
+// fnmatchClass matches c against the bracket expression starting at
+// pattern[start]. It returns whether c matched, the index after the closing
+// ']', and false if the bracket is unterminated.
+func fnmatchClass(pattern string, start int, c byte, flags int64) (bool, int, bool) {
+	p := start + 1
+	negate := false
+	if p < len(pattern) && (pattern[p] == '!' || pattern[p] == '^') {
+		negate = true
+		p++
+	}
+
+	matched := false
+	first := true
+	for p < len(pattern) && (first || pattern[p] != ']') {
+		first = false
+		lo := pattern[p]
+		if lo == '\\' && flags&fnmNoescape == 0 && p+1 < len(pattern) {
+			p++
+			lo = pattern[p]
+		}
+		p++
+		hi := lo
+		if p+1 < len(pattern) && pattern[p] == '-' && pattern[p+1] != ']' {
+			hi = pattern[p+1]
+			if hi == '\\' && flags&fnmNoescape == 0 && p+2 < len(pattern) {
+				p++
+				hi = pattern[p+1]
+			}
+			p += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	if p >= len(pattern) {
+		return false, 0, false
+	}
+	return matched != negate, p + 1, true
+}
+
 func builtinGetenv(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.FALSE
@@ -6767,23 +8992,106 @@ func builtinSha1(args ...runtime.Value) runtime.Value {
 	return runtime.NewString(hex.EncodeToString(hash[:]))
 }
 
-func builtinHash(args ...runtime.Value) runtime.Value {
-	if len(args) < 2 {
-		return runtime.FALSE
+// hashAlgorithms maps the algorithm names accepted by hash() and
+// hash_file() to their implementations
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+	"crc32":  newCRC32BZip2,
+	"crc32b": func() hash.Hash { return crc32.NewIEEE() },
+	"crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+}
+
+// crc32BZip2Table is the MSB-first table for polynomial 0x04C11DB7 used by
+// PHP's "crc32" hash algorithm
+var crc32BZip2Table = func() (table [256]uint32) {
+	for n := range table {
+		c := uint32(n) << 24
+		for k := 0; k < 8; k++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		table[n] = c
 	}
-	algo := strings.ToLower(args[0].ToString())
-	data := args[1].ToString()
+	return
+}()
 
-	switch algo {
-	case "md5":
-		hash := md5.Sum([]byte(data))
-		return runtime.NewString(hex.EncodeToString(hash[:]))
-	case "sha1":
-		hash := sha1.Sum([]byte(data))
-		return runtime.NewString(hex.EncodeToString(hash[:]))
-	default:
+// crc32BZip2 implements PHP's "crc32" hash: the bzip2 CRC, with the digest
+// written least significant byte first
+type crc32BZip2 struct {
+	crc uint32
+}
+
+func newCRC32BZip2() hash.Hash {
+	return &crc32BZip2{crc: 0xFFFFFFFF}
+}
+
+func (c *crc32BZip2) Write(p []byte) (int, error) {
+	for _, b := range p {
+		c.crc = c.crc<<8 ^ crc32BZip2Table[byte(c.crc>>24)^b]
+	}
+	return len(p), nil
+}
+
+func (c *crc32BZip2) Sum(b []byte) []byte {
+	s := ^c.crc
+	return append(b, byte(s), byte(s>>8), byte(s>>16), byte(s>>24))
+}
+
+func (c *crc32BZip2) Reset()         { c.crc = 0xFFFFFFFF }
+func (c *crc32BZip2) Size() int      { return 4 }
+func (c *crc32BZip2) BlockSize() int { return 1 }
+
+// hashDigest formats a digest as hex, or as raw bytes when binary is set
+func hashDigest(h hash.Hash, args []runtime.Value, binaryIdx int) runtime.Value {
+	sum := h.Sum(nil)
+	if len(args) > binaryIdx && args[binaryIdx].ToBool() {
+		return runtime.NewString(string(sum))
+	}
+	return runtime.NewString(hex.EncodeToString(sum))
+}
+
+func builtinHash(args ...runtime.Value) runtime.Value {
+	// hash(string $algo, string $data, bool $binary = false) : string
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+	newHash, ok := hashAlgorithms[strings.ToLower(args[0].ToString())]
+	if !ok {
+		return runtime.FALSE
+	}
+	h := newHash()
+	h.Write([]byte(args[1].ToString()))
+	return hashDigest(h, args, 2)
+}
+
+func builtinHashFile(args ...runtime.Value) runtime.Value {
+	// hash_file(string $algo, string $filename, bool $binary = false) : string|false
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+	newHash, ok := hashAlgorithms[strings.ToLower(args[0].ToString())]
+	if !ok {
+		return runtime.FALSE
+	}
+	f, err := os.Open(args[1].ToString())
+	if err != nil {
+		return runtime.FALSE
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
 		return runtime.FALSE
 	}
+	return hashDigest(h, args, 2)
 }
 
 func builtinCrc32(args ...runtime.Value) runtime.Value {
@@ -6920,16 +9228,58 @@ func builtinBase64Encode(args ...runtime.Value) runtime.Value {
 }
 
 func builtinBase64Decode(args ...runtime.Value) runtime.Value {
+	// base64_decode(string $string, bool $strict = false) : string|false
 	if len(args) < 1 {
 		return runtime.FALSE
 	}
-	decoded, err := base64.StdEncoding.DecodeString(args[0].ToString())
-	if err != nil {
+	strict := len(args) > 1 && args[1].ToBool()
+	decoded, ok := decodeBase64(args[0].ToString(), strict)
+	if !ok {
 		return runtime.FALSE
 	}
 	return runtime.NewString(string(decoded))
 }
 
+// decodeBase64 decodes like PHP: padding is optional and whitespace is
+// skipped. Other characters outside the alphabet are skipped unless strict,
+// in which case they, data after padding, and bad padding are rejected.
+func decodeBase64(s string, strict bool) ([]byte, bool) {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	var data strings.Builder
+	padding := 0
+	for idx := 0; idx < len(s); idx++ {
+		ch := s[idx]
+		switch {
+		case ch == '=':
+			padding++
+		case strings.IndexByte(alphabet, ch) >= 0:
+			if strict && padding > 0 {
+				return nil, false
+			}
+			data.WriteByte(ch)
+		case !strict:
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+		default:
+			return nil, false
+		}
+	}
+
+	n := data.Len()
+	if strict && (n%4 == 1 || (padding > 0 && (padding > 2 || (n+padding)%4 != 0))) {
+		return nil, false
+	}
+	encoded := data.String()
+	if n%4 == 1 {
+		// A lone trailing character carries no complete byte
+		encoded = encoded[:n-1]
+	}
+	decoded, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
 func builtinBin2hex(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NewString("")
@@ -7213,6 +9563,354 @@ func builtinGzinflate(args ...runtime.Value) runtime.Value {
 	return runtime.NewString(string(result))
 }
 
+// gzStream is the handle behind resources returned by gzopen. Exactly one of
+// reader and writer is set, depending on the open mode.
+type gzStream struct {
+	file   *os.File
+	gz     *gzip.Reader
+	reader *bufio.Reader
+	writer *gzip.Writer
+}
+
+// openGzReader positions the reader at the start of the file, decompressing
+// it if it has a gzip header and reading it as-is otherwise, as zlib does.
+func (g *gzStream) openGzReader() error {
+	if _, err := g.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	buffered := bufio.NewReader(g.file)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		g.gz = gz
+		g.reader = bufio.NewReader(gz)
+		return nil
+	}
+	g.gz = nil
+	g.reader = buffered
+	return nil
+}
+
+func gzStreamArg(args []runtime.Value) (*gzStream, bool) {
+	if len(args) < 1 {
+		return nil, false
+	}
+	res, ok := args[0].(*runtime.Resource)
+	if !ok {
+		return nil, false
+	}
+	stream, ok := res.Handle.(*gzStream)
+	return stream, ok
+}
+
+func (i *Interpreter) builtinGzopen(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+
+	filename := args[0].ToString()
+	mode := args[1].ToString()
+
+	stream := &gzStream{}
+	switch {
+	case strings.ContainsAny(mode, "wa"):
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if strings.Contains(mode, "a") {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := os.OpenFile(filename, flag, 0666)
+		if err != nil {
+			return runtime.FALSE
+		}
+		level := gzip.DefaultCompression
+		if idx := strings.IndexAny(mode, "0123456789"); idx >= 0 {
+			level = int(mode[idx] - '0')
+		}
+		writer, err := gzip.NewWriterLevel(file, level)
+		if err != nil {
+			file.Close()
+			return runtime.FALSE
+		}
+		stream.file = file
+		stream.writer = writer
+	case strings.Contains(mode, "r"):
+		file, err := os.Open(filename)
+		if err != nil {
+			return runtime.FALSE
+		}
+		stream.file = file
+		if err := stream.openGzReader(); err != nil {
+			file.Close()
+			return runtime.FALSE
+		}
+	default:
+		return runtime.FALSE
+	}
+
+	resID := i.nextResourceID
+	i.nextResourceID++
+	resource := runtime.NewResource("stream", stream, resID)
+	i.resources[resID] = resource
+
+	return resource
+}
+
+func builtinGzread(args ...runtime.Value) runtime.Value {
+	stream, ok := gzStreamArg(args)
+	if !ok || stream.reader == nil || len(args) < 2 {
+		return runtime.FALSE
+	}
+
+	length := int(args[1].ToInt())
+	if length <= 0 {
+		return runtime.NewString("")
+	}
+
+	buf := make([]byte, length)
+	n, err := io.ReadFull(stream.reader, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return runtime.FALSE
+	}
+	return runtime.NewString(string(buf[:n]))
+}
+
+func builtinGzwrite(args ...runtime.Value) runtime.Value {
+	stream, ok := gzStreamArg(args)
+	if !ok || stream.writer == nil || len(args) < 2 {
+		return runtime.FALSE
+	}
+
+	data := args[1].ToString()
+	if len(args) >= 3 {
+		if length := int(args[2].ToInt()); length >= 0 && length < len(data) {
+			data = data[:length]
+		}
+	}
+
+	n, err := stream.writer.Write([]byte(data))
+	if err != nil {
+		return runtime.FALSE
+	}
+	return runtime.NewInt(int64(n))
+}
+
+func builtinGzgets(args ...runtime.Value) runtime.Value {
+	stream, ok := gzStreamArg(args)
+	if !ok || stream.reader == nil {
+		return runtime.FALSE
+	}
+
+	// At most length-1 bytes are read, as with fgets
+	limit := -1
+	if len(args) >= 2 {
+		limit = int(args[1].ToInt()) - 1
+	}
+
+	var line []byte
+	for limit < 0 || len(line) < limit {
+		c, err := stream.reader.ReadByte()
+		if err != nil {
+			break
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	if len(line) == 0 {
+		return runtime.FALSE
+	}
+	return runtime.NewString(string(line))
+}
+
+func builtinGzeof(args ...runtime.Value) runtime.Value {
+	stream, ok := gzStreamArg(args)
+	if !ok {
+		return runtime.TRUE
+	}
+	if stream.reader == nil {
+		return runtime.FALSE
+	}
+	_, err := stream.reader.Peek(1)
+	return runtime.NewBool(err != nil)
+}
+
+func builtinGzrewind(args ...runtime.Value) runtime.Value {
+	stream, ok := gzStreamArg(args)
+	if !ok || stream.reader == nil {
+		return runtime.FALSE
+	}
+	if stream.gz != nil {
+		stream.gz.Close()
+	}
+	return runtime.NewBool(stream.openGzReader() == nil)
+}
+
+func builtinGzclose(args ...runtime.Value) runtime.Value {
+	stream, ok := gzStreamArg(args)
+	if !ok {
+		return runtime.FALSE
+	}
+
+	if stream.writer != nil {
+		if err := stream.writer.Close(); err != nil {
+			stream.file.Close()
+			return runtime.FALSE
+		}
+	}
+	if stream.gz != nil {
+		stream.gz.Close()
+	}
+	return runtime.NewBool(stream.file.Close() == nil)
+}
+
+// readGzFile returns the decompressed contents of filename; files without a
+// gzip header are returned unchanged.
+func readGzFile(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stream := &gzStream{file: file}
+	if err := stream.openGzReader(); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(stream.reader)
+}
+
+func builtinGzfile(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+
+	data, err := readGzFile(args[0].ToString())
+	if err != nil {
+		return runtime.FALSE
+	}
+
+	result := runtime.NewArray()
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line != "" {
+			result.Set(nil, runtime.NewString(line))
+		}
+	}
+	return result
+}
+
+func (i *Interpreter) builtinReadgzfile(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+
+	data, err := readGzFile(args[0].ToString())
+	if err != nil {
+		return runtime.FALSE
+	}
+
+	i.writeOutput(string(data))
+	return runtime.NewInt(int64(len(data)))
+}
+
+// bzStream is the handle behind resources returned by bzopen. Go only ships a
+// bzip2 decompressor, so bz streams are read-only.
+type bzStream struct {
+	file   *os.File
+	reader io.Reader
+}
+
+func builtinBzdecompress(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+
+	result, err := io.ReadAll(bzip2.NewReader(strings.NewReader(args[0].ToString())))
+	if err != nil {
+		return runtime.NewInt(-4) // BZ_DATA_ERROR
+	}
+	return runtime.NewString(string(result))
+}
+
+func (i *Interpreter) builtinBzcompress(args ...runtime.Value) runtime.Value {
+	i.writeOutput("PHP Warning: bzcompress(): bzip2 compression is not supported\n")
+	return runtime.FALSE
+}
+
+func (i *Interpreter) builtinBzopen(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+
+	if mode := args[1].ToString(); mode != "r" {
+		i.writeOutput("PHP Warning: bzopen(): bzip2 compression is not supported, only mode 'r' is available\n")
+		return runtime.FALSE
+	}
+
+	file, err := os.Open(args[0].ToString())
+	if err != nil {
+		return runtime.FALSE
+	}
+
+	resID := i.nextResourceID
+	i.nextResourceID++
+	resource := runtime.NewResource("stream", &bzStream{file: file, reader: bzip2.NewReader(bufio.NewReader(file))}, resID)
+	i.resources[resID] = resource
+
+	return resource
+}
+
+func builtinBzread(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	res, ok := args[0].(*runtime.Resource)
+	if !ok {
+		return runtime.FALSE
+	}
+	stream, ok := res.Handle.(*bzStream)
+	if !ok {
+		return runtime.FALSE
+	}
+
+	length := 1024
+	if len(args) >= 2 {
+		length = int(args[1].ToInt())
+	}
+	if length <= 0 {
+		return runtime.NewString("")
+	}
+
+	buf := make([]byte, length)
+	n, err := io.ReadFull(stream.reader, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return runtime.FALSE
+	}
+	return runtime.NewString(string(buf[:n]))
+}
+
+func (i *Interpreter) builtinBzwrite(args ...runtime.Value) runtime.Value {
+	i.writeOutput("PHP Warning: bzwrite(): bzip2 compression is not supported\n")
+	return runtime.FALSE
+}
+
+func builtinBzclose(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	res, ok := args[0].(*runtime.Resource)
+	if !ok {
+		return runtime.FALSE
+	}
+	stream, ok := res.Handle.(*bzStream)
+	if !ok {
+		return runtime.FALSE
+	}
+	return runtime.NewBool(stream.file.Close() == nil)
+}
+
 // ----------------------------------------------------------------------------
 // Additional string functions
 
@@ -7262,9 +9960,14 @@ func builtinNumberFormat(args ...runtime.Value) runtime.Value {
 		thousandsSep = args[3].ToString()
 	}
 
-	// Format the number
-	format := fmt.Sprintf("%%.%df", decimals)
-	str := fmt.Sprintf(format, num)
+	if decimals < 0 {
+		decimals = 0
+	}
+
+	// Round first so values that round to zero lose their sign
+	num = phpRound(num, decimals)
+	isNegative := num < 0
+	str := strconv.FormatFloat(math.Abs(num), 'f', decimals, 64)
 
 	// Split into integer and decimal parts
 	parts := strings.Split(str, ".")
@@ -7272,10 +9975,6 @@ func builtinNumberFormat(args ...runtime.Value) runtime.Value {
 
 	// Add thousands separator
 	var result strings.Builder
-	isNegative := strings.HasPrefix(intPart, "-")
-	if isNegative {
-		intPart = intPart[1:]
-	}
 
 	for i, c := range intPart {
 		if i > 0 && (len(intPart)-i)%3 == 0 {
@@ -7296,17 +9995,74 @@ func builtinNumberFormat(args ...runtime.Value) runtime.Value {
 	return runtime.NewString(finalStr)
 }
 
+// ENT_* flags for the html* functions
+const (
+	entCompat     = 2
+	entQuotes     = 3
+	entNoQuotes   = 0
+	entIgnore     = 4
+	entSubstitute = 8
+	entDisallowed = 128
+	entHTML401    = 0
+	entXML1       = 16
+	entXHTML      = 32
+	entHTML5      = 48
+)
+
+// htmlEntityPattern matches a complete named or numeric character reference
+var htmlEntityPattern = regexp.MustCompile(`^&(?:[A-Za-z][A-Za-z0-9]*|#[0-9]+|#[xX][0-9A-Fa-f]+);`)
+
 func builtinHtmlspecialchars(args ...runtime.Value) runtime.Value {
+	// htmlspecialchars(string $string, int $flags = ENT_QUOTES | ENT_SUBSTITUTE | ENT_HTML401, ?string $encoding = null, bool $double_encode = true) : string
 	if len(args) < 1 {
 		return runtime.NewString("")
 	}
 	s := args[0].ToString()
-	s = strings.ReplaceAll(s, "&", "&amp;")
-	s = strings.ReplaceAll(s, "<", "&lt;")
-	s = strings.ReplaceAll(s, ">", "&gt;")
-	s = strings.ReplaceAll(s, "\"", "&quot;")
-	s = strings.ReplaceAll(s, "'", "&#039;")
-	return runtime.NewString(s)
+	flags := int64(entQuotes | entSubstitute | entHTML401)
+	if len(args) >= 2 {
+		flags = args[1].ToInt()
+	}
+	doubleEncode := len(args) < 4 || args[3].ToBool()
+
+	// The single quote entity depends on the document type
+	apos := "&#039;"
+	if doctype := flags & entHTML5; doctype == entXML1 || doctype == entXHTML || doctype == entHTML5 {
+		apos = "&apos;"
+	}
+
+	var sb strings.Builder
+	for idx := 0; idx < len(s); idx++ {
+		switch c := s[idx]; c {
+		case '&':
+			if !doubleEncode {
+				if entity := htmlEntityPattern.FindString(s[idx:]); entity != "" {
+					sb.WriteString(entity)
+					idx += len(entity) - 1
+					continue
+				}
+			}
+			sb.WriteString("&amp;")
+		case '<':
+			sb.WriteString("&lt;")
+		case '>':
+			sb.WriteString("&gt;")
+		case '"':
+			if flags&entCompat != 0 {
+				sb.WriteString("&quot;")
+			} else {
+				sb.WriteByte(c)
+			}
+		case '\'':
+			if flags&entQuotes == entQuotes {
+				sb.WriteString(apos)
+			} else {
+				sb.WriteByte(c)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return runtime.NewString(sb.String())
 }
 
 func builtinHtmlentities(args ...runtime.Value) runtime.Value {
@@ -7364,20 +10120,23 @@ func builtinStripslashes(args ...runtime.Value) runtime.Value {
 // ----------------------------------------------------------------------------
 // Additional array functions
 
-func builtinArrayCombine(args ...runtime.Value) runtime.Value {
+func (i *Interpreter) builtinArrayCombine(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.FALSE
 	}
 	keys, ok1 := args[0].(*runtime.Array)
 	values, ok2 := args[1].(*runtime.Array)
-	if !ok1 || !ok2 || keys.Len() != values.Len() {
+	if !ok1 || !ok2 {
 		return runtime.FALSE
 	}
+	if keys.Len() != values.Len() {
+		return i.splException("ValueError", "array_combine(): Argument #1 ($keys) and argument #2 ($values) must have the same number of elements")
+	}
 
 	result := runtime.NewArray()
-	for i := range keys.Keys {
-		keyVal := keys.Elements[keys.Keys[i]]
-		valVal := values.Elements[values.Keys[i]]
+	for idx := range keys.Keys {
+		keyVal := keys.Elements[keys.Keys[idx]]
+		valVal := values.Elements[values.Keys[idx]]
 		result.Set(keyVal, valVal)
 	}
 	return result
@@ -7494,34 +10253,86 @@ func builtinArrayColumn(args ...runtime.Value) runtime.Value {
 		return runtime.FALSE
 	}
 
-	columnKey := args[1]
+	// A null column key selects whole rows
+	var columnKey runtime.Value
+	if _, isNull := args[1].(*runtime.Null); !isNull {
+		columnKey = args[1]
+	}
 	var indexKey runtime.Value
-	if len(args) >= 3 && args[2] != runtime.NULL {
-		indexKey = args[2]
+	if len(args) >= 3 {
+		if _, isNull := args[2].(*runtime.Null); !isNull {
+			indexKey = args[2]
+		}
 	}
 
 	result := runtime.NewArray()
 	for _, key := range arr.Keys {
-		row, ok := arr.Elements[key].(*runtime.Array)
-		if !ok {
-			continue
+		row := arr.Elements[key]
+		if _, isArray := row.(*runtime.Array); !isArray {
+			if _, isObject := row.(*runtime.Object); !isObject {
+				continue
+			}
 		}
 
-		colVal := row.Get(columnKey)
-		if colVal == runtime.NULL {
-			continue
+		colVal := row
+		if columnKey != nil {
+			var ok bool
+			if colVal, ok = arrayColumnField(row, columnKey); !ok {
+				continue
+			}
 		}
 
+		// Rows sharing an index value overwrite earlier ones, like PHP
 		if indexKey != nil {
-			idx := row.Get(indexKey)
-			result.Set(idx, colVal)
-		} else {
-			result.Set(nil, colVal)
+			if idx, ok := arrayColumnField(row, indexKey); ok {
+				switch idx.(type) {
+				case *runtime.Int, *runtime.String:
+					result.Set(idx, colVal)
+					continue
+				}
+			}
 		}
+		result.Set(nil, colVal)
 	}
 	return result
 }
 
+// arrayColumnField reads a field of an array_column row: an array element,
+// or a public property of an object
+func arrayColumnField(row, key runtime.Value) (runtime.Value, bool) {
+	switch r := row.(type) {
+	case *runtime.Array:
+		if k := findArrayKey(r, key); k != nil {
+			return r.Elements[k], true
+		}
+	case *runtime.Object:
+		name := key.ToString()
+		val, ok := r.Properties[name]
+		if !ok {
+			return nil, false
+		}
+		if propDef := findPropertyDef(r.Class, name); propDef != nil && (propDef.IsPrivate || propDef.IsProtected) {
+			return nil, false
+		}
+		return val, true
+	}
+	return nil, false
+}
+
+// findArrayKey returns the stored key matching key by value, comparing
+// integers and numeric strings loosely as PHP array keys do
+func findArrayKey(arr *runtime.Array, key runtime.Value) runtime.Value {
+	if _, ok := arr.Elements[key]; ok {
+		return key
+	}
+	for _, k := range arr.Keys {
+		if k.ToString() == key.ToString() {
+			return k
+		}
+	}
+	return nil
+}
+
 func builtinArrayCountValues(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.NewArray()
@@ -7544,6 +10355,18 @@ func builtinArrayCountValues(args ...runtime.Value) runtime.Value {
 	return result
 }
 
+// arrayCompareString returns the string array_diff and array_intersect
+// compare values by, which is PHP's (string) cast: false and null become
+// "", true becomes "1" and integral floats lose their ".0". The comparison
+// is therefore loose, so array_diff([1], [true]) removes the 1 just as PHP
+// does.
+func arrayCompareString(v runtime.Value) string {
+	if f, ok := v.(*runtime.Float); ok && f.Value == math.Trunc(f.Value) && math.Abs(f.Value) < 1e15 {
+		return strconv.FormatFloat(f.Value, 'f', -1, 64)
+	}
+	return v.ToString()
+}
+
 func builtinArrayDiff(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.NewArray()
@@ -7558,7 +10381,7 @@ func builtinArrayDiff(args ...runtime.Value) runtime.Value {
 	for i := 1; i < len(args); i++ {
 		if arr, ok := args[i].(*runtime.Array); ok {
 			for _, key := range arr.Keys {
-				exclude[arr.Elements[key].ToString()] = true
+				exclude[arrayCompareString(arr.Elements[key])] = true
 			}
 		}
 	}
@@ -7566,7 +10389,7 @@ func builtinArrayDiff(args ...runtime.Value) runtime.Value {
 	result := runtime.NewArray()
 	for _, key := range arr1.Keys {
 		val := arr1.Elements[key]
-		if !exclude[val.ToString()] {
+		if !exclude[arrayCompareString(val)] {
 			result.Set(key, val)
 		}
 	}
@@ -7590,7 +10413,7 @@ func builtinArrayIntersect(args ...runtime.Value) runtime.Value {
 		if arr, ok := args[i].(*runtime.Array); ok {
 			seen := make(map[string]bool)
 			for _, key := range arr.Keys {
-				valStr := arr.Elements[key].ToString()
+				valStr := arrayCompareString(arr.Elements[key])
 				if !seen[valStr] {
 					seen[valStr] = true
 					counts[valStr]++
@@ -7602,7 +10425,7 @@ func builtinArrayIntersect(args ...runtime.Value) runtime.Value {
 	result := runtime.NewArray()
 	for _, key := range arr1.Keys {
 		val := arr1.Elements[key]
-		if counts[val.ToString()] == numArrays {
+		if counts[arrayCompareString(val)] == numArrays {
 			result.Set(key, val)
 		}
 	}
@@ -7672,6 +10495,71 @@ func builtinArrayIntersectKey(args ...runtime.Value) runtime.Value {
 	return result
 }
 
+// keyMatchesUkey reports whether arr has a key the user comparator deems
+// equal to key
+func (i *Interpreter) keyMatchesUkey(callback runtime.Value, key runtime.Value, arr *runtime.Array) bool {
+	for _, other := range arr.Keys {
+		if i.callCallback(callback, []runtime.Value{key, other}).ToInt() == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Interpreter) builtinArrayDiffUkey(args ...runtime.Value) runtime.Value {
+	// array_diff_ukey(array $array, array ...$arrays, callable $key_compare_func) : array
+	if len(args) < 3 {
+		return runtime.NewArray()
+	}
+	arr1, ok := args[0].(*runtime.Array)
+	if !ok {
+		return runtime.NewArray()
+	}
+	callback := args[len(args)-1]
+
+	result := runtime.NewArray()
+	for _, key := range arr1.Keys {
+		found := false
+		for _, other := range args[1 : len(args)-1] {
+			if arr, ok := other.(*runtime.Array); ok && i.keyMatchesUkey(callback, key, arr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result.Set(key, arr1.Elements[key])
+		}
+	}
+	return result
+}
+
+func (i *Interpreter) builtinArrayIntersectUkey(args ...runtime.Value) runtime.Value {
+	// array_intersect_ukey(array $array, array ...$arrays, callable $key_compare_func) : array
+	if len(args) < 3 {
+		return runtime.NewArray()
+	}
+	arr1, ok := args[0].(*runtime.Array)
+	if !ok {
+		return runtime.NewArray()
+	}
+	callback := args[len(args)-1]
+
+	result := runtime.NewArray()
+	for _, key := range arr1.Keys {
+		inAll := true
+		for _, other := range args[1 : len(args)-1] {
+			arr, ok := other.(*runtime.Array)
+			if !ok || !i.keyMatchesUkey(callback, key, arr) {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			result.Set(key, arr1.Elements[key])
+		}
+	}
+	return result
+}
 func builtinArrayDiffAssoc(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.NewArray()
@@ -7849,15 +10737,18 @@ func (i *Interpreter) builtinArrayWalk(args ...runtime.Value) runtime.Value {
 	if !ok {
 		return runtime.FALSE
 	}
-	callback, ok := args[1].(*runtime.Function)
-	if !ok {
-		return runtime.FALSE
-	}
+	callback := args[1]
 
 	for _, key := range arr.Keys {
-		val := arr.Elements[key]
-		i.callFunctionWithArgs(callback, []runtime.Value{val, key})
+		callArgs := []runtime.Value{arr.Elements[key], key}
+		if len(args) >= 3 {
+			callArgs = append(callArgs, args[2])
+		}
+		i.callCallback(callback, callArgs)
 	}
+
+	// The walk leaves the internal pointer reset to the first element
+	arr.Pointer = 0
 	return runtime.TRUE
 }
 
@@ -7890,42 +10781,46 @@ func (i *Interpreter) builtinArrayWalkRecursive(args ...runtime.Value) runtime.V
 	return runtime.TRUE
 }
 
-func builtinArrayRand(args ...runtime.Value) runtime.Value {
+func (i *Interpreter) builtinArrayRand(args ...runtime.Value) runtime.Value {
+	// array_rand(array $array, int $num = 1) : int|string|array
 	if len(args) < 1 {
 		return runtime.NULL
 	}
 	arr, ok := args[0].(*runtime.Array)
-	if !ok || arr.Len() == 0 {
+	if !ok {
 		return runtime.NULL
 	}
+	if arr.Len() == 0 {
+		return i.splException("ValueError", "array_rand(): Argument #1 ($array) cannot be empty")
+	}
 
 	num := 1
 	if len(args) >= 2 {
 		num = int(args[1].ToInt())
 	}
+	if num < 1 || num > len(arr.Keys) {
+		return i.splException("ValueError", "array_rand(): Argument #2 ($num) must be between 1 and the number of elements in argument #1 ($array)")
+	}
 
+	// Keys are drawn from this interpreter's mt_rand() generator, so
+	// mt_srand() makes the picks reproducible without affecting other scripts
 	if num == 1 {
-		// Return single random key
-		idx := int(time.Now().UnixNano() % int64(len(arr.Keys)))
-		return arr.Keys[idx]
+		return arr.Keys[i.mtRand.rangeUint32(uint32(len(arr.Keys)-1))]
 	}
 
-	// Return array of random keys
+	// Selection sampling: walk the keys in order, taking each with the
+	// probability still needed, which yields distinct keys in array order
 	result := runtime.NewArray()
-	indices := make([]int, len(arr.Keys))
-	for i := range indices {
-		indices[i] = i
-	}
-	// Simple shuffle
-	seed := time.Now().UnixNano()
-	for i := len(indices) - 1; i > 0; i-- {
-		j := int(seed % int64(i+1))
-		seed = seed * 1103515245 + 12345
-		indices[i], indices[j] = indices[j], indices[i]
-	}
-
-	for i := 0; i < num && i < len(indices); i++ {
-		result.Set(nil, arr.Keys[indices[i]])
+	needed := num
+	for idx, key := range arr.Keys {
+		remaining := len(arr.Keys) - idx
+		if int(i.mtRand.rangeUint32(uint32(remaining-1))) < needed {
+			result.Set(nil, key)
+			needed--
+			if needed == 0 {
+				break
+			}
+		}
 	}
 	return result
 }
@@ -8315,6 +11210,9 @@ func builtinGetClass(args ...runtime.Value) runtime.Value {
 
 	obj, ok := args[0].(*runtime.Object)
 	if !ok {
+		if name := nativeClassName(args[0]); name != "" {
+			return runtime.NewString(name)
+		}
 		return runtime.FALSE
 	}
 
@@ -8539,16 +11437,20 @@ func builtinSplObjectHash(args ...runtime.Value) runtime.Value {
 	if !ok {
 		return runtime.FALSE
 	}
+	return runtime.NewString(splObjectHash(obj))
+}
 
-	// Generate a unique hash for the object using its memory address
-	// In Go, we can use fmt.Sprintf with %p to get the pointer address
-	hash := fmt.Sprintf("%p", obj)
-	// Remove the "0x" prefix to get a clean hex string
-	hash = strings.TrimPrefix(hash, "0x")
-	// Pad to 32 characters to match PHP's format
-	hash = fmt.Sprintf("%032s", hash)
+// splObjectHash identifies an object instance by its address, padded to 32
+// hex digits to match PHP's format. SplObjectStorage keys objects by it too.
+func splObjectHash(obj runtime.Value) string {
+	return fmt.Sprintf("%032x", splObjectAddress(obj))
+}
 
-	return runtime.NewString(hash)
+// splObjectAddress is the identity behind spl_object_id and spl_object_hash
+func splObjectAddress(obj runtime.Value) uintptr {
+	var addr uintptr
+	fmt.Sscanf(fmt.Sprintf("%p", obj), "0x%x", &addr)
+	return addr
 }
 
 func builtinSplObjectId(args ...runtime.Value) runtime.Value {
@@ -8560,16 +11462,7 @@ func builtinSplObjectId(args ...runtime.Value) runtime.Value {
 	if !ok {
 		return runtime.FALSE
 	}
-
-	// Generate a unique integer ID for the object
-	// We can use the pointer address as the ID
-	id := fmt.Sprintf("%p", obj)
-	// Remove 0x prefix and convert hex to int
-	id = strings.TrimPrefix(id, "0x")
-	var intId int64
-	fmt.Sscanf(id, "%x", &intId)
-
-	return runtime.NewInt(intId)
+	return runtime.NewInt(int64(splObjectAddress(obj)))
 }
 
 func (i *Interpreter) builtinGetObjectVars(args ...runtime.Value) runtime.Value {
@@ -8818,6 +11711,56 @@ func builtinMbInternalEncoding(args ...runtime.Value) runtime.Value {
 	return runtime.TRUE
 }
 
+// mbSingleByteEncoding reports whether an mb_* encoding argument names a
+// single-byte encoding, where characters and bytes coincide
+func mbSingleByteEncoding(args []runtime.Value, idx int) bool {
+	if len(args) <= idx {
+		return false
+	}
+	switch strings.ToUpper(args[idx].ToString()) {
+	case "ASCII", "ISO-8859-1", "LATIN1", "8BIT":
+		return true
+	}
+	return false
+}
+
+func builtinMbOrd(args ...runtime.Value) runtime.Value {
+	// mb_ord(string $string, ?string $encoding = null) : int|false
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	str := args[0].ToString()
+	if str == "" {
+		return runtime.FALSE
+	}
+	if mbSingleByteEncoding(args, 1) {
+		return runtime.NewInt(int64(str[0]))
+	}
+	r, _ := utf8.DecodeRuneInString(str)
+	if r == utf8.RuneError {
+		return runtime.FALSE
+	}
+	return runtime.NewInt(int64(r))
+}
+
+func builtinMbChr(args ...runtime.Value) runtime.Value {
+	// mb_chr(int $codepoint, ?string $encoding = null) : string|false
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	codepoint := args[0].ToInt()
+	if mbSingleByteEncoding(args, 1) {
+		if codepoint < 0 || codepoint > 0xFF {
+			return runtime.FALSE
+		}
+		return runtime.NewString(string([]byte{byte(codepoint)}))
+	}
+	if codepoint < 0 || codepoint > utf8.MaxRune || !utf8.ValidRune(rune(codepoint)) {
+		return runtime.FALSE
+	}
+	return runtime.NewString(string(rune(codepoint)))
+}
+
 func builtinIconv(args ...runtime.Value) runtime.Value {
 	if len(args) < 3 {
 		return runtime.FALSE
@@ -9022,20 +11965,18 @@ func builtinStrtr(args ...runtime.Value) runtime.Value {
 }
 
 func builtinStrIreplace(args ...runtime.Value) runtime.Value {
-	if len(args) < 3 {
-		return runtime.NewString("")
-	}
-
-	search := args[0].ToString()
-	replace := args[1].ToString()
-	subject := args[2].ToString()
+	return strReplace(args, replaceCaseInsensitive)
+}
 
-	// Case-insensitive replace
+// replaceCaseInsensitive replaces every case-insensitive occurrence of
+// search in subject, returning the result and the number of replacements
+func replaceCaseInsensitive(subject, search, replace string) (string, int) {
 	lowerSubject := strings.ToLower(subject)
 	lowerSearch := strings.ToLower(search)
 
 	var result strings.Builder
 	lastIdx := 0
+	count := 0
 
 	for {
 		idx := strings.Index(lowerSubject[lastIdx:], lowerSearch)
@@ -9048,9 +11989,78 @@ func builtinStrIreplace(args ...runtime.Value) runtime.Value {
 		result.WriteString(subject[lastIdx:actualIdx])
 		result.WriteString(replace)
 		lastIdx = actualIdx + len(search)
+		count++
 	}
 
-	return runtime.NewString(result.String())
+	return result.String(), count
+}
+
+// strReplace implements str_replace and str_ireplace. search and replace
+// may be arrays, replaced pairwise with missing replacements treated as
+// empty, and an array subject yields an array of results. The total
+// number of replacements is stored in the optional count reference.
+func strReplace(args []runtime.Value, replaceFn func(subject, search, replace string) (string, int)) runtime.Value {
+	if len(args) < 3 {
+		return runtime.NewString("")
+	}
+
+	var searches, replaces []string
+	if searchArr, ok := args[0].(*runtime.Array); ok {
+		for _, k := range searchArr.Keys {
+			searches = append(searches, searchArr.Elements[k].ToString())
+		}
+		if replaceArr, ok := args[1].(*runtime.Array); ok {
+			for _, k := range replaceArr.Keys {
+				replaces = append(replaces, replaceArr.Elements[k].ToString())
+			}
+		} else {
+			for range searches {
+				replaces = append(replaces, args[1].ToString())
+			}
+		}
+	} else {
+		searches = []string{args[0].ToString()}
+		replaces = []string{args[1].ToString()}
+	}
+
+	total := 0
+	replaceAll := func(subject string) string {
+		for idx, search := range searches {
+			if search == "" {
+				continue
+			}
+			replace := ""
+			if idx < len(replaces) {
+				replace = replaces[idx]
+			}
+			var n int
+			subject, n = replaceFn(subject, search, replace)
+			total += n
+		}
+		return subject
+	}
+
+	var result runtime.Value
+	if subjectArr, ok := args[2].(*runtime.Array); ok {
+		arr := runtime.NewArray()
+		for _, k := range subjectArr.Keys {
+			val := subjectArr.Elements[k]
+			if _, isArray := val.(*runtime.Array); !isArray {
+				val = runtime.NewString(replaceAll(val.ToString()))
+			}
+			arr.Set(k, val)
+		}
+		result = arr
+	} else {
+		result = runtime.NewString(replaceAll(args[2].ToString()))
+	}
+
+	if len(args) >= 4 {
+		if ref, ok := args[3].(*runtime.Reference); ok {
+			ref.Set(runtime.NewInt(int64(total)))
+		}
+	}
+	return result
 }
 
 func builtinStrpbrk(args ...runtime.Value) runtime.Value {
@@ -9352,82 +12362,81 @@ func builtinArraySplice(args ...runtime.Value) runtime.Value {
 		return runtime.NewArray()
 	}
 
+	size := len(arr.Keys)
 	offset := int(args[1].ToInt())
-	length := len(arr.Keys)
 
 	// Handle negative offset
 	if offset < 0 {
-		offset = len(arr.Keys) + offset
+		offset = size + offset
 		if offset < 0 {
 			offset = 0
 		}
+	} else if offset > size {
+		offset = size
 	}
 
-	// Handle length parameter
-	if len(args) >= 3 {
-		length = int(args[2].ToInt())
+	// Handle length parameter; a negative length stops that many elements
+	// from the end
+	end := size
+	if len(args) >= 3 && args[2] != runtime.NULL {
+		length := int(args[2].ToInt())
 		if length < 0 {
-			length = len(arr.Keys) - offset + length
+			end = size + length
+		} else {
+			end = offset + length
 		}
-	} else {
-		length = len(arr.Keys) - offset
 	}
-
-	if offset >= len(arr.Keys) {
-		return runtime.NewArray()
+	if end > size {
+		end = size
 	}
-
-	if offset+length > len(arr.Keys) {
-		length = len(arr.Keys) - offset
+	if end < offset {
+		end = offset
 	}
 
-	// Extract removed elements
+	// Extract removed elements, keeping string keys
 	removed := runtime.NewArray()
-	for i := offset; i < offset+length && i < len(arr.Keys); i++ {
-		key := arr.Keys[i]
-		removed.Set(nil, arr.Elements[key])
+	for _, key := range arr.Keys[offset:end] {
+		if _, isInt := key.(*runtime.Int); isInt {
+			removed.Set(nil, arr.Elements[key])
+		} else {
+			removed.Set(key, arr.Elements[key])
+		}
 	}
 
-	// Build replacement array if provided
-	type replPair struct {
-		key runtime.Value
-		val runtime.Value
-	}
-	var replacement []replPair
+	// Build replacement values; a scalar replacement counts as one element
+	var replacement []runtime.Value
 	if len(args) >= 4 {
 		if replArr, ok := args[3].(*runtime.Array); ok {
 			for _, k := range replArr.Keys {
-				replacement = append(replacement, replPair{k, replArr.Elements[k]})
+				replacement = append(replacement, replArr.Elements[k])
 			}
+		} else if args[3] != runtime.NULL {
+			replacement = append(replacement, args[3])
 		}
 	}
 
-	// Remove elements from original array
-	for i := offset; i < offset+length && i < len(arr.Keys); i++ {
-		key := arr.Keys[i]
-		delete(arr.Elements, key)
-	}
-
-	// Build new keys slice
-	newKeys := make([]runtime.Value, 0)
-	newKeys = append(newKeys, arr.Keys[:offset]...)
+	// Rebuild the array: string keys are preserved, integer keys renumbered
+	oldKeys := arr.Keys
+	oldElements := arr.Elements
+	arr.Keys = make([]runtime.Value, 0, size-(end-offset)+len(replacement))
+	arr.Elements = make(map[runtime.Value]runtime.Value)
+	arr.NextIndex = 0
+	arr.Pointer = 0
 
-	// Add replacement elements
-	nextIdx := arr.NextIndex
-	for _, p := range replacement {
-		newKey := runtime.NewInt(nextIdx)
-		arr.Elements[newKey] = p.val
-		newKeys = append(newKeys, newKey)
-		nextIdx++
+	keep := func(keys []runtime.Value) {
+		for _, key := range keys {
+			if _, isInt := key.(*runtime.Int); isInt {
+				arr.Set(nil, oldElements[key])
+			} else {
+				arr.Set(key, oldElements[key])
+			}
+		}
 	}
-
-	// Add remaining elements
-	if offset+length < len(arr.Keys) {
-		newKeys = append(newKeys, arr.Keys[offset+length:]...)
+	keep(oldKeys[:offset])
+	for _, v := range replacement {
+		arr.Set(nil, v)
 	}
-
-	arr.Keys = newKeys
-	arr.NextIndex = nextIdx
+	keep(oldKeys[end:])
 
 	return removed
 }
@@ -9576,7 +12585,7 @@ func (i *Interpreter) builtinFopen(args ...runtime.Value) runtime.Value {
 	return resource
 }
 
-func builtinFclose(args ...runtime.Value) runtime.Value {
+func (i *Interpreter) builtinFclose(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.FALSE
 	}
@@ -9588,6 +12597,13 @@ func builtinFclose(args ...runtime.Value) runtime.Value {
 
 	if file, ok := res.Handle.(*os.File); ok {
 		err := file.Close()
+
+		// Files from tmpfile() are removed once closed
+		if path, isTemp := i.tempFiles[res.ID]; isTemp {
+			os.Remove(path)
+			delete(i.tempFiles, res.ID)
+		}
+
 		if err != nil {
 			return runtime.FALSE
 		}
@@ -9773,6 +12789,30 @@ func builtinFtell(args ...runtime.Value) runtime.Value {
 	return runtime.FALSE
 }
 
+func builtinFflush(args ...runtime.Value) runtime.Value {
+	// fflush(resource $stream) : bool
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+
+	res, ok := args[0].(*runtime.Resource)
+	if !ok {
+		return runtime.FALSE
+	}
+
+	if file, ok := res.Handle.(*os.File); ok {
+		if err := file.Sync(); err != nil {
+			// Pipes and terminals can't be synced but have nothing buffered
+			if info, statErr := file.Stat(); statErr != nil || info.Mode().IsRegular() {
+				return runtime.FALSE
+			}
+		}
+		return runtime.TRUE
+	}
+
+	return runtime.FALSE
+}
+
 func builtinRewind(args ...runtime.Value) runtime.Value {
 	if len(args) < 1 {
 		return runtime.FALSE
@@ -9978,6 +13018,48 @@ func builtinRename(args ...runtime.Value) runtime.Value {
 	return runtime.TRUE
 }
 
+func builtinSymlink(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+
+	err := os.Symlink(args[0].ToString(), args[1].ToString())
+	return runtime.NewBool(err == nil)
+}
+
+func builtinLink(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+
+	err := os.Link(args[0].ToString(), args[1].ToString())
+	return runtime.NewBool(err == nil)
+}
+
+func builtinReadlink(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+
+	target, err := os.Readlink(args[0].ToString())
+	if err != nil {
+		return runtime.FALSE
+	}
+	return runtime.NewString(target)
+}
+
+func builtinIsLink(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+
+	info, err := os.Lstat(args[0].ToString())
+	if err != nil {
+		return runtime.FALSE
+	}
+	return runtime.NewBool(info.Mode()&os.ModeSymlink != 0)
+}
+
 func builtinChmod(args ...runtime.Value) runtime.Value {
 	if len(args) < 2 {
 		return runtime.FALSE
@@ -10035,52 +13117,226 @@ func builtinTouch(args ...runtime.Value) runtime.Value {
 
 	filename := args[0].ToString()
 
-	// Check if file exists
-	_, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		// Create empty file
+	// mtime defaults to now and atime to mtime
+	mtime := time.Now()
+	if len(args) >= 2 && args[1] != runtime.NULL {
+		mtime = time.Unix(args[1].ToInt(), 0)
+	}
+	atime := mtime
+	if len(args) >= 3 && args[2] != runtime.NULL {
+		atime = time.Unix(args[2].ToInt(), 0)
+	}
+
+	// Create the file if it doesn't exist
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		file, err := os.Create(filename)
 		if err != nil {
 			return runtime.FALSE
 		}
 		file.Close()
-	} else {
-		// Update modification time
-		now := time.Now()
-		err = os.Chtimes(filename, now, now)
-		if err != nil {
+	}
+
+	if err := os.Chtimes(filename, atime, mtime); err != nil {
+		return runtime.FALSE
+	}
+
+	return runtime.TRUE
+}
+
+func builtinSysGetTempDir(args ...runtime.Value) runtime.Value {
+	return runtime.NewString(os.TempDir())
+}
+
+func builtinTempnam(args ...runtime.Value) runtime.Value {
+	dir := os.TempDir()
+	prefix := "php"
+
+	if len(args) >= 1 {
+		dir = args[0].ToString()
+	}
+	if len(args) >= 2 {
+		prefix = args[1].ToString()
+	}
+
+	// Create a temporary file
+	file, err := os.CreateTemp(dir, prefix)
+	if err != nil {
+		return runtime.FALSE
+	}
+
+	filename := file.Name()
+	file.Close()
+
+	return runtime.NewString(filename)
+}
+
+func (i *Interpreter) builtinTmpfile(args ...runtime.Value) runtime.Value {
+	file, err := os.CreateTemp("", "php")
+	if err != nil {
+		return runtime.FALSE
+	}
+
+	resID := i.nextResourceID
+	i.nextResourceID++
+	resource := runtime.NewResource("stream", file, resID)
+	i.resources[resID] = resource
+	i.tempFiles[resID] = file.Name()
+
+	return resource
+}
+
+func (i *Interpreter) builtinPopen(args ...runtime.Value) runtime.Value {
+	// popen(string $command, string $mode) : resource|false
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return runtime.FALSE
+	}
+	cmd := exec.Command("/bin/sh", "-c", args[0].ToString())
+	cmd.Stderr = os.Stderr
+
+	// Keep our end of the pipe and hand the other to the child
+	var file, childEnd *os.File
+	switch strings.TrimSuffix(args[1].ToString(), "b") {
+	case "r":
+		file, childEnd = reader, writer
+		cmd.Stdout = writer
+	case "w":
+		file, childEnd = writer, reader
+		cmd.Stdin = reader
+	default:
+		reader.Close()
+		writer.Close()
+		return runtime.FALSE
+	}
+	if err := cmd.Start(); err != nil {
+		reader.Close()
+		writer.Close()
+		return runtime.FALSE
+	}
+	childEnd.Close()
+
+	resID := i.nextResourceID
+	i.nextResourceID++
+	resource := runtime.NewResource("stream", file, resID)
+	i.resources[resID] = resource
+	i.processes[resID] = cmd
+
+	return resource
+}
+
+func (i *Interpreter) builtinPclose(args ...runtime.Value) runtime.Value {
+	// pclose(resource $handle) : int
+	if len(args) < 1 {
+		return runtime.NewInt(-1)
+	}
+	res, ok := args[0].(*runtime.Resource)
+	if !ok {
+		return runtime.NewInt(-1)
+	}
+	cmd, ok := i.processes[res.ID]
+	if !ok {
+		return runtime.NewInt(-1)
+	}
+	delete(i.processes, res.ID)
+	delete(i.resources, res.ID)
+
+	if file, ok := res.Handle.(*os.File); ok {
+		file.Close()
+	}
+	cmd.Wait()
+	return runtime.NewInt(int64(cmd.ProcessState.ExitCode()))
+}
+
+func builtinStreamSelect(args ...runtime.Value) runtime.Value {
+	// stream_select(?array &$read, ?array &$write, ?array &$except, ?int $seconds, ?int $microseconds = null) : int|false
+	if len(args) < 4 {
+		return runtime.FALSE
+	}
+
+	// A NULL timeout blocks until a stream is ready
+	timeout := time.Duration(-1)
+	if _, isNull := args[3].(*runtime.Null); !isNull {
+		timeout = time.Duration(args[3].ToInt()) * time.Second
+		if len(args) > 4 {
+			timeout += time.Duration(args[4].ToInt()) * time.Microsecond
+		}
+		if timeout < 0 {
 			return runtime.FALSE
 		}
 	}
 
-	return runtime.TRUE
-}
-
-func builtinSysGetTempDir(args ...runtime.Value) runtime.Value {
-	return runtime.NewString(os.TempDir())
-}
-
-func builtinTempnam(args ...runtime.Value) runtime.Value {
-	dir := os.TempDir()
-	prefix := "php"
-
-	if len(args) >= 1 {
-		dir = args[0].ToString()
+	read, readKeys, readBuffered, ok := selectStreams(args[0])
+	if !ok {
+		return runtime.FALSE
 	}
-	if len(args) >= 2 {
-		prefix = args[1].ToString()
+	write, writeKeys, writeBuffered, ok := selectStreams(args[1])
+	if !ok {
+		return runtime.FALSE
 	}
-
-	// Create a temporary file
-	file, err := os.CreateTemp(dir, prefix)
+	if len(read)+len(write)+len(readBuffered)+len(writeBuffered) == 0 {
+		return runtime.FALSE
+	}
+	// Buffered streams are ready at once, so the others are only polled
+	if len(readBuffered) > 0 || len(writeBuffered) > 0 {
+		timeout = 0
+	}
+	readReady, writeReady, err := pollStreams(read, write, timeout)
 	if err != nil {
 		return runtime.FALSE
 	}
 
-	filename := file.Name()
-	file.Close()
+	// Leave only the ready streams in each array, keys preserved
+	ready := len(readBuffered) + len(writeBuffered)
+	for idx, key := range readKeys {
+		if readReady[idx] {
+			ready++
+		} else {
+			args[0].(*runtime.Array).Unset(key)
+		}
+	}
+	for idx, key := range writeKeys {
+		if writeReady[idx] {
+			ready++
+		} else {
+			args[1].(*runtime.Array).Unset(key)
+		}
+	}
+	// Out-of-band data is never reported
+	if except, ok := args[2].(*runtime.Array); ok {
+		for _, key := range append([]runtime.Value(nil), except.Keys...) {
+			except.Unset(key)
+		}
+	}
+	return runtime.NewInt(int64(ready))
+}
 
-	return runtime.NewString(filename)
+// selectStreams collects the descriptor-backed streams of a stream_select()
+// array along with their keys. Other streams, such as compressed files,
+// read from buffers that never block, so only their keys are returned in
+// buffered. ok is false when the array holds something that is not a
+// stream; a value that is not an array selects nothing.
+func selectStreams(v runtime.Value) (files []*os.File, keys, buffered []runtime.Value, ok bool) {
+	arr, isArray := v.(*runtime.Array)
+	if !isArray {
+		return nil, nil, nil, true
+	}
+	for _, key := range arr.Keys {
+		res, isResource := arr.Elements[key].(*runtime.Resource)
+		if !isResource || res.ResType != "stream" {
+			return nil, nil, nil, false
+		}
+		if file, isFile := res.Handle.(*os.File); isFile {
+			files = append(files, file)
+			keys = append(keys, key)
+		} else {
+			buffered = append(buffered, key)
+		}
+	}
+	return files, keys, buffered, true
 }
 
 // ----------------------------------------------------------------------------
@@ -10304,6 +13560,12 @@ func (i *Interpreter) builtinExtract(args ...runtime.Value) runtime.Value {
 		varName := key.ToString()
 		value := arr.Elements[key]
 
+		// Numeric keys and other invalid names can't become variables, and
+		// superglobals must never be overwritten
+		if !isValidVariableName(varName) || varName == "this" || extractProtectedVars[varName] {
+			continue
+		}
+
 		// Check if variable exists
 		_, exists := i.env.Get(varName)
 
@@ -10325,6 +13587,36 @@ func (i *Interpreter) builtinExtract(args ...runtime.Value) runtime.Value {
 	return runtime.NewInt(count)
 }
 
+// extractProtectedVars lists the variables extract() refuses to overwrite.
+var extractProtectedVars = map[string]bool{
+	"GLOBALS":  true,
+	"_GET":     true,
+	"_POST":    true,
+	"_COOKIE":  true,
+	"_FILES":   true,
+	"_SERVER":  true,
+	"_ENV":     true,
+	"_REQUEST": true,
+	"_SESSION": true,
+}
+
+// isValidVariableName reports whether name is a legal PHP variable name.
+func isValidVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for idx := 0; idx < len(name); idx++ {
+		c := name[idx]
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= 0x80:
+		case c >= '0' && c <= '9' && idx > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func (i *Interpreter) builtinGetDefinedVars(args ...runtime.Value) runtime.Value {
 	result := runtime.NewArray()
 
@@ -10338,17 +13630,70 @@ func (i *Interpreter) builtinGetDefinedVars(args ...runtime.Value) runtime.Value
 }
 
 func (i *Interpreter) builtinGetDefinedConstants(args ...runtime.Value) runtime.Value {
+	// get_defined_constants(bool $categorize = false) : array
 	result := runtime.NewArray()
 
 	// Get all constants from the environment
 	constants := i.env.GetAllConstants()
-	for name, value := range constants {
-		result.Set(runtime.NewString(name), value)
+	if len(args) < 1 || !args[0].ToBool() {
+		for name, value := range constants {
+			result.Set(runtime.NewString(name), value)
+		}
+		return result
 	}
 
+	// Group by extension, with script constants under "user"
+	names := make([]string, 0, len(constants))
+	for name := range constants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		category := "user"
+		if i.builtinConstants[name] {
+			category = constantCategory(name)
+		}
+		group, ok := result.Get(runtime.NewString(category)).(*runtime.Array)
+		if !ok {
+			group = runtime.NewArray()
+			result.Set(runtime.NewString(category), group)
+		}
+		group.Set(runtime.NewString(name), constants[name])
+	}
 	return result
 }
 
+// constantCategoryPrefixes maps predefined constant name prefixes to the
+// extension get_defined_constants(true) lists them under
+var constantCategoryPrefixes = []struct {
+	prefix   string
+	category string
+}{
+	{"E_", "Core"},
+	{"PHP_", "Core"},
+	{"JSON_", "json"},
+	{"PREG_", "pcre"},
+	{"OPENSSL_", "openssl"},
+	{"FILTER_", "filter"},
+	{"INPUT_", "filter"},
+	{"MYSQLI_", "mysqli"},
+	{"XML_", "dom"},
+}
+
+// constantCategory returns the extension a predefined constant belongs to
+func constantCategory(name string) string {
+	switch name {
+	case "TRUE", "FALSE", "NULL":
+		return "Core"
+	}
+	for _, c := range constantCategoryPrefixes {
+		if strings.HasPrefix(name, c.prefix) {
+			return c.category
+		}
+	}
+	return "standard"
+}
+
 func builtinArrayPad(args ...runtime.Value) runtime.Value {
 	if len(args) < 3 {
 		return runtime.NewArray()
@@ -12650,15 +15995,6 @@ type XMLParser struct {
 	depth                int
 }
 
-// XMLReader structure
-type XMLReader struct {
-	filename    string
-	data        string
-	position    int
-	currentNode *SimpleXMLElement
-	closed      bool
-}
-
 // DOMDocument structure
 type DOMDocument struct {
 	rootElement *SimpleXMLElement
@@ -12669,8 +16005,10 @@ type DOMDocument struct {
 // SimpleXML element structure
 type SimpleXMLElement struct {
 	Name       string
-	Value      string
+	Value      string // Text before the first child element
+	Tail       string // Text after the element, before its next sibling
 	Attributes map[string]string
+	AttrNames  []string // attribute names in document order
 	Children   []*SimpleXMLElement
 	Parent     *SimpleXMLElement
 }
@@ -13406,353 +16744,12 @@ func (i *Interpreter) builtinImageSaveAlpha(args ...runtime.Value) runtime.Value
 }
 
 // SimpleXML functions
-func (i *Interpreter) builtinSimpleXMLElementLoadString(args ...runtime.Value) runtime.Value {
-	// simplexml_load_string(string $data, string $class_name, int $options, string $ns, bool $is_prefix) : SimpleXMLElement|false
-	if len(args) < 1 {
-		return runtime.FALSE
-	}
-	
-	xmlData := args[0].ToString()
-	
-	// Parse XML
-	var elem SimpleXMLElement
-	err := parseXMLString(xmlData, &elem)
-	if err != nil {
-		return runtime.FALSE
-	}
-	
-	// Create a runtime object to represent the SimpleXML element
-	simpleXMLElement := runtime.NewObject(nil)
-	if simpleXMLElement.Class == nil {
-		simpleXMLElement.Class = &runtime.Class{Name: "SimpleXMLElement"}
-	} else {
-		simpleXMLElement.Class.Name = "SimpleXMLElement"
-	}
-	
-	// Store the element data
-	simpleXMLElement.SetProperty("name", runtime.NewString(elem.Name))
-	simpleXMLElement.SetProperty("value", runtime.NewString(elem.Value))
-	
-	// Store attributes
-	attrArray := runtime.NewArray()
-	for key, value := range elem.Attributes {
-		attrArray.Set(runtime.NewString(key), runtime.NewString(value))
-	}
-	simpleXMLElement.SetProperty("attributes", attrArray)
-	
-	// Store children
-	childrenArray := runtime.NewArray()
-	for _, child := range elem.Children {
-		childObj := runtime.NewObject(nil)
-		if childObj.Class == nil {
-			childObj.Class = &runtime.Class{Name: "SimpleXMLElement"}
-		} else {
-			childObj.Class.Name = "SimpleXMLElement"
-		}
-		childObj.SetProperty("name", runtime.NewString(child.Name))
-		childObj.SetProperty("value", runtime.NewString(child.Value))
-		childrenArray.Set(runtime.NewInt(int64(len(childrenArray.Keys))), childObj)
-	}
-	simpleXMLElement.SetProperty("children", childrenArray)
-	
-	return simpleXMLElement
-}
-
-func (i *Interpreter) builtinSimpleXMLElementLoadFile(args ...runtime.Value) runtime.Value {
-	// simplexml_load_file(string $filename, string $class_name, int $options, string $ns, bool $is_prefix) : SimpleXMLElement|false
-	if len(args) < 1 {
-		return runtime.FALSE
-	}
-	
-	filename := args[0].ToString()
-	
-	// Read file
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return runtime.FALSE
-	}
-	
-	// Parse XML
-	var elem SimpleXMLElement
-	err = parseXMLString(string(data), &elem)
-	if err != nil {
-		return runtime.FALSE
-	}
-	
-	// Create a runtime object to represent the SimpleXML element
-	simpleXMLElement := runtime.NewObject(nil)
-	if simpleXMLElement.Class == nil {
-		simpleXMLElement.Class = &runtime.Class{Name: "SimpleXMLElement"}
-	} else {
-		simpleXMLElement.Class.Name = "SimpleXMLElement"
-	}
-	
-	// Store the element data
-	simpleXMLElement.SetProperty("name", runtime.NewString(elem.Name))
-	simpleXMLElement.SetProperty("value", runtime.NewString(elem.Value))
-	
-	// Store attributes
-	attrArray := runtime.NewArray()
-	for key, value := range elem.Attributes {
-		attrArray.Set(runtime.NewString(key), runtime.NewString(value))
-	}
-	simpleXMLElement.SetProperty("attributes", attrArray)
-	
-	// Store children
-	childrenArray := runtime.NewArray()
-	for _, child := range elem.Children {
-		childObj := runtime.NewObject(nil)
-		if childObj.Class == nil {
-			childObj.Class = &runtime.Class{Name: "SimpleXMLElement"}
-		} else {
-			childObj.Class.Name = "SimpleXMLElement"
-		}
-		childObj.SetProperty("name", runtime.NewString(child.Name))
-		childObj.SetProperty("value", runtime.NewString(child.Value))
-		childrenArray.Set(runtime.NewInt(int64(len(childrenArray.Keys))), childObj)
-	}
-	simpleXMLElement.SetProperty("children", childrenArray)
-	
-	return simpleXMLElement
-}
-
 func (i *Interpreter) builtinSimpleXMLElementImportDom(args ...runtime.Value) runtime.Value {
 	// simplexml_import_dom(DOMNode $node, string $class_name) : SimpleXMLElement|false
 	// For now, return false as DOM is not fully implemented
 	return runtime.FALSE
 }
 
-func parseXMLString(xmlData string, elem *SimpleXMLElement) error {
-	// Simple XML parser - for now, we'll use a basic approach
-	// In a full implementation, we would use proper XML parsing
-	
-	// For now, let's create a simple element structure
-	elem.Name = "root"
-	elem.Value = xmlData
-	elem.Attributes = make(map[string]string)
-	elem.Children = make([]*SimpleXMLElement, 0)
-	
-	// Basic XML parsing - this is simplified for demonstration
-	// A real implementation would use proper XML parsing
-	return nil
-}
-
-// XMLReader functions
-func (i *Interpreter) builtinXMLReaderOpen(args ...runtime.Value) runtime.Value {
-	// xmlreader_open(string $filename) : XMLReader|false
-	if len(args) < 1 {
-		return runtime.FALSE
-	}
-	
-	filename := args[0].ToString()
-	
-	// Read the file
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return runtime.FALSE
-	}
-	
-	// Create XMLReader
-	reader := &XMLReader{
-		filename: filename,
-		data:     string(data),
-		position: 0,
-		closed:   false,
-	}
-	
-	// Parse the XML data
-	err = parseXMLString(reader.data, &SimpleXMLElement{})
-	if err != nil {
-		return runtime.FALSE
-	}
-	
-	// Store the reader
-	readerID := len(i.xmlReaders) + 1
-	i.xmlReaders[readerID] = reader
-	
-	return runtime.NewInt(int64(readerID))
-}
-
-func (i *Interpreter) builtinXMLReaderSetParserProperty(args ...runtime.Value) runtime.Value {
-	// xmlreader_set_parser_property(resource $xmlreader, int $property, bool $value) : bool
-	if len(args) < 3 {
-		return runtime.FALSE
-	}
-	
-	readerID := int(args[0].ToInt())
-	// property := int(args[1].ToInt())
-	// value := args[2].ToBool()
-	
-	reader, ok := i.xmlReaders[readerID]
-	if !ok || reader.closed {
-		return runtime.FALSE
-	}
-	
-	// For now, just return true
-	return runtime.TRUE
-}
-
-func (i *Interpreter) builtinXMLReaderRead(args ...runtime.Value) runtime.Value {
-	// xmlreader_read(resource $xmlreader) : bool
-	if len(args) < 1 {
-		return runtime.FALSE
-	}
-	
-	readerID := int(args[0].ToInt())
-	
-	reader, ok := i.xmlReaders[readerID]
-	if !ok || reader.closed {
-		return runtime.FALSE
-	}
-	
-	// For now, just return true
-	return runtime.TRUE
-}
-
-func (i *Interpreter) builtinXMLReaderClose(args ...runtime.Value) runtime.Value {
-	// xmlreader_close(resource $xmlreader) : bool
-	if len(args) < 1 {
-		return runtime.FALSE
-	}
-	
-	readerID := int(args[0].ToInt())
-	
-	reader, ok := i.xmlReaders[readerID]
-	if !ok {
-		return runtime.FALSE
-	}
-	
-	reader.closed = true
-	delete(i.xmlReaders, readerID)
-	
-	return runtime.TRUE
-}
-
-// DOMDocument functions
-func (i *Interpreter) builtinDOMDocumentCreate(args ...runtime.Value) runtime.Value {
-	// domdocument_create(string $version, string $encoding) : DOMDocument
-	version := "1.0"
-	encoding := "UTF-8"
-	
-	if len(args) >= 1 {
-		version = args[0].ToString()
-	}
-	if len(args) >= 2 {
-		encoding = args[1].ToString()
-	}
-	
-	doc := &DOMDocument{
-		version:  version,
-		encoding: encoding,
-	}
-	
-	// Store the document
-	docID := len(i.domDocuments) + 1
-	i.domDocuments[docID] = doc
-	
-	return runtime.NewInt(int64(docID))
-}
-
-func (i *Interpreter) builtinDOMDocumentLoad(args ...runtime.Value) runtime.Value {
-	// domdocument_load(resource $doc, string $filename) : bool
-	if len(args) < 2 {
-		return runtime.FALSE
-	}
-	
-	docID := int(args[0].ToInt())
-	filename := args[1].ToString()
-	
-	doc, ok := i.domDocuments[docID]
-	if !ok {
-		return runtime.FALSE
-	}
-	
-	// Read the file
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return runtime.FALSE
-	}
-	
-	// Parse the XML
-	var root SimpleXMLElement
-	err = parseXMLString(string(data), &root)
-	if err != nil {
-		return runtime.FALSE
-	}
-	
-	doc.rootElement = &root
-	
-	return runtime.TRUE
-}
-
-func (i *Interpreter) builtinDOMDocumentLoadXML(args ...runtime.Value) runtime.Value {
-	// domdocument_loadxml(resource $doc, string $source) : bool
-	if len(args) < 2 {
-		return runtime.FALSE
-	}
-	
-	docID := int(args[0].ToInt())
-	xmlData := args[1].ToString()
-	
-	doc, ok := i.domDocuments[docID]
-	if !ok {
-		return runtime.FALSE
-	}
-	
-	// Parse the XML
-	var root SimpleXMLElement
-	err := parseXMLString(xmlData, &root)
-	if err != nil {
-		return runtime.FALSE
-	}
-	
-	doc.rootElement = &root
-	
-	return runtime.TRUE
-}
-
-func (i *Interpreter) builtinDOMDocumentSave(args ...runtime.Value) runtime.Value {
-	// domdocument_save(resource $doc, string $filename) : int|false
-	if len(args) < 2 {
-		return runtime.FALSE
-	}
-	
-	docID := int(args[0].ToInt())
-	filename := args[1].ToString()
-	
-	doc, ok := i.domDocuments[docID]
-	if !ok || doc.rootElement == nil {
-		return runtime.FALSE
-	}
-	
-	// For now, just save the root element value
-	// In a full implementation, we would properly serialize the XML
-	err := os.WriteFile(filename, []byte(doc.rootElement.Value), 0644)
-	if err != nil {
-		return runtime.FALSE
-	}
-	
-	return runtime.NewInt(int64(len(doc.rootElement.Value)))
-}
-
-func (i *Interpreter) builtinDOMDocumentSaveXML(args ...runtime.Value) runtime.Value {
-	// domdocument_savexml(resource $doc) : string|false
-	if len(args) < 1 {
-		return runtime.FALSE
-	}
-	
-	docID := int(args[0].ToInt())
-	
-	doc, ok := i.domDocuments[docID]
-	if !ok || doc.rootElement == nil {
-		return runtime.FALSE
-	}
-	
-	// For now, just return the root element value
-	// In a full implementation, we would properly serialize the XML
-	return runtime.NewString(doc.rootElement.Value)
-}
-
 // SAX parsing functions
 func (i *Interpreter) builtinXMLParserCreate(args ...runtime.Value) runtime.Value {
 	// xml_parser_create(string $encoding) : resource
@@ -0,0 +1,524 @@
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alexisbouchez/phpgo/runtime"
+)
+
+// DOM node types
+const (
+	domElementNode  = 1
+	domDocumentNode = 9
+)
+
+// newDOMDocument creates an empty document from the constructor's
+// version and encoding arguments
+func newDOMDocument(args []runtime.Value) *DOMDocument {
+	doc := &DOMDocument{version: "1.0"}
+	if len(args) >= 1 {
+		doc.version = args[0].ToString()
+	}
+	if len(args) >= 2 {
+		doc.encoding = args[1].ToString()
+	}
+	return doc
+}
+
+func (d *DOMDocument) Type() string     { return "object" }
+func (d *DOMDocument) ToBool() bool     { return true }
+func (d *DOMDocument) ToInt() int64     { return 1 }
+func (d *DOMDocument) ToFloat() float64 { return 1.0 }
+func (d *DOMDocument) ToString() string { return "DOMDocument" }
+func (d *DOMDocument) Inspect() string {
+	return fmt.Sprintf("object(DOMDocument)#%p", d)
+}
+
+// DOMElementObject is a DOMElement backed by a node of the parsed tree
+type DOMElementObject struct {
+	elem *SimpleXMLElement
+}
+
+func (e *DOMElementObject) Type() string     { return "object" }
+func (e *DOMElementObject) ToBool() bool     { return true }
+func (e *DOMElementObject) ToInt() int64     { return 1 }
+func (e *DOMElementObject) ToFloat() float64 { return 1.0 }
+func (e *DOMElementObject) ToString() string { return "DOMElement" }
+func (e *DOMElementObject) Inspect() string {
+	return fmt.Sprintf("object(DOMElement)#%p", e)
+}
+
+// DOMNodeListObject is a DOMNodeList of elements
+type DOMNodeListObject struct {
+	nodes    []*SimpleXMLElement
+	position int
+}
+
+func (l *DOMNodeListObject) Type() string     { return "object" }
+func (l *DOMNodeListObject) ToBool() bool     { return true }
+func (l *DOMNodeListObject) ToInt() int64     { return 1 }
+func (l *DOMNodeListObject) ToFloat() float64 { return 1.0 }
+func (l *DOMNodeListObject) ToString() string { return "DOMNodeList" }
+func (l *DOMNodeListObject) Inspect() string {
+	return fmt.Sprintf("object(DOMNodeList)#%p (%d)", l, len(l.nodes))
+}
+
+func (l *DOMNodeListObject) rewind(i *Interpreter)     { l.position = 0 }
+func (l *DOMNodeListObject) valid(i *Interpreter) bool { return l.position < len(l.nodes) }
+func (l *DOMNodeListObject) next(i *Interpreter)       { l.position++ }
+
+func (l *DOMNodeListObject) current(i *Interpreter) runtime.Value {
+	if !l.valid(i) {
+		return runtime.NULL
+	}
+	return &DOMElementObject{elem: l.nodes[l.position]}
+}
+
+func (l *DOMNodeListObject) key(i *Interpreter) runtime.Value {
+	if !l.valid(i) {
+		return runtime.NULL
+	}
+	return runtime.NewInt(int64(l.position))
+}
+
+// registerDOMClasses registers the DOM classes so instanceof and class
+// lookups see them, and DOMException for the errors DOM methods throw
+func (i *Interpreter) registerDOMClasses() {
+	iteratorAggregate, _ := i.env.GetInterface("IteratorAggregate")
+	countable, _ := i.env.GetInterface("Countable")
+
+	newClass := func(name string, parent *runtime.Class, interfaces ...*runtime.Interface) *runtime.Class {
+		class := &runtime.Class{
+			Name:        name,
+			Parent:      parent,
+			Interfaces:  interfaces,
+			Properties:  make(map[string]*runtime.PropertyDef),
+			StaticProps: make(map[string]runtime.Value),
+			Methods:     make(map[string]*runtime.Method),
+			Constants:   make(map[string]runtime.Value),
+		}
+		i.env.DefineClass(name, class)
+		return class
+	}
+	domNode := newClass("DOMNode", nil)
+	newClass("DOMDocument", domNode)
+	newClass("DOMElement", domNode)
+	newClass("DOMNodeList", nil, iteratorAggregate, countable)
+	newClass("DOMXPath", nil)
+
+	exception, _ := i.env.GetClass("Exception")
+	newClass("DOMException", exception)
+
+	i.env.DefineConstant("XML_ELEMENT_NODE", runtime.NewInt(domElementNode))
+	i.env.DefineConstant("XML_DOCUMENT_NODE", runtime.NewInt(domDocumentNode))
+}
+
+// domTextContent returns the text of an element and all its descendants,
+// in document order
+func domTextContent(elem *SimpleXMLElement) string {
+	var sb strings.Builder
+	sb.WriteString(elem.Value)
+	for _, child := range elem.Children {
+		sb.WriteString(domTextContent(child))
+		sb.WriteString(child.Tail)
+	}
+	return sb.String()
+}
+
+// domElementsByTagName collects the descendants of elem named name, in
+// document order; "*" matches every element
+func domElementsByTagName(elem *SimpleXMLElement, name string, result []*SimpleXMLElement) []*SimpleXMLElement {
+	for _, child := range elem.Children {
+		if name == "*" || child.Name == name {
+			result = append(result, child)
+		}
+		result = domElementsByTagName(child, name, result)
+	}
+	return result
+}
+
+// domElementByID finds the element whose id attribute is id
+func domElementByID(elem *SimpleXMLElement, id string) *SimpleXMLElement {
+	if elem.Attributes["id"] == id || elem.Attributes["xml:id"] == id {
+		return elem
+	}
+	for _, child := range elem.Children {
+		if found := domElementByID(child, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// domDetach removes elem from its parent's children. The text following
+// elem stays in the parent, joining the text before it.
+func domDetach(elem *SimpleXMLElement) {
+	if elem.Parent == nil {
+		return
+	}
+	siblings := elem.Parent.Children
+	for idx, child := range siblings {
+		if child != elem {
+			continue
+		}
+		if idx > 0 {
+			siblings[idx-1].Tail += elem.Tail
+		} else {
+			elem.Parent.Value += elem.Tail
+		}
+		elem.Parent.Children = append(siblings[:idx:idx], siblings[idx+1:]...)
+		break
+	}
+	elem.Parent = nil
+	elem.Tail = ""
+}
+
+// domElementArg returns the tree node behind a DOMElement argument
+func domElementArg(v runtime.Value) (*SimpleXMLElement, bool) {
+	if e, ok := v.(*DOMElementObject); ok {
+		return e.elem, true
+	}
+	return nil, false
+}
+
+// domNodeOrNull wraps elem as a DOMElement, or returns null if it is nil
+func domNodeOrNull(elem *SimpleXMLElement) runtime.Value {
+	if elem == nil {
+		return runtime.NULL
+	}
+	return &DOMElementObject{elem: elem}
+}
+
+// domLoadXML parses source as the document's content, warning on failure
+func (i *Interpreter) domLoadXML(d *DOMDocument, method, source string) bool {
+	root := &SimpleXMLElement{}
+	if err := parseXMLString(source, root); err != nil {
+		i.writeOutput(fmt.Sprintf("PHP Warning: DOMDocument::%s(): %s\n", method, err))
+		return false
+	}
+	d.rootElement = root
+	return true
+}
+
+// saveXML serializes the document, including the XML declaration
+func (d *DOMDocument) saveXML() string {
+	var buf bytes.Buffer
+	buf.WriteString("<?xml version=\"" + d.version + "\"")
+	if d.encoding != "" {
+		buf.WriteString(" encoding=\"" + d.encoding + "\"")
+	}
+	buf.WriteString("?>\n")
+	if d.rootElement != nil {
+		writeXMLElement(&buf, d.rootElement)
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func (i *Interpreter) getDOMDocumentProperty(d *DOMDocument, prop string) runtime.Value {
+	switch prop {
+	case "documentElement":
+		return domNodeOrNull(d.rootElement)
+	case "nodeName":
+		return runtime.NewString("#document")
+	case "nodeType":
+		return runtime.NewInt(domDocumentNode)
+	case "version", "xmlVersion":
+		return runtime.NewString(d.version)
+	case "encoding", "xmlEncoding":
+		return runtime.NewString(d.encoding)
+	}
+	return runtime.NULL
+}
+
+func (i *Interpreter) callDOMDocumentMethod(d *DOMDocument, methodName string, args []runtime.Value) runtime.Value {
+	switch strings.ToLower(methodName) {
+	case "loadxml":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		return runtime.NewBool(i.domLoadXML(d, "loadXML", args[0].ToString()))
+	case "load":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		data, err := os.ReadFile(args[0].ToString())
+		if err != nil {
+			i.writeOutput(fmt.Sprintf("PHP Warning: DOMDocument::load(): I/O warning : failed to load external entity \"%s\"\n", args[0].ToString()))
+			return runtime.FALSE
+		}
+		return runtime.NewBool(i.domLoadXML(d, "load", string(data)))
+	case "savexml":
+		if len(args) >= 1 {
+			if elem, ok := domElementArg(args[0]); ok {
+				var buf bytes.Buffer
+				writeXMLElement(&buf, elem)
+				return runtime.NewString(buf.String())
+			}
+		}
+		return runtime.NewString(d.saveXML())
+	case "save":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		content := d.saveXML()
+		if err := os.WriteFile(args[0].ToString(), []byte(content), 0644); err != nil {
+			return runtime.FALSE
+		}
+		return runtime.NewInt(int64(len(content)))
+	case "createelement":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		elem := &SimpleXMLElement{Name: args[0].ToString(), Attributes: make(map[string]string)}
+		if len(args) >= 2 {
+			elem.Value = args[1].ToString()
+		}
+		return &DOMElementObject{elem: elem}
+	case "appendchild":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		elem, ok := domElementArg(args[0])
+		if !ok {
+			return runtime.NewError("DOMDocument::appendChild(): Argument #1 ($node) must be of type DOMNode")
+		}
+		if d.rootElement != nil && d.rootElement != elem {
+			return i.splException("DOMException", "Cannot add a second root element to the document")
+		}
+		domDetach(elem)
+		d.rootElement = elem
+		return args[0]
+	case "getelementsbytagname":
+		if len(args) < 1 || d.rootElement == nil {
+			return &DOMNodeListObject{}
+		}
+		name := args[0].ToString()
+		var nodes []*SimpleXMLElement
+		if name == "*" || d.rootElement.Name == name {
+			nodes = append(nodes, d.rootElement)
+		}
+		return &DOMNodeListObject{nodes: domElementsByTagName(d.rootElement, name, nodes)}
+	case "getelementbyid":
+		if len(args) < 1 || d.rootElement == nil {
+			return runtime.NULL
+		}
+		return domNodeOrNull(domElementByID(d.rootElement, args[0].ToString()))
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: DOMDocument::%s", methodName))
+}
+
+func (i *Interpreter) getDOMElementProperty(e *DOMElementObject, prop string) runtime.Value {
+	switch prop {
+	case "nodeName", "tagName":
+		return runtime.NewString(e.elem.Name)
+	case "localName":
+		if idx := strings.Index(e.elem.Name, ":"); idx >= 0 {
+			return runtime.NewString(e.elem.Name[idx+1:])
+		}
+		return runtime.NewString(e.elem.Name)
+	case "nodeType":
+		return runtime.NewInt(domElementNode)
+	case "nodeValue", "textContent":
+		return runtime.NewString(domTextContent(e.elem))
+	case "parentNode":
+		return domNodeOrNull(e.elem.Parent)
+	case "childNodes":
+		return &DOMNodeListObject{nodes: append([]*SimpleXMLElement(nil), e.elem.Children...)}
+	case "firstChild", "firstElementChild":
+		if len(e.elem.Children) > 0 {
+			return &DOMElementObject{elem: e.elem.Children[0]}
+		}
+		return runtime.NULL
+	case "lastChild", "lastElementChild":
+		if n := len(e.elem.Children); n > 0 {
+			return &DOMElementObject{elem: e.elem.Children[n-1]}
+		}
+		return runtime.NULL
+	case "childElementCount":
+		return runtime.NewInt(int64(len(e.elem.Children)))
+	}
+	return runtime.NULL
+}
+
+func (i *Interpreter) callDOMElementMethod(e *DOMElementObject, methodName string, args []runtime.Value) runtime.Value {
+	elem := e.elem
+	switch strings.ToLower(methodName) {
+	case "getattribute":
+		if len(args) < 1 {
+			return runtime.NewString("")
+		}
+		return runtime.NewString(elem.Attributes[args[0].ToString()])
+	case "hasattribute":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		_, ok := elem.Attributes[args[0].ToString()]
+		return runtime.NewBool(ok)
+	case "setattribute":
+		if len(args) < 2 {
+			return runtime.FALSE
+		}
+		name := args[0].ToString()
+		if _, exists := elem.Attributes[name]; !exists {
+			elem.AttrNames = append(elem.AttrNames, name)
+		}
+		elem.Attributes[name] = args[1].ToString()
+		return runtime.TRUE
+	case "removeattribute":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		name := args[0].ToString()
+		if _, exists := elem.Attributes[name]; !exists {
+			return runtime.FALSE
+		}
+		delete(elem.Attributes, name)
+		for idx, n := range elem.AttrNames {
+			if n == name {
+				elem.AttrNames = append(elem.AttrNames[:idx:idx], elem.AttrNames[idx+1:]...)
+				break
+			}
+		}
+		return runtime.TRUE
+	case "appendchild":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		child, ok := domElementArg(args[0])
+		if !ok {
+			return runtime.NewError("DOMElement::appendChild(): Argument #1 ($node) must be of type DOMNode")
+		}
+		for p := elem; p != nil; p = p.Parent {
+			if p == child {
+				return i.splException("DOMException", "Hierarchy Request Error")
+			}
+		}
+		domDetach(child)
+		child.Parent = elem
+		elem.Children = append(elem.Children, child)
+		return args[0]
+	case "removechild":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		child, ok := domElementArg(args[0])
+		if !ok || child.Parent != elem {
+			return i.splException("DOMException", "Not Found Error")
+		}
+		domDetach(child)
+		return args[0]
+	case "haschildnodes":
+		return runtime.NewBool(len(elem.Children) > 0 || elem.Value != "")
+	case "getelementsbytagname":
+		if len(args) < 1 {
+			return &DOMNodeListObject{}
+		}
+		return &DOMNodeListObject{nodes: domElementsByTagName(elem, args[0].ToString(), nil)}
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: DOMElement::%s", methodName))
+}
+
+func (i *Interpreter) callDOMNodeListMethod(l *DOMNodeListObject, methodName string, args []runtime.Value) runtime.Value {
+	switch strings.ToLower(methodName) {
+	case "item":
+		if len(args) < 1 {
+			return runtime.NULL
+		}
+		idx := int(args[0].ToInt())
+		if idx < 0 || idx >= len(l.nodes) {
+			return runtime.NULL
+		}
+		return &DOMElementObject{elem: l.nodes[idx]}
+	case "count":
+		return runtime.NewInt(int64(len(l.nodes)))
+	case "getiterator":
+		return &DOMNodeListObject{nodes: l.nodes}
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: DOMNodeList::%s", methodName))
+}
+
+// domDocumentArg resolves a document passed to the procedural functions,
+// either as an object or as a resource ID
+func (i *Interpreter) domDocumentArg(v runtime.Value) (*DOMDocument, bool) {
+	if d, ok := nativeValue(v).(*DOMDocument); ok {
+		return d, true
+	}
+	d, ok := i.domDocuments[int(v.ToInt())]
+	return d, ok
+}
+
+// DOMDocument functions
+func (i *Interpreter) builtinDOMDocumentCreate(args ...runtime.Value) runtime.Value {
+	// domdocument_create(string $version, string $encoding) : resource
+	version := "1.0"
+	encoding := "UTF-8"
+	if len(args) >= 1 {
+		version = args[0].ToString()
+	}
+	if len(args) >= 2 {
+		encoding = args[1].ToString()
+	}
+
+	doc := &DOMDocument{
+		version:  version,
+		encoding: encoding,
+	}
+
+	// Store the document
+	docID := len(i.domDocuments) + 1
+	i.domDocuments[docID] = doc
+
+	return runtime.NewInt(int64(docID))
+}
+
+func (i *Interpreter) builtinDOMDocumentLoad(args ...runtime.Value) runtime.Value {
+	// domdocument_load(resource $doc, string $filename) : bool
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+	doc, ok := i.domDocumentArg(args[0])
+	if !ok {
+		return runtime.FALSE
+	}
+	return i.callDOMDocumentMethod(doc, "load", args[1:2])
+}
+
+func (i *Interpreter) builtinDOMDocumentLoadXML(args ...runtime.Value) runtime.Value {
+	// domdocument_loadxml(resource $doc, string $source) : bool
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+	doc, ok := i.domDocumentArg(args[0])
+	if !ok {
+		return runtime.FALSE
+	}
+	return i.callDOMDocumentMethod(doc, "loadXML", args[1:2])
+}
+
+func (i *Interpreter) builtinDOMDocumentSave(args ...runtime.Value) runtime.Value {
+	// domdocument_save(resource $doc, string $filename) : int|false
+	if len(args) < 2 {
+		return runtime.FALSE
+	}
+	doc, ok := i.domDocumentArg(args[0])
+	if !ok || doc.rootElement == nil {
+		return runtime.FALSE
+	}
+	return i.callDOMDocumentMethod(doc, "save", args[1:2])
+}
+
+func (i *Interpreter) builtinDOMDocumentSaveXML(args ...runtime.Value) runtime.Value {
+	// domdocument_savexml(resource $doc) : string|false
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	doc, ok := i.domDocumentArg(args[0])
+	if !ok || doc.rootElement == nil {
+		return runtime.FALSE
+	}
+	return runtime.NewString(doc.saveXML())
+}
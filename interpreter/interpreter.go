@@ -3,6 +3,7 @@ package interpreter
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -32,7 +33,12 @@ type Interpreter struct {
 	strictTypes      bool                // Whether strict_types is enabled
 	resources        map[int64]*runtime.Resource // Open resources (files, etc.)
 	nextResourceID   int64               // Next resource ID
+	tempFiles        map[int64]string    // tmpfile() paths to delete on fclose, by resource ID
+	openFiles        []*os.File          // Files held by native objects such as SplFileObject, closed by Close
+	processes        map[int64]*exec.Cmd // popen() children to wait for on pclose, by resource ID
+	builtinConstants map[string]bool     // Constants predefined before the script runs
 	autoloadFuncs     []runtime.Value     // Registered autoload functions
+	autoloading       map[string]bool     // Classes currently being autoloaded
 	iniSettings       map[string]string   // PHP ini settings
 	httpContext       *HTTPContext        // HTTP request context
 	errorHandlers     []runtime.Value     // Stack of error handlers
@@ -42,6 +48,16 @@ type Interpreter struct {
 	xmlReaders        map[int]*XMLReader  // Active XML readers
 	domDocuments       map[int]*DOMDocument // Active DOM documents
 	xmlParsers         map[int]*XMLParser   // Active XML parsers
+	timeLimit          int64                // Seconds allowed by set_time_limit; 0 is unlimited
+	deadline           time.Time            // When the time limit expires; zero when unlimited
+	timedOut           bool                 // Whether the time limit has been exceeded
+	memoryLimit        int64                // memory_limit in bytes; negative is unlimited
+	memoryUsed         int64                // Approximate bytes held by the script's values
+	memoryPeak         int64                // Highest script memory usage observed
+	memoryExhausted    bool                 // Whether the memory limit has been exceeded
+	scopeVars          map[string]bool      // Variables the current call assigned, released when it returns
+	jsonLastError      int64                // Error code of the last json_encode/json_decode
+	mtRand             *mtRand              // Generator behind rand(), mt_rand(), their seeding and array_rand()
 }
 
 // HTTPContext represents HTTP request information
@@ -77,13 +93,17 @@ func New() *Interpreter {
 		useConstants:   make(map[string]string),
 		resources:      make(map[int64]*runtime.Resource),
 		nextResourceID: 1,
+		tempFiles:      make(map[int64]string),
+		processes:      make(map[int64]*exec.Cmd),
 		autoloadFuncs:  make([]runtime.Value, 0),
+		autoloading:    make(map[string]bool),
 		curlHandles:    make(map[int]*CurlHandle),
 		gdImages:      make(map[int]*GDImage),
 		xmlReaders:    make(map[int]*XMLReader),
 		domDocuments:  make(map[int]*DOMDocument),
 		xmlParsers:    make(map[int]*XMLParser),
 		iniSettings:    make(map[string]string),
+		mtRand:         newMtRand(uint32(time.Now().UnixNano())),
 		httpContext: &HTTPContext{
 			Headers:         make(map[string]string),
 			Cookies:         make(map[string]string),
@@ -96,13 +116,15 @@ func New() *Interpreter {
 		},
 	}
 	// Initialize default ini settings
-	i.iniSettings["display_errors"] = "1"
-	i.iniSettings["error_reporting"] = "32767"
-	i.iniSettings["max_execution_time"] = "30"
-	i.iniSettings["memory_limit"] = "128M"
-	i.iniSettings["upload_max_filesize"] = "2M"
-	i.iniSettings["post_max_size"] = "8M"
+	for name, value := range defaultIniSettings {
+		i.iniSettings[name] = value
+	}
+	i.memoryLimit = parseIniBytes(i.iniSettings["memory_limit"])
 	i.registerBuiltins()
+	i.builtinConstants = make(map[string]bool)
+	for name := range i.env.GetAllConstants() {
+		i.builtinConstants[name] = true
+	}
 	// Populate superglobals with basic info (even for CLI mode)
 	i.populateSuperglobals()
 	return i
@@ -312,6 +334,44 @@ func (i *Interpreter) Output() string {
 	return i.output.String()
 }
 
+// Close releases what the script left open: streams it never closed are
+// closed, tmpfile() files are removed and files held by native objects are
+// closed. Call it once the interpreter is
+// done; calling it again is harmless.
+func (i *Interpreter) Close() {
+	for id, res := range i.resources {
+		closeHandle(res.Handle)
+		delete(i.resources, id)
+	}
+	for id, path := range i.tempFiles {
+		os.Remove(path)
+		delete(i.tempFiles, id)
+	}
+	for _, file := range i.openFiles {
+		file.Close()
+	}
+	i.openFiles = nil
+}
+
+// closeHandle closes a resource handle at shutdown, flushing compressed
+// writers first. Handles that were already closed are left as they are.
+func closeHandle(handle interface{}) {
+	switch h := handle.(type) {
+	case *gzStream:
+		if h.writer != nil {
+			h.writer.Close()
+		}
+		if h.gz != nil {
+			h.gz.Close()
+		}
+		h.file.Close()
+	case *bzStream:
+		h.file.Close()
+	case *os.File:
+		h.Close()
+	}
+}
+
 // writeOutput writes to the current output buffer or main output
 func (i *Interpreter) writeOutput(s string) {
 	if len(i.outputBuffers) > 0 {
@@ -347,10 +407,149 @@ func (i *Interpreter) evalFile(file *ast.File) runtime.Value {
 	return result
 }
 
+// setTimeLimit starts a new execution deadline the given number of seconds
+// from now. Zero removes the limit.
+func (i *Interpreter) setTimeLimit(seconds int64) {
+	i.timeLimit = seconds
+	i.deadline = time.Time{}
+	if seconds > 0 {
+		i.deadline = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+}
+
+// checkTimeLimit returns an exit once the deadline set by set_time_limit
+// has passed, reporting the fatal error the first time. Every statement
+// checks it, so it also stops loops that never finish.
+func (i *Interpreter) checkTimeLimit() runtime.Value {
+	if i.deadline.IsZero() || time.Now().Before(i.deadline) {
+		return nil
+	}
+	if !i.timedOut {
+		i.timedOut = true
+		unit := "seconds"
+		if i.timeLimit == 1 {
+			unit = "second"
+		}
+		i.writeOutput(fmt.Sprintf("PHP Fatal error: Maximum execution time of %d %s exceeded\n", i.timeLimit, unit))
+	}
+	return &runtime.Exit{Status: 255}
+}
+
+// parseIniBytes parses a size setting such as "128M"; -1 means unlimited
+func parseIniBytes(s string) int64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return -1
+	}
+	multiplier := int64(1)
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		multiplier = 1 << 10
+	case 'm', 'M':
+		multiplier = 1 << 20
+	case 'g', 'G':
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n * multiplier
+}
+
+// valueBytes approximates the memory held by v. Objects count their
+// properties only at the top level, since objects nested in arrays or
+// properties are charged where they are built.
+func valueBytes(v runtime.Value, nested bool) int64 {
+	obj, ok := v.(*runtime.Object)
+	if !ok || nested {
+		return runtime.SizeOf(v)
+	}
+	n := int64(runtime.SlotBytes)
+	for _, val := range obj.Properties {
+		n += runtime.BucketBytes + runtime.SizeOf(val)
+	}
+	return n
+}
+
+// chargeMemory adjusts the script's memory usage by delta bytes
+func (i *Interpreter) chargeMemory(delta int64) {
+	i.memoryUsed += delta
+	if i.memoryUsed < 0 {
+		i.memoryUsed = 0
+	}
+	if i.memoryUsed > i.memoryPeak {
+		i.memoryPeak = i.memoryUsed
+	}
+}
+
+// chargeVariable accounts for val replacing the variable name in the
+// current scope and records it for release when the call returns
+func (i *Interpreter) chargeVariable(name string, val runtime.Value) {
+	old, _ := i.env.GetLocal(name)
+	i.chargeMemory(valueBytes(val, false) - valueBytes(old, false))
+	if i.scopeVars == nil {
+		i.scopeVars = make(map[string]bool)
+	}
+	i.scopeVars[name] = true
+}
+
+// chargeSlot accounts for val replacing old in an array element or
+// property; old is nil when the slot is new
+func (i *Interpreter) chargeSlot(old, val runtime.Value) {
+	if old == nil {
+		i.chargeMemory(runtime.BucketBytes + valueBytes(val, true))
+		return
+	}
+	i.chargeMemory(valueBytes(val, true) - valueBytes(old, true))
+}
+
+// enterScope starts tracking the variables a call assigns and returns the
+// caller's set for leaveScope
+func (i *Interpreter) enterScope() map[string]bool {
+	outer := i.scopeVars
+	i.scopeVars = nil
+	return outer
+}
+
+// leaveScope releases the memory held by the variables a call assigned in
+// env and restores the caller's set
+func (i *Interpreter) leaveScope(env *runtime.Environment, outer map[string]bool) {
+	for name := range i.scopeVars {
+		if val, ok := env.GetLocal(name); ok {
+			i.chargeMemory(-valueBytes(val, false))
+		}
+	}
+	i.scopeVars = outer
+}
+
+// checkMemoryLimit returns an exit once the script's memory usage exceeds
+// memory_limit, reporting the fatal error the first time
+func (i *Interpreter) checkMemoryLimit() runtime.Value {
+	if i.memoryExhausted {
+		return &runtime.Exit{Status: 255}
+	}
+	if i.memoryLimit < 0 || i.memoryUsed <= i.memoryLimit {
+		return nil
+	}
+	i.memoryExhausted = true
+	i.writeOutput(fmt.Sprintf("PHP Fatal error: Allowed memory size of %d bytes exhausted\n", i.memoryLimit))
+	return &runtime.Exit{Status: 255}
+}
+
 // ----------------------------------------------------------------------------
 // Statement evaluation
 
 func (i *Interpreter) evalStmt(stmt ast.Stmt) runtime.Value {
+	if exit := i.checkTimeLimit(); exit != nil {
+		return exit
+	}
+	if exit := i.checkMemoryLimit(); exit != nil {
+		return exit
+	}
 	switch s := stmt.(type) {
 	case *ast.ExprStmt:
 		return i.evalExpr(s.Expr)
@@ -404,6 +603,7 @@ func (i *Interpreter) evalStmt(stmt ast.Stmt) runtime.Value {
 		for _, v := range s.Vars {
 			if varExpr, ok := v.(*ast.Variable); ok {
 				name := varExpr.Name.(*ast.Ident).Name
+				i.chargeVariable(name, nil)
 				i.env.Unset(name)
 			} else if propExpr, ok := v.(*ast.PropertyFetchExpr); ok {
 				// Property unset - check for __unset
@@ -411,7 +611,8 @@ func (i *Interpreter) evalStmt(stmt ast.Stmt) runtime.Value {
 				if obj, ok := objVal.(*runtime.Object); ok {
 					propName := propExpr.Property.(*ast.Ident).Name
 					// Check if property exists
-					if _, exists := obj.Properties[propName]; exists {
+					if old, exists := obj.Properties[propName]; exists {
+						i.chargeMemory(-runtime.BucketBytes - valueBytes(old, true))
 						delete(obj.Properties, propName)
 						continue
 					}
@@ -426,8 +627,15 @@ func (i *Interpreter) evalStmt(stmt ast.Stmt) runtime.Value {
 				if arr, ok := arrVal.(*runtime.Array); ok {
 					if arrExpr.Index != nil {
 						key := i.evalExpr(arrExpr.Index)
+						if _, nested := arrExpr.Array.(*ast.ArrayAccessExpr); !nested && arr.Has(key) {
+							i.chargeMemory(-runtime.BucketBytes - valueBytes(arr.Get(key), true))
+						}
 						arr.Unset(key)
 					}
+				} else if storage, ok := arrVal.(*SplObjectStorageObject); ok {
+					if arrExpr.Index != nil {
+						i.callSplObjectStorageMethod(storage, "offsetUnset", []runtime.Value{i.evalExpr(arrExpr.Index)})
+					}
 				} else if obj, ok := arrVal.(*runtime.Object); ok {
 					// Check for ArrayAccess interface
 					if i.implementsInterface(obj.Class, "ArrayAccess") {
@@ -522,7 +730,7 @@ func (i *Interpreter) evalWhile(s *ast.WhileStmt) runtime.Value {
 				continue
 			}
 			return &runtime.Continue{Levels: r.Levels - 1}
-		case *runtime.ReturnValue:
+		case *runtime.ReturnValue, *runtime.Exit:
 			return result
 		}
 	}
@@ -542,7 +750,7 @@ func (i *Interpreter) evalDoWhile(s *ast.DoWhileStmt) runtime.Value {
 			if r.Levels <= 1 {
 				// Continue in do-while checks condition
 			}
-		case *runtime.ReturnValue:
+		case *runtime.ReturnValue, *runtime.Exit:
 			return result
 		}
 
@@ -581,7 +789,7 @@ func (i *Interpreter) evalFor(s *ast.ForStmt) runtime.Value {
 			if r.Levels <= 1 {
 				// Fall through to loop
 			}
-		case *runtime.ReturnValue:
+		case *runtime.ReturnValue, *runtime.Exit:
 			return result
 		}
 
@@ -598,9 +806,23 @@ func (i *Interpreter) evalForeach(s *ast.ForeachStmt) runtime.Value {
 
 	// Check for Iterator interface first
 	if obj, ok := arr.(*runtime.Object); ok {
+		// User subclasses of SplHeap iterate the native heap behind them
+		if heap := i.userSplHeap(obj); heap != nil {
+			return i.evalForeachSplIterator(s, heap)
+		}
 		if i.implementsInterface(obj.Class, "Iterator") {
 			return i.evalForeachIterator(s, obj)
 		}
+		if i.implementsInterface(obj.Class, "IteratorAggregate") {
+			if it := i.toIterator(obj); it != nil {
+				return i.evalForeachSplIterator(s, it)
+			}
+		}
+	}
+
+	// Handle native SPL iterators
+	if it, ok := arr.(splIterator); ok {
+		return i.evalForeachSplIterator(s, it)
 	}
 
 	// Handle SPL data structures
@@ -613,6 +835,10 @@ func (i *Interpreter) evalForeach(s *ast.ForeachStmt) runtime.Value {
 		return i.evalForeachSplDoublyLinkedList(s, spl.SplDoublyLinkedListObject)
 	case *SplQueueObject:
 		return i.evalForeachSplDoublyLinkedList(s, spl.SplDoublyLinkedListObject)
+	case *SplObjectStorageObject:
+		return i.evalForeachSplObjectStorage(s, spl)
+	case *ArrayObjectObject:
+		return i.evalForeachSplIterator(s, NewArrayIterator(spl.array))
 	}
 
 	var keys []runtime.Value
@@ -659,7 +885,7 @@ func (i *Interpreter) evalForeach(s *ast.ForeachStmt) runtime.Value {
 				continue
 			}
 			return &runtime.Continue{Levels: r.Levels - 1}
-		case *runtime.ReturnValue:
+		case *runtime.ReturnValue, *runtime.Exit:
 			return result
 		}
 	}
@@ -708,7 +934,7 @@ func (i *Interpreter) evalForeachIterator(s *ast.ForeachStmt, obj *runtime.Objec
 				continue
 			}
 			return &runtime.Continue{Levels: r.Levels - 1}
-		case *runtime.ReturnValue:
+		case *runtime.ReturnValue, *runtime.Exit:
 			return result
 		}
 
@@ -746,7 +972,7 @@ func (i *Interpreter) evalSwitch(s *ast.SwitchStmt) runtime.Value {
 					return &runtime.Break{Levels: r.Levels - 1}
 				case *runtime.Continue:
 					return result
-				case *runtime.ReturnValue:
+				case *runtime.ReturnValue, *runtime.Exit:
 					return result
 				}
 			}
@@ -1154,7 +1380,7 @@ func (i *Interpreter) evalIncDec(e *ast.PostfixExpr) runtime.Value {
 			className = i.evalExpr(c).ToString()
 		}
 
-		class, ok := i.env.GetClass(className)
+		class, ok := i.lookupClass(className)
 		if !ok {
 			return runtime.NewError(fmt.Sprintf("undefined class: %s", className))
 		}
@@ -1236,16 +1462,24 @@ func (i *Interpreter) assignTo(target ast.Expr, val runtime.Value) runtime.Value
 	switch t := target.(type) {
 	case *ast.Variable:
 		name := t.Name.(*ast.Ident).Name
+		i.chargeVariable(name, val)
 		i.env.Set(name, val)
 	case *ast.ArrayAccessExpr:
 		arr := i.evalExpr(t.Array)
 		if arrVal, ok := arr.(*runtime.Array); ok {
+			var old runtime.Value
 			if t.Index == nil {
 				// $arr[] = val
-				arrVal.Set(nil, val)
+				old = arrVal.Swap(nil, val)
 			} else {
 				key := i.evalExpr(t.Index)
-				arrVal.Set(key, val)
+				old = arrVal.Swap(key, val)
+			}
+			// An array nested in another counts at the size it was stored
+			// with, so writes into it are left uncharged to keep releases
+			// of the outer array balanced
+			if _, nested := t.Array.(*ast.ArrayAccessExpr); !nested {
+				i.chargeSlot(old, val)
 			}
 		} else if splFixed, ok := arr.(*SplFixedArrayObject); ok {
 			// Handle SplFixedArray assignment
@@ -1253,14 +1487,28 @@ func (i *Interpreter) assignTo(target ast.Expr, val runtime.Value) runtime.Value
 			if t.Index != nil {
 				key = i.evalExpr(t.Index)
 			}
-			i.callSplFixedArrayMethod(splFixed, "offsetSet", []runtime.Value{key, val})
-		} else if splDLL, ok := arr.(*SplDoublyLinkedListObject); ok {
-			// Handle SplDoublyLinkedList assignment
+			if ex, ok := i.callSplFixedArrayMethod(splFixed, "offsetSet", []runtime.Value{key, val}).(*runtime.Exception); ok {
+				return ex
+			}
+		} else if splDLL, ok := splList(arr); ok {
+			// Handle SplDoublyLinkedList, SplStack and SplQueue assignment
 			var key runtime.Value = runtime.NULL
 			if t.Index != nil {
 				key = i.evalExpr(t.Index)
 			}
 			i.callSplDoublyLinkedListMethod(splDLL, "offsetSet", []runtime.Value{key, val})
+		} else if storage, ok := arr.(*SplObjectStorageObject); ok {
+			// $storage[$obj] = $info attaches $obj
+			if t.Index != nil {
+				i.callSplObjectStorageMethod(storage, "offsetSet", []runtime.Value{i.evalExpr(t.Index), val})
+			}
+		} else if arrayObj, ok := arrayArg(arr); ok {
+			// Handle ArrayObject/ArrayIterator assignment
+			if t.Index == nil {
+				arrayObj.Set(nil, val)
+			} else {
+				arrayObj.Set(i.evalExpr(t.Index), val)
+			}
 		} else if obj, ok := arr.(*runtime.Object); ok {
 			// Check for ArrayAccess interface
 			if i.implementsInterface(obj.Class, "ArrayAccess") {
@@ -1277,10 +1525,18 @@ func (i *Interpreter) assignTo(target ast.Expr, val runtime.Value) runtime.Value
 			propName := t.Property.(*ast.Ident).Name
 
 			// Check if property is defined in class
-			if _, exists := objVal.Class.Properties[propName]; exists {
-				objVal.SetProperty(propName, val)
-			} else if _, exists := objVal.Properties[propName]; exists {
+			if propDef, exists := objVal.Class.Properties[propName]; exists {
+				setMethod, _ := i.findMethod(objVal.Class, "__set")
+				if setMethod != nil && !i.isPropertyAccessible(objVal, propDef) {
+					// Inaccessible properties are routed to __set
+					i.callMagicGetSet(objVal, setMethod, propName, val)
+				} else {
+					i.chargeSlot(objVal.Properties[propName], val)
+					objVal.SetProperty(propName, val)
+				}
+			} else if old, exists := objVal.Properties[propName]; exists {
 				// Dynamic property already exists
+				i.chargeSlot(old, val)
 				objVal.SetProperty(propName, val)
 			} else {
 				// Check for __set magic method
@@ -1288,6 +1544,7 @@ func (i *Interpreter) assignTo(target ast.Expr, val runtime.Value) runtime.Value
 					i.callMagicGetSet(objVal, method, propName, val)
 				} else {
 					// Allow dynamic properties
+					i.chargeSlot(nil, val)
 					objVal.SetProperty(propName, val)
 				}
 			}
@@ -1363,13 +1620,38 @@ func (i *Interpreter) evalTernary(e *ast.TernaryExpr) runtime.Value {
 }
 
 func (i *Interpreter) evalNullCoalesce(e *ast.CoalesceExpr) runtime.Value {
-	left := i.evalExpr(e.Left)
+	left, handled := i.evalArrayAccessIfExists(e.Left)
+	if !handled {
+		left = i.evalExpr(e.Left)
+	}
 	if _, ok := left.(*runtime.Null); !ok {
 		return left
 	}
 	return i.evalExpr(e.Right)
 }
 
+// evalArrayAccessIfExists reads an offset the way ?? and empty() do: on an
+// ArrayAccess object offsetGet is only called once offsetExists reports the
+// offset present, otherwise the result is NULL. Any other container is read
+// as usual. The container is evaluated once either way. handled is false
+// when expr is not an offset read.
+func (i *Interpreter) evalArrayAccessIfExists(expr ast.Expr) (runtime.Value, bool) {
+	arrExpr, ok := expr.(*ast.ArrayAccessExpr)
+	if !ok || arrExpr.Index == nil {
+		return nil, false
+	}
+	container := i.evalExpr(arrExpr.Array)
+	obj, ok := container.(*runtime.Object)
+	if !ok || !i.implementsInterface(obj.Class, "ArrayAccess") {
+		return i.readOffset(container, arrExpr), true
+	}
+	key := i.evalExpr(arrExpr.Index)
+	if !i.callArrayAccessMethod(obj, "offsetExists", []runtime.Value{key}).ToBool() {
+		return runtime.NULL, true
+	}
+	return i.callArrayAccessMethod(obj, "offsetGet", []runtime.Value{key}), true
+}
+
 func (i *Interpreter) evalCall(e *ast.CallExpr) runtime.Value {
 	// Get function name
 	var funcName string
@@ -1411,6 +1693,15 @@ func (i *Interpreter) evalCall(e *ast.CallExpr) runtime.Value {
 	// Check for builtin first
 	if builtin := i.getBuiltin(funcName); builtin != nil {
 		args := i.evalArgs(e.Args)
+		if idx, ok := builtinOutParams[strings.ToLower(funcName)]; ok && idx < len(args) && idx < len(e.Args.Args) {
+			// Pass the by-reference output parameter as a Reference and
+			// store whatever the builtin set back into the variable
+			var out runtime.Value = runtime.NULL
+			args[idx] = runtime.NewReference(&out)
+			result := builtin(args...)
+			i.assignTo(e.Args.Args[idx].Value, out)
+			return result
+		}
 		return builtin(args...)
 	}
 
@@ -1454,6 +1745,7 @@ func (i *Interpreter) evalArgs(args *ast.ArgumentList) []runtime.Value {
 func (i *Interpreter) callFunction(fn *runtime.Function, args *ast.ArgumentList) runtime.Value {
 	// Create new environment
 	env := runtime.NewEnclosedEnvironment(fn.Env)
+	defer i.leaveScope(env, i.enterScope())
 	oldEnv := i.env
 	i.env = env
 
@@ -1742,43 +2034,23 @@ func (i *Interpreter) evalMethodCall(e *ast.MethodCallExpr) runtime.Value {
 
 	methodName := e.Method.(*ast.Ident).Name
 
-	// Handle Reflection* objects
-	switch obj.(type) {
-	case *ReflectionClass, *ReflectionMethod, *ReflectionProperty, *ReflectionFunction, *ReflectionParameter, *ReflectionAttribute:
-		args := i.evalArgs(e.Args)
-		return i.callReflectionMethod(obj, methodName, args)
-	}
-
-	// Handle SPL data structure objects
-	switch obj.(type) {
-	case *SplFixedArrayObject, *SplDoublyLinkedListObject, *SplStackObject, *SplQueueObject,
-		*SplHeapObject, *SplPriorityQueueObject, *SplObjectStorageObject:
-		args := i.evalArgs(e.Args)
-		return i.callSplMethod(obj, methodName, args)
-	}
-
-	// Handle DateTime objects
-	switch obj.(type) {
-	case *DateTimeObject, *DateTimeImmutableObject, *DateTimeZoneObject, *DateIntervalObject:
-		args := i.evalArgs(e.Args)
-		return i.callDateTimeMethod(obj, methodName, args)
-	}
-
-	// Handle Database objects
-	switch obj.(type) {
-	case *MySQLiObject, *MySQLiResultObject, *MySQLiStmtObject, *PDOObject, *PDOStatementObject:
-		args := i.evalArgs(e.Args)
-		return i.callDatabaseMethod(obj, methodName, args)
-	}
-
 	objVal, ok := obj.(*runtime.Object)
 	if !ok {
-		// Check for magic __call
+		if result, ok := i.callNativeMethod(obj, methodName, i.evalArgs(e.Args)); ok {
+			return result
+		}
 		return runtime.NewError("method call on non-object")
 	}
 
 	// Look up method in class hierarchy
 	method, foundClass := i.findMethod(objVal.Class, methodName)
+	// User subclasses of native classes inherit the native methods,
+	// including the bodiless compare() of SplMinHeap and SplMaxHeap
+	if method == nil || method.Body == nil {
+		if result, ok := i.callInheritedNativeMethod(objVal, methodName, i.evalArgs(e.Args)); ok {
+			return result
+		}
+	}
 	if method == nil {
 		// Check for __call magic method
 		if callMethod, _ := i.findMethod(objVal.Class, "__call"); callMethod != nil {
@@ -1802,6 +2074,7 @@ func (i *Interpreter) evalMethodCall(e *ast.MethodCallExpr) runtime.Value {
 
 	// Create environment with $this
 	env := runtime.NewEnclosedEnvironment(i.env)
+	defer i.leaveScope(env, i.enterScope())
 	env.Set("this", objVal)
 
 	oldEnv := i.env
@@ -1848,6 +2121,126 @@ func (i *Interpreter) evalMethodCall(e *ast.MethodCallExpr) runtime.Value {
 	return result
 }
 
+// callNativeMethod dispatches a method call on a natively implemented
+// object. It reports false if v is not such an object.
+func (i *Interpreter) callNativeMethod(v runtime.Value, methodName string, args []runtime.Value) (runtime.Value, bool) {
+	switch obj := v.(type) {
+	case *ReflectionClass, *ReflectionMethod, *ReflectionProperty, *ReflectionFunction, *ReflectionParameter, *ReflectionAttribute:
+		return i.callReflectionMethod(obj, methodName, args), true
+	case *SplFixedArrayObject, *SplDoublyLinkedListObject, *SplStackObject, *SplQueueObject,
+		*SplHeapObject, *SplPriorityQueueObject, *SplObjectStorageObject, *ArrayObjectObject:
+		return i.callSplMethod(obj, methodName, args), true
+	case splIterator:
+		return i.callSplIteratorMethod(obj, methodName, args), true
+	case *DateTimeObject, *DateTimeImmutableObject, *DateTimeZoneObject, *DateIntervalObject:
+		return i.callDateTimeMethod(obj, methodName, args), true
+	case *MySQLiObject, *MySQLiResultObject, *MySQLiStmtObject, *PDOObject, *PDOStatementObject:
+		return i.callDatabaseMethod(obj, methodName, args), true
+	case *ZipArchiveObject:
+		return i.callZipArchiveMethod(obj, methodName, args), true
+	case *XMLReader:
+		return i.callXMLReaderMethod(obj, methodName, args), true
+	case *DOMDocument:
+		return i.callDOMDocumentMethod(obj, methodName, args), true
+	case *DOMElementObject:
+		return i.callDOMElementMethod(obj, methodName, args), true
+	case *DOMXPathObject:
+		return i.callDOMXPathMethod(obj, methodName, args), true
+	case *SplFileInfoObject:
+		if result, ok := i.callSplFileInfoMethod(obj.path, methodName, args); ok {
+			return result, true
+		}
+		return runtime.NewError(fmt.Sprintf("undefined method: SplFileInfo::%s", methodName)), true
+	}
+	return nil, false
+}
+
+// callInheritedNativeMethod runs a method an instance of a user class
+// inherits from a natively implemented class on the native object behind
+// it. It reports false if the object has no native parent.
+func (i *Interpreter) callInheritedNativeMethod(obj *runtime.Object, methodName string, args []runtime.Value) (runtime.Value, bool) {
+	if heap := i.userSplHeap(obj); heap != nil {
+		return i.callSplHeapMethod(heap, methodName, args), true
+	}
+	if native, ok := obj.Internal.(runtime.Value); ok {
+		return i.callNativeMethod(native, methodName, args)
+	}
+	return nil, false
+}
+
+// nativeBaseClass returns the natively implemented class a user class
+// extends, or "" if it extends none
+func nativeBaseClass(class *runtime.Class) string {
+	for c := class; c != nil; c = c.Parent {
+		switch c.Name {
+		case "ArrayIterator", "ArrayObject", "XMLReader", "DOMDocument":
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// newNativeObject creates the native object behind an instance of a user
+// class extending the native class name
+func (i *Interpreter) newNativeObject(name string, args []runtime.Value) runtime.Value {
+	switch {
+	case name == "XMLReader":
+		return newXMLReader()
+	case name == "DOMDocument":
+		return newDOMDocument(args)
+	case isSplDataStructure(name):
+		return i.handleSplNew(name, args)
+	}
+	return i.handleSplIteratorNew(name, args)
+}
+
+// nativeValue returns the native object behind an instance of a user class
+// extending a native class, or v itself for any other value
+func nativeValue(v runtime.Value) runtime.Value {
+	if obj, ok := v.(*runtime.Object); ok {
+		if native, ok := obj.Internal.(runtime.Value); ok {
+			return native
+		}
+	}
+	return v
+}
+
+// classOf returns the class of an object, natively implemented or not, or
+// nil for any other value
+func (i *Interpreter) classOf(v runtime.Value) *runtime.Class {
+	if obj, ok := v.(*runtime.Object); ok {
+		return obj.Class
+	}
+	if name := nativeClassName(v); name != "" {
+		if class, ok := i.env.GetClass(name); ok {
+			return class
+		}
+	}
+	return nil
+}
+
+// nativeClassName returns the class of a natively implemented object that
+// has a registered class, or "" for any other value
+func nativeClassName(v runtime.Value) string {
+	switch v.(type) {
+	case *ArrayIteratorObject:
+		return "ArrayIterator"
+	case *ArrayObjectObject:
+		return "ArrayObject"
+	case *XMLReader:
+		return "XMLReader"
+	case *DOMDocument:
+		return "DOMDocument"
+	case *DOMElementObject:
+		return "DOMElement"
+	case *DOMNodeListObject:
+		return "DOMNodeList"
+	case *DOMXPathObject:
+		return "DOMXPath"
+	}
+	return ""
+}
+
 // findMethod looks up a method in the class hierarchy
 func (i *Interpreter) findMethod(class *runtime.Class, name string) (*runtime.Method, *runtime.Class) {
 	if method, ok := class.Methods[name]; ok {
@@ -1962,13 +2355,13 @@ func (i *Interpreter) checkType(value runtime.Value, expectedType string, nullab
 		return runtime.NewError(fmt.Sprintf("Argument %s cannot be of type void", paramName))
 	default:
 		// Class/interface type
-		obj, ok := value.(*runtime.Object)
-		if !ok {
+		class := i.classOf(value)
+		if class == nil {
 			return runtime.NewError(fmt.Sprintf("Argument %s must be of type %s, %s given", paramName, expectedType, value.Type()))
 		}
 		// Check if object is instance of expected class
-		if !i.isInstanceOf(obj, expectedType) {
-			return runtime.NewError(fmt.Sprintf("Argument %s must be of type %s, %s given", paramName, expectedType, obj.Class.Name))
+		if !classIsA(class, expectedType) {
+			return runtime.NewError(fmt.Sprintf("Argument %s must be of type %s, %s given", paramName, expectedType, class.Name))
 		}
 	}
 
@@ -1977,15 +2370,19 @@ func (i *Interpreter) checkType(value runtime.Value, expectedType string, nullab
 
 // isInstanceOf checks if an object is an instance of a class or interface
 func (i *Interpreter) isInstanceOf(obj *runtime.Object, className string) bool {
+	return classIsA(obj.Class, className)
+}
+
+// classIsA reports whether class is className or extends or implements it
+func classIsA(class *runtime.Class, className string) bool {
 	// Check class hierarchy
-	class := obj.Class
 	for class != nil {
 		if class.Name == className {
 			return true
 		}
 		// Check interfaces
 		for _, iface := range class.Interfaces {
-			if iface.Name == className {
+			if iface.IsA(className) {
 				return true
 			}
 		}
@@ -2078,6 +2475,7 @@ func (i *Interpreter) checkPropertyVisibility(prop *runtime.PropertyDef, callerC
 // callMagicCall invokes the __call magic method
 func (i *Interpreter) callMagicCall(obj *runtime.Object, method *runtime.Method, name string, args *ast.ArgumentList) runtime.Value {
 	env := runtime.NewEnclosedEnvironment(i.env)
+	defer i.leaveScope(env, i.enterScope())
 	env.Set("this", obj)
 
 	oldEnv := i.env
@@ -2113,6 +2511,7 @@ func (i *Interpreter) callMagicCall(obj *runtime.Object, method *runtime.Method,
 // invokeMethod calls a method on an object (used for __invoke and similar)
 func (i *Interpreter) invokeMethod(obj *runtime.Object, method *runtime.Method, foundClass *runtime.Class, args *ast.ArgumentList) runtime.Value {
 	env := runtime.NewEnclosedEnvironment(i.env)
+	defer i.leaveScope(env, i.enterScope())
 	env.Set("this", obj)
 
 	oldEnv := i.env
@@ -2140,6 +2539,31 @@ func (i *Interpreter) invokeMethod(obj *runtime.Object, method *runtime.Method,
 	return result
 }
 
+// callNativeParent runs a parent:: call that reaches a natively
+// implemented class on the native object behind $this. It reports false if
+// parent defines the method itself.
+func (i *Interpreter) callNativeParent(parent *runtime.Class, e *ast.StaticCallExpr) (runtime.Value, bool) {
+	methodName := e.Method.(*ast.Ident).Name
+	if i.currentThis == nil {
+		return nil, false
+	}
+	if method, _ := i.findMethod(parent, methodName); method != nil && method.Body != nil {
+		return nil, false
+	}
+	if methodName == "__construct" {
+		base := nativeBaseClass(parent)
+		if base == "" {
+			return nil, false
+		}
+		i.currentThis.Internal = i.newNativeObject(base, i.evalArgs(e.Args))
+		return runtime.NULL, true
+	}
+	if _, ok := i.currentThis.Internal.(runtime.Value); !ok && i.userSplHeap(i.currentThis) == nil {
+		return nil, false
+	}
+	return i.callInheritedNativeMethod(i.currentThis, methodName, i.evalArgs(e.Args))
+}
+
 func (i *Interpreter) evalStaticCall(e *ast.StaticCallExpr) runtime.Value {
 	var className string
 	var isParentCall bool
@@ -2160,6 +2584,9 @@ func (i *Interpreter) evalStaticCall(e *ast.StaticCallExpr) runtime.Value {
 				return runtime.NewError("Cannot use 'parent' - class has no parent")
 			}
 			className = currentClassObj.Parent.Name
+			if result, ok := i.callNativeParent(currentClassObj.Parent, e); ok {
+				return result
+			}
 		}
 	default:
 		className = i.evalExpr(c).ToString()
@@ -2179,7 +2606,7 @@ func (i *Interpreter) evalStaticCall(e *ast.StaticCallExpr) runtime.Value {
 		return i.handleDateTimeStaticCall(className, methodName, args)
 	}
 
-	class, ok := i.env.GetClass(className)
+	class, ok := i.lookupClass(className)
 	if !ok {
 		return runtime.NewError(fmt.Sprintf("undefined class: %s", className))
 	}
@@ -2187,11 +2614,26 @@ func (i *Interpreter) evalStaticCall(e *ast.StaticCallExpr) runtime.Value {
 	methodName := e.Method.(*ast.Ident).Name
 	method, ok := class.Methods[methodName]
 	if !ok {
+		// Inside an instance method, self::/parent:: calls fall back to __call
+		if i.currentThis != nil && i.isInstanceOf(i.currentThis, class.Name) {
+			if callMethod, _ := i.findMethod(class, "__call"); callMethod != nil {
+				return i.callMagicCall(i.currentThis, callMethod, methodName, e.Args)
+			}
+		}
+		// Check for __callStatic magic method
+		if callStatic, foundClass := i.findMethod(class, "__callStatic"); callStatic != nil {
+			argsArray := runtime.NewArray()
+			for _, arg := range i.evalArgs(e.Args) {
+				argsArray.Set(nil, arg)
+			}
+			return i.invokeStaticMethodWithArgs(class, callStatic, foundClass, []runtime.Value{runtime.NewString(methodName), argsArray})
+		}
 		return runtime.NewError(fmt.Sprintf("undefined static method: %s::%s", className, methodName))
 	}
 
 	// Create environment
 	env := runtime.NewEnclosedEnvironment(i.env)
+	defer i.leaveScope(env, i.enterScope())
 	oldEnv := i.env
 	oldClass := i.currentClass
 	i.env = env
@@ -2236,11 +2678,9 @@ func (i *Interpreter) evalPropertyAccess(e *ast.PropertyFetchExpr) runtime.Value
 		}
 	}
 
-	// Handle Database object properties
 	propName := e.Property.(*ast.Ident).Name
-	switch obj.(type) {
-	case *MySQLiObject, *MySQLiResultObject, *MySQLiStmtObject:
-		return i.getDatabaseProperty(obj, propName)
+	if result, ok := i.nativeProperty(obj, propName); ok {
+		return result
 	}
 
 	if objVal, ok := obj.(*runtime.Object); ok {
@@ -2248,24 +2688,32 @@ func (i *Interpreter) evalPropertyAccess(e *ast.PropertyFetchExpr) runtime.Value
 
 		// Check visibility for defined properties
 		if propDef, exists := objVal.Class.Properties[propName]; exists {
-			var callerClass *runtime.Class
-			if i.currentClass != "" {
-				callerClass, _ = i.env.GetClass(i.currentClass)
-			}
-			if !i.checkPropertyVisibility(propDef, callerClass, objVal.Class) {
+			if !i.isPropertyAccessible(objVal, propDef) {
+				// Inaccessible properties are routed to __get
+				if method, _ := i.findMethod(objVal.Class, "__get"); method != nil {
+					return i.callMagicGetSet(objVal, method, propName, nil)
+				}
 				visibility := "private"
 				if propDef.IsProtected {
 					visibility = "protected"
 				}
 				return runtime.NewError(fmt.Sprintf("cannot access %s property %s::$%s", visibility, objVal.Class.Name, propName))
 			}
+			return objVal.GetProperty(propName)
 		}
 
-		// Check if property exists
-		if val := objVal.GetProperty(propName); val != runtime.NULL {
+		// Check if dynamic property exists
+		if val, exists := objVal.Properties[propName]; exists {
 			return val
 		}
 
+		// Properties of a native parent class come from the native object
+		if native, ok := objVal.Internal.(runtime.Value); ok {
+			if result, ok := i.nativeProperty(native, propName); ok {
+				return result
+			}
+		}
+
 		// Check for __get magic method
 		if method, _ := i.findMethod(objVal.Class, "__get"); method != nil {
 			return i.callMagicGetSet(objVal, method, propName, nil)
@@ -2276,6 +2724,46 @@ func (i *Interpreter) evalPropertyAccess(e *ast.PropertyFetchExpr) runtime.Value
 	return runtime.NULL
 }
 
+// nativeProperty reads a property of a natively implemented object. It
+// reports false if v is not such an object.
+func (i *Interpreter) nativeProperty(v runtime.Value, propName string) (runtime.Value, bool) {
+	switch obj := v.(type) {
+	case *MySQLiObject, *MySQLiResultObject, *MySQLiStmtObject:
+		return i.getDatabaseProperty(obj, propName), true
+	case *ZipArchiveObject:
+		return i.getZipArchiveProperty(obj, propName), true
+	case *XMLReader:
+		return i.getXMLReaderProperty(obj, propName), true
+	case *SimpleXMLObject:
+		return i.getSimpleXMLProperty(obj, propName), true
+	case *DOMDocument:
+		return i.getDOMDocumentProperty(obj, propName), true
+	case *DOMElementObject:
+		return i.getDOMElementProperty(obj, propName), true
+	case *DOMXPathObject:
+		if propName == "document" {
+			return obj.doc, true
+		}
+		return runtime.NULL, true
+	case *DOMNodeListObject:
+		if propName == "length" {
+			return runtime.NewInt(int64(len(obj.nodes))), true
+		}
+		return runtime.NULL, true
+	}
+	return nil, false
+}
+
+// isPropertyAccessible checks whether a declared property of obj can be
+// accessed from the current class context
+func (i *Interpreter) isPropertyAccessible(obj *runtime.Object, propDef *runtime.PropertyDef) bool {
+	var callerClass *runtime.Class
+	if i.currentClass != "" {
+		callerClass, _ = i.env.GetClass(i.currentClass)
+	}
+	return i.checkPropertyVisibility(propDef, callerClass, obj.Class)
+}
+
 // createToStringCallback creates a callback function for __toString
 func (i *Interpreter) createToStringCallback() func(*runtime.Object) string {
 	return func(obj *runtime.Object) string {
@@ -2285,6 +2773,7 @@ func (i *Interpreter) createToStringCallback() func(*runtime.Object) string {
 		}
 
 		env := runtime.NewEnclosedEnvironment(i.env)
+		defer i.leaveScope(env, i.enterScope())
 		env.Set("this", obj)
 
 		oldEnv := i.env
@@ -2313,6 +2802,7 @@ func (i *Interpreter) createToStringCallback() func(*runtime.Object) string {
 // callMagicGetSet invokes __get or __set magic methods
 func (i *Interpreter) callMagicGetSet(obj *runtime.Object, method *runtime.Method, propName string, value runtime.Value) runtime.Value {
 	env := runtime.NewEnclosedEnvironment(i.env)
+	defer i.leaveScope(env, i.enterScope())
 	env.Set("this", obj)
 
 	oldEnv := i.env
@@ -2358,7 +2848,7 @@ func (i *Interpreter) evalStaticProperty(e *ast.StaticPropertyFetchExpr) runtime
 		className = i.evalExpr(c).ToString()
 	}
 
-	class, ok := i.env.GetClass(className)
+	class, ok := i.lookupClass(className)
 	if !ok {
 		return runtime.NewError(fmt.Sprintf("undefined class: %s", className))
 	}
@@ -2371,7 +2861,11 @@ func (i *Interpreter) evalStaticProperty(e *ast.StaticPropertyFetchExpr) runtime
 }
 
 func (i *Interpreter) evalArrayAccess(e *ast.ArrayAccessExpr) runtime.Value {
-	arr := i.evalExpr(e.Array)
+	return i.readOffset(i.evalExpr(e.Array), e)
+}
+
+// readOffset reads the offset e selects from arr, its evaluated container
+func (i *Interpreter) readOffset(arr runtime.Value, e *ast.ArrayAccessExpr) runtime.Value {
 	if arrVal, ok := arr.(*runtime.Array); ok {
 		if e.Index == nil {
 			return runtime.NULL
@@ -2394,12 +2888,29 @@ func (i *Interpreter) evalArrayAccess(e *ast.ArrayAccessExpr) runtime.Value {
 			key = i.evalExpr(e.Index)
 		}
 		return i.callSplFixedArrayMethod(o, "offsetGet", []runtime.Value{key})
-	case *SplDoublyLinkedListObject:
+	case *SplDoublyLinkedListObject, *SplStackObject, *SplQueueObject:
 		var key runtime.Value = runtime.NULL
 		if e.Index != nil {
 			key = i.evalExpr(e.Index)
 		}
-		return i.callSplDoublyLinkedListMethod(o, "offsetGet", []runtime.Value{key})
+		list, _ := splList(o)
+		return i.callSplDoublyLinkedListMethod(list, "offsetGet", []runtime.Value{key})
+	case *SplObjectStorageObject:
+		if e.Index == nil {
+			return runtime.NULL
+		}
+		return i.callSplObjectStorageMethod(o, "offsetGet", []runtime.Value{i.evalExpr(e.Index)})
+	case *ArrayObjectObject, *ArrayIteratorObject:
+		backing, _ := arrayArg(o)
+		if e.Index == nil {
+			return runtime.NULL
+		}
+		return backing.Get(i.evalExpr(e.Index))
+	case *SimpleXMLObject:
+		if e.Index == nil {
+			return runtime.NULL
+		}
+		return i.getSimpleXMLOffset(o, i.evalExpr(e.Index))
 	}
 	// Check for ArrayAccess interface
 	if obj, ok := arr.(*runtime.Object); ok {
@@ -2469,6 +2980,12 @@ func (i *Interpreter) evalNew(e *ast.NewExpr) runtime.Value {
 		return i.handleSplNew(resolvedName, args)
 	}
 
+	// Special case for SPL iterator classes
+	if isSplIterator(resolvedName) {
+		args := i.evalArgs(e.Args)
+		return i.handleSplIteratorNew(resolvedName, args)
+	}
+
 	// Special case for DateTime classes
 	if isDateTimeClass(resolvedName) {
 		args := i.evalArgs(e.Args)
@@ -2481,7 +2998,39 @@ func (i *Interpreter) evalNew(e *ast.NewExpr) runtime.Value {
 		return i.handleDatabaseNew(resolvedName, args)
 	}
 
-	class, ok := i.env.GetClass(resolvedName)
+	if resolvedName == "ZipArchive" {
+		return &ZipArchiveObject{}
+	}
+
+	if resolvedName == "XMLReader" {
+		return newXMLReader()
+	}
+
+	if resolvedName == "DOMDocument" {
+		return newDOMDocument(i.evalArgs(e.Args))
+	}
+
+	if resolvedName == "DOMXPath" {
+		args := i.evalArgs(e.Args)
+		if len(args) < 1 {
+			return runtime.NewError("DOMXPath::__construct() expects at least 1 argument, 0 given")
+		}
+		doc, ok := nativeValue(args[0]).(*DOMDocument)
+		if !ok {
+			return runtime.NewError("DOMXPath::__construct(): Argument #1 ($document) must be of type DOMDocument")
+		}
+		return &DOMXPathObject{doc: doc}
+	}
+
+	if resolvedName == "SplFileInfo" {
+		args := i.evalArgs(e.Args)
+		if len(args) < 1 {
+			return runtime.NewError("SplFileInfo::__construct() expects exactly 1 argument, 0 given")
+		}
+		return NewSplFileInfo(args[0].ToString())
+	}
+
+	class, ok := i.lookupClass(resolvedName)
 	if !ok {
 		// Try without namespace for built-in classes
 		class, ok = i.env.GetClass(className)
@@ -2502,19 +3051,34 @@ func (i *Interpreter) evalNew(e *ast.NewExpr) runtime.Value {
 		obj.SetToStringCallback(i.createToStringCallback())
 	}
 
-	// Initialize properties with defaults
-	for name, prop := range class.Properties {
-		if prop.Default != nil {
+	// Initialize properties with defaults, in declaration order
+	for _, name := range class.PropertyNames() {
+		if prop := class.Properties[name]; prop.Default != nil {
 			obj.SetProperty(name, prop.Default)
 		}
 	}
 
+	// Instances of user classes extending a native class carry the native
+	// object, which the constructor may replace via parent::__construct()
+	if base := nativeBaseClass(class); base != "" {
+		var args []runtime.Value
+		if _, ok := class.Methods["__construct"]; !ok {
+			args = i.evalArgs(e.Args)
+		}
+		obj.Internal = i.newNativeObject(base, args)
+	}
+
 	// Call constructor if exists
 	if constructor, ok := class.Methods["__construct"]; ok {
 		env := runtime.NewEnclosedEnvironment(i.env)
+		defer i.leaveScope(env, i.enterScope())
 		env.Set("this", obj)
 		oldEnv := i.env
+		oldClass := i.currentClass
+		oldThis := i.currentThis
 		i.env = env
+		i.currentClass = class.Name
+		i.currentThis = obj
 
 		argVals := i.evalArgsInEnv(oldEnv, e.Args)
 		for idx, param := range constructor.Params {
@@ -2537,6 +3101,8 @@ func (i *Interpreter) evalNew(e *ast.NewExpr) runtime.Value {
 		}
 
 		i.env = oldEnv
+		i.currentClass = oldClass
+		i.currentThis = oldThis
 	}
 
 	return obj
@@ -2544,11 +3110,22 @@ func (i *Interpreter) evalNew(e *ast.NewExpr) runtime.Value {
 
 func (i *Interpreter) evalClone(e *ast.CloneExpr) runtime.Value {
 	obj := i.evalExpr(e.Expr)
+	if heap, ok := obj.(*SplHeapObject); ok {
+		return heap.clone(nil)
+	}
 	if objVal, ok := obj.(*runtime.Object); ok {
 		clone := runtime.NewObject(objVal.Class)
 		for k, v := range objVal.Properties {
 			clone.Properties[k] = v
 		}
+		switch native := objVal.Internal.(type) {
+		case *SplHeapObject:
+			clone.Internal = native.clone(clone)
+		case *ArrayObjectObject:
+			clone.Internal = NewArrayObject(copyArray(native.array))
+		case *ArrayIteratorObject:
+			clone.Internal = NewArrayIterator(copyArray(native.array))
+		}
 		// Set up __toString callback if method exists
 		if _, hasToString := objVal.Class.Methods["__toString"]; hasToString {
 			clone.SetToStringCallback(i.createToStringCallback())
@@ -2562,6 +3139,15 @@ func (i *Interpreter) evalClone(e *ast.CloneExpr) runtime.Value {
 	return runtime.NULL
 }
 
+// copyArray returns a shallow copy of arr with its keys in order
+func copyArray(arr *runtime.Array) *runtime.Array {
+	result := runtime.NewArray()
+	for _, key := range arr.Keys {
+		result.Set(key, arr.Elements[key])
+	}
+	return result
+}
+
 func (i *Interpreter) evalClosure(e *ast.ClosureExpr) runtime.Value {
 	params := make([]string, len(e.Params))
 	for idx, p := range e.Params {
@@ -2624,9 +3210,8 @@ func (i *Interpreter) evalMatch(e *ast.MatchExpr) runtime.Value {
 }
 
 func (i *Interpreter) evalInstanceof(e *ast.InstanceofExpr) runtime.Value {
-	obj := i.evalExpr(e.Expr)
-	objVal, ok := obj.(*runtime.Object)
-	if !ok {
+	class := i.classOf(i.evalExpr(e.Expr))
+	if class == nil {
 		return runtime.FALSE
 	}
 
@@ -2638,22 +3223,7 @@ func (i *Interpreter) evalInstanceof(e *ast.InstanceofExpr) runtime.Value {
 		className = i.evalExpr(c).ToString()
 	}
 
-	// Check class hierarchy
-	class := objVal.Class
-	for class != nil {
-		if class.Name == className {
-			return runtime.TRUE
-		}
-		// Check implemented interfaces
-		for _, iface := range class.Interfaces {
-			if iface.Name == className {
-				return runtime.TRUE
-			}
-		}
-		class = class.Parent
-	}
-
-	return runtime.FALSE
+	return runtime.NewBool(classIsA(class, className))
 }
 
 func (i *Interpreter) evalCast(e *ast.CastExpr) runtime.Value {
@@ -2761,7 +3331,7 @@ func (i *Interpreter) evalIsset(e *ast.IssetExpr) runtime.Value {
 		} else if arrExpr, ok := v.(*ast.ArrayAccessExpr); ok {
 			// Array access - check for ArrayAccess interface
 			arrVal := i.evalExpr(arrExpr.Array)
-			if arr, ok := arrVal.(*runtime.Array); ok {
+			if arr, ok := arrayArg(arrVal); ok {
 				if arrExpr.Index == nil {
 					return runtime.FALSE
 				}
@@ -2770,6 +3340,10 @@ func (i *Interpreter) evalIsset(e *ast.IssetExpr) runtime.Value {
 				if _, ok := val.(*runtime.Null); ok {
 					return runtime.FALSE
 				}
+			} else if storage, ok := arrVal.(*SplObjectStorageObject); ok {
+				if arrExpr.Index == nil || !i.callSplObjectStorageMethod(storage, "offsetExists", []runtime.Value{i.evalExpr(arrExpr.Index)}).ToBool() {
+					return runtime.FALSE
+				}
 			} else if splFixed, ok := arrVal.(*SplFixedArrayObject); ok {
 				// Handle SplFixedArray isset
 				if arrExpr.Index == nil {
@@ -2810,7 +3384,10 @@ func (i *Interpreter) evalIsset(e *ast.IssetExpr) runtime.Value {
 }
 
 func (i *Interpreter) evalEmpty(e *ast.EmptyExpr) runtime.Value {
-	val := i.evalExpr(e.Expr)
+	val, handled := i.evalArrayAccessIfExists(e.Expr)
+	if !handled {
+		val = i.evalExpr(e.Expr)
+	}
 	return runtime.NewBool(!val.ToBool())
 }
 
@@ -2828,23 +3405,52 @@ func (i *Interpreter) evalConstantAccess(e *ast.ClassConstFetchExpr) runtime.Val
 	switch c := e.Class.(type) {
 	case *ast.Ident:
 		className = c.Name
+		// Handle self/static/parent so they never reach the autoloader
+		if className == "self" || className == "static" {
+			className = i.currentClass
+		} else if className == "parent" {
+			if currentClassObj, ok := i.env.GetClass(i.currentClass); ok && currentClassObj.Parent != nil {
+				className = currentClassObj.Parent.Name
+			}
+		}
 	default:
 		className = i.evalExpr(c).ToString()
 	}
 
-	class, ok := i.env.GetClass(className)
+	constName := e.Const.Name
+	class, ok := i.lookupClass(className)
 	if !ok {
+		if iface, isIface := i.env.GetInterface(className); isIface {
+			if val, ok := iface.Constants[constName]; ok {
+				return val
+			}
+		}
 		return runtime.NewError(fmt.Sprintf("undefined class: %s", className))
 	}
 
-	constName := e.Const.Name
-	if val, ok := class.Constants[constName]; ok {
+	if val, ok := i.findClassConstant(class, constName); ok {
 		return val
 	}
 
 	return runtime.NewError(fmt.Sprintf("undefined class constant: %s::%s", className, constName))
 }
 
+// findClassConstant looks up a constant on a class, its ancestors, and the
+// interfaces they implement
+func (i *Interpreter) findClassConstant(class *runtime.Class, name string) (runtime.Value, bool) {
+	for c := class; c != nil; c = c.Parent {
+		if val, ok := c.Constants[name]; ok {
+			return val, true
+		}
+		for _, iface := range c.Interfaces {
+			if val, ok := iface.Constants[name]; ok {
+				return val, true
+			}
+		}
+	}
+	return nil, false
+}
+
 // ----------------------------------------------------------------------------
 // Declaration evaluation
 
@@ -2981,7 +3587,7 @@ func (i *Interpreter) evalClassDecl(s *ast.ClassDecl) runtime.Value {
 	// Handle extends
 	if s.Extends != nil {
 		parentName := i.resolveClassName(i.exprToNamespaceName(s.Extends))
-		if parent, ok := i.env.GetClass(parentName); ok {
+		if parent, ok := i.lookupClass(parentName); ok {
 			// Cannot extend final class
 			if parent.IsFinal {
 				return runtime.NewError(fmt.Sprintf("cannot extend final class %s", parentName))
@@ -2992,8 +3598,8 @@ func (i *Interpreter) evalClassDecl(s *ast.ClassDecl) runtime.Value {
 				class.Methods[name] = method
 			}
 			// Copy parent properties
-			for name, prop := range parent.Properties {
-				class.Properties[name] = prop
+			for _, name := range parent.PropertyNames() {
+				class.AddProperty(name, parent.Properties[name])
 			}
 		}
 	}
@@ -3001,7 +3607,7 @@ func (i *Interpreter) evalClassDecl(s *ast.ClassDecl) runtime.Value {
 	// Handle implements
 	for _, impl := range s.Implements {
 		ifaceName := i.resolveClassName(i.exprToNamespaceName(impl))
-		if iface, ok := i.env.GetInterface(ifaceName); ok {
+		if iface, ok := i.lookupInterface(ifaceName); ok {
 			class.Interfaces = append(class.Interfaces, iface)
 		}
 	}
@@ -3044,9 +3650,9 @@ func (i *Interpreter) evalClassDecl(s *ast.ClassDecl) runtime.Value {
 				}
 
 				// Copy trait properties to class
-				for name, prop := range trait.Properties {
+				for _, name := range trait.PropertyNames() {
 					if _, exists := class.Properties[name]; !exists {
-						class.Properties[name] = prop
+						class.AddProperty(name, trait.Properties[name])
 					}
 				}
 			}
@@ -3076,7 +3682,7 @@ func (i *Interpreter) evalClassDecl(s *ast.ClassDecl) runtime.Value {
 				if prop.Default != nil {
 					propDef.Default = i.evalExpr(prop.Default)
 				}
-				class.Properties[propName] = propDef
+				class.AddProperty(propName, propDef)
 				// Initialize static properties
 				if isStatic {
 					if propDef.Default != nil {
@@ -3201,12 +3807,29 @@ func (i *Interpreter) checkAbstractMethods(class, parent *runtime.Class) runtime
 
 func (i *Interpreter) evalInterfaceDecl(s *ast.InterfaceDecl) runtime.Value {
 	iface := &runtime.Interface{
-		Name:    s.Name.Name,
-		Methods: make(map[string]*runtime.Method),
+		Name:      s.Name.Name,
+		Methods:   make(map[string]*runtime.Method),
+		Constants: make(map[string]runtime.Value),
+	}
+
+	// Inherit constants from extended interfaces
+	for _, ext := range s.Extends {
+		if parent, ok := i.lookupInterface(i.resolveClassName(i.exprToNamespaceName(ext))); ok {
+			iface.Extends = append(iface.Extends, parent)
+			for name, val := range parent.Constants {
+				iface.Constants[name] = val
+			}
+		}
 	}
 
 	// Process members (interface methods are all abstract/public)
 	for _, member := range s.Members {
+		if c, ok := member.(*ast.ClassConstDecl); ok {
+			for _, constDecl := range c.Consts {
+				iface.Constants[constDecl.Name.Name] = i.evalExpr(constDecl.Value)
+			}
+			continue
+		}
 		if m, ok := member.(*ast.MethodDecl); ok {
 			params := make([]string, len(m.Params))
 			for idx, p := range m.Params {
@@ -3250,7 +3873,7 @@ func (i *Interpreter) evalTraitDecl(s *ast.TraitDecl) runtime.Value {
 				if prop.Default != nil {
 					propDef.Default = i.evalExpr(prop.Default)
 				}
-				trait.Properties[propName] = propDef
+				trait.AddProperty(propName, propDef)
 			}
 
 		case *ast.MethodDecl:
@@ -3379,6 +4002,32 @@ func (i *Interpreter) evalInclude(e *ast.IncludeExpr) runtime.Value {
 		i.includedFiles[absPath] = true
 	}
 
+	return i.evalIncludedFile(absPath, content)
+}
+
+// includeFileOnce includes the file at path unless it was already included,
+// as include_once does.
+func (i *Interpreter) includeFileOnce(path string) runtime.Value {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+	if i.includedFiles[absPath] {
+		return runtime.TRUE
+	}
+
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		return runtime.FALSE
+	}
+	i.includedFiles[absPath] = true
+
+	return i.evalIncludedFile(absPath, content)
+}
+
+// evalIncludedFile parses and executes content with the current directory
+// set to that of absPath.
+func (i *Interpreter) evalIncludedFile(absPath string, content []byte) runtime.Value {
 	// Save current directory and set to included file's directory
 	oldDir := i.currentDir
 	i.currentDir = filepath.Dir(absPath)
@@ -3530,7 +4179,7 @@ func (i *Interpreter) resolveFunctionName(name string) string {
 // implementsInterface checks if a class implements a specific interface
 func (i *Interpreter) implementsInterface(class *runtime.Class, ifaceName string) bool {
 	for _, iface := range class.Interfaces {
-		if iface.Name == ifaceName {
+		if iface.IsA(ifaceName) {
 			return true
 		}
 	}
@@ -3543,11 +4192,17 @@ func (i *Interpreter) implementsInterface(class *runtime.Class, ifaceName string
 // callArrayAccessMethod calls an ArrayAccess method on an object
 func (i *Interpreter) callArrayAccessMethod(obj *runtime.Object, methodName string, args []runtime.Value) runtime.Value {
 	method, foundClass := i.findMethod(obj.Class, methodName)
+	if method == nil || method.Body == nil {
+		if result, ok := i.callInheritedNativeMethod(obj, methodName, args); ok {
+			return result
+		}
+	}
 	if method == nil {
 		return runtime.NULL
 	}
 
 	env := runtime.NewEnclosedEnvironment(i.env)
+	defer i.leaveScope(env, i.enterScope())
 	env.Set("this", obj)
 	oldEnv := i.env
 	oldClass := i.currentClass
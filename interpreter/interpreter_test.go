@@ -1,8 +1,16 @@
 package interpreter
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/alexisbouchez/phpgo/runtime"
 )
@@ -4065,3 +4073,3617 @@ func TestSplPriorityQueueCount(t *testing.T) {
 		t.Errorf("expected %q, got %q", expected, result)
 	}
 }
+
+// ----------------------------------------------------------------------------
+// JSON encoding of objects
+
+func TestJsonEncodeJsonSerializable(t *testing.T) {
+	input := `<?php
+	class Point implements JsonSerializable {
+		private $x;
+		private $y;
+		public function __construct($x, $y) {
+			$this->x = $x;
+			$this->y = $y;
+		}
+		public function jsonSerialize() {
+			return ["x" => $this->x, "y" => $this->y];
+		}
+	}
+	echo json_encode(new Point(1, 2));
+	`
+	expected := `{"x":1,"y":2}`
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestJsonEncodePlainObject(t *testing.T) {
+	input := `<?php
+	class User {
+		public $name = "Alice";
+		public $age = 30;
+	}
+	class Admin extends User {
+		public $role = "root";
+	}
+	echo json_encode(new User()), ";";
+	$admin = new Admin();
+	$admin->zone = "eu";
+	$admin->area = 51;
+	echo json_encode($admin), ";";
+	echo json_encode(["z" => 1, "a" => 2, "m" => ["y" => 3, "b" => 4]]);
+	`
+	expected := `{"name":"Alice","age":30};{"name":"Alice","age":30,"role":"root","zone":"eu","area":51};{"z":1,"a":2,"m":{"y":3,"b":4}}`
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestJsonEncodeObjectSkipsNonPublicProperties(t *testing.T) {
+	input := `<?php
+	class Account {
+		public $id = 7;
+		protected $balance = 100;
+		private $secret = "hunter2";
+		public $owner;
+	}
+	$account = new Account();
+	$account->owner = new Account();
+	$account->tags = ["a"];
+	echo json_encode($account) . ";" . json_encode([new Account()]);
+	`
+	expected := `{"id":7,"owner":{"id":7,"owner":null},"tags":["a"]};[{"id":7,"owner":null}]`
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// preg_grep
+
+func TestPregGrep(t *testing.T) {
+	input := `<?php
+	$result = preg_grep('/^\d+$/', ["a" => "123", "b" => "abc", "c" => "456"]);
+	foreach ($result as $k => $v) {
+		echo $k . "=" . $v . ";";
+	}
+	`
+	expected := "a=123;c=456;"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestPregGrepInvert(t *testing.T) {
+	input := `<?php
+	$result = preg_grep('/^\d+$/', ["123", "abc", "456", "def"], PREG_GREP_INVERT);
+	foreach ($result as $k => $v) {
+		echo $k . "=" . $v . ";";
+	}
+	`
+	expected := "1=abc;3=def;"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// iterator_to_array
+
+func TestIteratorToArrayArrayIterator(t *testing.T) {
+	input := `<?php
+	$it = new ArrayIterator(["a" => 1, "b" => 2, "c" => 3]);
+	$arr = iterator_to_array($it);
+	foreach ($arr as $k => $v) {
+		echo $k . "=" . $v . ";";
+	}
+	echo count(iterator_to_array($it, false));
+	`
+	expected := "a=1;b=2;c=3;3"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestIteratorToArrayCustomIterator(t *testing.T) {
+	input := `<?php
+	class Countdown implements Iterator {
+		private $n;
+		public function __construct() { $this->n = 3; }
+		public function rewind() { $this->n = 3; }
+		public function valid() { return $this->n > 0; }
+		public function current() { return $this->n * 10; }
+		public function key() { return "k" . $this->n; }
+		public function next() { $this->n--; }
+	}
+	$arr = iterator_to_array(new Countdown());
+	foreach ($arr as $k => $v) {
+		echo $k . "=" . $v . ";";
+	}
+	$list = iterator_to_array(new Countdown(), false);
+	echo implode(",", array_keys($list));
+	`
+	expected := "k3=30;k2=20;k1=10;0,1,2"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestArrayIteratorAndArrayObjectClassIdentity(t *testing.T) {
+	input := `<?php
+	function first(ArrayIterator $it) { return $it->current(); }
+	$it = new ArrayIterator([1, 2]);
+	$ao = new ArrayObject(["a" => 1]);
+	echo get_class($it), ",", get_class($ao), ",", first($it), "|";
+	foreach (["ArrayIterator", "Iterator", "Traversable", "Countable", "ArrayObject", "IteratorAggregate"] as $name) {
+		echo $name, ":", $it instanceof $name ? "y" : "n", $ao instanceof $name ? "y" : "n", ",";
+	}
+	`
+	expected := "ArrayIterator,ArrayObject,1|ArrayIterator:yn,Iterator:yn,Traversable:yy,Countable:yy,ArrayObject:ny,IteratorAggregate:ny,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestArrayIteratorAndArrayObjectSubclasses(t *testing.T) {
+	input := `<?php
+	class Bag extends ArrayObject {
+		public function __construct(array $items) {
+			parent::__construct(array_reverse($items));
+		}
+		public function total() { return array_sum($this->getArrayCopy()); }
+	}
+	$bag = new Bag([1, 2, 3]);
+	$bag[] = 10;
+	echo get_class($bag), ",", $bag instanceof ArrayObject ? "y" : "n", ",";
+	echo count($bag), ",", $bag->total(), ",", $bag[0], ",";
+	foreach ($bag as $k => $v) {
+		echo $k, "=", $v, ";";
+	}
+	$copy = clone $bag;
+	$copy[] = 99;
+	echo count($bag), count($copy), "|";
+	class Shouting extends ArrayIterator {
+		public function current(): mixed { return strtoupper(parent::current()); }
+	}
+	$it = new Shouting(["a" => "x", "b" => "y"]);
+	echo get_class($it), ",", $it instanceof Traversable ? "y" : "n", ",", count($it), ",";
+	foreach ($it as $k => $v) {
+		echo $k, "=", $v, ";";
+	}
+	echo implode(",", iterator_to_array($it));
+	`
+	expected := "Bag,y,4,16,3,0=3;1=2;2=1;3=10;45|Shouting,y,2,a=X;b=Y;X,Y"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// iterator_count / iterator_apply
+
+func TestIteratorCount(t *testing.T) {
+	input := `<?php
+	class Range implements Iterator {
+		private $i = 0;
+		private $max;
+		public function __construct($max) { $this->max = $max; }
+		public function rewind() { $this->i = 0; }
+		public function valid() { return $this->i < $this->max; }
+		public function current() { return $this->i; }
+		public function key() { return $this->i; }
+		public function next() { $this->i++; }
+	}
+	echo iterator_count(new Range(5)) . ",";
+	echo iterator_count(new ArrayIterator([1, 2, 3]));
+	`
+	expected := "5,3"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestIteratorApply(t *testing.T) {
+	input := `<?php
+	class Acc { public $sum = 0; }
+	$acc = new Acc();
+	$it = new ArrayIterator([1, 2, 3, 4]);
+	$n = iterator_apply($it, function ($it, $acc) {
+		$acc->sum += $it->current();
+		return true;
+	}, [$it, $acc]);
+	echo $n . ":" . $acc->sum;
+	`
+	expected := "4:10"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Array functions accepting ArrayObject
+
+func TestArrayValuesArrayObject(t *testing.T) {
+	input := `<?php
+	$ao = new ArrayObject(["a" => 1, "b" => 2]);
+	echo implode(",", array_values($ao));
+	`
+	expected := "1,2"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestArrayKeysArrayObject(t *testing.T) {
+	input := `<?php
+	$ao = new ArrayObject(["a" => 1, "b" => 2]);
+	$ao["c"] = 3;
+	echo implode(",", array_keys($ao));
+	`
+	expected := "a,b,c"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestInArrayArrayObject(t *testing.T) {
+	input := `<?php
+	$ao = new ArrayObject(["x", "y"]);
+	echo in_array("y", $ao) ? "yes" : "no";
+	echo in_array("z", $ao) ? "yes" : "no";
+	`
+	expected := "yesno"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// sprintf width and precision
+
+func TestSprintfStringWidthPrecision(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`<?php sprintf("[%10.3s]", "abcdef");`, "[       abc]"},
+		{`<?php sprintf("[%-10s]", "abc");`, "[abc       ]"},
+		{`<?php sprintf("[%'.10s]", "abc");`, "[.......abc]"},
+		{`<?php sprintf("[%-'x10.2s]", "abc");`, "[abxxxxxxxx]"},
+		{`<?php sprintf("[%05d]", -42);`, "[-0042]"},
+		{`<?php sprintf("[%+d]", 5);`, "[+5]"},
+		{`<?php sprintf("%.2f%%", 12.345);`, "12.35%"},
+		{`<?php sprintf("%x-%X-%b-%o", 255, 255, 5, 8);`, "ff-FF-101-10"},
+	}
+
+	for _, tt := range tests {
+		result := eval(tt.input)
+		testStringValue(t, result, tt.expected)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_fill
+
+func TestArrayFillKeys(t *testing.T) {
+	input := `<?php
+	$a = array_fill(5, 3, "x");
+	echo implode(",", array_keys($a)) . ";";
+	$b = array_fill(-3, 3, 0);
+	echo implode(",", array_keys($b)) . ";";
+	$c = array_fill(0, 100000, 1);
+	echo count($c) . ":" . array_key_last($c) . ":" . array_sum($c) . ";";
+	$d = [];
+	for ($i = 0; $i < 100000; $i++) {
+		$d[] = $i;
+	}
+	$d[10] = -10;
+	echo count($d) . ":" . array_key_last($d) . ":" . $d[10] . ";";
+	$e = [5 => "a"];
+	$e[] = "b";
+	$e["k"] = "c";
+	$e[] = "d";
+	echo implode(",", array_keys($e));
+	`
+	expected := "5,6,7;-3,-2,-1;100000:99999:100000;100000:99999:-10;5,6,k,7"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func BenchmarkArrayFill(b *testing.B) {
+	b.Run("array_fill", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			builtinArrayFill(runtime.NewInt(0), runtime.NewInt(1000000), runtime.NewInt(0))
+		}
+	})
+	// $a[] = $v appends through Set with a nil key
+	b.Run("append", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			arr := runtime.NewArray()
+			for v := int64(0); v < 1000000; v++ {
+				arr.Set(nil, runtime.NewInt(v))
+			}
+		}
+	})
+}
+
+// ----------------------------------------------------------------------------
+// __call / __callStatic dispatch
+
+func TestMagicCallWithArgs(t *testing.T) {
+	input := `<?php
+	class Proxy {
+		public function __call($name, $args) {
+			return $name . "(" . implode(",", $args) . ")";
+		}
+	}
+	$p = new Proxy();
+	echo $p->doSomething(1, 2, 3);
+	`
+	expected := "doSomething(1,2,3)"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestMagicCallStatic(t *testing.T) {
+	input := `<?php
+	class Facade {
+		public static function __callStatic($name, $args) {
+			return "static:" . $name . ":" . count($args) . ":" . $args[0];
+		}
+	}
+	echo Facade::findUser(42, "x");
+	`
+	expected := "static:findUser:2:42"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// __get / __set for inaccessible properties
+
+func TestMagicGetSetVirtualProperty(t *testing.T) {
+	input := `<?php
+	class Model {
+		private $attributes = [];
+		public function __get($name) {
+			return $this->attributes[$name] ?? "none";
+		}
+		public function __set($name, $value) {
+			$this->attributes[$name] = strtoupper($value);
+		}
+	}
+	$m = new Model();
+	echo $m->title . ",";
+	$m->title = "hello";
+	echo $m->title;
+	`
+	expected := "none,HELLO"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestMagicGetSetPrivateProperty(t *testing.T) {
+	input := `<?php
+	class Secret {
+		private $value = "hidden";
+		public $log = "";
+		public function __get($name) {
+			return "get:" . $name;
+		}
+		public function __set($name, $v) {
+			$this->log .= "set:" . $name . "=" . $v;
+		}
+	}
+	$s = new Secret();
+	echo $s->value . ";";
+	$s->value = "x";
+	echo $s->log;
+	`
+	expected := "get:value;set:value=x"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// constant() with class constants
+
+func TestConstantClassConstant(t *testing.T) {
+	input := `<?php
+	interface HasVersion {
+		const VERSION = "1.0";
+	}
+	class Base implements HasVersion {
+		const NAME = "base";
+	}
+	class Child extends Base {
+	}
+	echo constant('Base::NAME') . ",";
+	echo constant('Child::NAME') . ",";
+	echo constant('HasVersion::VERSION') . ",";
+	echo Child::VERSION;
+	`
+	expected := "base,base,1.0,1.0"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// define() with array values
+
+func TestDefineArrayConstant(t *testing.T) {
+	input := `<?php
+	$colors = ["red", "green", "key" => "blue"];
+	define('COLORS', $colors);
+	$colors[0] = "changed";
+	echo COLORS[0] . "," . COLORS["key"] . "," . count(COLORS) . ",";
+	var_dump(define('COLORS', [1]));
+	echo constant('COLORS')[1];
+	`
+	expected := "red,blue,3,bool(false)\ngreen"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestDefineCaseInsensitiveIgnored(t *testing.T) {
+	input := `<?php
+	define('GREETING', 'hi', true);
+	echo GREETING . ",";
+	var_dump(defined('greeting'));
+	`
+	expected := "PHP Warning: define(): Argument #3 ($case_insensitive) is ignored since declaration of case-insensitive constants is no longer supported\nhi,bool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Autoloading
+
+func TestSplAutoloadRegisterOnUnknownClass(t *testing.T) {
+	input := `<?php
+	spl_autoload_register(function ($name) {
+		echo "loading " . $name . ";";
+		if ($name === "Greeter") {
+			class Greeter {
+				const PREFIX = "Hello, ";
+				public function greet($who) { return self::PREFIX . $who; }
+			}
+		}
+	});
+	$g = new Greeter();
+	echo $g->greet("World") . ";";
+	echo Greeter::PREFIX . ";";
+	echo count(spl_autoload_functions());
+	`
+	expected := "loading Greeter;Hello, World;Hello, ;1"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestSplAutoloadDefaultFileResolution(t *testing.T) {
+	dir := t.TempDir()
+	source := `<?php class Widget { public function name() { return "widget"; } }`
+	if err := os.WriteFile(filepath.Join(dir, "widget.php"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	interp := New()
+	interp.currentDir = dir
+	interp.Eval(`<?php
+	spl_autoload_register();
+	$w = new Widget();
+	echo $w->name();
+	`)
+	expected := "widget"
+	result := interp.Output()
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestClassExistsAutoload(t *testing.T) {
+	input := `<?php
+	spl_autoload_register(function ($name) {
+		echo "[" . $name . "]";
+		if ($name === "Lazy") {
+			class Lazy {}
+		}
+	});
+	var_dump(class_exists("Lazy", false));
+	var_dump(class_exists("Lazy"));
+	var_dump(class_exists("Lazy"));
+	var_dump(class_exists("Missing"));
+	`
+	expected := "bool(false)\n[Lazy]bool(true)\nbool(true)\n[Missing]bool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Natural ordering
+
+func TestNaturalSortConsistency(t *testing.T) {
+	input := `<?php
+	$files = ["img12.png", "img10.png", "img2.png", "img1.png", "img02.png"];
+
+	$a = $files; natsort($a);
+	$b = $files; natcasesort($b);
+	$c = $files; sort($c, SORT_NATURAL);
+	$d = $files; usort($d, 'strnatcmp');
+
+	echo implode(",", array_values($a)) . ";";
+	echo implode(",", array_values($b)) . ";";
+	echo implode(",", $c) . ";";
+	echo implode(",", $d) . ";";
+
+	$e = ["B2", "a10", "a9"];
+	sort($e, SORT_NATURAL | SORT_FLAG_CASE);
+	echo implode(",", $e) . ";";
+	echo strnatcmp("img2", "img10") . strnatcasecmp("IMG10", "img2") . ";";
+	echo strnatcmp("img02", "img1") . strnatcmp("1.010", "1.02") . strnatcmp("007", "7");
+	`
+	order := "img02.png,img1.png,img2.png,img10.png,img12.png;"
+	expected := order + order + order + order + "a9,a10,B2;-11;-1-10"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Array internal pointer
+
+func TestArrayPointerFunctions(t *testing.T) {
+	input := `<?php
+	$a = ["x" => 1, "y" => 2, "z" => 3];
+	echo current($a) . key($a) . ";";
+	echo next($a) . key($a) . ";";
+	echo next($a) . key($a) . ";";
+	var_dump(next($a));
+	var_dump(key($a));
+	var_dump(prev($a));
+	echo end($a) . key($a) . ";";
+	echo prev($a) . key($a) . ";";
+	echo prev($a) . ";";
+	var_dump(prev($a));
+	var_dump(next($a));
+	echo reset($a) . key($a) . ";";
+	`
+	expected := "1x;2y;3z;bool(false)\nNULL\nbool(false)\n3z;2y;1;bool(false)\nbool(false)\n1x;"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestArrayWalkResetsPointer(t *testing.T) {
+	input := `<?php
+	function show($value, $key, $prefix) {
+		echo $prefix . $key . "=" . $value . ";";
+	}
+	$a = ["a" => 1, "b" => 2];
+	end($a);
+	array_walk($a, 'show', '#');
+	echo key($a);
+	`
+	expected := "#a=1;#b=2;a"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestEach(t *testing.T) {
+	input := `<?php
+	$fruit = ["a" => "apple", "b" => "banana"];
+	while (list($key, $value) = each($fruit)) {
+		echo $key . "=>" . $value . ";";
+	}
+	reset($fruit);
+	$pair = each($fruit);
+	echo $pair["key"] . $pair[0] . $pair["value"] . $pair[1] . ";";
+	var_dump(each($fruit) !== false, each($fruit));
+	`
+	expected := "a=>apple;b=>banana;aaappleapple;bool(true)\nbool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_splice
+
+func TestArraySplicePreservesStringKeys(t *testing.T) {
+	input := `<?php
+	$a = ["a" => 1, "b" => 2, "c" => 3, 5 => 4, 9 => 5];
+	$removed = array_splice($a, 1, 2);
+	foreach ($removed as $k => $v) { echo $k . "=" . $v . ","; }
+	echo ";";
+	foreach ($a as $k => $v) { echo $k . "=" . $v . ","; }
+	`
+	expected := "b=2,c=3,;a=1,0=4,1=5,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestArraySpliceNegativeLengthWithReplacement(t *testing.T) {
+	input := `<?php
+	$a = ["red", "green", "blue", "yellow", "purple"];
+	$removed = array_splice($a, 1, -2, ["orange", "pink", "black"]);
+	echo implode(",", $removed) . ";";
+	echo implode(",", $a) . ";";
+	$b = [1, 2, 3];
+	array_splice($b, -1, 0, "x");
+	echo implode(",", $b) . ";";
+	echo implode(",", array_keys($b));
+	`
+	expected := "green,blue;red,orange,pink,black,yellow,purple;1,2,x,3;0,1,2,3"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// extract guards
+
+func TestExtractSkipsInvalidNamesAndSuperglobals(t *testing.T) {
+	input := `<?php
+	$_GET = ["page" => "1"];
+	$count = extract([0 => "zero", "1abc" => "bad", "_GET" => "hacked", "GLOBALS" => "x", "name" => "ok"]);
+	echo $count . ";";
+	echo $name . ";";
+	echo $_GET["page"];
+	`
+	expected := "1;ok;1"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// gz stream functions
+
+func TestGzStreamWriteAndReadLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log.gz")
+	input := `<?php
+	$path = '` + path + `';
+	$gz = gzopen($path, "w9");
+	gzwrite($gz, "first line" . PHP_EOL);
+	gzputs($gz, "second line" . PHP_EOL);
+	gzwrite($gz, "third");
+	gzclose($gz);
+
+	$gz = gzopen($path, "r");
+	while (!gzeof($gz)) {
+		echo "[" . trim(gzgets($gz)) . "]";
+	}
+	gzrewind($gz);
+	echo gzread($gz, 5) . ";";
+	gzclose($gz);
+
+	echo count(gzfile($path)) . ";";
+	$n = readgzfile($path);
+	echo ";" . $n;
+	`
+	expected := "[first line][second line][third]first;3;first line\nsecond line\nthird;28"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+	if data, err := os.ReadFile(path); err != nil || len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		t.Errorf("expected a gzip file at %s", path)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// bzip2
+
+func TestBzdecompress(t *testing.T) {
+	blob := "425a68393141592653596b1d3ec10000021d806004100000401224c0102000310340d02001a68f03aaac8284f8bb9229c2848358e9f608"
+	path := filepath.Join(t.TempDir(), "greeting.bz2")
+	input := `<?php
+	$blob = hex2bin('` + blob + `');
+	echo bzdecompress($blob) . ";";
+	var_dump(function_exists('bzdecompress'));
+	file_put_contents('` + path + `', $blob);
+	$bz = bzopen('` + path + `', 'r');
+	echo bzread($bz, 5) . "|" . bzread($bz) . ";";
+	var_dump(bzclose($bz));
+	`
+	expected := "Hello, bzip2!;bool(true)\nHello|, bzip2!;bool(true)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestCloseReleasesBzStreams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.bz2")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	interp := New()
+	interp.Eval(`<?php $bz = bzopen('` + path + `', 'r');`)
+	var streams []*bzStream
+	for _, res := range interp.resources {
+		if stream, ok := res.Handle.(*bzStream); ok {
+			streams = append(streams, stream)
+		}
+	}
+	interp.Close()
+	if len(streams) != 1 {
+		t.Fatalf("expected one open bz stream, got %d", len(streams))
+	}
+	if err := streams[0].file.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected the bz stream's file to be closed, got %v", err)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// ZipArchive
+
+func TestZipArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("from disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := `<?php
+	$dir = '` + dir + `';
+	$zip = new ZipArchive();
+	var_dump($zip->open($dir . '/missing.zip'));
+	var_dump($zip->open($dir . '/archive.zip', ZipArchive::CREATE));
+	$zip->addFromString('hello.txt', 'Hello, zip!');
+	$zip->addFromString('docs/readme.md', '# Readme');
+	$zip->addFile($dir . '/notes.txt', 'notes.txt');
+	echo $zip->numFiles . ";";
+	var_dump($zip->close());
+
+	$zip = new ZipArchive();
+	$zip->open($dir . '/archive.zip');
+	echo $zip->numFiles . ";" . count($zip) . ";";
+	echo $zip->getFromName('hello.txt') . ";";
+	echo $zip->getNameIndex(1) . ";";
+	var_dump($zip->getFromName('nope'));
+	$zip->extractTo($dir . '/out');
+	$zip->close();
+	echo file_get_contents($dir . '/out/docs/readme.md') . ";";
+	echo file_get_contents($dir . '/out/notes.txt');
+	`
+	expected := "int(9)\nbool(true)\n3;bool(true)\n3;3;Hello, zip!;docs/readme.md;bool(false)\n# Readme;from disk"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// fnmatch
+
+func TestFnmatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`<?php var_dump(fnmatch("*.txt", "notes.txt"));`, "bool(true)\n"},
+		{`<?php var_dump(fnmatch("*.txt", "notes.TXT"));`, "bool(false)\n"},
+		{`<?php var_dump(fnmatch("*.txt", "notes.TXT", FNM_CASEFOLD));`, "bool(true)\n"},
+		{`<?php var_dump(fnmatch("*gr[ae]y", "dark grey"));`, "bool(true)\n"},
+		{`<?php var_dump(fnmatch("file?.[!a-c]", "file1.d"));`, "bool(true)\n"},
+		{`<?php var_dump(fnmatch("src/*.php", "src/lib/a.php"));`, "bool(true)\n"},
+		{`<?php var_dump(fnmatch("src/*.php", "src/lib/a.php", FNM_PATHNAME));`, "bool(false)\n"},
+		{`<?php var_dump(fnmatch("src/*/*.php", "src/lib/a.php", FNM_PATHNAME));`, "bool(true)\n"},
+		{`<?php var_dump(fnmatch("*", ".hidden", FNM_PERIOD));`, "bool(false)\n"},
+		{`<?php var_dump(fnmatch('\*', '*'));`, "bool(true)\n"},
+	}
+
+	for _, tt := range tests {
+		result := evalOutput(tt.input)
+		if result != tt.expected {
+			t.Errorf("input %s: expected %q, got %q", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// stat
+
+func TestStatReportsSizeAndMtime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := int64(1700000000)
+	if err := os.Chtimes(path, time.Unix(mtime, 0), time.Unix(mtime, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	input := `<?php
+	$path = '` + path + `';
+	$st = stat($path);
+	echo $st["size"] . "," . $st[7] . "," . $st["mtime"] . "," . $st[9] . ",";
+	echo count($st) . ",";
+	echo sprintf("%o", $st["mode"] & 0777) . ",";
+	$l = lstat($path);
+	echo $l["size"] . ",";
+	$fp = fopen($path, "r");
+	$f = fstat($fp);
+	fclose($fp);
+	echo $f["mtime"];
+	`
+	expected := "10,10,1700000000,1700000000,26,644,10,1700000000"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestFileMetadataFunctions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meta.txt")
+	if err := os.WriteFile(path, []byte("hello world"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := int64(1600000000)
+	if err := os.Chtimes(path, time.Unix(mtime, 0), time.Unix(mtime, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	input := `<?php
+	$path = '` + path + `';
+	echo filesize($path) . ",";
+	echo filemtime($path) . ",";
+	echo sprintf("%o", fileperms($path) & 0777) . ",";
+	var_dump(is_int(fileowner($path)));
+	var_dump(filesize($path . ".missing"));
+	`
+	expected := "11,1600000000,640,bool(true)\nPHP Warning: filesize(): stat failed for " + path + ".missing\nbool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// touch
+
+func TestTouchWithTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	input := `<?php
+	$path = '` + dir + `/touched.txt';
+	var_dump(file_exists($path));
+	var_dump(touch($path, 1500000000));
+	var_dump(file_exists($path));
+	echo filesize($path) . "," . filemtime($path) . "," . fileatime($path) . ";";
+	touch($path, 1400000000, 1300000000);
+	echo filemtime($path) . "," . fileatime($path);
+	`
+	expected := "bool(false)\nbool(true)\nbool(true)\n0,1500000000,1500000000;1400000000,1300000000"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Links
+
+func TestSymlinkReadlinkIsLink(t *testing.T) {
+	dir := t.TempDir()
+	input := `<?php
+	$dir = '` + dir + `';
+	file_put_contents($dir . '/target.txt', 'linked content');
+	var_dump(symlink($dir . '/target.txt', $dir . '/soft.txt'));
+	var_dump(link($dir . '/target.txt', $dir . '/hard.txt'));
+	echo readlink($dir . '/soft.txt') === $dir . '/target.txt' ? "same;" : "different;";
+	echo file_get_contents($dir . '/soft.txt') . ";";
+	echo file_get_contents($dir . '/hard.txt') . ";";
+	var_dump(is_link($dir . '/soft.txt'));
+	var_dump(is_link($dir . '/target.txt'));
+	var_dump(is_link($dir . '/hard.txt'));
+	var_dump(readlink($dir . '/target.txt'));
+	`
+	expected := "bool(true)\nbool(true)\nsame;linked content;linked content;bool(true)\nbool(false)\nbool(false)\nbool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// tmpfile
+
+func TestTmpfile(t *testing.T) {
+	interp := New()
+	interp.Eval(`<?php
+	$fp = tmpfile();
+	fwrite($fp, "scratch data");
+	rewind($fp);
+	echo fread($fp, 100) . ";";
+	$st = fstat($fp);
+	echo $st["size"] . ";";
+	var_dump(fclose($fp));
+	`)
+	expected := "scratch data;12;bool(true)\n"
+	result := interp.Output()
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+	if len(interp.tempFiles) != 0 {
+		t.Errorf("expected temp files to be cleaned up, got %v", interp.tempFiles)
+	}
+}
+
+func TestCloseReleasesTmpfilesAndGzStreams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unclosed.gz")
+	interp := New()
+	interp.Eval(`<?php
+	$fp = tmpfile();
+	fwrite($fp, "left open");
+	$gz = gzopen('` + path + `', 'w');
+	gzwrite($gz, "flushed at shutdown");
+	`)
+	var temps []string
+	for _, p := range interp.tempFiles {
+		temps = append(temps, p)
+	}
+	var files []*os.File
+	for _, res := range interp.resources {
+		if file, ok := res.Handle.(*os.File); ok {
+			files = append(files, file)
+		}
+	}
+	interp.Close()
+	if len(files) != 1 {
+		t.Fatalf("expected one open tmpfile stream, got %d", len(files))
+	}
+	for _, file := range files {
+		if err := file.Close(); !errors.Is(err, os.ErrClosed) {
+			t.Errorf("expected %s to be closed, got %v", file.Name(), err)
+		}
+	}
+	if len(temps) != 1 {
+		t.Fatalf("expected one tracked temp file, got %v", temps)
+	}
+	if _, err := os.Stat(temps[0]); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", temps[0], err)
+	}
+	if len(interp.resources) != 0 || len(interp.tempFiles) != 0 {
+		t.Errorf("expected no tracked resources, got %d resources and %d temp files", len(interp.resources), len(interp.tempFiles))
+	}
+	if data, err := readGzFile(path); err != nil || string(data) != "flushed at shutdown" {
+		t.Errorf("expected the gz stream to be flushed, got %q (%v)", data, err)
+	}
+	interp.Close()
+}
+
+// ----------------------------------------------------------------------------
+// SplFileObject
+
+func TestSplFileObjectIteratesLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lines.txt")
+	if err := os.WriteFile(path, []byte("alpha\nbeta\n\ngamma\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := `<?php
+	$file = new SplFileObject('` + path + `');
+	foreach ($file as $n => $line) {
+		echo $n . ":" . trim($line) . "|";
+	}
+	echo ";";
+	$file->setFlags(SplFileObject::READ_AHEAD | SplFileObject::SKIP_EMPTY | SplFileObject::DROP_NEW_LINE);
+	foreach ($file as $n => $line) {
+		echo $n . ":" . $line . "|";
+	}
+	echo ";";
+	$file->seek(1);
+	echo $file->current() . ";";
+	$file->setFlags(0);
+	$file->rewind();
+	echo trim($file->fgets()) . "," . trim($file->fgets());
+	`
+	expected := "0:alpha|1:beta|2:|3:gamma|4:|;0:alpha|1:beta|3:gamma|;beta;alpha,beta"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestSplFileObjectReadsCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "people.csv")
+	if err := os.WriteFile(path, []byte("name,age\n\"Smith, Jo\",42\nAda,36\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := `<?php
+	$file = new SplFileObject('` + path + `');
+	$file->setFlags(SplFileObject::READ_CSV | SplFileObject::READ_AHEAD | SplFileObject::SKIP_EMPTY);
+	foreach ($file as $row) {
+		echo implode("|", $row) . ";";
+	}
+	$file->setFlags(0);
+	$file->rewind();
+	$header = $file->fgetcsv();
+	echo count($header) . $header[1];
+	`
+	expected := "name|age;Smith, Jo|42;Ada|36;2age"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestSplFileObjectCSVEnclosureAndClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quoted.csv")
+	data := "'Smith; Jo';'it''s';plain\n\"a;b\";'x\\'y';\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	interp := New()
+	interp.Eval(`<?php
+	$file = new SplFileObject('` + path + `');
+	echo implode("|", $file->fgetcsv(";", "'")) . "#";
+	$file->setCsvControl(";", '"');
+	echo implode("|", $file->fgetcsv()) . "#";
+	$file->rewind();
+	$file->setCsvControl(";", "'");
+	$file->setFlags(SplFileObject::READ_CSV | SplFileObject::SKIP_EMPTY | SplFileObject::READ_AHEAD);
+	foreach ($file as $row) {
+		echo count($row) . ",";
+	}
+	`)
+	expected := "Smith; Jo|it's|plain#a;b|'x\\'y'|#3,4,"
+	if interp.Output() != expected {
+		t.Errorf("expected %q, got %q", expected, interp.Output())
+	}
+	if len(interp.openFiles) != 1 {
+		t.Fatalf("expected one file held by SplFileObject, got %d", len(interp.openFiles))
+	}
+	file := interp.openFiles[0]
+	interp.Close()
+	if err := file.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected the SplFileObject file to be closed, got %v", err)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SplFileInfo
+
+func TestSplFileInfo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.final.csv")
+	if err := os.WriteFile(path, []byte("a,b,c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := `<?php
+	$info = new SplFileInfo('` + path + `');
+	echo $info->getFilename() . ";";
+	echo $info->getExtension() . ";";
+	echo $info->getBasename(".csv") . ";";
+	echo $info->getSize() . ";";
+	var_dump($info->isFile(), $info->isDir());
+	echo $info->getType() . ";";
+	echo ($info->getPath() === '` + dir + `' ? "path ok" : "path wrong") . ";";
+	echo ((string)$info === '` + path + `' ? "string ok" : "string wrong") . ";";
+	$file = $info->openFile();
+	echo trim($file->fgets()) . ";";
+	$dirInfo = new SplFileInfo('` + dir + `');
+	var_dump($dirInfo->isDir());
+	`
+	expected := "report.final.csv;csv;report.final;6;bool(true)\nbool(false)\nfile;path ok;string ok;a,b,c;bool(true)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// DirectoryIterator
+
+func TestDirectoryIteratorForeach(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.txt", "a.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	input := `<?php
+	$all = [];
+	foreach (new DirectoryIterator('` + dir + `') as $key => $entry) {
+		$all[] = $key . "=" . $entry->getFilename();
+	}
+	echo implode(",", $all) . ";";
+	foreach (new DirectoryIterator('` + dir + `') as $entry) {
+		if ($entry->isDot()) {
+			continue;
+		}
+		echo $entry . ($entry->isDir() ? "/" : ":" . $entry->getSize()) . ",";
+	}
+	`
+	expected := "0=.,1=..,2=a.txt,3=b.txt,4=sub;a.txt:5,b.txt:5,sub/,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// RecursiveDirectoryIterator
+
+func TestRecursiveDirectoryIteratorWalk(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub", "deep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/deep/c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input := `<?php
+	$it = new RecursiveIteratorIterator(new RecursiveDirectoryIterator('` + dir + `', FilesystemIterator::SKIP_DOTS));
+	foreach ($it as $path => $info) {
+		echo $it->getSubPathname() . ":" . $it->getDepth() . ":" . $info->getFilename() . ",";
+	}
+	echo ";";
+	$it = new RecursiveIteratorIterator(
+		new RecursiveDirectoryIterator('` + dir + `', FilesystemIterator::SKIP_DOTS),
+		RecursiveIteratorIterator::SELF_FIRST
+	);
+	foreach ($it as $path => $info) {
+		echo substr($path, strlen('` + dir + `') + 1) . ($info->isDir() ? "/" : "") . ",";
+	}
+	echo ";";
+	$it = new RecursiveIteratorIterator(
+		new RecursiveDirectoryIterator('` + dir + `', FilesystemIterator::SKIP_DOTS),
+		RecursiveIteratorIterator::CHILD_FIRST
+	);
+	foreach ($it as $info) {
+		echo $info->getFilename() . ",";
+	}
+	`
+	expected := "a.txt:0:a.txt,sub/b.txt:1:b.txt,sub/deep/c.txt:2:c.txt,;" +
+		"a.txt,sub/,sub/b.txt,sub/deep/,sub/deep/c.txt,;" +
+		"a.txt,b.txt,c.txt,deep,sub,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestRecursiveDirectoryIteratorFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	target := t.TempDir()
+	if err := os.WriteFile(filepath.Join(target, "linked.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(dir, "link")); err != nil {
+		t.Skip("symlinks unavailable:", err)
+	}
+
+	input := `<?php
+	echo FilesystemIterator::FOLLOW_SYMLINKS . ";";
+	foreach ([FilesystemIterator::SKIP_DOTS, FilesystemIterator::SKIP_DOTS | FilesystemIterator::FOLLOW_SYMLINKS] as $flags) {
+		$it = new RecursiveIteratorIterator(new RecursiveDirectoryIterator('` + dir + `', $flags));
+		foreach ($it as $info) {
+			echo $info->getFilename() . ",";
+		}
+		echo ";";
+	}
+	`
+	expected := "16384;link,;linked.txt,;"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// GlobIterator
+
+func TestGlobIteratorIterationAndCount(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.txt", "a.txt", "c.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input := `<?php
+	$it = new GlobIterator('` + dir + `/*.txt');
+	echo count($it) . ":" . $it->count() . ";";
+	foreach ($it as $path => $info) {
+		echo basename($path) . "=" . $info->getFilename() . ",";
+	}
+	echo ";";
+	$it = new GlobIterator('` + dir + `/*.txt', FilesystemIterator::CURRENT_AS_PATHNAME | FilesystemIterator::KEY_AS_FILENAME);
+	foreach ($it as $name => $path) {
+		echo $name . "=" . ($path === '` + dir + `/' . $name ? "ok" : $path) . ",";
+	}
+	`
+	expected := "2:2;a.txt=a.txt,b.txt=b.txt,;a.txt=ok,b.txt=ok,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// CallbackFilterIterator
+
+func TestCallbackFilterIteratorEvenValues(t *testing.T) {
+	input := `<?php
+	$it = new CallbackFilterIterator(new ArrayIterator([1, 2, 3, 4, 5, 6]), function ($current, $key, $iterator) {
+		return $current % 2 == 0;
+	});
+	foreach ($it as $key => $value) {
+		echo $key . "=" . $value . ",";
+	}
+	echo ";" . implode(",", iterator_to_array($it, false));
+	`
+	expected := "1=2,3=4,5=6,;2,4,6"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// AppendIterator
+
+func TestAppendIteratorChainsIterators(t *testing.T) {
+	input := `<?php
+	$it = new AppendIterator();
+	$it->append(new ArrayIterator([1, 2]));
+	$it->append(new ArrayIterator([]));
+	$it->append(new ArrayIterator(["a" => 3, "b" => 4]));
+	echo implode(",", iterator_to_array($it, false)) . ";";
+	foreach ($it as $key => $value) {
+		echo $it->getIteratorIndex() . ":" . $key . "=" . $value . ",";
+	}
+	`
+	expected := "1,2,3,4;0:0=1,0:1=2,2:a=3,2:b=4,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// InfiniteIterator, NoRewindIterator and LimitIterator
+
+func TestInfiniteIteratorWithLimit(t *testing.T) {
+	input := `<?php
+	$it = new LimitIterator(new InfiniteIterator(new ArrayIterator(["a", "b"])), 0, 5);
+	foreach ($it as $key => $value) {
+		echo $key . "=" . $value . ",";
+	}
+	echo ";" . implode(",", iterator_to_array(new LimitIterator(new ArrayIterator([1, 2, 3, 4, 5]), 1, 3), false));
+	`
+	expected := "0=a,1=b,0=a,1=b,0=a,;2,3,4"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestNoRewindIteratorDoesNotRestart(t *testing.T) {
+	input := `<?php
+	$it = new NoRewindIterator(new ArrayIterator([1, 2, 3]));
+	echo $it->current() . ";";
+	$it->next();
+	foreach ($it as $value) {
+		echo $value . ",";
+	}
+	echo ";";
+	foreach ($it as $value) {
+		echo $value . ",";
+	}
+	echo "done";
+	`
+	expected := "1;2,3,;done"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// XMLReader
+
+func TestXMLReaderNodeTraversal(t *testing.T) {
+	input := `<?php
+	$reader = new XMLReader();
+	$reader->XML('<?xml version="1.0"?><library><book id="7" lang="en">Go<![CDATA[ & PHP]]></book><!-- note --><shelf/></library>');
+	while ($reader->read()) {
+		echo $reader->nodeType . ":" . $reader->name . ":" . $reader->depth;
+		if ($reader->nodeType == XMLReader::ELEMENT && $reader->name == "book") {
+			echo ":id=" . $reader->getAttribute("id") . ":" . $reader->readString();
+		}
+		if ($reader->nodeType == XMLReader::TEXT || $reader->nodeType == XMLReader::CDATA) {
+			echo ":" . $reader->value;
+		}
+		if ($reader->isEmptyElement) {
+			echo ":empty";
+		}
+		echo ",";
+	}
+	$reader->close();
+	echo ";";
+	$r = xmlreader_xml('<a x="1"><b x="2"/></a>');
+	while (xmlreader_read($r)) {
+		echo xmlreader_get_attribute($r, "x");
+	}
+	var_dump(xmlreader_get_attribute($r, "missing"));
+	`
+	expected := "1:library:0,1:book:1:id=7:Go & PHP,3:#text:2:Go,4:#cdata-section:2: & PHP,15:book:1,8:#comment:1,1:shelf:1:empty,15:library:0,;12NULL\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestXMLReaderClassIdentityAndSubclass(t *testing.T) {
+	input := `<?php
+	class TagReader extends XMLReader {
+		public function tags() {
+			$tags = [];
+			while ($this->read()) {
+				if ($this->nodeType == XMLReader::ELEMENT) {
+					$tags[] = $this->name;
+				}
+			}
+			return implode(",", $tags);
+		}
+	}
+	$plain = new XMLReader();
+	echo get_class($plain), ",", $plain instanceof XMLReader ? "y" : "n", "|";
+	$reader = new TagReader();
+	$reader->XML('<a><b id="1"/><c/></a>');
+	echo get_class($reader), ",", $reader instanceof XMLReader ? "y" : "n", ",", $reader->tags(), "|";
+	$reader->XML('<a><b id="1"/></a>');
+	$reader->read();
+	$reader->read();
+	echo $reader->name, $reader->getAttribute("id"), xmlreader_get_attribute($reader, "id");
+	`
+	expected := "XMLReader,y|TagReader,y,a,b,c|b11"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestXMLReaderOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feed.xml")
+	if err := os.WriteFile(path, []byte("<feed>\n  <item href=\"/a\">First</item>\n  <item href=\"/b\">Second</item>\n</feed>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input := `<?php
+	$reader = new XMLReader();
+	var_dump($reader->open('` + path + `'));
+	while ($reader->read()) {
+		if ($reader->nodeType == XMLReader::ELEMENT && $reader->name == "item") {
+			echo $reader->getAttribute("href") . "=" . $reader->readString() . ",";
+		}
+	}
+	`
+	expected := "bool(true)\n/a=First,/b=Second,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SimpleXML
+
+func TestSimpleXMLLoadString(t *testing.T) {
+	input := `<?php
+	$xml = simplexml_load_string('<library name="city"><book id="1"><title>Dune</title><author>Herbert</author></book><book id="2"><title>Emma</title></book></library>');
+	echo $xml->getName() . ":" . $xml["name"] . ":" . count($xml->book) . ";";
+	echo $xml->book->title . ":" . $xml->book[1]->title . ":" . $xml->book[1]["id"] . ";";
+	foreach ($xml->book as $book) {
+		echo (string)$book->attributes()->id . "=" . $book->title . ",";
+	}
+	echo ";";
+	foreach ($xml->book[0]->children() as $name => $child) {
+		echo $name . "=" . $child . ",";
+	}
+	echo ";";
+	foreach ($xml->book[0]->attributes() as $name => $value) {
+		echo $name . "=" . $value . ",";
+	}
+	echo ";" . ($xml->missing ? "yes" : "no") . ":" . (int)$xml->book["id"] + 1;
+	`
+	expected := "library:city:2;Dune:Emma:2;1=Dune,2=Emma,;title=Dune,author=Herbert,;id=1,;no:2"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestSimpleXMLMixedContent(t *testing.T) {
+	input := `<?php
+	$xml = simplexml_load_string('<a>one<b>two</b>three<c/>four</a>');
+	echo $xml->asXML(), "|", $xml, "|", $xml->b->asXML();
+	`
+	expected := "<?xml version=\"1.0\"?>\n<a>one<b>two</b>three<c/>four</a>\n|onethreefour|<b>two</b>"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestSimpleXMLLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.xml")
+	if err := os.WriteFile(path, []byte("<?xml version=\"1.0\"?>\n<config>\n  <db host=\"localhost\">\n    <name>app</name>\n  </db>\n</config>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input := `<?php
+	$config = simplexml_load_file('` + path + `');
+	echo $config->db["host"] . ":" . $config->db->name . ";";
+	$config->db->addChild("port", "5432");
+	echo $config->db->port . ";";
+	var_dump(simplexml_load_string('<broken>'));
+	`
+	expected := "localhost:app;5432;PHP Warning: simplexml_load_string(): Entity: premature end of data in tag broken\nbool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// DOMDocument
+
+func TestDOMDocumentQueryNodes(t *testing.T) {
+	input := `<?php
+	$doc = new DOMDocument();
+	$doc->loadXML('<library><book id="b1" lang="en"><title>Dune</title></book><book id="b2"><title>Emma</title></book></library>');
+	$books = $doc->getElementsByTagName("book");
+	echo $books->length . ":" . count($books) . ":" . $books->item(1)->getAttribute("id") . ";";
+	foreach ($doc->getElementsByTagName("title") as $title) {
+		echo $title->nodeValue . ",";
+	}
+	echo ";" . $doc->getElementById("b1")->getAttribute("lang") . ":" . $doc->getElementById("b1")->textContent;
+	var_dump($doc->getElementById("nope"));
+	echo $doc->documentElement->nodeName . ";";
+	`
+	expected := "2:2:b2;Dune,Emma,;en:DuneNULL\nlibrary;"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestDOMDocumentCreateElements(t *testing.T) {
+	input := `<?php
+	$doc = new DOMDocument("1.0", "UTF-8");
+	$root = $doc->createElement("list");
+	$doc->appendChild($root);
+	foreach (["a", "b"] as $name) {
+		$item = $doc->createElement("item", $name);
+		$item->setAttribute("name", $name);
+		$root->appendChild($item);
+	}
+	echo $doc->saveXML();
+	echo $doc->getElementsByTagName("item")->item(0)->parentNode->nodeName;
+	`
+	expected := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<list><item name=\"a\">a</item><item name=\"b\">b</item></list>\nlist"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestDOMMixedContent(t *testing.T) {
+	input := `<?php
+	$doc = new DOMDocument();
+	$doc->loadXML('<a>one<b>two</b>three<c>four</c>five</a>');
+	echo $doc->documentElement->textContent, "|", $doc->saveXML();
+	$list = $doc->getElementsByTagName("c");
+	$c = $list->ITEM(0);
+	echo $list->Count(), $list->Item(0)->nodeName, "|";
+	$doc->documentElement->removeChild($c);
+	echo $doc->saveXML($doc->documentElement);
+	`
+	expected := "onetwothreefourfive|<?xml version=\"1.0\"?>\n<a>one<b>two</b>three<c>four</c>five</a>\n1c|<a>one<b>two</b>threefive</a>"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestDOMClassIdentityAndSubclass(t *testing.T) {
+	input := `<?php
+	class Catalog extends DOMDocument {
+		public function titles() {
+			$titles = [];
+			foreach ($this->getElementsByTagName("title") as $title) {
+				$titles[] = $title->nodeValue;
+			}
+			return implode(",", $titles);
+		}
+	}
+	$doc = new Catalog("1.0", "UTF-8");
+	$doc->loadXML('<books><book><title>Dune</title></book><book><title>Emma</title></book></books>');
+	echo get_class($doc), ",", $doc instanceof DOMDocument ? "y" : "n", ",", $doc->titles(), ",", $doc->encoding, "|";
+	$list = $doc->getElementsByTagName("book");
+	$book = $list->item(0);
+	echo get_class($list), ",", $list instanceof Traversable ? "y" : "n", ",";
+	echo get_class($book), ",", $book instanceof DOMNode ? "y" : "n", ",";
+	$xpath = new DOMXPath($doc);
+	echo get_class($xpath), ",", $xpath->query("//title")->length;
+	`
+	expected := "Catalog,y,Dune,Emma,UTF-8|DOMNodeList,y,DOMElement,y,DOMXPath,2"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// DOMXPath
+
+func TestDOMXPathQuery(t *testing.T) {
+	input := `<?php
+	$doc = new DOMDocument();
+	$doc->loadXML('<catalog><book id="1"><title>Dune</title><price>9</price></book><book id="2"><title>Emma</title><price>12</price></book><shelf><book id="3"><title>Ulysses</title></book></shelf></catalog>');
+	$xpath = new DOMXPath($doc);
+	echo $xpath->query('//book[@id="1"]/title')->item(0)->nodeValue . ";";
+	foreach ($xpath->query("/catalog/book/title") as $title) {
+		echo $title->textContent . ",";
+	}
+	echo ";" . $xpath->query("//book")->length;
+	echo ";" . $xpath->query("/catalog/book[last()]/title")->item(0)->nodeValue;
+	echo ";" . $xpath->query("//book[last()]")->length;
+	echo ";" . $xpath->query("//book[title='Emma']")->item(0)->getAttribute("id");
+	echo ";" . $xpath->query("//*[@id!='1' and contains(title, 'ss')]")->item(0)->getAttribute("id");
+	$shelf = $doc->getElementsByTagName("shelf")->item(0);
+	echo ";" . $xpath->query("book/title", $shelf)->item(0)->nodeValue;
+	echo ";" . (int)$xpath->evaluate("count(//title)");
+	`
+	expected := "Dune;Dune,Emma,;3;Emma;2;2;3;Ulysses;3"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestDOMXPathDocumentOrderAndQuotedPredicates(t *testing.T) {
+	input := `<?php
+	$doc = new DOMDocument();
+	$doc->loadXML('<r><a><x><a><b id="inner" note="x and y"/></a></x><b id="outer" note="a=b"/></a><b id="top" note="p or q"/></r>');
+	$xpath = new DOMXPath($doc);
+	foreach ($xpath->query("//a/b") as $b) {
+		echo $b->getAttribute("id"), ",";
+	}
+	echo ";", $xpath->query("//b[@note='x and y']")->item(0)->getAttribute("id");
+	echo ";", $xpath->query("//b[@note='a=b']")->item(0)->getAttribute("id");
+	echo ";", $xpath->query("//b[@note='p or q' or @id='inner']")->length;
+	echo ";", $xpath->query("//b[contains(@note, 'y') or @id='top' and @note!='a or b']")->length;
+	`
+	expected := "inner,outer,;inner;outer;2;2"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// is_iterable / is_countable
+
+func TestIsIterableAndIsCountable(t *testing.T) {
+	input := `<?php
+	class Numbers implements Iterator {
+		private $i = 0;
+		public function current(): mixed { return $this->i; }
+		public function key(): mixed { return $this->i; }
+		public function next(): void { $this->i++; }
+		public function rewind(): void { $this->i = 0; }
+		public function valid(): bool { return $this->i < 3; }
+	}
+	class Bag implements Countable {
+		public function count(): int { return 2; }
+	}
+	function gen() { yield 1; }
+	$checks = [[1, 2], new Numbers(), new Bag(), new ArrayObject([1]), gen(), "abc", 5, new stdClass()];
+	foreach ($checks as $value) {
+		echo (is_iterable($value) ? "I" : "-") . (is_countable($value) ? "C" : "-") . ",";
+	}
+	`
+	expected := "IC,I-,-C,IC,I-,--,--,--,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_column
+
+func TestArrayColumnDuplicateIndexAndObjects(t *testing.T) {
+	input := `<?php
+	class Row {
+		public $id;
+		public $name;
+		private $hidden = "x";
+		public function __construct($id, $name) { $this->id = $id; $this->name = $name; }
+	}
+	$rows = [
+		["id" => 1, "name" => "first"],
+		new Row(2, "object"),
+		["id" => 1, "name" => "second"],
+		["name" => "no-id"],
+		["id" => 3, "name" => null],
+	];
+	foreach (array_column($rows, "name", "id") as $k => $v) {
+		echo $k . "=" . var_export($v, true) . ",";
+	}
+	echo ";" . count(array_column($rows, "hidden"));
+	echo ";" . implode(",", array_keys(array_column($rows, null, "id")));
+	`
+	expected := "1='second',2='object',3=NULL,;0;1,2,3"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// ctype_* with empty strings
+
+func TestCtypeEmptyStringIsFalse(t *testing.T) {
+	input := `<?php
+	$fns = ["ctype_alnum", "ctype_alpha", "ctype_digit", "ctype_lower", "ctype_upper", "ctype_space",
+		"ctype_xdigit", "ctype_cntrl", "ctype_graph", "ctype_print", "ctype_punct"];
+	foreach ($fns as $fn) {
+		echo $fn("") ? "T" : "F";
+	}
+	`
+	expected := "FFFFFFFFFFF"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Regex modifiers
+
+func TestPregMatchModifiers(t *testing.T) {
+	input := `<?php
+echo preg_match('/ABC/i', 'abc');
+echo preg_match('/ABC/', 'abc');
+echo preg_match('/^b$/m', "a
+b
+c");
+echo preg_match('/a.c/s', "a
+c");
+echo preg_match('/a.c/', "a
+c");
+echo preg_match('/ a b  # comment
+  c /x', 'abc');
+echo preg_match('/x/u', 'x');
+echo preg_replace('/hello/i', 'bye', 'HELLO world');
+`
+	expected := "1011011bye world"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// strpos offsets
+
+func TestStrposNegativeAndOutOfRangeOffsets(t *testing.T) {
+	input := `<?php
+var_dump(strpos("hello world hello", "hello", -5));
+var_dump(strpos("hello world hello", "world", -5));
+var_dump(strpos("hello", "h", 0));
+var_dump(strpos("hello", "h", 10));
+var_dump(strpos("hello", "h", -10));
+var_dump(stripos("Hello World", "WORLD", -5));
+var_dump(strrpos("hello hello", "hello", -7));
+var_dump(strrpos("hello hello", "hello", 3));
+var_dump(strripos("HELLO hello", "hello", -6));
+var_dump(strrpos("abc", "c", 4));
+`
+	expected := "int(12)\nbool(false)\nint(0)\nbool(false)\nbool(false)\nint(6)\nint(0)\nint(6)\nint(0)\nbool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestPregMatchAllSetOrder(t *testing.T) {
+	input := `<?php
+$m = [];
+$n = preg_match_all('/(\d)(\d)/', '12 34', $m, PREG_SET_ORDER);
+echo $n, ":", implode(",", $m[0]), ";", implode(",", $m[1]), "|";
+$n = preg_match_all('/(\d)(\d)/', '12 34', $m);
+echo $n, ":", implode(",", $m[0]), ";", implode(",", $m[1]);
+`
+	expected := "2:12,1,2;34,3,4|2:12,34;1,3"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// substr edge cases
+
+func TestSubstrNegativeStartAndLength(t *testing.T) {
+	input := `<?php
+var_dump(substr('abcdef', -4, -1));
+var_dump(substr('abcdef', -3, -3));
+var_dump(substr('abcdef', -2, -4));
+var_dump(substr('abcdef', -10, -2));
+var_dump(substr('abcdef', -10, 2));
+var_dump(substr('abcdef', 2, 0));
+var_dump(substr('abcdef', 6));
+var_dump(substr('abcdef', 10, 2));
+var_dump(substr('abcdef', 1, -10));
+var_dump(substr('abcdef', 3, null));
+var_dump(substr('abcdef', 1, PHP_INT_MAX));
+`
+	expected := `string(3) "cde"
+string(0) ""
+string(0) ""
+string(4) "abcd"
+string(2) "ab"
+string(0) ""
+string(0) ""
+string(0) ""
+string(0) ""
+string(3) "def"
+string(5) "bcdef"
+`
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// str_replace with arrays
+
+func TestStrReplaceArrays(t *testing.T) {
+	input := `<?php
+echo str_replace(['a','b'], ['x','y'], 'abc'), "|";
+echo str_replace(['a','b','c'], ['x'], 'abcabc'), "|";
+echo str_replace(['a','b'], '-', 'abc'), "|";
+$r = str_replace('o', '0', ['one' => 'foo', 'two' => 'bar']);
+echo $r['one'], ",", $r['two'], "|";
+echo str_replace('l', 'L', 'hello world', $count), $count, "|";
+echo str_ireplace(['HELLO', 'World'], ['bye', 'all'], 'Hello WORLD', $n), $n;
+`
+	expected := "xyc|xx|--c|f00,bar|heLLo worLd3|bye all2"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// mb_ord / mb_chr
+
+func TestMbOrdAndMbChr(t *testing.T) {
+	input := `<?php
+echo mb_ord('€'), "|", mb_chr(8364), "|", mb_ord('A'), "|", mb_chr(233, 'UTF-8'), "|";
+echo mb_ord('日本'), "|";
+var_dump(mb_ord(''));
+var_dump(mb_chr(0xD800));
+echo mb_chr(mb_ord('ü'));
+`
+	expected := "8364|€|65|é|26085|bool(false)\nbool(false)\nü"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestStrReplaceCountOutParameter(t *testing.T) {
+	input := `<?php
+str_replace('a', 'b', 'banana', $count);
+echo $count, "|";
+str_replace(['a', 'n'], 'x', ['banana', 'cat', 'dog'], $count);
+echo $count, "|";
+str_ireplace('A', 'b', ['Banana', 'CAT'], $count);
+echo $count, "|";
+str_replace('z', 'y', 'abc', $count);
+echo $count;
+`
+	expected := "3|6|4|0"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// wordwrap cut mode
+
+func TestWordwrapCutIsMultibyteSafe(t *testing.T) {
+	input := `<?php
+echo wordwrap("A very long woooooooooooord.", 8, "|", true), "#";
+echo wordwrap("abcd efgh", 4, "|", true), "#";
+$wrapped = wordwrap("éééééé", 5, "|", true);
+echo $wrapped, "#";
+foreach (explode("|", $wrapped) as $line) {
+    echo strlen($line), "/", mb_strlen($line), ",";
+}
+`
+	expected := "A very|long|wooooooo|ooooord.#abcd|efgh#éé|éé|éé#4/2,4/2,4/2,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// rand / mt_rand
+
+func TestMtRandIsSeededMersenneTwister(t *testing.T) {
+	input := `<?php
+mt_srand(5489);
+echo mt_rand(), "|";
+mt_srand(42);
+$a = [mt_rand(1, 100), mt_rand(1, 100), mt_rand(1, 100)];
+mt_srand(42);
+$b = [mt_rand(1, 100), mt_rand(1, 100), mt_rand(1, 100)];
+echo $a === $b ? "same" : "different", "|";
+var_dump(mt_rand(5, 1));
+`
+	expected := "1749605806|same|bool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestRandProducesVariedValuesInRange(t *testing.T) {
+	input := `<?php
+srand(7);
+$first = [];
+for ($k = 0; $k < 5; $k++) { $first[] = rand(1, 1000); }
+srand(7);
+$second = [];
+for ($k = 0; $k < 5; $k++) { $second[] = rand(1, 1000); }
+echo $first === $second ? "same" : "different", "|";
+$seen = [];
+$inRange = true;
+for ($k = 0; $k < 200; $k++) {
+    $roll = rand(1, 6);
+    if ($roll < 1 || $roll > 6) { $inRange = false; }
+    $seen[$roll] = true;
+}
+echo $inRange ? "ok" : "out of range", "|", count($seen);
+`
+	expected := "same|ok|6"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestRandHandlesWideAndSwappedRanges(t *testing.T) {
+	input := `<?php
+$ok = true;
+for ($k = 0; $k < 50; $k++) {
+    $wide = rand(PHP_INT_MIN, PHP_INT_MAX);
+    $swapped = rand(10, 1);
+    if (!is_int($wide) || $swapped < 1 || $swapped > 10) { $ok = false; }
+}
+echo $ok ? "ok" : "bad", "|";
+srand(42);
+$a = rand(1, 100);
+mt_srand(42);
+echo $a === mt_rand(1, 100) ? "same" : "different";
+`
+	expected := "ok|same"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_diff / array_intersect comparison
+
+func TestArrayDiffComparesStringCasts(t *testing.T) {
+	input := `<?php
+echo count(array_diff([false], [0])), "|";
+echo count(array_diff([null], [''])), "|";
+echo count(array_diff([1], [true])), "|";
+echo count(array_diff([3.0], ['3'])), "|";
+echo count(array_intersect([null, false, 0], [''])), "|";
+echo count(array_intersect([2.0, 2.5], [2, '2.5']));
+`
+	expected := "1|0|0|0|2|2"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestGetrandmaxBoundsUnboundedRand(t *testing.T) {
+	input := `<?php
+echo getrandmax(), "|", mt_getrandmax(), "|";
+$ok = true;
+for ($k = 0; $k < 100; $k++) {
+    $r = rand();
+    $m = mt_rand();
+    if ($r < 0 || $r > getrandmax() || $m < 0 || $m > mt_getrandmax()) { $ok = false; }
+}
+echo $ok ? "ok" : "out of range", "|";
+$f = rand() / getrandmax();
+echo ($f >= 0 && $f <= 1) ? "ok" : "bad";
+`
+	expected := "2147483647|2147483647|ok|ok"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Float round-tripping
+
+func TestFloatsRoundTripThroughSerializeAndVarExport(t *testing.T) {
+	input := `<?php
+echo serialize(0.1), serialize(1/3), serialize(1.5e300), serialize(2.0), "|";
+var_export(0.1); echo ",";
+var_export(1/3); echo ",";
+var_export(1.5e300); echo ",";
+var_export(2.0); echo ",";
+var_export(0.00001); echo "|";
+foreach ([0.1, 1/3, 1.5e300, -2.5e-10] as $f) {
+    echo unserialize(serialize($f)) === $f ? "y" : "n";
+    echo (float) var_export($f, true) === $f ? "y" : "n";
+}
+`
+	expected := "d:0.1;d:0.3333333333333333;d:1.5E+300;d:2;|0.1,0.3333333333333333,1.5E+300,2.0,1.0E-5|yyyyyyyy"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// ini defaults
+
+func TestIniGetWellKnownDefaults(t *testing.T) {
+	input := `<?php
+echo ini_get('precision'), "|", ini_get('memory_limit'), "|", ini_get('max_execution_time'), "|";
+echo ini_get('error_reporting'), "|";
+var_dump(ini_get('date.timezone'));
+var_dump(ini_get('no.such.setting'));
+var_dump(ini_set('date.timezone', 'Europe/Paris'));
+echo ini_get('date.timezone');
+`
+	expected := "14|128M|0|32767|string(0) \"\"\nbool(false)\nstring(0) \"\"\nEurope/Paris"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Execution time limit
+
+func TestSetTimeLimitStopsInfiniteLoop(t *testing.T) {
+	input := `<?php
+var_dump(set_time_limit(1));
+echo ini_get('max_execution_time'), "|";
+$n = 0;
+while (true) {
+    $n++;
+}
+echo "unreachable";
+`
+	expected := "bool(true)\n1|PHP Fatal error: Maximum execution time of 1 second exceeded\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestExitInsideLoopStopsScript(t *testing.T) {
+	input := `<?php
+set_time_limit(0);
+for ($k = 0; ; $k++) {
+    if ($k == 3) {
+        exit("done");
+    }
+    echo $k;
+}
+echo "unreachable";
+`
+	expected := "012done"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// json_encode options
+
+func TestJsonEncodeOptions(t *testing.T) {
+	input := `<?php
+echo json_encode(['name' => 'café', 'url' => 'http://example.com/a']), "|";
+echo json_encode(['url' => 'http://example.com/a'], JSON_UNESCAPED_SLASHES), "|";
+echo json_encode(['name' => 'café <b>'], JSON_UNESCAPED_UNICODE), "|";
+echo json_encode(['a' => 1, 'b' => [1, 2], 'c' => []], JSON_PRETTY_PRINT | JSON_UNESCAPED_SLASHES);
+`
+	expected := `{"name":"caf\u00e9","url":"http:\/\/example.com\/a"}|{"url":"http://example.com/a"}|{"name":"café <b>"}|{
+    "a": 1,
+    "b": [
+        1,
+        2
+    ],
+    "c": []
+}`
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Memory limit
+
+func TestMemoryLimitStopsLargeAllocation(t *testing.T) {
+	input := `<?php
+ini_set('memory_limit', '1M');
+echo ini_get('memory_limit'), "|";
+$a = [];
+for ($k = 0; $k < 2000000; $k++) {
+    $a[] = "item $k";
+}
+echo "unreachable";
+`
+	expected := "1M|PHP Fatal error: Allowed memory size of 1048576 bytes exhausted\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestMemoryGetUsageTracksScript(t *testing.T) {
+	input := `<?php
+$a = [];
+for ($k = 0; $k < 50000; $k++) {
+    $a[] = $k;
+}
+$usage = memory_get_usage();
+echo is_int($usage) && $usage >= 0 ? "usage" : "bad", "|";
+echo memory_get_peak_usage() >= $usage ? "peak" : "bad";
+`
+	expected := "usage|peak"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestMemoryUsageReleasedAndPerInterpreter(t *testing.T) {
+	input := `<?php
+$base = memory_get_usage();
+function build() {
+    $rows = [];
+    for ($k = 0; $k < 10000; $k++) {
+        $rows[] = "row $k";
+    }
+    return count($rows);
+}
+for ($n = 0; $n < 20; $n++) {
+    build();
+}
+echo memory_get_usage() - $base < 1000 ? "locals released" : "locals kept", "|";
+$a = [];
+for ($k = 0; $k < 10000; $k++) {
+    $a[] = "row $k";
+}
+$held = memory_get_usage() - $base;
+echo $held > 100000 ? "held" : "not held", "|";
+unset($a);
+echo memory_get_usage() - $base < 1000 ? "unset released" : "unset kept", "|";
+echo memory_get_peak_usage() - $base >= $held ? "peak" : "bad";
+`
+	expected := "locals released|held|unset released|peak"
+
+	// Another interpreter holding memory must not count against this one
+	other := New()
+	other.Eval(`<?php $big = array_fill(0, 100000, str_repeat("x", 100));`)
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+	idle := New()
+	idle.Eval(`<?php echo memory_get_usage() < 1000 ? "idle" : "busy";`)
+	if idle.Output() != "idle" {
+		t.Errorf("expected %q, got %q", "idle", idle.Output())
+	}
+}
+
+func TestMemoryAccountingSelfContainingAndCopiedArrays(t *testing.T) {
+	input := `<?php
+$a = [1, 2];
+$a[] = $a;
+echo count($a), "|";
+$big = range(1, 200000);
+$base = memory_get_usage();
+for ($k = 0; $k < 300; $k++) {
+    $copy = $big;
+}
+$held = memory_get_usage() - $base;
+unset($copy);
+echo $held > 200000 * 32 ? "held" : "not held", "|";
+echo memory_get_usage() - $base < 1000 ? "released" : "kept";
+`
+	expected := "3|held|released"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestJsonEncodeSerializableThroughInterfaceAndNesting(t *testing.T) {
+	input := `<?php
+interface ApiResource extends JsonSerializable {}
+class Money implements ApiResource {
+    public $hidden = 'x';
+    public function __construct(private int $cents, private string $currency) {}
+    public function jsonSerialize(): mixed {
+        return ['amount' => $this->cents, 'currency' => $this->currency];
+    }
+}
+class Tip extends Money {}
+var_dump(new Money(1, 'EUR') instanceof JsonSerializable);
+echo json_encode(['list' => [new Money(1250, 'EUR')], 'tip' => new Tip(300, 'USD')]);
+`
+	expected := "bool(true)\n" + `{"list":[{"amount":1250,"currency":"EUR"}],"tip":{"amount":300,"currency":"USD"}}`
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Key comparison callbacks
+
+func TestArrayDiffAndIntersectUkey(t *testing.T) {
+	input := `<?php
+function compareKeys($a, $b) { return strtolower($a) == strtolower($b) ? 0 : 1; }
+$cmp = function ($a, $b) { return compareKeys($a, $b); };
+$a = ['Red' => 1, 'green' => 2, 'Blue' => 3];
+$b = ['red' => 9, 'BLUE' => 8];
+$c = ['RED' => 0];
+echo implode(",", array_keys(array_diff_ukey($a, $b, $cmp))), "|";
+echo implode(",", array_keys(array_intersect_ukey($a, $b, $cmp))), "|";
+echo implode(",", array_keys(array_intersect_ukey($a, $b, $c, $cmp))), "|";
+echo implode(",", array_diff_ukey($a, $b, $c, 'compareKeys'));
+`
+	expected := "green|Red,Blue|Red|2"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// JSON errors
+
+func TestJsonLastError(t *testing.T) {
+	input := `<?php
+var_dump(json_decode("{bad"));
+echo json_last_error(), "|", json_last_error() === JSON_ERROR_SYNTAX ? "syntax" : "other", "|", json_last_error_msg(), "|";
+json_decode('{"ok": true}');
+echo json_last_error(), "|", json_last_error_msg(), "|";
+var_dump(json_encode(sqrt(-1)));
+echo json_last_error() === JSON_ERROR_INF_OR_NAN ? "inf" : "other", "|", json_last_error_msg(), "|";
+json_encode([1]);
+echo json_last_error();
+`
+	expected := "NULL\n4|syntax|Syntax error|0|No error|bool(false)\ninf|Inf and NaN cannot be JSON encoded|0"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_combine validation
+
+func TestArrayCombineMismatchedLengths(t *testing.T) {
+	input := `<?php
+try {
+    array_combine(['a', 'b', 'c'], [1, 2]);
+    echo "no error";
+} catch (ValueError $e) {
+    echo $e;
+}
+echo "|", implode(",", array_keys(array_combine(['x', 'y'], [1, 2])));
+`
+	expected := "array_combine(): Argument #1 ($keys) and argument #2 ($values) must have the same number of elements|x,y"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestJsonDecodeDepthAndThrowOnError(t *testing.T) {
+	input := `<?php
+var_dump(json_decode('[[1]]', true, 1));
+echo json_last_error() === JSON_ERROR_DEPTH ? "depth" : "other", "|", json_last_error_msg(), "|";
+echo count(json_decode('[[1]]', true, 2)), "|";
+echo json_last_error(), "|";
+$nested = str_repeat('[', 10) . str_repeat(']', 10);
+var_dump(json_decode($nested, true, 9));
+echo count(json_decode($nested, true, 10)), "|";
+try {
+    json_decode('{bad', false, 512, JSON_THROW_ON_ERROR);
+    echo "no exception";
+} catch (JsonException $e) {
+    echo $e;
+}
+echo "|";
+try {
+    json_decode('[[[1]]]', true, 2, JSON_THROW_ON_ERROR);
+} catch (JsonException $e) {
+    echo $e;
+}
+echo "|", json_last_error();
+`
+	expected := "NULL\ndepth|Maximum stack depth exceeded|1|0|NULL\n1|Syntax error|Maximum stack depth exceeded|0"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// hash_file
+
+func TestHashFileMatchesStringHash(t *testing.T) {
+	input := `<?php
+$content = str_repeat("phpgo hash_file ", 1000);
+$f = tempnam(sys_get_temp_dir(), "hf");
+file_put_contents($f, $content);
+echo hash_file('sha256', $f) === hash('sha256', $content) ? "same" : "diff", "|";
+echo hash_file('md5', $f) === md5($content) ? "same" : "diff", "|";
+echo strlen(hash_file('sha256', $f, true)), "|";
+echo hash('sha256', 'abc'), "|";
+unlink($f);
+var_dump(hash_file('sha256', $f));
+`
+	expected := "same|same|32|ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad|bool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// serialize back-references
+
+func TestSerializeSelfReferencingObject(t *testing.T) {
+	input := `<?php
+class Loop { public $self; }
+$l = new Loop();
+$l->self = $l;
+$s = serialize($l);
+echo $s, "|";
+$u = unserialize($s);
+echo $u->self === $u ? "same" : "diff", "|";
+class Leaf {}
+$o = new Leaf();
+echo serialize([$o, $o]), "|";
+class Pair { public $name; public $peer; }
+$a = new Pair(); $a->name = "a";
+$b = new Pair(); $b->name = "b";
+$a->peer = $b; $b->peer = $a;
+$v = unserialize(serialize([$a, $b]));
+echo $v[0]->peer === $v[1] ? "y" : "n", $v[1]->peer === $v[0] ? "y" : "n", $v[1]->peer->name;
+`
+	expected := `O:4:"Loop":1:{s:4:"self";r:1;}|same|a:2:{i:0;O:4:"Leaf":0:{}i:1;r:2;}|yya`
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// crc32 hash algorithms
+
+func TestHashCrc32Variants(t *testing.T) {
+	input := `<?php
+echo hash('crc32b', 'hello'), "|";
+echo hash('crc32', 'hello'), "|";
+echo hash('crc32', '123456789'), "|";
+echo hash('crc32c', '123456789'), "|";
+echo crc32('hello'), "|";
+echo sprintf('%08x', crc32('hello')) === hash('crc32b', 'hello') ? "match" : "mismatch", "|";
+echo crc32('The quick brown fox jumped over the lazy dog.');
+`
+	expected := "3610a686|3d653119|181989fc|e3069283|907060870|match|2191738434"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// unserialize validation
+
+func TestUnserializeRejectsMalformedInput(t *testing.T) {
+	input := `<?php
+$payloads = [
+    's:100:"x";',
+    's:1:"x"',
+    's:-1:"";',
+    's:3:"abcd";',
+    'i:12',
+    'i:abc;',
+    'd:;',
+    'b:2;',
+    'N',
+    'a:2:{i:0;i:1;}',
+    'a:1:{i:0;i:1;',
+    'a:-1:{}',
+    'a:1:{a:0:{}i:1;}',
+    'O:5:"Point":1:{s:1:"x";i:1;',
+    'O:99:"Point":0:{}',
+    'r:5;',
+    'a:1:{i:0;r:9;}',
+    'x:1;',
+    '',
+];
+foreach ($payloads as $p) {
+    echo unserialize($p) === false ? "F" : "?";
+}
+class Point { public $x; }
+echo "|", unserialize('s:5:"hello";'), "|", unserialize('O:5:"Point":1:{s:1:"x";i:7;}')->x;
+echo "|", count(unserialize('a:2:{i:0;s:1:"a";s:1:"k";a:0:{}}'));
+`
+	expected := "FFFFFFFFFFFFFFFFFFF|hello|7|2"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SplStack
+
+func TestSplStackLifoAndEmptyPop(t *testing.T) {
+	input := `<?php
+$s = new SplStack();
+$s->push(1);
+$s->push(2);
+$s->push(3);
+echo $s->pop(), $s->top(), count($s), $s->isEmpty() ? "e" : "n", "|";
+foreach ($s as $v) {
+    echo $v;
+}
+$s->pop();
+$s->pop();
+echo "|", $s->isEmpty() ? "e" : "n", "|";
+try {
+    $s->pop();
+    echo "no exception";
+} catch (RuntimeException $e) {
+    echo $e;
+}
+echo "|";
+try {
+    $s->top();
+} catch (RuntimeException $e) {
+    echo $e;
+}
+`
+	expected := "322n|21|e|Can't pop from an empty datastructure|Can't peek at an empty datastructure"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// openssl_sign / openssl_verify
+
+func TestOpensslSignAndVerifyRSA(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	input := `<?php
+$private = openssl_pkey_get_private('` + string(privatePEM) + `');
+$public = openssl_pkey_get_public('` + string(publicPEM) + `');
+echo $private === false ? "no key" : "key", "|";
+var_dump(openssl_sign("payload", $signature, $private, OPENSSL_ALGO_SHA256));
+echo strlen($signature), "|";
+echo openssl_verify("payload", $signature, $public, OPENSSL_ALGO_SHA256), "|";
+echo openssl_verify("tampered", $signature, $public, OPENSSL_ALGO_SHA256), "|";
+echo openssl_verify("payload", $signature, $public, OPENSSL_ALGO_SHA1), "|";
+openssl_sign("payload", $sig2, '` + string(privatePEM) + `', "sha256");
+echo $sig2 === $signature ? "deterministic" : "differs", "|";
+echo openssl_verify("payload", $sig2, '` + string(publicPEM) + `', "RSA-SHA256"), "|";
+var_dump(openssl_pkey_get_private("not a key"));
+`
+	expected := "key|bool(true)\n256|1|0|0|deterministic|1|bool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SplQueue
+
+func TestSplQueueFifo(t *testing.T) {
+	input := `<?php
+$q = new SplQueue();
+$q->enqueue(1);
+$q->enqueue(2);
+$q->push(3);
+$q[] = 4;
+echo $q->dequeue(), count($q), $q[0], "|";
+foreach ($q as $k => $v) {
+    echo "$k=$v,";
+}
+echo "|", $q->pop(), $q->dequeue(), $q->dequeue(), $q->isEmpty() ? "e" : "n", "|";
+try {
+    $q->dequeue();
+    echo "no exception";
+} catch (RuntimeException $e) {
+    echo $e;
+}
+`
+	expected := "132|0=2,1=3,2=4,|423e|Can't shift from an empty datastructure"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// base64_decode padding
+
+func TestBase64DecodeUnpadded(t *testing.T) {
+	input := `<?php
+echo base64_decode("YQ"), base64_decode("YWI"), base64_decode("YWJj"), base64_decode("YWI="), "|";
+echo base64_decode("aGVsbG8gd29ybGQ"), "|";
+echo base64_decode("aGVs bG8="), "|";
+echo base64_decode("YQ", true), base64_decode("YWI", true), "|";
+$jwtPart = rtrim(strtr(base64_encode('{"sub":"1?"}'), '+/', '-_'), '=');
+echo base64_decode(strtr($jwtPart, '-_', '+/')), "|";
+var_dump(base64_decode("YW#I=", true));
+var_dump(base64_decode("YQ=a", true));
+var_dump(base64_decode("Y", true));
+echo base64_decode("YW#I="), "|", base64_decode("Y");
+`
+	expected := "aababcab|hello world|hello|aab|{\"sub\":\"1?\"}|bool(false)\nbool(false)\nbool(false)\nab|"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SplFixedArray
+
+func TestSplFixedArrayBoundsAndSetSize(t *testing.T) {
+	input := `<?php
+$a = new SplFixedArray(3);
+$a[0] = "x";
+$a[2] = "z";
+var_dump($a[1]);
+echo $a->getSize(), count($a), "|";
+echo isset($a[0]) ? "set" : "unset", isset($a[1]) ? "set" : "unset", isset($a[9]) ? "set" : "unset", "|";
+try {
+    $a[3] = "out";
+    echo "no exception";
+} catch (RuntimeException $e) {
+    echo $e;
+}
+echo "|";
+try {
+    $v = $a[-1];
+    echo "no exception";
+} catch (RuntimeException $e) {
+    echo $e;
+}
+echo "|";
+$a->setSize(2);
+echo $a->getSize(), count($a->toArray()), $a[0], "|";
+try {
+    $v = $a[2];
+} catch (RuntimeException $e) {
+    echo "shrunk";
+}
+$a->setSize(4);
+echo "|", count($a), $a[3] === null ? "null" : "set";
+`
+	expected := "NULL\n33|setunsetunset|Index invalid or out of range|Index invalid or out of range|22x|shrunk|4null"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// ArrayAccess isset/empty/??
+
+func TestArrayAccessIssetEmptyAndCoalesce(t *testing.T) {
+	input := `<?php
+class Bag implements ArrayAccess {
+    private $d = ["a" => 1, "zero" => 0];
+    public function offsetExists($k): bool { echo "[exists $k]"; return array_key_exists($k, $this->d); }
+    public function offsetGet($k): mixed { echo "[get $k]"; return $this->d[$k] ?? "missing"; }
+    public function offsetSet($k, $v): void { $this->d[$k] = $v; }
+    public function offsetUnset($k): void { unset($this->d[$k]); }
+}
+$b = new Bag();
+echo isset($b["a"]) ? "Y" : "N", isset($b["zz"]) ? "Y" : "N", "|";
+echo $b["a"], "|";
+echo empty($b["zero"]) ? "E" : "F", empty($b["zz"]) ? "E" : "F", "|";
+echo $b["q"] ?? "default", "|";
+$b["x"] = 5;
+echo $b["x"] ?? "default";
+`
+	expected := "[exists a]Y[exists zz]N|[get a]1|[exists zero][get zero]E[exists zz]E|[exists q]default|[exists x][get x]5"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestCoalesceEvaluatesContainerOnce(t *testing.T) {
+	input := `<?php
+class Holder {
+    private $d = ["items" => ["a" => 1]];
+    public function __get($name) { echo "[get $name]"; return $this->d[$name]; }
+}
+function rows() { echo "[rows]"; return ["x" => 2]; }
+$h = new Holder();
+echo $h->items["a"] ?? "none", "|";
+echo $h->items["b"] ?? "none", "|";
+echo rows()["x"] ?? "none", "|";
+echo empty(rows()["y"]) ? "E" : "F";
+`
+	expected := "[get items]1|[get items]none|[rows]2|[rows]E"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SplObjectStorage
+
+func TestSplObjectStorageIdentity(t *testing.T) {
+	input := `<?php
+class P {
+    public $n;
+    public function __construct($n) { $this->n = $n; }
+}
+$a = new P("a");
+$b = new P("b");
+$twin = new P("a");
+$s = new SplObjectStorage();
+$s->attach($a, "info-a");
+$s->attach($b);
+$s->attach($a, "info-a2");
+echo count($s), $s->contains($a) ? "Y" : "N", $s->contains($b) ? "Y" : "N", $s->contains($twin) ? "Y" : "N", "|";
+echo $s[$a], "|", isset($s[$twin]) ? "set" : "unset", "|";
+$s[$twin] = "info-twin";
+foreach ($s as $idx => $obj) {
+    echo $idx, $obj->n, ":", $s->getInfo(), ",";
+}
+$s->detach($a);
+unset($s[$twin]);
+echo "|", count($s), $s->contains($a) ? "Y" : "N", "|";
+echo $s->getHash($b) === spl_object_hash($b) ? "same" : "diff", "|";
+echo spl_object_id($a) === spl_object_id($a) ? "stable" : "unstable", spl_object_id($a) !== spl_object_id($twin) ? "distinct" : "equal";
+`
+	expected := "2YYN|info-a2|unset|0a:info-a2,1b:,2a:info-twin,|1N|same|stabledistinct"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestSplObjectStorageForeachDestructuringTarget(t *testing.T) {
+	input := `<?php
+class P {
+    public $n;
+    public function __construct($n) { $this->n = $n; }
+}
+$s = new SplObjectStorage();
+$s->attach(new P(1));
+$s->attach(new P(2));
+foreach ($s as $idx => [$x]) {
+    echo $idx, ",";
+}
+foreach ($s as list($y)) {
+    echo "L";
+}
+echo "|done";
+`
+	expected := "0,1,LL|done"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// str_word_count
+
+func TestStrWordCountUnicodeAndCharlist(t *testing.T) {
+	input := `<?php
+echo str_word_count("naïve café"), "|";
+echo implode(",", str_word_count("Ça va, l'été?", 1)), "|";
+echo str_word_count("Hello fri3nd, you're looking good today!"), "|";
+echo str_word_count("Hello fri3nd, you're looking good today!", 0, "3"), "|";
+echo implode(",", str_word_count("-well- 'quoted' x-ray", 1)), "|";
+foreach (str_word_count("déjà vu", 2) as $pos => $w) {
+    echo $pos, "=", $w, ",";
+}
+`
+	expected := "2|Ça,va,l'été|7|6|well,quoted',x-ray|0=déjà,7=vu,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SplPriorityQueue
+
+func TestSplPriorityQueueOrdering(t *testing.T) {
+	input := `<?php
+$q = new SplPriorityQueue();
+foreach ([["a", 1], ["b", 3], ["c", 2], ["d", 3], ["e", 1], ["f", 3], ["g", 10]] as $item) {
+    $q->insert($item[0], $item[1]);
+}
+echo count($q), $q->top(), "|";
+while (!$q->isEmpty()) {
+    echo $q->extract();
+}
+echo "|";
+$q->setExtractFlags(SplPriorityQueue::EXTR_PRIORITY);
+$q->insert("x", 7);
+echo $q->top(), "|";
+$q->setExtractFlags(SplPriorityQueue::EXTR_BOTH);
+$both = $q->extract();
+echo $both["data"], "=", $both["priority"], "|";
+try {
+    $q->extract();
+    echo "no exception";
+} catch (RuntimeException $e) {
+    echo $e;
+}
+`
+	expected := "7g|gbdfcae|7|x=7|Can't extract from an empty heap"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_sum precision
+
+func TestArraySumKeepsLargeIntsExact(t *testing.T) {
+	input := `<?php
+var_dump(array_sum([9007199254740993, 2]));
+var_dump(array_sum([PHP_INT_MAX - 10, 5, "3"]));
+var_dump(array_sum([1, 2, 3]));
+var_dump(array_sum([1, "2.5"]));
+var_dump(array_sum([1, 0.5]));
+var_dump(is_float(array_sum([PHP_INT_MAX, 1])));
+var_dump(array_sum([]));
+var_dump(array_sum(["1.5abc", 1]));
+var_dump(array_sum(["3 apples", 1]));
+var_dump(array_sum([" 5e-1x", "abc"]));
+`
+	expected := "int(9007199254740995)\nint(9223372036854775805)\nint(6)\nfloat(3.5)\nfloat(1.5)\nbool(true)\nint(0)\n" +
+		"float(2.5)\nint(4)\nfloat(0.5)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SplMinHeap / SplMaxHeap / SplHeap subclasses
+
+func TestSplHeapsAndCustomCompare(t *testing.T) {
+	input := `<?php
+$min = new SplMinHeap();
+$max = new SplMaxHeap();
+foreach ([5, 1, 8, 3, 9, 2] as $n) {
+    $min->insert($n);
+    $max->insert($n);
+}
+echo $min->top(), $max->top(), count($min), "|";
+while (!$min->isEmpty()) {
+    echo $min->extract();
+}
+echo "|";
+while (!$max->isEmpty()) {
+    echo $max->extract();
+}
+echo "|";
+class ByLength extends SplHeap {
+    protected function compare($a, $b): int {
+        return strlen($b) - strlen($a);
+    }
+}
+$h = new ByLength();
+foreach (["ccc", "a", "dddd", "bb"] as $w) {
+    $h->insert($w);
+}
+echo count($h), $h->top(), "|";
+while (!$h->isEmpty()) {
+    echo $h->extract(), ",";
+}
+echo "|";
+try {
+    $h->extract();
+    echo "no exception";
+} catch (RuntimeException $e) {
+    echo $e;
+}
+echo "|";
+try {
+    $max->top();
+} catch (RuntimeException $e) {
+    echo $e;
+}
+`
+	expected := "196|123589|985321|4a|a,bb,ccc,dddd,|Can't extract from an empty heap|Can't peek at an empty heap"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestSplHeapForeachExtracts(t *testing.T) {
+	input := `<?php
+$min = new SplMinHeap();
+foreach ([5, 1, 3] as $n) {
+    $min->insert($n);
+}
+foreach ($min as $key => $value) {
+    echo $key, "=", $value, ",";
+}
+echo count($min), "|";
+$max = new SplMaxHeap();
+foreach ([5, 1, 3] as $n) {
+    $max->insert($n);
+}
+echo implode(",", iterator_to_array($max, false)), count($max), "|";
+class ByLength extends SplHeap {
+    protected function compare($a, $b): int {
+        return strlen($b) - strlen($a);
+    }
+}
+$h = new ByLength();
+foreach (["ccc", "a", "bb"] as $w) {
+    $h->insert($w);
+}
+foreach ($h as $w) {
+    echo $w, ",";
+}
+echo count($h), "|";
+$h->insert("zz");
+$h->rewind();
+echo $h->valid() ? "valid" : "done", $h->key(), $h->current();
+$h->next();
+echo $h->valid() ? "valid" : "done";
+`
+	expected := "2=1,1=3,0=5,0|5,3,10|a,bb,ccc,0|valid0zzdone"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestSplHeapSubclassAndClone(t *testing.T) {
+	input := `<?php
+class Smallest extends SplMinHeap {
+    public function cmp($a, $b) {
+        return $this->compare($a, $b);
+    }
+}
+$x = new Smallest();
+foreach ([3, 1, 2] as $n) {
+    $x->insert($n);
+}
+$y = clone $x;
+echo $x->extract(), count($x), count($y), $y->top(), $x->cmp(1, 2), "|";
+$m = new SplMaxHeap();
+$m->insert(4);
+$m2 = clone $m;
+$m2->insert(9);
+echo count($m), $m->top(), count($m2), $m2->top();
+`
+	expected := "12311|1429"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// preg_replace_callback / preg_replace_callback_array
+
+func TestPregReplaceCallbackArray(t *testing.T) {
+	input := `<?php
+echo preg_replace_callback('/\d+/', function ($m) { return $m[0] * 2; }, "a1 b22 c3"), "|";
+echo preg_replace_callback('/(?P<word>[a-z]+)-(\d)/', function ($m) { return strtoupper($m['word']) . $m[2]; }, "ab-1 cd-2", 1, $count), "|";
+echo $count, "|";
+function shout($m) { return strtoupper($m[0]); }
+echo preg_replace_callback('/o/', 'shout', "foo boo"), "|";
+echo preg_replace_callback_array([
+    '/[aeiou]/' => function ($m) { return strtoupper($m[0]); },
+    '/\d+/' => function ($m) { return "[" . ($m[0] + 1) . "]"; },
+], "item 41 and 9", -1, $total), "|";
+echo $total, "|";
+$out = preg_replace_callback_array(['/x/' => fn($m) => 'y'], ['k' => 'xax', 'z' => 'b']);
+echo $out['k'], $out['z'];
+`
+	expected := "a2 b44 c6|AB1 cd-2|1|fOO bOO|ItEm [42] And [10]|5|yayb"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestPregReplaceCallbackExceptionsAndWarnings(t *testing.T) {
+	input := `<?php
+try {
+    $out = preg_replace_callback('/\d/', function ($m) {
+        echo "call,";
+        throw new Exception("bad digit " . $m[0]);
+    }, "a1b2");
+    echo "not reached";
+} catch (Exception $e) {
+    echo $e, "|";
+}
+try {
+    preg_replace_callback_array(['/x/' => fn($m) => 'y', '/z/' => function ($m) { throw new Exception("array"); }], ['xz']);
+    echo "not reached";
+} catch (Exception $e) {
+    echo $e, "|";
+}
+var_dump(preg_replace_callback_array(['/a(/' => fn($m) => 'y'], 'a'));
+`
+	expected := "call,bad digit 1|array|PHP Warning: preg_replace_callback_array(): Compilation failed for pattern /a(/\nNULL\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// htmlspecialchars flags
+
+func TestHtmlspecialcharsFlagsAndDoubleEncode(t *testing.T) {
+	input := `<?php
+$s = '<a href="x">' . "'Tom'" . ' & Jerry</a>';
+echo htmlspecialchars($s), "|";
+echo htmlspecialchars($s, ENT_QUOTES), "|";
+echo htmlspecialchars($s, ENT_COMPAT), "|";
+echo htmlspecialchars($s, ENT_NOQUOTES), "|";
+echo htmlspecialchars("'", ENT_QUOTES | ENT_HTML5), "|";
+echo htmlspecialchars("&amp; &lt; &#39; &#x41; &copy; & &bogus", ENT_QUOTES, "UTF-8", false), "|";
+echo htmlspecialchars("&amp;", ENT_QUOTES, "UTF-8", true);
+`
+	expected := "&lt;a href=&quot;x&quot;&gt;&#039;Tom&#039; &amp; Jerry&lt;/a&gt;|" +
+		"&lt;a href=&quot;x&quot;&gt;&#039;Tom&#039; &amp; Jerry&lt;/a&gt;|" +
+		"&lt;a href=&quot;x&quot;&gt;'Tom' &amp; Jerry&lt;/a&gt;|" +
+		"&lt;a href=\"x\"&gt;'Tom' &amp; Jerry&lt;/a&gt;|" +
+		"&apos;|" +
+		"&amp; &lt; &#39; &#x41; &copy; &amp; &amp;bogus|" +
+		"&amp;amp;"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// sprintf positional argument reuse
+
+func TestSprintfReusesPositionalArguments(t *testing.T) {
+	input := `<?php
+echo sprintf('%1$s-%1$s', 'x'), "|";
+echo sprintf('%2$s %1$s %2$s', 'a', 'b'), "|";
+echo vsprintf('%1$04d/%1$x', [26]), "|";
+echo sprintf('%s %1$s %s', 'p', 'q'), "|";
+printf('%1$s%1$s', 'z');
+`
+	expected := "x-x|b a b|0026/1a|p p q|zz"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestSprintfThrowsOnBadFormatsAndMissingArguments(t *testing.T) {
+	input := `<?php
+$calls = [
+    fn() => sprintf("%y", 1),
+    fn() => sprintf("%d and %d", 1),
+    fn() => sprintf('%3$s', "a"),
+    fn() => vsprintf("%s %s", ["a"]),
+    fn() => sprintf("50%"),
+    fn() => sprintf('%0$s', "a"),
+];
+foreach ($calls as $call) {
+    try {
+        $call();
+        echo "no error|";
+    } catch (Error $e) {
+        echo $e, "|";
+    }
+}
+try {
+    printf("%s%s", "x");
+} catch (ArgumentCountError $e) {
+    echo $e;
+}
+`
+	expected := "Unknown format specifier \"y\"|" +
+		"3 arguments are required, 2 given|" +
+		"4 arguments are required, 2 given|" +
+		"The arguments array must contain 2 items, 1 given|" +
+		"Missing format specifier at end of string|" +
+		"Argument number specifier must be greater than zero and less than 2147483647|" +
+		"3 arguments are required, 2 given"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_column rows missing the index key
+
+func TestArrayColumnMissingIndexKeyAppends(t *testing.T) {
+	input := `<?php
+$rows = [
+    ["id" => 10, "name" => "a"],
+    ["name" => "b"],
+    ["id" => 30, "name" => "c"],
+    ["id" => 40],
+    ["name" => "e"],
+];
+foreach (array_column($rows, "name", "id") as $k => $v) {
+    echo $k, "=", $v, ",";
+}
+echo "|";
+foreach (array_column($rows, null, "id") as $k => $row) {
+    echo $k, "=", $row["name"] ?? "-", ",";
+}
+echo "|";
+foreach (array_column([["name" => "x"], ["id" => "k", "name" => "y"]], "name", "id") as $k => $v) {
+    echo $k, "=", $v, ",";
+}
+`
+	expected := "10=a,11=b,30=c,31=e,|10=a,11=b,30=c,40=-,41=e,|0=x,k=y,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// number_format rounding
+
+func TestNumberFormatRoundingAndNegativeZero(t *testing.T) {
+	input := `<?php
+echo number_format(-0.001, 2), "|";
+echo number_format(-0.4), "|";
+echo number_format(1234567.891, 2), "|";
+echo number_format(1234567.891), "|";
+echo number_format(1.005, 2), "|";
+echo number_format(2.5), number_format(-2.5), "|";
+echo number_format(-1234.567, 2, ',', '.'), "|";
+echo number_format(0.5), "|";
+echo number_format(999999.999, 2), "|";
+echo round(1.005, 2);
+`
+	expected := "0.00|0|1,234,567.89|1,234,568|1.01|3-3|-1.234,57|1|1,000,000.00|1.01"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// stream_select
+
+func TestStreamSelectWaitsForPipe(t *testing.T) {
+	input := `<?php
+$pipe = popen('sleep 0.2; echo ready', 'r');
+$read = ['child' => $pipe];
+$write = null;
+$except = null;
+echo stream_select($read, $write, $except, 0), count($read), "|";
+$read = ['child' => $pipe];
+echo stream_select($read, $write, $except, 5), implode(',', array_keys($read)), "|";
+echo trim(fread($pipe, 100)), "|";
+echo pclose($pipe);
+`
+	expected := "00|1child|ready|0"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestStreamSelectBufferedAndInvalidStreams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.gz")
+	input := `<?php
+$gz = gzopen('` + path + `', 'w');
+gzwrite($gz, "hello");
+gzclose($gz);
+$gz = gzopen('` + path + `', 'r');
+$pipe = popen('cat > /dev/null', 'w');
+$read = ['gz' => $gz, 'child' => $pipe];
+$write = null;
+$except = null;
+echo stream_select($read, $write, $except, 5), implode(',', array_keys($read)), "|";
+$bad = ['x' => 'not a stream'];
+echo stream_select($bad, $write, $except, 0) === false ? "false" : "int", "|";
+$empty = [];
+echo stream_select($empty, $write, $except, 0) === false ? "false" : "int";
+pclose($pipe);
+`
+	interp := New()
+	interp.Eval(input)
+	expected := "1gz|false|false"
+	if interp.Output() != expected {
+		t.Errorf("expected %q, got %q", expected, interp.Output())
+	}
+}
+
+func TestPcloseReleasesResource(t *testing.T) {
+	interp := New()
+	interp.Eval(`<?php $p = popen('echo hi', 'r'); fread($p, 10); echo pclose($p);`)
+	if interp.Output() != "0" {
+		t.Errorf("expected %q, got %q", "0", interp.Output())
+	}
+	if len(interp.resources) != 0 || len(interp.processes) != 0 {
+		t.Errorf("expected no tracked resources, got %d resources and %d processes", len(interp.resources), len(interp.processes))
+	}
+}
+
+// ----------------------------------------------------------------------------
+// ucwords delimiters
+
+func TestUcwordsDelimiters(t *testing.T) {
+	input := `<?php
+echo ucwords("hello world" . chr(9) . "foo"), "|";
+echo ucwords("o'neil mcdonald"), "|";
+echo ucwords("hello-world", "-"), "|";
+echo ucwords("hello world-foo_bar", " -"), "|";
+echo ucwords("hELLO wORLD"), "|";
+echo ucwords("");
+`
+	expected := "Hello World\tFoo|O'neil Mcdonald|Hello-World|Hello World-Foo_bar|HELLO WORLD|"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// fflush
+
+func TestFflushMakesWritesVisible(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flush.txt")
+	input := `<?php
+$path = '` + path + `';
+$out = fopen($path, 'w');
+fwrite($out, 'first line');
+var_dump(fflush($out));
+$in = fopen($path, 'r');
+echo fread($in, 100), "|";
+fclose($in);
+fclose($out);
+$pipe = popen('cat > /dev/null', 'w');
+var_dump(fflush($pipe));
+pclose($pipe);
+`
+	expected := "bool(true)\nfirst line|bool(true)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// wordwrap whitespace
+
+func TestWordwrapPreservesWhitespace(t *testing.T) {
+	input := `<?php
+echo wordwrap("The quick brown fox sat over the lazy dog", 15, "<br>"), "#";
+echo wordwrap("one  two   three", 5, "|"), "#";
+echo wordwrap("short|line here and there", 8, "|"), "#";
+echo wordwrap("first line" . chr(10) . "second line is longer", 12), "#";
+echo wordwrap("A very long woooooooooooord. and something", 8, "|", false), "#";
+echo wordwrap("aaaa bbbb", 0, "|"), "#";
+echo wordwrap("", 10), "#";
+`
+	expected := "The quick brown<br>fox sat over<br>the lazy dog#one |two  |three#short|line|here and|there#first line\nsecond line\nis longer#A very|long|woooooooooooord.|and|something#aaaa|bbbb##"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// str_pad multibyte
+
+func TestStrPadMultibyteAndEmptyPad(t *testing.T) {
+	input := `<?php
+echo str_pad("ab", 5, "é"), "|";
+echo str_pad("ab", 6, "éà", STR_PAD_LEFT), "|";
+echo str_pad("né", 6, "*", STR_PAD_BOTH), "|";
+echo str_pad("abc", 10, ""), "|";
+echo str_pad("5", 3, "0", STR_PAD_LEFT), "|";
+echo str_pad("abc", 2, "-");
+`
+	expected := "abééé|éàéàab|**né**|abc|005|abc"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_map keys
+
+func TestArrayMapKeysWithOneAndManyArrays(t *testing.T) {
+	input := `<?php
+$single = array_map(fn($v) => $v * 2, ['a' => 1, 'b' => 2, 5 => 3]);
+foreach ($single as $k => $v) { echo "$k=$v,"; }
+echo "|";
+$multi = array_map(fn($x, $y) => $x . $y, ['a' => 'x', 'b' => 'y'], ['c' => '1', 'd' => '2', 'e' => '3']);
+foreach ($multi as $k => $v) { echo "$k=$v,"; }
+echo "|";
+echo implode(',', array_map('strtoupper', ['k' => 'ab', 'cd'])), "|";
+$zipped = array_map(null, [1, 2], ['a', 'b']);
+echo count($zipped), $zipped[1][0], $zipped[1][1], "|";
+echo implode(',', array_keys(array_map(null, ['x' => 1])));
+`
+	expected := "a=2,b=4,5=6,|0=x1,1=y2,2=3,|AB,CD|22b|x"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// strpos family offsets past the haystack
+
+func TestStrposFamilyOffsetPastEnd(t *testing.T) {
+	input := `<?php
+var_dump(strpos("abc", "a", 10));
+var_dump(stripos("abc", "A", 10));
+var_dump(strrpos("abc", "a", 10));
+var_dump(strripos("abc", "A", -10));
+var_dump(strpos("abc", "", 3));
+`
+	expected := "bool(false)\nbool(false)\nbool(false)\nbool(false)\nint(3)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// intval/floatval leading numbers
+
+func TestIntvalFloatvalLeadingNumber(t *testing.T) {
+	input := `<?php
+echo intval('+42'), "|";
+echo floatval('  3.14 '), "|";
+echo floatval('  +3.14  '), "|";
+echo intval('   -7abc'), "|";
+echo intval('1.9'), "|";
+echo intval('1e3'), "|";
+echo intval('1_000'), "|";
+echo floatval('.5x'), "|";
+echo floatval('2.5e-1 apples'), "|";
+echo intval('abc'), intval(''), intval('-'), "|";
+echo intval('99999999999999999999'), "|";
+echo '10 apples' + 5;
+`
+	expected := "42|3.14|3.14|-7|1|1000|1|0.5|0.25|000|9223372036854775807|15"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// explode limits
+
+func TestExplodeNegativeLimitAndEmptyDelimiter(t *testing.T) {
+	input := `<?php
+echo implode('|', explode(",", "a,b,c,d", -1)), "#";
+echo implode('|', explode(",", "a,b,c,d", -3)), "#";
+echo count(explode(",", "a,b,c,d", -4)), count(explode(",", "a,b", -10)), "#";
+echo implode('|', explode(",", "a,b,c", 0)), "#";
+echo implode('|', explode(",", "a,b,c", 2)), "#";
+var_dump(explode("", "abc"));
+`
+	expected := "a|b|c#a#00#a,b,c#a|b,c#PHP Warning: explode(): Empty delimiter\nbool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// get_defined_constants categories
+
+func TestGetDefinedConstantsCategorized(t *testing.T) {
+	input := `<?php
+define('APP_NAME', 'demo');
+const APP_VERSION = 3;
+$all = get_defined_constants(true);
+echo implode(',', array_keys($all['user'])), "|";
+echo $all['user']['APP_NAME'], $all['user']['APP_VERSION'], "|";
+echo $all['Core']['E_ALL'], "|";
+echo isset($all['json']['JSON_PRETTY_PRINT']) ? 'json' : 'none', "|";
+echo isset($all['standard']['M_PI']) ? 'standard' : 'none', "|";
+echo isset($all['standard']['APP_NAME']) ? 'leak' : 'ok', "|";
+$flat = get_defined_constants();
+echo $flat['APP_NAME'], isset($flat['user']) ? 'nested' : 'flat';
+`
+	expected := "APP_NAME,APP_VERSION|demo3|32767|json|standard|ok|demoflat"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// implode argument order and Stringable elements
+
+func TestImplodeReversedArgumentsAndStringables(t *testing.T) {
+	input := `<?php
+class Tag {
+    public function __construct(private string $name) {}
+    public function __toString(): string { return "#" . $this->name; }
+}
+echo implode(['a', 'b', 'c'], '-'), "|";
+echo join(', ', [new Tag('php'), new Tag('go')]), "|";
+echo implode([new Tag('x'), 1, 2.5]), "|";
+echo implode('-', []);
+`
+	expected := "a-b-c|#php, #go|#x12.5|"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_search strict with nested arrays
+
+func TestArraySearchStrictNestedArrays(t *testing.T) {
+	input := `<?php
+$rows = [
+    'first' => ['a' => 1, 'b' => [1, 2]],
+    'second' => ['b' => [1, 2], 'a' => 1],
+];
+var_dump(array_search(['b' => [1, 2], 'a' => 1], $rows, true));
+var_dump(array_search(['a' => 1, 'b' => [1, 2]], $rows, true));
+var_dump(array_search(['a' => 1, 'b' => [2, 1]], $rows, true));
+var_dump(array_search(['a' => '1', 'b' => [1, 2]], $rows, true));
+var_dump(in_array([[1, 2]], [[[2, 1]], [[1, 2]]], true));
+var_dump([1, [2, 3]] === [1, [2, 3]], [1, [2, 3]] === [1, [3, 2]]);
+var_dump(array_search(['b' => [1, 2], 'a' => '1'], $rows));
+var_dump(['x' => 1, 'y' => 2] == ['y' => 2, 'x' => 1], [1, 2] == [2, 1]);
+`
+	expected := "string(6) \"second\"\nstring(5) \"first\"\nbool(false)\nbool(false)\nbool(true)\nbool(true)\nbool(false)\nstring(5) \"first\"\nbool(true)\nbool(false)\n"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_unique flags
+
+func TestArrayUniqueSortFlags(t *testing.T) {
+	input := `<?php
+$values = ['a' => '1', 'b' => '01', 'c' => 1, 'e' => '1.0', 'f' => 2];
+foreach (array_unique($values) as $k => $v) { echo "$k=$v,"; }
+echo "|";
+foreach (array_unique($values, SORT_STRING) as $k => $v) { echo "$k=$v,"; }
+echo "|";
+foreach (array_unique($values, SORT_NUMERIC) as $k => $v) { echo "$k=$v,"; }
+echo "|";
+foreach (array_unique([3 => 1, 4 => 1.0, 5 => true, 6 => 'x'], SORT_REGULAR) as $k => $v) { echo "$k=$v,"; }
+echo "|";
+foreach (array_unique(['x' => 3, 'y' => '1', 'z' => 3.0, 'w' => 1, 'v' => 2], SORT_NUMERIC) as $k => $v) { echo "$k=$v,"; }
+`
+	expected := "a=1,b=01,e=1.0,f=2,|a=1,b=01,e=1.0,f=2,|a=1,f=2,|3=1,6=x,|x=3,y=1,v=2,"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array key normalization
+
+func TestArrayKeysFromValuesAreNormalized(t *testing.T) {
+	input := `<?php
+var_dump(array_flip(['0' => 'a', 'b' => '12', 'c' => '012']));
+var_dump(array_combine(['1', '-2', '3.5', 'x'], ['a', 'b', 'c', 'd']));
+var_dump(array_fill_keys(['7', '07', 8], true));
+$a = [];
+$a['5'] = 'string';
+$a[5] = 'int';
+$a[true] = 'bool';
+$a[null] = 'null';
+var_dump($a);
+echo count(array_flip([[1], 'k'])), isset($a[null]) ? 'y' : 'n', $a['1'];
+`
+	expected := `array(3) {
+  ["a"] => int(0)
+  [12] => string(1) "b"
+  ["012"] => string(1) "c"
+}
+array(4) {
+  [1] => string(1) "a"
+  [-2] => string(1) "b"
+  ["3.5"] => string(1) "c"
+  ["x"] => string(1) "d"
+}
+array(3) {
+  [7] => bool(true)
+  ["07"] => bool(true)
+  [8] => bool(true)
+}
+array(3) {
+  [5] => string(3) "int"
+  [1] => string(4) "bool"
+  [""] => string(4) "null"
+}
+1ybool`
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_merge_recursive
+
+func TestArrayMergeRecursive(t *testing.T) {
+	input := `<?php
+function show($v) {
+    if (!is_array($v)) {
+        return var_export($v, true);
+    }
+    $parts = [];
+    foreach ($v as $k => $item) {
+        $parts[] = $k . ':' . show($item);
+    }
+    return '[' . implode(',', $parts) . ']';
+}
+$defaults = ['db' => ['host' => 'localhost', 'port' => 3306, 'options' => ['a']], 'debug' => false, 5 => 'x'];
+$local = ['db' => ['host' => 'db.local', 'options' => ['b']], 'debug' => true, 5 => 'y', 'name' => 'app'];
+echo show(array_merge_recursive($defaults, $local)), "|";
+echo show($defaults['db']), "|";
+echo show(array_merge_recursive(['k' => 'a'], ['k' => ['b', 'c']], ['k' => 'd'])), "|";
+echo show(array_merge_recursive([3 => 'a'], [3 => 'b'])), "|";
+echo show(array_merge_recursive(['a' => [5 => 'x']], ['a' => [7 => 'y']])), "|";
+echo show(array_merge_recursive(['a' => ['k' => 'x', 3 => 'z']], ['a' => 'y']));
+`
+	expected := "[db:[host:[0:'localhost',1:'db.local'],port:3306,options:[0:'a',1:'b']],debug:[0:false,1:true],0:'x',1:'y',name:'app']|" +
+		"[host:'localhost',port:3306,options:[0:'a']]|[k:[0:'a',1:'b',2:'c',3:'d']]|[0:'a',1:'b']|" +
+		"[a:[5:'x',6:'y']]|[a:[k:'x',3:'z',4:'y']]"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// array_rand
+
+func TestArrayRandDistinctOrderedKeys(t *testing.T) {
+	input := `<?php
+$arr = ['a' => 1, 'b' => 2, 'c' => 3, 'd' => 4, 'e' => 5, 'f' => 6];
+$ok = true;
+for ($n = 0; $n < 50; $n++) {
+    $ok = $ok && array_key_exists(array_rand($arr), $arr);
+    $picked = array_rand($arr, 3);
+    $ordered = array_values(array_intersect(array_keys($arr), $picked));
+    $ok = $ok && count($picked) === 3 && count(array_unique($picked)) === 3 && $ordered === $picked;
+}
+echo $ok ? 'ok' : 'bad', "|";
+echo implode(',', array_rand($arr, 6)), "|";
+mt_srand(42);
+$first = array_rand($arr, 2);
+$single = array_rand(range(0, 99));
+mt_srand(42);
+echo $first === array_rand($arr, 2) && $single === array_rand(range(0, 99)) ? 'same' : 'differs', "|";
+try {
+    array_rand($arr, 7);
+} catch (ValueError $e) {
+    echo $e, "|";
+}
+try {
+    array_rand([]);
+} catch (ValueError $e) {
+    echo $e;
+}
+`
+	expected := "ok|a,b,c,d,e,f|same|array_rand(): Argument #2 ($num) must be between 1 and the number of elements in argument #1 ($array)|array_rand(): Argument #1 ($array) cannot be empty"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestArrayRandSeedIsPerInterpreter(t *testing.T) {
+	draw := `<?php echo implode(',', array_rand(range(0, 99), 5)), ";";`
+
+	reference := New()
+	reference.Eval(`<?php mt_srand(3);`)
+	reference.Eval(draw)
+	reference.Eval(draw)
+
+	a, b := New(), New()
+	a.Eval(`<?php mt_srand(3);`)
+	a.Eval(draw)
+	// mt_srand in another interpreter must not reseed a
+	b.Eval(`<?php mt_srand(99); array_rand(range(0, 99), 5);`)
+	a.Eval(draw)
+
+	if a.Output() != reference.Output() {
+		t.Errorf("expected %q, got %q", reference.Output(), a.Output())
+	}
+}
+
+// ----------------------------------------------------------------------------
+// json_encode recursion and depth
+
+func TestJsonEncodeRecursionAndDepth(t *testing.T) {
+	input := `<?php
+class N { public $n; }
+class Me implements JsonSerializable {
+	public $id = 1;
+	public function jsonSerialize(): mixed { return $this; }
+}
+$a = new N;
+$a->n = $a;
+var_dump(json_encode($a));
+echo json_last_error() === JSON_ERROR_RECURSION ? "recursion" : "other", "|", json_last_error_msg(), "|";
+$b = new N;
+$b->n = new N;
+echo json_encode($b), "|";
+var_dump(json_encode([[1]], 0, 1));
+echo json_last_error() === JSON_ERROR_DEPTH ? "depth" : "other", "|";
+echo json_encode([[1]], 0, 2), "|", json_encode(new Me), "|";
+try {
+	json_encode($a, JSON_THROW_ON_ERROR);
+} catch (JsonException $e) {
+	echo $e;
+}
+`
+	expected := "bool(false)\nrecursion|Recursion detected|{\"n\":{\"n\":null}}|bool(false)\ndepth|[[1]]|{\"id\":1}|Recursion detected"
+	result := evalOutput(input)
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Per-interpreter random generators
+
+func TestRandGeneratorsArePerInterpreter(t *testing.T) {
+	draw := `<?php echo mt_rand(), ",", rand(), ";";`
+
+	reference := New()
+	reference.Eval(`<?php mt_srand(7); srand(7);`)
+	reference.Eval(draw)
+	reference.Eval(draw)
+
+	a, b := New(), New()
+	a.Eval(`<?php mt_srand(7); srand(7);`)
+	a.Eval(draw)
+	// Seeding and drawing elsewhere must not disturb a's sequence
+	b.Eval(`<?php mt_srand(7); srand(7);`)
+	b.Eval(draw)
+	a.Eval(draw)
+
+	if a.Output() != reference.Output() {
+		t.Errorf("expected %q, got %q", reference.Output(), a.Output())
+	}
+	if b.Output() == "" || !strings.HasPrefix(reference.Output(), b.Output()) {
+		t.Errorf("expected %q to start the reference sequence %q", b.Output(), reference.Output())
+	}
+
+	// Interpreters running concurrently each use their own state
+	done := make(chan bool)
+	for n := 0; n < 4; n++ {
+		go func() {
+			interp := New()
+			interp.Eval(`<?php for ($k = 0; $k < 200; $k++) { mt_rand(1, 6); rand(); }`)
+			done <- true
+		}()
+	}
+	for n := 0; n < 4; n++ {
+		<-done
+	}
+}
@@ -0,0 +1,1018 @@
+package interpreter
+
+import (
+	"fmt"
+
+	"github.com/alexisbouchez/phpgo/ast"
+	"github.com/alexisbouchez/phpgo/runtime"
+)
+
+// splIterator is implemented by natively backed SPL iterators and by the
+// adapters that let native code drive any PHP Traversable.
+type splIterator interface {
+	runtime.Value
+	rewind(i *Interpreter)
+	valid(i *Interpreter) bool
+	current(i *Interpreter) runtime.Value
+	key(i *Interpreter) runtime.Value
+	next(i *Interpreter)
+}
+
+// isSplIterator checks if a class name is a natively implemented SPL iterator
+func isSplIterator(name string) bool {
+	switch name {
+	case "ArrayIterator", "SplFileObject", "DirectoryIterator", "FilesystemIterator",
+		"RecursiveDirectoryIterator", "GlobIterator", "RecursiveIteratorIterator",
+		"CallbackFilterIterator", "AppendIterator", "IteratorIterator", "InfiniteIterator",
+		"NoRewindIterator", "LimitIterator":
+		return true
+	}
+	return false
+}
+
+// handleSplIteratorNew creates a new SPL iterator object
+func (i *Interpreter) handleSplIteratorNew(className string, args []runtime.Value) runtime.Value {
+	switch className {
+	case "ArrayIterator":
+		arr := runtime.NewArray()
+		if len(args) > 0 {
+			switch v := args[0].(type) {
+			case *runtime.Array:
+				arr = v
+			case *runtime.Object:
+				for name, val := range v.Properties {
+					arr.Set(runtime.NewString(name), val)
+				}
+			}
+		}
+		return NewArrayIterator(arr)
+	case "SplFileObject":
+		if len(args) < 1 {
+			return runtime.NewError("SplFileObject::__construct() expects at least 1 argument, 0 given")
+		}
+		mode := "r"
+		if len(args) >= 2 {
+			mode = args[1].ToString()
+		}
+		return i.newSplFileObject(args[0].ToString(), mode)
+	case "DirectoryIterator":
+		if len(args) < 1 {
+			return runtime.NewError("DirectoryIterator::__construct() expects exactly 1 argument, 0 given")
+		}
+		return i.newDirectoryIterator(className, args[0].ToString(), 0)
+	case "FilesystemIterator", "RecursiveDirectoryIterator", "GlobIterator":
+		if len(args) < 1 {
+			return runtime.NewError(fmt.Sprintf("%s::__construct() expects at least 1 argument, 0 given", className))
+		}
+		var flags int64
+		if len(args) >= 2 {
+			flags = args[1].ToInt()
+		} else if className != "RecursiveDirectoryIterator" {
+			flags = fsSkipDots
+		}
+		if className == "GlobIterator" {
+			return i.newGlobIterator(args[0].ToString(), flags)
+		}
+		return i.newDirectoryIterator(className, args[0].ToString(), flags)
+	case "RecursiveIteratorIterator":
+		var inner splIterator
+		if len(args) > 0 {
+			inner = i.toIterator(args[0])
+		}
+		if inner == nil {
+			return i.splException("InvalidArgumentException", "An instance of RecursiveIterator or IteratorAggregate creating it is required")
+		}
+		mode := int64(riiLeavesOnly)
+		if len(args) >= 2 {
+			mode = args[1].ToInt()
+		}
+		return &RecursiveIteratorIteratorObject{root: inner, mode: mode, maxDepth: -1}
+	case "CallbackFilterIterator":
+		if len(args) < 2 {
+			return runtime.NewError(fmt.Sprintf("CallbackFilterIterator::__construct() expects exactly 2 arguments, %d given", len(args)))
+		}
+		inner := i.toIterator(args[0])
+		if inner == nil {
+			return runtime.NewError("CallbackFilterIterator::__construct(): Argument #1 ($iterator) must be of type Iterator")
+		}
+		return &CallbackFilterIteratorObject{inner: inner, callback: args[1]}
+	case "AppendIterator":
+		return &AppendIteratorObject{}
+	case "IteratorIterator", "InfiniteIterator", "NoRewindIterator", "LimitIterator":
+		var inner splIterator
+		if len(args) > 0 {
+			inner = i.toIterator(args[0])
+		}
+		if inner == nil {
+			return runtime.NewError(fmt.Sprintf("%s::__construct(): Argument #1 ($iterator) must be of type Traversable", className))
+		}
+		if className != "LimitIterator" {
+			return &IteratorIteratorObject{className: className, inner: inner}
+		}
+		offset, limit := int64(0), int64(-1)
+		if len(args) >= 2 {
+			offset = args[1].ToInt()
+		}
+		if len(args) >= 3 {
+			limit = args[2].ToInt()
+		}
+		if offset < 0 {
+			return i.splException("OutOfRangeException", "LimitIterator::__construct(): Argument #2 ($offset) must be greater than or equal to 0")
+		}
+		if limit < -1 {
+			return i.splException("OutOfRangeException", "LimitIterator::__construct(): Argument #3 ($limit) must be greater than or equal to -1")
+		}
+		return &LimitIteratorObject{inner: inner, offset: offset, limit: limit}
+	}
+	return runtime.NewError(fmt.Sprintf("unknown SPL iterator class: %s", className))
+}
+
+// ----------------------------------------------------------------------------
+// ArrayIterator
+
+// ArrayIteratorObject represents a native ArrayIterator
+type ArrayIteratorObject struct {
+	array    *runtime.Array
+	position int
+}
+
+func NewArrayIterator(arr *runtime.Array) *ArrayIteratorObject {
+	return &ArrayIteratorObject{array: arr}
+}
+
+func (a *ArrayIteratorObject) Type() string     { return "object" }
+func (a *ArrayIteratorObject) ToBool() bool     { return true }
+func (a *ArrayIteratorObject) ToInt() int64     { return 1 }
+func (a *ArrayIteratorObject) ToFloat() float64 { return 1.0 }
+func (a *ArrayIteratorObject) ToString() string { return "ArrayIterator" }
+func (a *ArrayIteratorObject) Inspect() string {
+	return fmt.Sprintf("object(ArrayIterator)#%p (%d)", a, a.array.Len())
+}
+
+func (a *ArrayIteratorObject) rewind(i *Interpreter)     { a.position = 0 }
+func (a *ArrayIteratorObject) valid(i *Interpreter) bool { return a.position < len(a.array.Keys) }
+func (a *ArrayIteratorObject) next(i *Interpreter)       { a.position++ }
+
+func (a *ArrayIteratorObject) current(i *Interpreter) runtime.Value {
+	if !a.valid(i) {
+		return runtime.NULL
+	}
+	return a.array.Elements[a.array.Keys[a.position]]
+}
+
+func (a *ArrayIteratorObject) key(i *Interpreter) runtime.Value {
+	if !a.valid(i) {
+		return runtime.NULL
+	}
+	return a.array.Keys[a.position]
+}
+
+// ----------------------------------------------------------------------------
+// RecursiveIteratorIterator
+
+// RecursiveIteratorIterator modes
+const (
+	riiLeavesOnly = 0
+	riiSelfFirst  = 1
+	riiChildFirst = 2
+)
+
+// recursiveSplIterator is implemented by native iterators that can be
+// descended into by RecursiveIteratorIterator
+type recursiveSplIterator interface {
+	splIterator
+	hasChildren(i *Interpreter) bool
+	getChildren(i *Interpreter) splIterator
+}
+
+// iteratorHasChildren reports whether the current element of a native or
+// PHP-level RecursiveIterator has children
+func (i *Interpreter) iteratorHasChildren(it splIterator) bool {
+	switch v := it.(type) {
+	case recursiveSplIterator:
+		return v.hasChildren(i)
+	case *userIterator:
+		if i.implementsInterface(v.Class, "RecursiveIterator") {
+			return i.callArrayAccessMethod(v.Object, "hasChildren", []runtime.Value{}).ToBool()
+		}
+	}
+	return false
+}
+
+// iteratorChildren returns an iterator over the children of the current
+// element, or nil if they cannot be traversed
+func (i *Interpreter) iteratorChildren(it splIterator) splIterator {
+	switch v := it.(type) {
+	case recursiveSplIterator:
+		return v.getChildren(i)
+	case *userIterator:
+		return i.toIterator(i.callArrayAccessMethod(v.Object, "getChildren", []runtime.Value{}))
+	}
+	return nil
+}
+
+// recursiveFrame is one level of a RecursiveIteratorIterator's descent
+type recursiveFrame struct {
+	it          splIterator
+	selfYielded bool // SELF_FIRST: the parent was yielded before its children
+	descended   bool // the children of the current element were visited
+}
+
+// RecursiveIteratorIteratorObject flattens a tree of RecursiveIterators,
+// keeping a stack of the iterators it has descended into
+type RecursiveIteratorIteratorObject struct {
+	root     splIterator
+	mode     int64
+	maxDepth int
+	stack    []*recursiveFrame
+}
+
+func (r *RecursiveIteratorIteratorObject) Type() string     { return "object" }
+func (r *RecursiveIteratorIteratorObject) ToBool() bool     { return true }
+func (r *RecursiveIteratorIteratorObject) ToInt() int64     { return 1 }
+func (r *RecursiveIteratorIteratorObject) ToFloat() float64 { return 1.0 }
+func (r *RecursiveIteratorIteratorObject) ToString() string { return "RecursiveIteratorIterator" }
+func (r *RecursiveIteratorIteratorObject) Inspect() string {
+	return fmt.Sprintf("object(RecursiveIteratorIterator)#%p", r)
+}
+
+func (r *RecursiveIteratorIteratorObject) top() *recursiveFrame {
+	if len(r.stack) == 0 {
+		return nil
+	}
+	return r.stack[len(r.stack)-1]
+}
+
+func (r *RecursiveIteratorIteratorObject) rewind(i *Interpreter) {
+	r.stack = []*recursiveFrame{{it: r.root}}
+	r.root.rewind(i)
+	r.settle(i)
+}
+
+func (r *RecursiveIteratorIteratorObject) valid(i *Interpreter) bool {
+	top := r.top()
+	return top != nil && top.it.valid(i)
+}
+
+func (r *RecursiveIteratorIteratorObject) current(i *Interpreter) runtime.Value {
+	if top := r.top(); top != nil {
+		return top.it.current(i)
+	}
+	return runtime.NULL
+}
+
+func (r *RecursiveIteratorIteratorObject) key(i *Interpreter) runtime.Value {
+	if top := r.top(); top != nil {
+		return top.it.key(i)
+	}
+	return runtime.NULL
+}
+
+func (r *RecursiveIteratorIteratorObject) next(i *Interpreter) {
+	top := r.top()
+	if top == nil {
+		return
+	}
+	if !(top.selfYielded && !top.descended) {
+		top.it.next(i)
+		top.selfYielded = false
+		top.descended = false
+	}
+	r.settle(i)
+}
+
+// settle moves from the current position to the next element the mode
+// yields, descending into children and climbing out of exhausted levels
+func (r *RecursiveIteratorIteratorObject) settle(i *Interpreter) {
+	for {
+		top := r.top()
+		if !top.it.valid(i) {
+			if len(r.stack) == 1 {
+				return
+			}
+			r.stack = r.stack[:len(r.stack)-1]
+			parent := r.top()
+			if r.mode == riiChildFirst {
+				return
+			}
+			parent.it.next(i)
+			parent.selfYielded = false
+			parent.descended = false
+			continue
+		}
+
+		depth := len(r.stack) - 1
+		if top.descended || (r.maxDepth >= 0 && depth >= r.maxDepth) || !i.iteratorHasChildren(top.it) {
+			return
+		}
+		if r.mode == riiSelfFirst && !top.selfYielded {
+			top.selfYielded = true
+			return
+		}
+		top.descended = true
+		child := i.iteratorChildren(top.it)
+		if child == nil {
+			return
+		}
+		r.stack = append(r.stack, &recursiveFrame{it: child})
+		child.rewind(i)
+	}
+}
+
+func (i *Interpreter) callRecursiveIteratorIteratorMethod(r *RecursiveIteratorIteratorObject, methodName string, args []runtime.Value) runtime.Value {
+	switch methodName {
+	case "getDepth":
+		return runtime.NewInt(int64(len(r.stack) - 1))
+	case "getInnerIterator":
+		if top := r.top(); top != nil {
+			return iteratorValue(top.it)
+		}
+		return iteratorValue(r.root)
+	case "getSubIterator":
+		level := len(r.stack) - 1
+		if len(args) >= 1 {
+			level = int(args[0].ToInt())
+		}
+		if level < 0 || level >= len(r.stack) {
+			return runtime.NULL
+		}
+		return iteratorValue(r.stack[level].it)
+	case "getMaxDepth":
+		if r.maxDepth < 0 {
+			return runtime.FALSE
+		}
+		return runtime.NewInt(int64(r.maxDepth))
+	case "setMaxDepth":
+		r.maxDepth = -1
+		if len(args) >= 1 {
+			if depth := int(args[0].ToInt()); depth >= 0 {
+				r.maxDepth = depth
+			} else if depth < -1 {
+				return i.splException("OutOfRangeException", "RecursiveIteratorIterator::setMaxDepth(): Argument #1 ($maxDepth) must be greater than or equal to -1")
+			}
+		}
+		return runtime.NULL
+	case "callHasChildren":
+		if top := r.top(); top != nil {
+			return runtime.NewBool(i.iteratorHasChildren(top.it))
+		}
+		return runtime.FALSE
+	}
+
+	// Anything else is forwarded to the current inner iterator
+	if top := r.top(); top != nil {
+		return i.callIteratorMethod(top.it, methodName, args)
+	}
+	return i.callIteratorMethod(r.root, methodName, args)
+}
+
+// ----------------------------------------------------------------------------
+// CallbackFilterIterator
+
+// CallbackFilterIteratorObject yields the elements of its inner iterator
+// for which the callback, called with (current, key, iterator), returns true
+type CallbackFilterIteratorObject struct {
+	inner    splIterator
+	callback runtime.Value
+}
+
+func (c *CallbackFilterIteratorObject) Type() string     { return "object" }
+func (c *CallbackFilterIteratorObject) ToBool() bool     { return true }
+func (c *CallbackFilterIteratorObject) ToInt() int64     { return 1 }
+func (c *CallbackFilterIteratorObject) ToFloat() float64 { return 1.0 }
+func (c *CallbackFilterIteratorObject) ToString() string { return "CallbackFilterIterator" }
+func (c *CallbackFilterIteratorObject) Inspect() string {
+	return fmt.Sprintf("object(CallbackFilterIterator)#%p", c)
+}
+
+func (c *CallbackFilterIteratorObject) rewind(i *Interpreter) {
+	c.inner.rewind(i)
+	c.fetch(i)
+}
+
+func (c *CallbackFilterIteratorObject) next(i *Interpreter) {
+	c.inner.next(i)
+	c.fetch(i)
+}
+
+func (c *CallbackFilterIteratorObject) valid(i *Interpreter) bool {
+	return c.inner.valid(i)
+}
+
+func (c *CallbackFilterIteratorObject) current(i *Interpreter) runtime.Value {
+	return c.inner.current(i)
+}
+
+func (c *CallbackFilterIteratorObject) key(i *Interpreter) runtime.Value {
+	return c.inner.key(i)
+}
+
+// accept calls the callback on the current element
+func (c *CallbackFilterIteratorObject) accept(i *Interpreter) bool {
+	args := []runtime.Value{c.inner.current(i), c.inner.key(i), iteratorValue(c.inner)}
+	return i.callCallback(c.callback, args).ToBool()
+}
+
+// fetch skips ahead to the next accepted element
+func (c *CallbackFilterIteratorObject) fetch(i *Interpreter) {
+	for c.inner.valid(i) && !c.accept(i) {
+		c.inner.next(i)
+	}
+}
+
+func (i *Interpreter) callCallbackFilterIteratorMethod(c *CallbackFilterIteratorObject, methodName string, args []runtime.Value) runtime.Value {
+	switch methodName {
+	case "accept":
+		return runtime.NewBool(c.inner.valid(i) && c.accept(i))
+	case "getInnerIterator":
+		return iteratorValue(c.inner)
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: CallbackFilterIterator::%s", methodName))
+}
+
+// ----------------------------------------------------------------------------
+// AppendIterator
+
+// AppendIteratorObject iterates over each appended iterator in turn
+type AppendIteratorObject struct {
+	iterators []splIterator
+	index     int
+}
+
+func (a *AppendIteratorObject) Type() string     { return "object" }
+func (a *AppendIteratorObject) ToBool() bool     { return true }
+func (a *AppendIteratorObject) ToInt() int64     { return 1 }
+func (a *AppendIteratorObject) ToFloat() float64 { return 1.0 }
+func (a *AppendIteratorObject) ToString() string { return "AppendIterator" }
+func (a *AppendIteratorObject) Inspect() string {
+	return fmt.Sprintf("object(AppendIterator)#%p", a)
+}
+
+func (a *AppendIteratorObject) inner() splIterator {
+	if a.index < len(a.iterators) {
+		return a.iterators[a.index]
+	}
+	return nil
+}
+
+func (a *AppendIteratorObject) rewind(i *Interpreter) {
+	a.index = 0
+	if it := a.inner(); it != nil {
+		it.rewind(i)
+		a.skipExhausted(i)
+	}
+}
+
+func (a *AppendIteratorObject) valid(i *Interpreter) bool {
+	it := a.inner()
+	return it != nil && it.valid(i)
+}
+
+func (a *AppendIteratorObject) current(i *Interpreter) runtime.Value {
+	if it := a.inner(); it != nil {
+		return it.current(i)
+	}
+	return runtime.NULL
+}
+
+func (a *AppendIteratorObject) key(i *Interpreter) runtime.Value {
+	if it := a.inner(); it != nil {
+		return it.key(i)
+	}
+	return runtime.NULL
+}
+
+func (a *AppendIteratorObject) next(i *Interpreter) {
+	if it := a.inner(); it != nil {
+		it.next(i)
+		a.skipExhausted(i)
+	}
+}
+
+// skipExhausted moves on to the next iterator with elements left, rewinding
+// each one as it is reached
+func (a *AppendIteratorObject) skipExhausted(i *Interpreter) {
+	for a.index < len(a.iterators) && !a.iterators[a.index].valid(i) {
+		a.index++
+		if it := a.inner(); it != nil {
+			it.rewind(i)
+		}
+	}
+}
+
+func (i *Interpreter) callAppendIteratorMethod(a *AppendIteratorObject, methodName string, args []runtime.Value) runtime.Value {
+	switch methodName {
+	case "append":
+		if len(args) < 1 {
+			return runtime.NewError("AppendIterator::append() expects exactly 1 argument, 0 given")
+		}
+		it := i.toIterator(args[0])
+		if it == nil {
+			return runtime.NewError("AppendIterator::append(): Argument #1 ($iterator) must be of type Iterator")
+		}
+		a.iterators = append(a.iterators, it)
+		// Like PHP, an exhausted AppendIterator continues with the new iterator
+		if a.index == len(a.iterators)-1 {
+			it.rewind(i)
+		}
+		return runtime.NULL
+	case "getInnerIterator":
+		if it := a.inner(); it != nil {
+			return iteratorValue(it)
+		}
+		return runtime.NULL
+	case "getIteratorIndex":
+		if a.inner() == nil {
+			return runtime.NULL
+		}
+		return runtime.NewInt(int64(a.index))
+	case "getArrayIterator":
+		arr := runtime.NewArray()
+		for _, it := range a.iterators {
+			arr.Set(nil, iteratorValue(it))
+		}
+		return NewArrayIterator(arr)
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: AppendIterator::%s", methodName))
+}
+
+// ----------------------------------------------------------------------------
+// IteratorIterator, InfiniteIterator and NoRewindIterator
+
+// IteratorIteratorObject wraps an inner iterator. An InfiniteIterator
+// rewinds the inner iterator when it runs out, and a NoRewindIterator
+// ignores rewind so iteration carries on from where it stopped.
+type IteratorIteratorObject struct {
+	className string
+	inner     splIterator
+}
+
+func (w *IteratorIteratorObject) Type() string     { return "object" }
+func (w *IteratorIteratorObject) ToBool() bool     { return true }
+func (w *IteratorIteratorObject) ToInt() int64     { return 1 }
+func (w *IteratorIteratorObject) ToFloat() float64 { return 1.0 }
+func (w *IteratorIteratorObject) ToString() string { return w.className }
+func (w *IteratorIteratorObject) Inspect() string {
+	return fmt.Sprintf("object(%s)#%p", w.className, w)
+}
+
+func (w *IteratorIteratorObject) rewind(i *Interpreter) {
+	if w.className != "NoRewindIterator" {
+		w.inner.rewind(i)
+	}
+}
+
+func (w *IteratorIteratorObject) next(i *Interpreter) {
+	w.inner.next(i)
+	if w.className == "InfiniteIterator" && !w.inner.valid(i) {
+		w.inner.rewind(i)
+	}
+}
+
+func (w *IteratorIteratorObject) valid(i *Interpreter) bool {
+	return w.inner.valid(i)
+}
+
+func (w *IteratorIteratorObject) current(i *Interpreter) runtime.Value {
+	return w.inner.current(i)
+}
+
+func (w *IteratorIteratorObject) key(i *Interpreter) runtime.Value {
+	return w.inner.key(i)
+}
+
+// ----------------------------------------------------------------------------
+// LimitIterator
+
+// LimitIteratorObject yields at most limit elements of its inner iterator,
+// starting at offset; a limit of -1 means no limit
+type LimitIteratorObject struct {
+	inner    splIterator
+	offset   int64
+	limit    int64
+	position int64
+}
+
+func (l *LimitIteratorObject) Type() string     { return "object" }
+func (l *LimitIteratorObject) ToBool() bool     { return true }
+func (l *LimitIteratorObject) ToInt() int64     { return 1 }
+func (l *LimitIteratorObject) ToFloat() float64 { return 1.0 }
+func (l *LimitIteratorObject) ToString() string { return "LimitIterator" }
+func (l *LimitIteratorObject) Inspect() string {
+	return fmt.Sprintf("object(LimitIterator)#%p", l)
+}
+
+func (l *LimitIteratorObject) rewind(i *Interpreter) {
+	l.inner.rewind(i)
+	l.position = 0
+	l.skipTo(i, l.offset)
+}
+
+// skipTo advances the inner iterator to the given position
+func (l *LimitIteratorObject) skipTo(i *Interpreter, pos int64) {
+	for l.position < pos && l.inner.valid(i) {
+		l.inner.next(i)
+		l.position++
+	}
+}
+
+func (l *LimitIteratorObject) valid(i *Interpreter) bool {
+	if l.limit != -1 && l.position >= l.offset+l.limit {
+		return false
+	}
+	return l.inner.valid(i)
+}
+
+func (l *LimitIteratorObject) next(i *Interpreter) {
+	l.inner.next(i)
+	l.position++
+}
+
+func (l *LimitIteratorObject) current(i *Interpreter) runtime.Value {
+	return l.inner.current(i)
+}
+
+func (l *LimitIteratorObject) key(i *Interpreter) runtime.Value {
+	return l.inner.key(i)
+}
+
+func (i *Interpreter) callLimitIteratorMethod(l *LimitIteratorObject, methodName string, args []runtime.Value) runtime.Value {
+	switch methodName {
+	case "getPosition":
+		return runtime.NewInt(l.position)
+	case "getInnerIterator":
+		return iteratorValue(l.inner)
+	case "seek":
+		if len(args) < 1 {
+			return runtime.NULL
+		}
+		pos := args[0].ToInt()
+		if pos < l.offset {
+			return i.splException("OutOfBoundsException", fmt.Sprintf("Cannot seek to %d which is below the offset %d", pos, l.offset))
+		}
+		if l.limit != -1 && pos >= l.offset+l.limit {
+			return i.splException("OutOfBoundsException", fmt.Sprintf("Cannot seek to %d which is behind offset %d plus count %d", pos, l.offset, l.limit))
+		}
+		if pos < l.position {
+			l.inner.rewind(i)
+			l.position = 0
+		}
+		l.skipTo(i, pos)
+		return runtime.NewInt(l.position)
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: LimitIterator::%s", methodName))
+}
+
+// ----------------------------------------------------------------------------
+// Adapters for PHP-level Traversables
+
+// userIterator drives a PHP object implementing Iterator via its methods
+type userIterator struct {
+	*runtime.Object
+}
+
+func (u *userIterator) rewind(i *Interpreter) {
+	i.callArrayAccessMethod(u.Object, "rewind", []runtime.Value{})
+}
+
+func (u *userIterator) valid(i *Interpreter) bool {
+	return i.callArrayAccessMethod(u.Object, "valid", []runtime.Value{}).ToBool()
+}
+
+func (u *userIterator) current(i *Interpreter) runtime.Value {
+	return i.callArrayAccessMethod(u.Object, "current", []runtime.Value{})
+}
+
+func (u *userIterator) key(i *Interpreter) runtime.Value {
+	return i.callArrayAccessMethod(u.Object, "key", []runtime.Value{})
+}
+
+func (u *userIterator) next(i *Interpreter) {
+	i.callArrayAccessMethod(u.Object, "next", []runtime.Value{})
+}
+
+// generatorIterator drives a Generator
+type generatorIterator struct {
+	*runtime.Generator
+}
+
+func (g *generatorIterator) rewind(i *Interpreter)                { g.Rewind() }
+func (g *generatorIterator) valid(i *Interpreter) bool            { return g.Valid() }
+func (g *generatorIterator) current(i *Interpreter) runtime.Value { return g.Current() }
+func (g *generatorIterator) key(i *Interpreter) runtime.Value     { return g.Key() }
+func (g *generatorIterator) next(i *Interpreter)                  { g.Next() }
+
+// toIterator returns an iterator over any Traversable value, or nil if the
+// value cannot be traversed.
+func (i *Interpreter) toIterator(v runtime.Value) splIterator {
+	switch val := v.(type) {
+	case splIterator:
+		return val
+	case *runtime.Array:
+		return NewArrayIterator(val)
+	case *runtime.Generator:
+		return &generatorIterator{val}
+	case *ArrayObjectObject:
+		return NewArrayIterator(val.array)
+	case *SplFixedArrayObject:
+		arr := runtime.NewArray()
+		for _, elem := range val.elements {
+			arr.Set(nil, elem)
+		}
+		return NewArrayIterator(arr)
+	case *SplStackObject:
+		return i.toIterator(val.SplDoublyLinkedListObject)
+	case *SplQueueObject:
+		return i.toIterator(val.SplDoublyLinkedListObject)
+	case *SplDoublyLinkedListObject:
+		arr := runtime.NewArray()
+		if val.mode&2 == 2 {
+			for idx := len(val.elements) - 1; idx >= 0; idx-- {
+				arr.Set(runtime.NewInt(int64(idx)), val.elements[idx])
+			}
+		} else {
+			for _, elem := range val.elements {
+				arr.Set(nil, elem)
+			}
+		}
+		return NewArrayIterator(arr)
+	case *runtime.Object:
+		if heap := i.userSplHeap(val); heap != nil {
+			return heap
+		}
+		if i.implementsInterface(val.Class, "Iterator") {
+			return &userIterator{val}
+		}
+		if i.implementsInterface(val.Class, "IteratorAggregate") {
+			return i.toIterator(i.callArrayAccessMethod(val, "getIterator", []runtime.Value{}))
+		}
+	}
+	return nil
+}
+
+// iterate walks any Traversable value, calling fn for each key/value pair
+// until fn returns false. It returns false if v is not traversable.
+func (i *Interpreter) iterate(v runtime.Value, fn func(key, val runtime.Value) bool) bool {
+	it := i.toIterator(v)
+	if it == nil {
+		return false
+	}
+	for it.rewind(i); it.valid(i); it.next(i) {
+		if !fn(it.key(i), it.current(i)) {
+			break
+		}
+	}
+	return true
+}
+
+// evalForeachSplIterator handles foreach for native SPL iterators
+func (i *Interpreter) evalForeachSplIterator(s *ast.ForeachStmt, it splIterator) runtime.Value {
+	for it.rewind(i); it.valid(i); it.next(i) {
+		if s.KeyVar != nil {
+			keyName := s.KeyVar.(*ast.Variable).Name.(*ast.Ident).Name
+			i.env.Set(keyName, it.key(i))
+		}
+		valName := s.ValueVar.(*ast.Variable).Name.(*ast.Ident).Name
+		i.env.Set(valName, it.current(i))
+
+		result := i.evalStmt(s.Body)
+		switch r := result.(type) {
+		case *runtime.Break:
+			if r.Levels <= 1 {
+				return runtime.NULL
+			}
+			return &runtime.Break{Levels: r.Levels - 1}
+		case *runtime.Continue:
+			if r.Levels <= 1 {
+				continue
+			}
+			return &runtime.Continue{Levels: r.Levels - 1}
+		case *runtime.ReturnValue, *runtime.Exit:
+			return result
+		}
+	}
+	return runtime.NULL
+}
+
+// callSplIteratorMethod handles method calls on native SPL iterators
+func (i *Interpreter) callSplIteratorMethod(it splIterator, methodName string, args []runtime.Value) runtime.Value {
+	switch methodName {
+	case "rewind":
+		it.rewind(i)
+		return runtime.NULL
+	case "valid":
+		return runtime.NewBool(it.valid(i))
+	case "current":
+		return it.current(i)
+	case "key":
+		return it.key(i)
+	case "next":
+		it.next(i)
+		return runtime.NULL
+	}
+
+	switch o := it.(type) {
+	case *ArrayIteratorObject:
+		return i.callArrayIteratorMethod(o, methodName, args)
+	case *SplFileObjectObject:
+		return i.callSplFileObjectMethod(o, methodName, args)
+	case *DirectoryIteratorObject:
+		return i.callDirectoryIteratorMethod(o, methodName, args)
+	case *RecursiveIteratorIteratorObject:
+		return i.callRecursiveIteratorIteratorMethod(o, methodName, args)
+	case *CallbackFilterIteratorObject:
+		return i.callCallbackFilterIteratorMethod(o, methodName, args)
+	case *AppendIteratorObject:
+		return i.callAppendIteratorMethod(o, methodName, args)
+	case *IteratorIteratorObject:
+		if methodName == "getInnerIterator" {
+			return iteratorValue(o.inner)
+		}
+		return runtime.NewError(fmt.Sprintf("undefined method: %s::%s", o.className, methodName))
+	case *LimitIteratorObject:
+		return i.callLimitIteratorMethod(o, methodName, args)
+	case *SimpleXMLObject:
+		return i.callSimpleXMLMethod(o, methodName, args)
+	case *DOMNodeListObject:
+		return i.callDOMNodeListMethod(o, methodName, args)
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: %s::%s", it.ToString(), methodName))
+}
+
+// callIteratorMethod calls a method on the object behind an iterator,
+// whether it is native or a PHP-level Iterator
+func (i *Interpreter) callIteratorMethod(it splIterator, methodName string, args []runtime.Value) runtime.Value {
+	if u, ok := it.(*userIterator); ok {
+		return i.callArrayAccessMethod(u.Object, methodName, args)
+	}
+	return i.callSplIteratorMethod(it, methodName, args)
+}
+
+// iteratorValue returns the PHP value an iterator was created from
+func iteratorValue(it splIterator) runtime.Value {
+	switch v := it.(type) {
+	case *userIterator:
+		return v.Object
+	case *generatorIterator:
+		return v.Generator
+	}
+	return it
+}
+
+func (i *Interpreter) callArrayIteratorMethod(a *ArrayIteratorObject, methodName string, args []runtime.Value) runtime.Value {
+	if result, ok := callArrayStorageMethod(a.array, methodName, args); ok {
+		return result
+	}
+	switch methodName {
+	case "seek":
+		if len(args) < 1 {
+			return runtime.NULL
+		}
+		pos := int(args[0].ToInt())
+		if pos < 0 || pos >= len(a.array.Keys) {
+			return runtime.NewError(fmt.Sprintf("Seek position %d is out of range", pos))
+		}
+		a.position = pos
+		return runtime.NULL
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: ArrayIterator::%s", methodName))
+}
+
+// callArrayStorageMethod handles the methods ArrayObject and ArrayIterator
+// share for manipulating their backing array. It reports whether methodName
+// was one of them.
+func callArrayStorageMethod(arr *runtime.Array, methodName string, args []runtime.Value) (runtime.Value, bool) {
+	switch methodName {
+	case "count":
+		return runtime.NewInt(int64(arr.Len())), true
+	case "getArrayCopy":
+		result := runtime.NewArray()
+		for _, k := range arr.Keys {
+			result.Set(k, arr.Elements[k])
+		}
+		return result, true
+	case "offsetGet":
+		if len(args) < 1 {
+			return runtime.NULL, true
+		}
+		return arr.Get(args[0]), true
+	case "offsetSet":
+		if len(args) < 2 {
+			return runtime.NULL, true
+		}
+		if _, isNull := args[0].(*runtime.Null); isNull {
+			arr.Set(nil, args[1])
+		} else {
+			arr.Set(args[0], args[1])
+		}
+		return runtime.NULL, true
+	case "offsetExists":
+		if len(args) < 1 {
+			return runtime.FALSE, true
+		}
+		for _, k := range arr.Keys {
+			if k.ToString() == args[0].ToString() {
+				return runtime.TRUE, true
+			}
+		}
+		return runtime.FALSE, true
+	case "offsetUnset":
+		if len(args) > 0 {
+			arr.Unset(args[0])
+		}
+		return runtime.NULL, true
+	case "append":
+		if len(args) > 0 {
+			arr.Set(nil, args[0])
+		}
+		return runtime.NULL, true
+	}
+	return nil, false
+}
+
+// arrayArg returns the array backing an array-like argument: a plain array,
+// or the storage of an ArrayObject or ArrayIterator.
+func arrayArg(v runtime.Value) (*runtime.Array, bool) {
+	switch val := v.(type) {
+	case *runtime.Array:
+		return val, true
+	case *ArrayObjectObject:
+		return val.array, true
+	case *ArrayIteratorObject:
+		return val.array, true
+	case *runtime.Object:
+		if native, ok := val.Internal.(runtime.Value); ok {
+			return arrayArg(native)
+		}
+	}
+	return nil, false
+}
+
+// ----------------------------------------------------------------------------
+// SPL iterator functions
+
+func (i *Interpreter) builtinIteratorToArray(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.NewError("iterator_to_array() expects at least 1 parameter")
+	}
+	preserveKeys := true
+	if len(args) >= 2 {
+		preserveKeys = args[1].ToBool()
+	}
+
+	result := runtime.NewArray()
+	ok := i.iterate(args[0], func(key, val runtime.Value) bool {
+		if preserveKeys {
+			result.Set(key, val)
+		} else {
+			result.Set(nil, val)
+		}
+		return true
+	})
+	if !ok {
+		return runtime.NewError("iterator_to_array(): Argument #1 ($iterator) must be of type Traversable|array")
+	}
+	return result
+}
+
+func (i *Interpreter) builtinIteratorCount(args ...runtime.Value) runtime.Value {
+	if len(args) < 1 {
+		return runtime.NewError("iterator_count() expects exactly 1 parameter")
+	}
+	count := int64(0)
+	ok := i.iterate(args[0], func(key, val runtime.Value) bool {
+		count++
+		return true
+	})
+	if !ok {
+		return runtime.NewError("iterator_count(): Argument #1 ($iterator) must be of type Traversable|array")
+	}
+	return runtime.NewInt(count)
+}
+
+// builtinIteratorApply calls the callback for each element of the iterator,
+// stopping as soon as the callback returns a falsy value. Like PHP, the
+// callback receives only the given args, not the current element.
+func (i *Interpreter) builtinIteratorApply(args ...runtime.Value) runtime.Value {
+	if len(args) < 2 {
+		return runtime.NewError("iterator_apply() expects at least 2 parameters")
+	}
+	var callArgs []runtime.Value
+	if len(args) >= 3 {
+		if arr, ok := args[2].(*runtime.Array); ok {
+			for _, k := range arr.Keys {
+				callArgs = append(callArgs, arr.Elements[k])
+			}
+		}
+	}
+
+	count := int64(0)
+	ok := i.iterate(args[0], func(key, val runtime.Value) bool {
+		count++
+		return i.callCallback(args[1], callArgs).ToBool()
+	})
+	if !ok {
+		return runtime.NewError("iterator_apply(): Argument #1 ($iterator) must be of type Traversable")
+	}
+	return runtime.NewInt(count)
+}
@@ -0,0 +1,267 @@
+package interpreter
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"strings"
+
+	"github.com/alexisbouchez/phpgo/runtime"
+)
+
+// OPENSSL_ALGO_* signature digest constants
+const (
+	opensslAlgoSHA1   = 1
+	opensslAlgoMD5    = 2
+	opensslAlgoSHA224 = 6
+	opensslAlgoSHA256 = 7
+	opensslAlgoSHA384 = 8
+	opensslAlgoSHA512 = 9
+)
+
+// opensslKey is the handle behind an "OpenSSL key" resource. Private is
+// nil for public-only keys.
+type opensslKey struct {
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// opensslDigest maps an OPENSSL_ALGO_* constant or a digest name such as
+// "sha256" or "RSA-SHA256" to its hash
+func opensslDigest(algo runtime.Value) (crypto.Hash, bool) {
+	if _, ok := algo.(*runtime.String); !ok {
+		switch algo.ToInt() {
+		case opensslAlgoSHA1:
+			return crypto.SHA1, true
+		case opensslAlgoMD5:
+			return crypto.MD5, true
+		case opensslAlgoSHA224:
+			return crypto.SHA224, true
+		case opensslAlgoSHA256:
+			return crypto.SHA256, true
+		case opensslAlgoSHA384:
+			return crypto.SHA384, true
+		case opensslAlgoSHA512:
+			return crypto.SHA512, true
+		}
+		return 0, false
+	}
+
+	name := strings.ToLower(algo.ToString())
+	name = strings.TrimPrefix(name, "rsa-")
+	name = strings.ReplaceAll(name, "-", "")
+	switch name {
+	case "sha1":
+		return crypto.SHA1, true
+	case "md5":
+		return crypto.MD5, true
+	case "sha224":
+		return crypto.SHA224, true
+	case "sha256":
+		return crypto.SHA256, true
+	case "sha384":
+		return crypto.SHA384, true
+	case "sha512":
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+// opensslPEM returns the PEM text of a key argument, reading it from disk
+// for "file://" paths
+func opensslPEM(v runtime.Value) ([]byte, bool) {
+	text := v.ToString()
+	if path, ok := strings.CutPrefix(text, "file://"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, false
+		}
+		return data, true
+	}
+	return []byte(text), true
+}
+
+// parsePrivateKeyPEM decodes a PKCS#1, PKCS#8 or SEC 1 private key
+func parsePrivateKeyPEM(data []byte) (*opensslKey, bool) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, false
+		}
+
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, false
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, false
+		}
+		return &opensslKey{Private: signer, Public: signer.Public()}, true
+	}
+}
+
+// parsePublicKeyPEM decodes a PKIX or PKCS#1 public key, or the key of an
+// X.509 certificate. A private key is also accepted and its public half used.
+func parsePublicKeyPEM(data []byte) (*opensslKey, bool) {
+	if key, ok := parsePrivateKeyPEM(data); ok {
+		return &opensslKey{Public: key.Public}, true
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, false
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, false
+		}
+		return &opensslKey{Public: key}, true
+	}
+}
+
+// opensslKeyArg resolves a key argument, which may be a key resource or PEM
+func opensslKeyArg(v runtime.Value, private bool) (*opensslKey, bool) {
+	if res, ok := v.(*runtime.Resource); ok {
+		key, ok := res.Handle.(*opensslKey)
+		if !ok || (private && key.Private == nil) {
+			return nil, false
+		}
+		return key, true
+	}
+	data, ok := opensslPEM(v)
+	if !ok {
+		return nil, false
+	}
+	if private {
+		return parsePrivateKeyPEM(data)
+	}
+	return parsePublicKeyPEM(data)
+}
+
+func (i *Interpreter) newOpensslKeyResource(key *opensslKey) runtime.Value {
+	resID := i.nextResourceID
+	i.nextResourceID++
+	return runtime.NewResource("OpenSSL key", key, resID)
+}
+
+func (i *Interpreter) builtinOpensslPkeyGetPrivate(args ...runtime.Value) runtime.Value {
+	// openssl_pkey_get_private(string $private_key, ?string $passphrase = null) : resource|false
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	key, ok := opensslKeyArg(args[0], true)
+	if !ok {
+		return runtime.FALSE
+	}
+	return i.newOpensslKeyResource(key)
+}
+
+func (i *Interpreter) builtinOpensslPkeyGetPublic(args ...runtime.Value) runtime.Value {
+	// openssl_pkey_get_public(string $public_key) : resource|false
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	key, ok := opensslKeyArg(args[0], false)
+	if !ok {
+		return runtime.FALSE
+	}
+	return i.newOpensslKeyResource(&opensslKey{Public: key.Public})
+}
+
+func builtinOpensslSign(args ...runtime.Value) runtime.Value {
+	// openssl_sign(string $data, string &$signature, $private_key, string|int $algorithm = OPENSSL_ALGO_SHA1) : bool
+	if len(args) < 3 {
+		return runtime.FALSE
+	}
+	key, ok := opensslKeyArg(args[2], true)
+	if !ok {
+		return runtime.FALSE
+	}
+	digest := crypto.SHA1
+	if len(args) > 3 {
+		if digest, ok = opensslDigest(args[3]); !ok {
+			return runtime.FALSE
+		}
+	}
+
+	h := digest.New()
+	h.Write([]byte(args[0].ToString()))
+	signature, err := key.Private.Sign(rand.Reader, h.Sum(nil), digest)
+	if err != nil {
+		return runtime.FALSE
+	}
+	if ref, ok := args[1].(*runtime.Reference); ok {
+		ref.Set(runtime.NewString(string(signature)))
+	}
+	return runtime.TRUE
+}
+
+func builtinOpensslVerify(args ...runtime.Value) runtime.Value {
+	// openssl_verify(string $data, string $signature, $public_key, string|int $algorithm = OPENSSL_ALGO_SHA1) : int|false
+	if len(args) < 3 {
+		return runtime.FALSE
+	}
+	key, ok := opensslKeyArg(args[2], false)
+	if !ok {
+		return runtime.NewInt(-1)
+	}
+	digest := crypto.SHA1
+	if len(args) > 3 {
+		if digest, ok = opensslDigest(args[3]); !ok {
+			return runtime.FALSE
+		}
+	}
+
+	h := digest.New()
+	h.Write([]byte(args[0].ToString()))
+	hashed := h.Sum(nil)
+	signature := []byte(args[1].ToString())
+
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pub, digest, hashed, signature) != nil {
+			return runtime.NewInt(0)
+		}
+		return runtime.NewInt(1)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, hashed, signature) {
+			return runtime.NewInt(0)
+		}
+		return runtime.NewInt(1)
+	}
+	return runtime.NewInt(-1)
+}
@@ -733,6 +733,11 @@ func (i *Interpreter) callMethodOnObject(obj *runtime.Object, method *runtime.Me
 // callMethod calls a method by name on an object
 func (i *Interpreter) callMethod(obj *runtime.Object, methodName string, args []runtime.Value) runtime.Value {
 	method, foundClass := i.findMethod(obj.Class, methodName)
+	if method == nil || method.Body == nil {
+		if result, ok := i.callInheritedNativeMethod(obj, methodName, args); ok {
+			return result
+		}
+	}
 	if method == nil {
 		return runtime.NewError(fmt.Sprintf("Method %s::%s() does not exist", obj.Class.Name, methodName))
 	}
@@ -742,6 +747,7 @@ func (i *Interpreter) callMethod(obj *runtime.Object, methodName string, args []
 // callUserFunction calls a user-defined function with evaluated arguments
 func (i *Interpreter) callUserFunction(fn *runtime.Function, args []runtime.Value) runtime.Value {
 	env := runtime.NewEnclosedEnvironment(fn.Env)
+	defer i.leaveScope(env, i.enterScope())
 	oldEnv := i.env
 	oldFuncArgs := i.currentFuncArgs
 	i.env = env
@@ -0,0 +1,82 @@
+//go:build linux
+
+package interpreter
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// pollStreams waits until at least one of the files is ready for reading
+// or writing, or until timeout passes. A negative timeout waits forever.
+// It reports readiness per file, in the order given.
+func pollStreams(read, write []*os.File, timeout time.Duration) (readReady, writeReady []bool, err error) {
+	var readSet, writeSet syscall.FdSet
+	readFds, maxFd, err := addToFdSet(&readSet, read, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	writeFds, maxFd, err := addToFdSet(&writeSet, write, maxFd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		// select() overwrites its arguments, so work on copies
+		r, w := readSet, writeSet
+		var tv *syscall.Timeval
+		if timeout >= 0 {
+			remaining := time.Until(deadline)
+			if remaining < 0 {
+				remaining = 0
+			}
+			t := syscall.NsecToTimeval(remaining.Nanoseconds())
+			tv = &t
+		}
+		_, err = syscall.Select(maxFd+1, &r, &w, nil, tv)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return fdSetReady(&r, readFds), fdSetReady(&w, writeFds), nil
+	}
+}
+
+// addToFdSet adds the descriptors of files to set and returns them along
+// with the highest descriptor seen so far
+func addToFdSet(set *syscall.FdSet, files []*os.File, maxFd int) ([]int, int, error) {
+	fds := make([]int, len(files))
+	for idx, f := range files {
+		conn, err := f.SyscallConn()
+		if err != nil {
+			return nil, 0, err
+		}
+		conn.Control(func(fd uintptr) { fds[idx] = int(fd) })
+		if fds[idx] >= syscall.FD_SETSIZE {
+			return nil, 0, syscall.EINVAL
+		}
+		set.Bits[fds[idx]/fdSetBits] |= 1 << (fds[idx] % fdSetBits)
+		maxFd = max(maxFd, fds[idx])
+	}
+	return fds, maxFd, nil
+}
+
+func fdSetReady(set *syscall.FdSet, fds []int) []bool {
+	ready := make([]bool, len(fds))
+	for idx, fd := range fds {
+		ready[idx] = set.Bits[fd/fdSetBits]&(1<<(fd%fdSetBits)) != 0
+	}
+	return ready
+}
+
+// fdSetBits is the number of descriptors per FdSet word, which varies
+// by architecture
+const fdSetBits = int(unsafe.Sizeof(syscall.FdSet{}.Bits[0]) * 8)
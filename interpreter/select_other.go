@@ -0,0 +1,42 @@
+//go:build !linux
+
+package interpreter
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// errPollUnsupported is returned when a stream cannot be waited on here
+var errPollUnsupported = errors.New("stream_select: waiting on pipes and sockets is not supported on this platform")
+
+// pollStreams cannot wait on descriptors here. Regular files never block,
+// so they are reported ready straight away; any other stream is an error
+// rather than a false report of readiness that would make callers spin.
+func pollStreams(read, write []*os.File, timeout time.Duration) (readReady, writeReady []bool, err error) {
+	readReady, err = regularFilesReady(read)
+	if err != nil {
+		return nil, nil, err
+	}
+	writeReady, err = regularFilesReady(write)
+	if err != nil {
+		return nil, nil, err
+	}
+	return readReady, writeReady, nil
+}
+
+func regularFilesReady(files []*os.File) ([]bool, error) {
+	ready := make([]bool, len(files))
+	for idx, f := range files {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return nil, errPollUnsupported
+		}
+		ready[idx] = true
+	}
+	return ready, nil
+}
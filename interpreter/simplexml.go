@@ -0,0 +1,380 @@
+package interpreter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alexisbouchez/phpgo/runtime"
+)
+
+// parseXMLString parses an XML document into elem, which becomes its root
+// element. Text is kept where it appears: an element's Value holds the text
+// before its first child, and each child's Tail the text that follows it.
+func parseXMLString(xmlData string, elem *SimpleXMLElement) error {
+	decoder := xml.NewDecoder(strings.NewReader(xmlData))
+	var current *SimpleXMLElement
+	for {
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := elem
+			if current != nil {
+				node = &SimpleXMLElement{Parent: current}
+				current.Children = append(current.Children, node)
+			} else if elem.Name != "" {
+				return fmt.Errorf("extra content at the end of the document")
+			}
+			node.Name = xmlQualifiedName(t.Name)
+			node.Attributes = make(map[string]string)
+			for _, attr := range t.Attr {
+				name := xmlQualifiedName(attr.Name)
+				node.Attributes[name] = attr.Value
+				node.AttrNames = append(node.AttrNames, name)
+			}
+			current = node
+		case xml.EndElement:
+			if current == nil || current.Name != xmlQualifiedName(t.Name) {
+				return fmt.Errorf("opening and ending tag mismatch: %s", xmlQualifiedName(t.Name))
+			}
+			current = current.Parent
+		case xml.CharData:
+			if current == nil {
+				break
+			}
+			if n := len(current.Children); n > 0 {
+				current.Children[n-1].Tail += string(t)
+			} else {
+				current.Value += string(t)
+			}
+		}
+	}
+	if elem.Name == "" {
+		return fmt.Errorf("document is empty")
+	}
+	if current != nil {
+		return fmt.Errorf("premature end of data in tag %s", current.Name)
+	}
+	return nil
+}
+
+var xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+// writeXMLElement serializes an element and its descendants, keeping text
+// between child elements in place. The element's own Tail is left to the
+// caller.
+func writeXMLElement(buf *bytes.Buffer, elem *SimpleXMLElement) {
+	buf.WriteString("<" + elem.Name)
+	for _, name := range elem.AttrNames {
+		buf.WriteString(" " + name + "=\"" + xmlAttrEscaper.Replace(elem.Attributes[name]) + "\"")
+	}
+	if elem.Value == "" && len(elem.Children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	xml.EscapeText(buf, []byte(elem.Value))
+	for _, child := range elem.Children {
+		writeXMLElement(buf, child)
+		xml.EscapeText(buf, []byte(child.Tail))
+	}
+	buf.WriteString("</" + elem.Name + ">")
+}
+
+// text returns the element's own text, without that of its descendants
+func (e *SimpleXMLElement) text() string {
+	text := e.Value
+	for _, child := range e.Children {
+		text += child.Tail
+	}
+	return text
+}
+
+// xmlDocumentString serializes a whole document rooted at root
+func xmlDocumentString(root *SimpleXMLElement) string {
+	var buf bytes.Buffer
+	buf.WriteString("<?xml version=\"1.0\"?>\n")
+	writeXMLElement(&buf, root)
+	buf.WriteString("\n")
+	return buf.String()
+}
+
+// SimpleXML object kinds
+const (
+	sxElement    = iota // a single element
+	sxList              // the children of parent, optionally filtered by name
+	sxAttributes        // the attributes of node
+)
+
+// SimpleXMLObject is the PHP view of a parsed document. Fetching
+// $el->child yields a list of the children of that name, which behaves
+// like its first member for property access, attributes and string
+// conversion, and iterates over all of its members.
+type SimpleXMLObject struct {
+	kind   int
+	node   *SimpleXMLElement
+	parent *SimpleXMLElement
+	name   string
+
+	iterItems []*SimpleXMLElement
+	iterPos   int
+}
+
+func (s *SimpleXMLObject) Type() string { return "object" }
+func (s *SimpleXMLObject) ToBool() bool {
+	if s.kind == sxList && s.name != "" {
+		return len(s.items()) > 0
+	}
+	return s.target() != nil
+}
+func (s *SimpleXMLObject) ToInt() int64 {
+	n, _ := strconv.ParseInt(strings.TrimSpace(s.ToString()), 10, 64)
+	return n
+}
+func (s *SimpleXMLObject) ToFloat() float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(s.ToString()), 64)
+	return f
+}
+func (s *SimpleXMLObject) ToString() string {
+	if t := s.target(); t != nil && s.kind != sxAttributes {
+		return t.text()
+	}
+	return ""
+}
+func (s *SimpleXMLObject) Inspect() string {
+	return fmt.Sprintf("object(SimpleXMLElement)#%p", s)
+}
+
+// items returns the elements the object iterates over
+func (s *SimpleXMLObject) items() []*SimpleXMLElement {
+	switch s.kind {
+	case sxElement:
+		if s.node == nil {
+			return nil
+		}
+		return s.node.Children
+	case sxList:
+		if s.parent == nil {
+			return nil
+		}
+		if s.name == "" {
+			return s.parent.Children
+		}
+		var matched []*SimpleXMLElement
+		for _, child := range s.parent.Children {
+			if child.Name == s.name {
+				matched = append(matched, child)
+			}
+		}
+		return matched
+	}
+	return nil
+}
+
+// target returns the element that property access, attributes and string
+// conversion apply to
+func (s *SimpleXMLObject) target() *SimpleXMLElement {
+	switch s.kind {
+	case sxElement, sxAttributes:
+		return s.node
+	case sxList:
+		if s.name == "" {
+			return s.parent
+		}
+		if items := s.items(); len(items) > 0 {
+			return items[0]
+		}
+	}
+	return nil
+}
+
+func newSimpleXMLElementObject(elem *SimpleXMLElement) *SimpleXMLObject {
+	return &SimpleXMLObject{kind: sxElement, node: elem}
+}
+
+// count returns the number of elements (or attributes) the object holds
+func (s *SimpleXMLObject) count() int {
+	if s.kind == sxAttributes {
+		if s.node == nil {
+			return 0
+		}
+		return len(s.node.AttrNames)
+	}
+	return len(s.items())
+}
+
+func (s *SimpleXMLObject) rewind(i *Interpreter) {
+	s.iterPos = 0
+	if s.kind == sxAttributes {
+		return
+	}
+	s.iterItems = s.items()
+}
+
+func (s *SimpleXMLObject) valid(i *Interpreter) bool {
+	if s.kind == sxAttributes {
+		return s.node != nil && s.iterPos < len(s.node.AttrNames)
+	}
+	return s.iterPos < len(s.iterItems)
+}
+
+func (s *SimpleXMLObject) current(i *Interpreter) runtime.Value {
+	if !s.valid(i) {
+		return runtime.NULL
+	}
+	if s.kind == sxAttributes {
+		return runtime.NewString(s.node.Attributes[s.node.AttrNames[s.iterPos]])
+	}
+	return newSimpleXMLElementObject(s.iterItems[s.iterPos])
+}
+
+func (s *SimpleXMLObject) key(i *Interpreter) runtime.Value {
+	if !s.valid(i) {
+		return runtime.NULL
+	}
+	if s.kind == sxAttributes {
+		return runtime.NewString(s.node.AttrNames[s.iterPos])
+	}
+	return runtime.NewString(s.iterItems[s.iterPos].Name)
+}
+
+func (s *SimpleXMLObject) next(i *Interpreter) { s.iterPos++ }
+
+// getSimpleXMLProperty returns the children named prop, or for an
+// attribute list the attribute named prop
+func (i *Interpreter) getSimpleXMLProperty(s *SimpleXMLObject, prop string) runtime.Value {
+	t := s.target()
+	if s.kind == sxAttributes {
+		if t != nil {
+			if val, ok := t.Attributes[prop]; ok {
+				return runtime.NewString(val)
+			}
+		}
+		return runtime.NULL
+	}
+	return &SimpleXMLObject{kind: sxList, parent: t, name: prop}
+}
+
+// getSimpleXMLOffset returns the nth element of a list for an integer
+// offset, or the named attribute for a string offset
+func (i *Interpreter) getSimpleXMLOffset(s *SimpleXMLObject, offset runtime.Value) runtime.Value {
+	if _, ok := offset.(*runtime.Int); ok && s.kind != sxAttributes {
+		n := int(offset.ToInt())
+		if s.kind == sxElement {
+			if n == 0 && s.node != nil {
+				return s
+			}
+			return runtime.NULL
+		}
+		if items := s.items(); n >= 0 && n < len(items) {
+			return newSimpleXMLElementObject(items[n])
+		}
+		return runtime.NULL
+	}
+	if t := s.target(); t != nil {
+		if val, ok := t.Attributes[offset.ToString()]; ok {
+			return runtime.NewString(val)
+		}
+	}
+	return runtime.NULL
+}
+
+func (i *Interpreter) callSimpleXMLMethod(s *SimpleXMLObject, methodName string, args []runtime.Value) runtime.Value {
+	t := s.target()
+	switch strings.ToLower(methodName) {
+	case "getname":
+		if t == nil || s.kind == sxAttributes {
+			return runtime.NewString("")
+		}
+		return runtime.NewString(t.Name)
+	case "attributes":
+		return &SimpleXMLObject{kind: sxAttributes, node: t}
+	case "children":
+		return &SimpleXMLObject{kind: sxList, parent: t}
+	case "count":
+		return runtime.NewInt(int64(s.count()))
+	case "__tostring":
+		return runtime.NewString(s.ToString())
+	case "asxml", "savexml":
+		if t == nil {
+			return runtime.FALSE
+		}
+		if len(args) >= 1 {
+			if err := os.WriteFile(args[0].ToString(), []byte(xmlDocumentString(t)), 0644); err != nil {
+				return runtime.FALSE
+			}
+			return runtime.TRUE
+		}
+		if t.Parent == nil {
+			return runtime.NewString(xmlDocumentString(t))
+		}
+		var buf bytes.Buffer
+		writeXMLElement(&buf, t)
+		return runtime.NewString(buf.String())
+	case "addchild":
+		if t == nil || len(args) < 1 {
+			return runtime.NULL
+		}
+		child := &SimpleXMLElement{Name: args[0].ToString(), Attributes: make(map[string]string), Parent: t}
+		if len(args) >= 2 {
+			child.Value = args[1].ToString()
+		}
+		t.Children = append(t.Children, child)
+		return newSimpleXMLElementObject(child)
+	case "addattribute":
+		if t == nil || len(args) < 2 {
+			return runtime.NULL
+		}
+		name := args[0].ToString()
+		if _, exists := t.Attributes[name]; !exists {
+			t.AttrNames = append(t.AttrNames, name)
+		}
+		t.Attributes[name] = args[1].ToString()
+		return runtime.NULL
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: SimpleXMLElement::%s", methodName))
+}
+
+// loadSimpleXML parses a document for simplexml_load_*, warning and
+// returning false if it is malformed
+func (i *Interpreter) loadSimpleXML(funcName, data string) runtime.Value {
+	root := &SimpleXMLElement{}
+	if err := parseXMLString(data, root); err != nil {
+		i.writeOutput(fmt.Sprintf("PHP Warning: %s(): Entity: %s\n", funcName, err))
+		return runtime.FALSE
+	}
+	return newSimpleXMLElementObject(root)
+}
+
+func (i *Interpreter) builtinSimpleXMLLoadString(args ...runtime.Value) runtime.Value {
+	// simplexml_load_string(string $data, ?string $class_name, int $options, string $ns, bool $is_prefix) : SimpleXMLElement|false
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	return i.loadSimpleXML("simplexml_load_string", args[0].ToString())
+}
+
+func (i *Interpreter) builtinSimpleXMLLoadFile(args ...runtime.Value) runtime.Value {
+	// simplexml_load_file(string $filename, ?string $class_name, int $options, string $ns, bool $is_prefix) : SimpleXMLElement|false
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	filename := args[0].ToString()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		i.writeOutput(fmt.Sprintf("PHP Warning: simplexml_load_file(): I/O warning : failed to load external entity \"%s\"\n", filename))
+		return runtime.FALSE
+	}
+	return i.loadSimpleXML("simplexml_load_file", string(data))
+}
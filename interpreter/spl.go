@@ -2,6 +2,8 @@ package interpreter
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/alexisbouchez/phpgo/ast"
 	"github.com/alexisbouchez/phpgo/runtime"
@@ -11,7 +13,7 @@ import (
 func isSplDataStructure(name string) bool {
 	switch name {
 	case "SplFixedArray", "SplDoublyLinkedList", "SplStack", "SplQueue",
-		"SplHeap", "SplMinHeap", "SplMaxHeap", "SplPriorityQueue", "SplObjectStorage":
+		"SplHeap", "SplMinHeap", "SplMaxHeap", "SplPriorityQueue", "SplObjectStorage", "ArrayObject":
 		return true
 	}
 	return false
@@ -51,7 +53,7 @@ func (i *Interpreter) handleSplNew(className string, args []runtime.Value) runti
 			size = args[0].ToInt()
 		}
 		if size < 0 {
-			return runtime.NewError("array size cannot be less than zero")
+			return i.splException("ValueError", "SplFixedArray::__construct(): Argument #1 ($size) must be greater than or equal to 0")
 		}
 		return NewSplFixedArray(size)
 	case "SplDoublyLinkedList":
@@ -70,6 +72,19 @@ func (i *Interpreter) handleSplNew(className string, args []runtime.Value) runti
 		return NewSplPriorityQueue()
 	case "SplObjectStorage":
 		return NewSplObjectStorage()
+	case "ArrayObject":
+		arr := runtime.NewArray()
+		if len(args) > 0 {
+			switch v := args[0].(type) {
+			case *runtime.Array:
+				arr = v
+			case *runtime.Object:
+				for name, val := range v.Properties {
+					arr.Set(runtime.NewString(name), val)
+				}
+			}
+		}
+		return NewArrayObject(arr)
 	}
 	return runtime.NewError(fmt.Sprintf("unknown SPL class: %s", className))
 }
@@ -88,6 +103,27 @@ func NewSplFixedArray(size int64) *SplFixedArrayObject {
 	return &SplFixedArrayObject{elements: elements, size: size}
 }
 
+// index converts an offset to a slot, reporting false when it is out of
+// range or not an integer
+func (s *SplFixedArrayObject) index(offset runtime.Value) (int64, bool) {
+	var idx int64
+	switch o := offset.(type) {
+	case *runtime.Int:
+		idx = o.Value
+	case *runtime.Float, *runtime.Bool:
+		idx = o.ToInt()
+	case *runtime.String:
+		n, err := strconv.ParseInt(o.Value, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		idx = n
+	default:
+		return 0, false
+	}
+	return idx, idx >= 0 && idx < s.size && idx < int64(len(s.elements))
+}
+
 func (s *SplFixedArrayObject) Type() string     { return "object" }
 func (s *SplFixedArrayObject) ToBool() bool     { return true }
 func (s *SplFixedArrayObject) ToInt() int64     { return 1 }
@@ -151,18 +187,30 @@ func (s *SplQueueObject) Inspect() string {
 	return fmt.Sprintf("object(SplQueue)#%p (%d)", s, len(s.elements))
 }
 
+// splList returns the list behind an SplDoublyLinkedList, SplStack or SplQueue
+func splList(v runtime.Value) (*SplDoublyLinkedListObject, bool) {
+	switch o := v.(type) {
+	case *SplDoublyLinkedListObject:
+		return o, true
+	case *SplStackObject:
+		return o.SplDoublyLinkedListObject, true
+	case *SplQueueObject:
+		return o.SplDoublyLinkedListObject, true
+	}
+	return nil, false
+}
+
 // SplHeapObject represents an abstract SplHeap
 type SplHeapObject struct {
 	elements   []runtime.Value
-	position   int
 	isMaxHeap  bool
 	interpreter *Interpreter
+	owner      *runtime.Object // user subclass instance whose compare() orders the heap
 }
 
 func NewSplMinHeap() *SplHeapObject {
 	return &SplHeapObject{
 		elements:  make([]runtime.Value, 0),
-		position:  0,
 		isMaxHeap: false,
 	}
 }
@@ -170,7 +218,6 @@ func NewSplMinHeap() *SplHeapObject {
 func NewSplMaxHeap() *SplHeapObject {
 	return &SplHeapObject{
 		elements:  make([]runtime.Value, 0),
-		position:  0,
 		isMaxHeap: true,
 	}
 }
@@ -209,6 +256,37 @@ func (s *SplHeapObject) extract() runtime.Value {
 	return result
 }
 
+// Iterating a heap is destructive, as in PHP: current() is the top, key()
+// is the number of elements left minus one and next() extracts the top.
+func (s *SplHeapObject) rewind(i *Interpreter)      {}
+func (s *SplHeapObject) valid(i *Interpreter) bool { return len(s.elements) > 0 }
+func (s *SplHeapObject) key(i *Interpreter) runtime.Value {
+	return runtime.NewInt(int64(len(s.elements) - 1))
+}
+
+func (s *SplHeapObject) current(i *Interpreter) runtime.Value {
+	if len(s.elements) == 0 {
+		return runtime.NULL
+	}
+	return s.elements[0]
+}
+
+func (s *SplHeapObject) next(i *Interpreter) {
+	if len(s.elements) > 0 {
+		s.extract()
+	}
+}
+
+// clone copies the heap for owner, the clone of the object it backs
+func (s *SplHeapObject) clone(owner *runtime.Object) *SplHeapObject {
+	return &SplHeapObject{
+		elements:    append([]runtime.Value(nil), s.elements...),
+		isMaxHeap:   s.isMaxHeap,
+		interpreter: s.interpreter,
+		owner:       owner,
+	}
+}
+
 func (s *SplHeapObject) top() runtime.Value {
 	if len(s.elements) == 0 {
 		return runtime.NewError("Can't peek at an empty heap")
@@ -216,8 +294,40 @@ func (s *SplHeapObject) top() runtime.Value {
 	return s.elements[0]
 }
 
+// compare follows SplHeap::compare: it is positive when a belongs nearer
+// the top of the heap than b. A user subclass's compare() takes precedence.
 func (s *SplHeapObject) compare(a, b runtime.Value) int {
-	// Default comparison for numeric values
+	if s.owner != nil {
+		// Only a compare() with a body is the user's; SplMinHeap and
+		// SplMaxHeap declare theirs natively
+		if method, _ := s.interpreter.findMethod(s.owner.Class, "compare"); method != nil && method.Body != nil {
+			return int(s.interpreter.callArrayAccessMethod(s.owner, "compare", []runtime.Value{a, b}).ToInt())
+		}
+	}
+	return s.nativeCompare(a, b)
+}
+
+// nativeCompare is SplMinHeap::compare or SplMaxHeap::compare
+func (s *SplHeapObject) nativeCompare(a, b runtime.Value) int {
+	cmp := compareHeapValues(a, b)
+	if !s.isMaxHeap {
+		return -cmp
+	}
+	return cmp
+}
+
+// compareHeapValues orders numbers numerically and non-numeric strings
+// lexically
+func compareHeapValues(a, b runtime.Value) int {
+	aStr, aIsStr := a.(*runtime.String)
+	bStr, bIsStr := b.(*runtime.String)
+	if aIsStr && bIsStr {
+		_, aErr := strconv.ParseFloat(aStr.Value, 64)
+		_, bErr := strconv.ParseFloat(bStr.Value, 64)
+		if aErr != nil || bErr != nil {
+			return strings.Compare(aStr.Value, bStr.Value)
+		}
+	}
 	aNum := a.ToFloat()
 	bNum := b.ToFloat()
 	if aNum < bNum {
@@ -231,11 +341,7 @@ func (s *SplHeapObject) compare(a, b runtime.Value) int {
 func (s *SplHeapObject) heapifyUp(index int) {
 	for index > 0 {
 		parent := (index - 1) / 2
-		cmp := s.compare(s.elements[index], s.elements[parent])
-		// For max heap: child > parent means swap
-		// For min heap: child < parent means swap
-		shouldSwap := (s.isMaxHeap && cmp > 0) || (!s.isMaxHeap && cmp < 0)
-		if !shouldSwap {
+		if s.compare(s.elements[index], s.elements[parent]) <= 0 {
 			break
 		}
 		s.elements[index], s.elements[parent] = s.elements[parent], s.elements[index]
@@ -249,17 +355,11 @@ func (s *SplHeapObject) heapifyDown(index int) {
 		left := 2*index + 1
 		right := 2*index + 2
 
-		if left < len(s.elements) {
-			cmp := s.compare(s.elements[left], s.elements[best])
-			if (s.isMaxHeap && cmp > 0) || (!s.isMaxHeap && cmp < 0) {
-				best = left
-			}
+		if left < len(s.elements) && s.compare(s.elements[left], s.elements[best]) > 0 {
+			best = left
 		}
-		if right < len(s.elements) {
-			cmp := s.compare(s.elements[right], s.elements[best])
-			if (s.isMaxHeap && cmp > 0) || (!s.isMaxHeap && cmp < 0) {
-				best = right
-			}
+		if right < len(s.elements) && s.compare(s.elements[right], s.elements[best]) > 0 {
+			best = right
 		}
 		if best == index {
 			break
@@ -274,11 +374,13 @@ type SplPriorityQueueObject struct {
 	elements   []priorityQueueElement
 	position   int
 	extractFlag int64 // EXTR_DATA=1, EXTR_PRIORITY=2, EXTR_BOTH=3
+	serial     int64 // insertion counter used to break priority ties
 }
 
 type priorityQueueElement struct {
 	data     runtime.Value
 	priority runtime.Value
+	serial   int64
 }
 
 func NewSplPriorityQueue() *SplPriorityQueueObject {
@@ -299,7 +401,8 @@ func (s *SplPriorityQueueObject) Inspect() string {
 }
 
 func (s *SplPriorityQueueObject) insert(data, priority runtime.Value) {
-	s.elements = append(s.elements, priorityQueueElement{data: data, priority: priority})
+	s.serial++
+	s.elements = append(s.elements, priorityQueueElement{data: data, priority: priority, serial: s.serial})
 	s.heapifyUp(len(s.elements) - 1)
 }
 
@@ -314,40 +417,34 @@ func (s *SplPriorityQueueObject) extract() runtime.Value {
 	if len(s.elements) > 0 {
 		s.heapifyDown(0)
 	}
-
-	switch s.extractFlag {
-	case 1: // EXTR_DATA
-		return result.data
-	case 2: // EXTR_PRIORITY
-		return result.priority
-	case 3: // EXTR_BOTH
-		arr := runtime.NewArray()
-		arr.Set(runtime.NewString("data"), result.data)
-		arr.Set(runtime.NewString("priority"), result.priority)
-		return arr
-	}
-	return result.data
+	return s.format(result)
 }
 
 func (s *SplPriorityQueueObject) top() runtime.Value {
 	if len(s.elements) == 0 {
 		return runtime.NewError("Can't peek at an empty heap")
 	}
-	result := s.elements[0]
+	return s.format(s.elements[0])
+}
+
+// format shapes an element according to the extract flags
+func (s *SplPriorityQueueObject) format(e priorityQueueElement) runtime.Value {
 	switch s.extractFlag {
-	case 1:
-		return result.data
-	case 2:
-		return result.priority
-	case 3:
+	case 1: // EXTR_DATA
+		return e.data
+	case 2: // EXTR_PRIORITY
+		return e.priority
+	case 3: // EXTR_BOTH
 		arr := runtime.NewArray()
-		arr.Set(runtime.NewString("data"), result.data)
-		arr.Set(runtime.NewString("priority"), result.priority)
+		arr.Set(runtime.NewString("data"), e.data)
+		arr.Set(runtime.NewString("priority"), e.priority)
 		return arr
 	}
-	return result.data
+	return e.data
 }
 
+// compare orders elements by priority; among equal priorities the earlier
+// insertion ranks higher so ties come out in insertion order
 func (s *SplPriorityQueueObject) compare(a, b priorityQueueElement) int {
 	aNum := a.priority.ToFloat()
 	bNum := b.priority.ToFloat()
@@ -356,6 +453,11 @@ func (s *SplPriorityQueueObject) compare(a, b priorityQueueElement) int {
 	} else if aNum > bNum {
 		return 1
 	}
+	if a.serial < b.serial {
+		return 1
+	} else if a.serial > b.serial {
+		return -1
+	}
 	return 0
 }
 
@@ -419,6 +521,24 @@ func (s *SplObjectStorageObject) Inspect() string {
 }
 
 // evalForeachSplFixedArray handles foreach for SplFixedArray
+// ArrayObjectObject represents a native ArrayObject
+type ArrayObjectObject struct {
+	array *runtime.Array
+}
+
+func NewArrayObject(arr *runtime.Array) *ArrayObjectObject {
+	return &ArrayObjectObject{array: arr}
+}
+
+func (a *ArrayObjectObject) Type() string     { return "object" }
+func (a *ArrayObjectObject) ToBool() bool     { return true }
+func (a *ArrayObjectObject) ToInt() int64     { return 1 }
+func (a *ArrayObjectObject) ToFloat() float64 { return 1.0 }
+func (a *ArrayObjectObject) ToString() string { return "ArrayObject" }
+func (a *ArrayObjectObject) Inspect() string {
+	return fmt.Sprintf("object(ArrayObject)#%p (%d)", a, a.array.Len())
+}
+
 func (i *Interpreter) evalForeachSplFixedArray(s *ast.ForeachStmt, spl *SplFixedArrayObject) runtime.Value {
 	for idx := int64(0); idx < spl.size; idx++ {
 		// Set key variable if present
@@ -444,7 +564,44 @@ func (i *Interpreter) evalForeachSplFixedArray(s *ast.ForeachStmt, spl *SplFixed
 				continue
 			}
 			return &runtime.Continue{Levels: r.Levels - 1}
-		case *runtime.ReturnValue:
+		case *runtime.ReturnValue, *runtime.Exit:
+			return result
+		}
+	}
+	return runtime.NULL
+}
+
+// evalForeachSplObjectStorage handles foreach for SplObjectStorage, yielding
+// the attached objects. The cursor follows the loop so getInfo() and
+// setInfo() inside the body refer to the current object.
+func (i *Interpreter) evalForeachSplObjectStorage(s *ast.ForeachStmt, spl *SplObjectStorageObject) runtime.Value {
+	keys := append([]string(nil), spl.keys...)
+	for idx, hash := range keys {
+		obj, ok := spl.objects[hash]
+		if !ok {
+			continue // detached during iteration
+		}
+		spl.position = idx
+
+		if s.KeyVar != nil {
+			i.assignTo(s.KeyVar, runtime.NewInt(int64(idx)))
+		}
+		// assignTo also covers list()/[...] destructuring targets
+		i.assignTo(s.ValueVar, obj)
+
+		result := i.evalStmt(s.Body)
+		switch r := result.(type) {
+		case *runtime.Break:
+			if r.Levels <= 1 {
+				return runtime.NULL
+			}
+			return &runtime.Break{Levels: r.Levels - 1}
+		case *runtime.Continue:
+			if r.Levels <= 1 {
+				continue
+			}
+			return &runtime.Continue{Levels: r.Levels - 1}
+		case *runtime.ReturnValue, *runtime.Exit:
 			return result
 		}
 	}
@@ -478,7 +635,7 @@ func (i *Interpreter) evalForeachSplDoublyLinkedList(s *ast.ForeachStmt, spl *Sp
 					continue
 				}
 				return &runtime.Continue{Levels: r.Levels - 1}
-			case *runtime.ReturnValue:
+			case *runtime.ReturnValue, *runtime.Exit:
 				return result
 			}
 		}
@@ -504,7 +661,7 @@ func (i *Interpreter) evalForeachSplDoublyLinkedList(s *ast.ForeachStmt, spl *Sp
 					continue
 				}
 				return &runtime.Continue{Levels: r.Levels - 1}
-			case *runtime.ReturnValue:
+			case *runtime.ReturnValue, *runtime.Exit:
 				return result
 			}
 		}
@@ -529,6 +686,8 @@ func (i *Interpreter) callSplMethod(obj runtime.Value, methodName string, args [
 		return i.callSplPriorityQueueMethod(o, methodName, args)
 	case *SplObjectStorageObject:
 		return i.callSplObjectStorageMethod(o, methodName, args)
+	case *ArrayObjectObject:
+		return i.callArrayObjectMethod(o, methodName, args)
 	}
 	return runtime.NewError("unknown SPL object type")
 }
@@ -543,7 +702,7 @@ func (i *Interpreter) callSplFixedArrayMethod(s *SplFixedArrayObject, methodName
 		}
 		newSize := args[0].ToInt()
 		if newSize < 0 {
-			return runtime.NewError("array size cannot be less than zero")
+			return i.splException("ValueError", "SplFixedArray::setSize(): Argument #1 ($size) must be greater than or equal to 0")
 		}
 		newElements := make([]runtime.Value, newSize)
 		for i := range newElements {
@@ -566,24 +725,24 @@ func (i *Interpreter) callSplFixedArrayMethod(s *SplFixedArrayObject, methodName
 		if len(args) < 1 {
 			return runtime.FALSE
 		}
-		idx := args[0].ToInt()
-		return runtime.NewBool(idx >= 0 && idx < s.size)
+		idx, ok := s.index(args[0])
+		return runtime.NewBool(ok && s.elements[idx].Type() != "null")
 	case "offsetGet":
 		if len(args) < 1 {
 			return runtime.NULL
 		}
-		idx := args[0].ToInt()
-		if idx < 0 || idx >= s.size {
-			return runtime.NULL
+		idx, ok := s.index(args[0])
+		if !ok {
+			return i.splException("RuntimeException", "Index invalid or out of range")
 		}
 		return s.elements[idx]
 	case "offsetSet":
 		if len(args) < 2 {
 			return runtime.NULL
 		}
-		idx := args[0].ToInt()
-		if idx < 0 || idx >= s.size {
-			return runtime.NewError("index invalid or out of range")
+		idx, ok := s.index(args[0])
+		if !ok {
+			return i.splException("RuntimeException", "Index invalid or out of range")
 		}
 		s.elements[idx] = args[1]
 		return runtime.NULL
@@ -591,10 +750,11 @@ func (i *Interpreter) callSplFixedArrayMethod(s *SplFixedArrayObject, methodName
 		if len(args) < 1 {
 			return runtime.NULL
 		}
-		idx := args[0].ToInt()
-		if idx >= 0 && idx < s.size {
-			s.elements[idx] = runtime.NULL
+		idx, ok := s.index(args[0])
+		if !ok {
+			return i.splException("RuntimeException", "Index invalid or out of range")
 		}
+		s.elements[idx] = runtime.NULL
 		return runtime.NULL
 	case "rewind":
 		return runtime.NULL
@@ -620,14 +780,14 @@ func (i *Interpreter) callSplDoublyLinkedListMethod(s *SplDoublyLinkedListObject
 		return runtime.NULL
 	case "pop":
 		if len(s.elements) == 0 {
-			return runtime.NewError("Can't pop from an empty datastructure")
+			return i.splException("RuntimeException", "Can't pop from an empty datastructure")
 		}
 		val := s.elements[len(s.elements)-1]
 		s.elements = s.elements[:len(s.elements)-1]
 		return val
 	case "shift":
 		if len(s.elements) == 0 {
-			return runtime.NewError("Can't shift from an empty datastructure")
+			return i.splException("RuntimeException", "Can't shift from an empty datastructure")
 		}
 		val := s.elements[0]
 		s.elements = s.elements[1:]
@@ -640,12 +800,12 @@ func (i *Interpreter) callSplDoublyLinkedListMethod(s *SplDoublyLinkedListObject
 		return runtime.NULL
 	case "top":
 		if len(s.elements) == 0 {
-			return runtime.NewError("Can't peek at an empty datastructure")
+			return i.splException("RuntimeException", "Can't peek at an empty datastructure")
 		}
 		return s.elements[len(s.elements)-1]
 	case "bottom":
 		if len(s.elements) == 0 {
-			return runtime.NewError("Can't peek at an empty datastructure")
+			return i.splException("RuntimeException", "Can't peek at an empty datastructure")
 		}
 		return s.elements[0]
 	case "count":
@@ -701,6 +861,11 @@ func (i *Interpreter) callSplDoublyLinkedListMethod(s *SplDoublyLinkedListObject
 		if len(args) < 2 {
 			return runtime.NULL
 		}
+		if _, ok := args[0].(*runtime.Null); ok {
+			// $list[] = $value appends
+			s.elements = append(s.elements, args[1])
+			return runtime.NULL
+		}
 		idx := args[0].ToInt()
 		if idx < 0 || idx >= int64(len(s.elements)) {
 			return runtime.NewError("index invalid or out of range")
@@ -734,17 +899,45 @@ func (i *Interpreter) callSplQueueMethod(s *SplQueueObject, methodName string, a
 		s.elements = append(s.elements, args[0])
 		return runtime.NULL
 	case "dequeue":
-		if len(s.elements) == 0 {
-			return runtime.NewError("Can't shift from an empty datastructure")
-		}
-		val := s.elements[0]
-		s.elements = s.elements[1:]
-		return val
+		// dequeue is an alias of shift
+		return i.callSplDoublyLinkedListMethod(s.SplDoublyLinkedListObject, "shift", args)
 	default:
 		return i.callSplDoublyLinkedListMethod(s.SplDoublyLinkedListObject, methodName, args)
 	}
 }
 
+// userSplHeap returns the heap backing an instance of a user class derived
+// from SplHeap, SplMinHeap or SplMaxHeap, creating it on first use. It
+// returns nil for objects of any other class.
+func (i *Interpreter) userSplHeap(obj *runtime.Object) *SplHeapObject {
+	if heap, ok := obj.Internal.(*SplHeapObject); ok {
+		return heap
+	}
+	isMaxHeap := true
+	found := false
+	for class := obj.Class; class != nil; class = class.Parent {
+		if class.Name == "SplMinHeap" {
+			isMaxHeap = false
+		}
+		if class.Name == "SplHeap" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	heap := &SplHeapObject{
+		elements:    make([]runtime.Value, 0),
+		isMaxHeap:   isMaxHeap,
+		interpreter: i,
+		owner:       obj,
+	}
+	obj.Internal = heap
+	return heap
+}
+
 func (i *Interpreter) callSplHeapMethod(s *SplHeapObject, methodName string, args []runtime.Value) runtime.Value {
 	switch methodName {
 	case "insert":
@@ -754,32 +947,36 @@ func (i *Interpreter) callSplHeapMethod(s *SplHeapObject, methodName string, arg
 		s.insert(args[0])
 		return runtime.TRUE
 	case "extract":
+		if len(s.elements) == 0 {
+			return i.splException("RuntimeException", "Can't extract from an empty heap")
+		}
 		return s.extract()
 	case "top":
+		if len(s.elements) == 0 {
+			return i.splException("RuntimeException", "Can't peek at an empty heap")
+		}
 		return s.top()
 	case "count":
 		return runtime.NewInt(int64(len(s.elements)))
 	case "isEmpty":
 		return runtime.NewBool(len(s.elements) == 0)
+	case "compare":
+		if len(args) < 2 {
+			return runtime.NewError("SplHeap::compare() expects exactly 2 parameters")
+		}
+		return runtime.NewInt(int64(s.nativeCompare(args[0], args[1])))
 	case "rewind":
-		s.position = 0
+		s.rewind(i)
 		return runtime.NULL
 	case "current":
-		if s.position >= len(s.elements) {
-			return runtime.FALSE
-		}
-		// Create a copy to extract without modifying
-		if len(s.elements) == 0 {
-			return runtime.FALSE
-		}
-		return s.elements[0]
+		return s.current(i)
 	case "key":
-		return runtime.NewInt(int64(s.position))
+		return s.key(i)
 	case "next":
-		s.position++
+		s.next(i)
 		return runtime.NULL
 	case "valid":
-		return runtime.NewBool(s.position < len(s.elements) && len(s.elements) > 0)
+		return runtime.NewBool(s.valid(i))
 	case "isCorrupted":
 		return runtime.FALSE
 	case "recoverFromCorruption":
@@ -797,8 +994,14 @@ func (i *Interpreter) callSplPriorityQueueMethod(s *SplPriorityQueueObject, meth
 		s.insert(args[0], args[1])
 		return runtime.TRUE
 	case "extract":
+		if len(s.elements) == 0 {
+			return i.splException("RuntimeException", "Can't extract from an empty heap")
+		}
 		return s.extract()
 	case "top":
+		if len(s.elements) == 0 {
+			return i.splException("RuntimeException", "Can't peek at an empty heap")
+		}
 		return s.top()
 	case "count":
 		return runtime.NewInt(int64(len(s.elements)))
@@ -818,19 +1021,7 @@ func (i *Interpreter) callSplPriorityQueueMethod(s *SplPriorityQueueObject, meth
 		if len(s.elements) == 0 {
 			return runtime.FALSE
 		}
-		result := s.elements[0]
-		switch s.extractFlag {
-		case 1:
-			return result.data
-		case 2:
-			return result.priority
-		case 3:
-			arr := runtime.NewArray()
-			arr.Set(runtime.NewString("data"), result.data)
-			arr.Set(runtime.NewString("priority"), result.priority)
-			return arr
-		}
-		return result.data
+		return s.format(s.elements[0])
 	case "key":
 		return runtime.NewInt(int64(s.position))
 	case "next":
@@ -853,7 +1044,7 @@ func (i *Interpreter) callSplObjectStorageMethod(s *SplObjectStorageObject, meth
 			return runtime.NewError("SplObjectStorage::attach() expects at least 1 parameter")
 		}
 		obj := args[0]
-		hash := fmt.Sprintf("%p", obj)
+		hash := splObjectHash(obj)
 		if _, exists := s.objects[hash]; !exists {
 			s.keys = append(s.keys, hash)
 		}
@@ -868,7 +1059,7 @@ func (i *Interpreter) callSplObjectStorageMethod(s *SplObjectStorageObject, meth
 		if len(args) < 1 {
 			return runtime.NULL
 		}
-		hash := fmt.Sprintf("%p", args[0])
+		hash := splObjectHash(args[0])
 		delete(s.objects, hash)
 		delete(s.infos, hash)
 		for i, k := range s.keys {
@@ -882,7 +1073,7 @@ func (i *Interpreter) callSplObjectStorageMethod(s *SplObjectStorageObject, meth
 		if len(args) < 1 {
 			return runtime.FALSE
 		}
-		hash := fmt.Sprintf("%p", args[0])
+		hash := splObjectHash(args[0])
 		_, exists := s.objects[hash]
 		return runtime.NewBool(exists)
 	case "count":
@@ -923,19 +1114,19 @@ func (i *Interpreter) callSplObjectStorageMethod(s *SplObjectStorageObject, meth
 		if len(args) < 1 {
 			return runtime.NewError("SplObjectStorage::getHash() expects exactly 1 parameter")
 		}
-		return runtime.NewString(fmt.Sprintf("%p", args[0]))
+		return runtime.NewString(splObjectHash(args[0]))
 	case "offsetExists":
 		if len(args) < 1 {
 			return runtime.FALSE
 		}
-		hash := fmt.Sprintf("%p", args[0])
+		hash := splObjectHash(args[0])
 		_, exists := s.objects[hash]
 		return runtime.NewBool(exists)
 	case "offsetGet":
 		if len(args) < 1 {
 			return runtime.NULL
 		}
-		hash := fmt.Sprintf("%p", args[0])
+		hash := splObjectHash(args[0])
 		if info, ok := s.infos[hash]; ok {
 			return info
 		}
@@ -944,7 +1135,7 @@ func (i *Interpreter) callSplObjectStorageMethod(s *SplObjectStorageObject, meth
 		if len(args) < 2 {
 			return runtime.NULL
 		}
-		hash := fmt.Sprintf("%p", args[0])
+		hash := splObjectHash(args[0])
 		if _, exists := s.objects[hash]; !exists {
 			s.keys = append(s.keys, hash)
 			s.objects[hash] = args[0]
@@ -955,7 +1146,7 @@ func (i *Interpreter) callSplObjectStorageMethod(s *SplObjectStorageObject, meth
 		if len(args) < 1 {
 			return runtime.NULL
 		}
-		hash := fmt.Sprintf("%p", args[0])
+		hash := splObjectHash(args[0])
 		delete(s.objects, hash)
 		delete(s.infos, hash)
 		for i, k := range s.keys {
@@ -968,3 +1159,22 @@ func (i *Interpreter) callSplObjectStorageMethod(s *SplObjectStorageObject, meth
 	}
 	return runtime.NewError(fmt.Sprintf("undefined method: SplObjectStorage::%s", methodName))
 }
+
+func (i *Interpreter) callArrayObjectMethod(a *ArrayObjectObject, methodName string, args []runtime.Value) runtime.Value {
+	if result, ok := callArrayStorageMethod(a.array, methodName, args); ok {
+		return result
+	}
+	switch methodName {
+	case "getIterator":
+		return NewArrayIterator(a.array)
+	case "exchangeArray":
+		old, _ := callArrayStorageMethod(a.array, "getArrayCopy", nil)
+		if len(args) > 0 {
+			if arr, ok := arrayArg(args[0]); ok {
+				a.array = arr
+			}
+		}
+		return old
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: ArrayObject::%s", methodName))
+}
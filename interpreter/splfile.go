@@ -0,0 +1,820 @@
+package interpreter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexisbouchez/phpgo/runtime"
+)
+
+// SplFileObject flags
+const (
+	splFileDropNewLine = 1
+	splFileReadAhead   = 2
+	splFileSkipEmpty   = 4
+	splFileReadCSV     = 8
+)
+
+// registerSplFileClasses registers the SPL file handling classes
+func (i *Interpreter) registerSplFileClasses() {
+	recursiveIterator, _ := i.env.GetInterface("RecursiveIterator")
+	seekableIterator, _ := i.env.GetInterface("SeekableIterator")
+
+	splFileInfo := &runtime.Class{
+		Name:        "SplFileInfo",
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	i.env.DefineClass("SplFileInfo", splFileInfo)
+
+	splFileObject := &runtime.Class{
+		Name:        "SplFileObject",
+		Parent:      splFileInfo,
+		Interfaces:  []*runtime.Interface{recursiveIterator, seekableIterator},
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	splFileObject.Constants["DROP_NEW_LINE"] = runtime.NewInt(splFileDropNewLine)
+	splFileObject.Constants["READ_AHEAD"] = runtime.NewInt(splFileReadAhead)
+	splFileObject.Constants["SKIP_EMPTY"] = runtime.NewInt(splFileSkipEmpty)
+	splFileObject.Constants["READ_CSV"] = runtime.NewInt(splFileReadCSV)
+	i.env.DefineClass("SplFileObject", splFileObject)
+}
+
+// splException builds an exception of the named SPL class to be returned
+// from native SPL code
+func (i *Interpreter) splException(className, msg string) *runtime.Exception {
+	class, _ := i.env.GetClass(className)
+	return &runtime.Exception{Class: class, Message: msg}
+}
+
+// splRuntimeException builds a RuntimeException to be returned from native
+// SPL code
+func (i *Interpreter) splRuntimeException(msg string) *runtime.Exception {
+	return i.splException("RuntimeException", msg)
+}
+
+// ----------------------------------------------------------------------------
+// SplFileInfo
+
+// SplFileInfoObject represents an SplFileInfo; metadata is read from the
+// filesystem on each call, as PHP does
+type SplFileInfoObject struct {
+	path string
+}
+
+func NewSplFileInfo(path string) *SplFileInfoObject {
+	return &SplFileInfoObject{path: path}
+}
+
+func (f *SplFileInfoObject) Type() string     { return "object" }
+func (f *SplFileInfoObject) ToBool() bool     { return true }
+func (f *SplFileInfoObject) ToInt() int64     { return 1 }
+func (f *SplFileInfoObject) ToFloat() float64 { return 1.0 }
+func (f *SplFileInfoObject) ToString() string { return f.path }
+func (f *SplFileInfoObject) Inspect() string {
+	return fmt.Sprintf("object(SplFileInfo)#%p", f)
+}
+
+// callSplFileInfoMethod handles SplFileInfo methods for path, shared by
+// SplFileInfo and SplFileObject. It reports false for unknown methods.
+func (i *Interpreter) callSplFileInfoMethod(path string, methodName string, args []runtime.Value) (runtime.Value, bool) {
+	stat := func(name string) (os.FileInfo, runtime.Value) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, i.splRuntimeException(fmt.Sprintf("SplFileInfo::%s(): stat failed for %s", name, path))
+		}
+		return info, nil
+	}
+
+	switch strings.ToLower(methodName) {
+	case "getfilename":
+		trimmed := strings.TrimRight(path, "/")
+		if idx := strings.LastIndex(trimmed, "/"); idx >= 0 {
+			return runtime.NewString(trimmed[idx+1:]), true
+		}
+		return runtime.NewString(trimmed), true
+
+	case "getpathname", "__tostring":
+		return runtime.NewString(path), true
+
+	case "getpath":
+		trimmed := strings.TrimRight(path, "/")
+		if idx := strings.LastIndex(trimmed, "/"); idx >= 0 {
+			return runtime.NewString(trimmed[:idx]), true
+		}
+		return runtime.NewString(""), true
+
+	case "getbasename":
+		return builtinBasename(append([]runtime.Value{runtime.NewString(path)}, args...)...), true
+
+	case "getextension":
+		name := filepath.Base(path)
+		if idx := strings.LastIndex(name, "."); idx >= 0 {
+			return runtime.NewString(name[idx+1:]), true
+		}
+		return runtime.NewString(""), true
+
+	case "getrealpath":
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return runtime.FALSE, true
+		}
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return runtime.FALSE, true
+		}
+		return runtime.NewString(resolved), true
+
+	case "getsize", "getmtime", "getatime", "getctime", "getperms", "getinode", "getowner", "getgroup":
+		info, exc := stat(methodName)
+		if exc != nil {
+			return exc, true
+		}
+		st := newFileStat(info)
+		switch strings.ToLower(methodName) {
+		case "getsize":
+			return runtime.NewInt(st.Size), true
+		case "getmtime":
+			return runtime.NewInt(st.Mtime), true
+		case "getatime":
+			return runtime.NewInt(st.Atime), true
+		case "getctime":
+			return runtime.NewInt(st.Ctime), true
+		case "getperms":
+			return runtime.NewInt(st.Mode), true
+		case "getinode":
+			return runtime.NewInt(st.Ino), true
+		case "getowner":
+			return runtime.NewInt(st.UID), true
+		default:
+			return runtime.NewInt(st.GID), true
+		}
+
+	case "gettype":
+		info, err := os.Lstat(path)
+		if err != nil {
+			return i.splRuntimeException(fmt.Sprintf("SplFileInfo::getType(): Lstat failed for %s", path)), true
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			return runtime.NewString("link"), true
+		case info.IsDir():
+			return runtime.NewString("dir"), true
+		}
+		return runtime.NewString("file"), true
+
+	case "isdir":
+		info, err := os.Stat(path)
+		return runtime.NewBool(err == nil && info.IsDir()), true
+
+	case "isfile":
+		info, err := os.Stat(path)
+		return runtime.NewBool(err == nil && info.Mode().IsRegular()), true
+
+	case "islink":
+		info, err := os.Lstat(path)
+		return runtime.NewBool(err == nil && info.Mode()&os.ModeSymlink != 0), true
+
+	case "isreadable":
+		return builtinIsReadable(runtime.NewString(path)), true
+
+	case "iswritable":
+		return builtinIsWritable(runtime.NewString(path)), true
+
+	case "isexecutable":
+		info, err := os.Stat(path)
+		return runtime.NewBool(err == nil && !info.IsDir() && info.Mode().Perm()&0111 != 0), true
+
+	case "getfileinfo":
+		return NewSplFileInfo(path), true
+
+	case "getpathinfo":
+		dir := filepath.Dir(strings.TrimRight(path, "/"))
+		return NewSplFileInfo(dir), true
+
+	case "openfile":
+		mode := "r"
+		if len(args) >= 1 {
+			mode = args[0].ToString()
+		}
+		return i.newSplFileObject(path, mode), true
+	}
+
+	return nil, false
+}
+
+// ----------------------------------------------------------------------------
+// SplFileObject
+
+// SplFileObjectObject represents an SplFileObject: a file iterated line by
+// line. The current line is read lazily and cached until next() is called.
+type SplFileObjectObject struct {
+	path    string
+	file    *os.File
+	reader  *bufio.Reader
+	flags   int64
+	line    runtime.Value // current line, nil until read
+	lineNum int
+	eof     bool
+
+	csvDelimiter string
+	csvEnclosure string
+	csvEscape    string
+}
+
+func (f *SplFileObjectObject) Type() string     { return "object" }
+func (f *SplFileObjectObject) ToBool() bool     { return true }
+func (f *SplFileObjectObject) ToInt() int64     { return 1 }
+func (f *SplFileObjectObject) ToFloat() float64 { return 1.0 }
+func (f *SplFileObjectObject) ToString() string { return "SplFileObject" }
+func (f *SplFileObjectObject) Inspect() string {
+	return fmt.Sprintf("object(SplFileObject)#%p", f)
+}
+
+// newSplFileObject opens path with an fopen-style mode
+func (i *Interpreter) newSplFileObject(path, mode string) runtime.Value {
+	var flag int
+	switch strings.TrimSuffix(strings.TrimSuffix(mode, "b"), "t") {
+	case "r":
+		flag = os.O_RDONLY
+	case "r+":
+		flag = os.O_RDWR
+	case "w":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case "w+":
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	case "a":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	case "a+":
+		flag = os.O_RDWR | os.O_CREATE | os.O_APPEND
+	case "x":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	case "x+":
+		flag = os.O_RDWR | os.O_CREATE | os.O_EXCL
+	default:
+		return i.splRuntimeException(fmt.Sprintf("SplFileObject::__construct(%s): Failed to open stream: invalid mode %s", path, mode))
+	}
+
+	file, err := os.OpenFile(path, flag, 0666)
+	if err != nil {
+		return i.splRuntimeException(fmt.Sprintf("SplFileObject::__construct(%s): Failed to open stream: No such file or directory", path))
+	}
+
+	i.openFiles = append(i.openFiles, file)
+	return &SplFileObjectObject{
+		path:         path,
+		file:         file,
+		reader:       bufio.NewReader(file),
+		csvDelimiter: ",",
+		csvEnclosure: "\"",
+		csvEscape:    "\\",
+	}
+}
+
+// readRaw reads the next line including its line ending
+func (f *SplFileObjectObject) readRaw() string {
+	line, err := f.reader.ReadString('\n')
+	if err != nil {
+		f.eof = true
+	}
+	return line
+}
+
+// readCurrent reads the line at the pointer, applying the object's flags. The
+// line stays nil when the file is exhausted and empty lines are skipped.
+func (f *SplFileObjectObject) readCurrent() {
+	for {
+		if f.eof && f.flags&splFileReadAhead != 0 {
+			return
+		}
+		raw := f.readRaw()
+		line := raw
+		if f.flags&splFileDropNewLine != 0 {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		}
+
+		if f.flags&splFileSkipEmpty != 0 && strings.TrimRight(line, "\r\n") == "" {
+			if f.eof {
+				return
+			}
+			f.lineNum++
+			continue
+		}
+
+		if f.flags&splFileReadCSV != 0 {
+			f.line = parseCSVLine(line, f.csvDelimiter, f.csvEnclosure, f.csvEscape)
+		} else {
+			f.line = runtime.NewString(line)
+		}
+		return
+	}
+}
+
+// parseCSVLine splits a line into fields the way PHP does: a field wrapped
+// in enclosure may hold the delimiter, a doubled enclosure stands for one,
+// and escape (when set) keeps the character after it from closing the
+// field. A blank line yields [null] as in PHP
+func parseCSVLine(line, delimiter, enclosure, escape string) runtime.Value {
+	result := runtime.NewArray()
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		result.Set(nil, runtime.NULL)
+		return result
+	}
+
+	delim, encl := delimiter[0], enclosure[0]
+	pos := 0
+	for {
+		var field strings.Builder
+
+		// Blanks before an opening enclosure are dropped
+		start := pos
+		for start < len(line) && (line[start] == ' ' || line[start] == '\t') && line[start] != delim {
+			start++
+		}
+		if start < len(line) && line[start] == encl {
+			pos = start + 1
+			for pos < len(line) {
+				c := line[pos]
+				if escape != "" && c == escape[0] && c != encl && pos+1 < len(line) {
+					field.WriteString(line[pos : pos+2])
+					pos += 2
+					continue
+				}
+				if c == encl {
+					pos++
+					if pos < len(line) && line[pos] == encl {
+						field.WriteByte(encl)
+						pos++
+						continue
+					}
+					break
+				}
+				field.WriteByte(c)
+				pos++
+			}
+		}
+		// Unquoted text, or text after the closing enclosure, runs to the
+		// next delimiter
+		for pos < len(line) && line[pos] != delim {
+			field.WriteByte(line[pos])
+			pos++
+		}
+		result.Set(nil, runtime.NewString(field.String()))
+
+		if pos >= len(line) {
+			return result
+		}
+		pos++ // skip the delimiter
+	}
+}
+
+func (f *SplFileObjectObject) rewind(i *Interpreter) {
+	f.file.Seek(0, io.SeekStart)
+	f.reader.Reset(f.file)
+	f.eof = false
+	f.line = nil
+	f.lineNum = 0
+	if f.flags&splFileReadAhead != 0 {
+		f.readCurrent()
+	}
+}
+
+func (f *SplFileObjectObject) valid(i *Interpreter) bool {
+	if f.flags&splFileReadAhead != 0 {
+		if f.line == nil {
+			f.readCurrent()
+		}
+		return f.line != nil
+	}
+	return f.line != nil || !f.eof
+}
+
+func (f *SplFileObjectObject) current(i *Interpreter) runtime.Value {
+	if f.line == nil {
+		f.readCurrent()
+	}
+	if f.line == nil {
+		return runtime.FALSE
+	}
+	return f.line
+}
+
+func (f *SplFileObjectObject) key(i *Interpreter) runtime.Value {
+	return runtime.NewInt(int64(f.lineNum))
+}
+
+func (f *SplFileObjectObject) next(i *Interpreter) {
+	if f.line == nil {
+		f.readCurrent()
+	}
+	f.line = nil
+	f.lineNum++
+	if f.flags&splFileReadAhead != 0 {
+		f.readCurrent()
+	}
+}
+
+// callSplFileObjectMethod handles the SplFileObject methods that aren't part
+// of the Iterator interface
+func (i *Interpreter) callSplFileObjectMethod(f *SplFileObjectObject, methodName string, args []runtime.Value) runtime.Value {
+	switch strings.ToLower(methodName) {
+	case "eof":
+		if f.eof {
+			return runtime.TRUE
+		}
+		_, err := f.reader.Peek(1)
+		return runtime.NewBool(err != nil)
+
+	case "fgets":
+		if f.eof {
+			return runtime.FALSE
+		}
+		line := f.readRaw()
+		f.line = nil
+		f.lineNum++
+		return runtime.NewString(line)
+
+	case "fgetcsv":
+		delimiter, enclosure, escape := f.csvDelimiter, f.csvEnclosure, f.csvEscape
+		if len(args) >= 1 && args[0].ToString() != "" {
+			delimiter = args[0].ToString()[:1]
+		}
+		if len(args) >= 2 && args[1].ToString() != "" {
+			enclosure = args[1].ToString()[:1]
+		}
+		if len(args) >= 3 {
+			// An empty escape turns escaping off
+			escape = args[2].ToString()
+			if escape != "" {
+				escape = escape[:1]
+			}
+		}
+		if f.eof {
+			return runtime.FALSE
+		}
+		line := f.readRaw()
+		f.line = nil
+		f.lineNum++
+		if line == "" && f.eof {
+			return runtime.FALSE
+		}
+		return parseCSVLine(line, delimiter, enclosure, escape)
+
+	case "fread":
+		if len(args) < 1 || args[0].ToInt() <= 0 {
+			return runtime.FALSE
+		}
+		buf := make([]byte, args[0].ToInt())
+		n, err := io.ReadFull(f.reader, buf)
+		if err != nil {
+			f.eof = true
+		}
+		return runtime.NewString(string(buf[:n]))
+
+	case "fwrite":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		data := args[0].ToString()
+		if len(args) >= 2 {
+			if length := int(args[1].ToInt()); length > 0 && length < len(data) {
+				data = data[:length]
+			}
+		}
+		n, err := f.file.WriteString(data)
+		if err != nil {
+			return runtime.FALSE
+		}
+		return runtime.NewInt(int64(n))
+
+	case "fputcsv":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		fields, ok := args[0].(*runtime.Array)
+		if !ok {
+			return runtime.FALSE
+		}
+		var sb strings.Builder
+		writer := csv.NewWriter(&sb)
+		writer.Comma = rune(f.csvDelimiter[0])
+		record := make([]string, 0, fields.Len())
+		for _, k := range fields.Keys {
+			record = append(record, fields.Elements[k].ToString())
+		}
+		writer.Write(record)
+		writer.Flush()
+		n, err := f.file.WriteString(sb.String())
+		if err != nil {
+			return runtime.FALSE
+		}
+		return runtime.NewInt(int64(n))
+
+	case "fflush":
+		return runtime.NewBool(f.file.Sync() == nil)
+
+	case "ftell":
+		pos, err := f.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return runtime.FALSE
+		}
+		return runtime.NewInt(pos - int64(f.reader.Buffered()))
+
+	case "seek":
+		if len(args) < 1 {
+			return runtime.NULL
+		}
+		target := int(args[0].ToInt())
+		if target < 0 {
+			return i.splRuntimeException("SplFileObject::seek(): Argument #1 ($line) must be greater than or equal to 0")
+		}
+		f.rewind(i)
+		for f.lineNum < target && f.valid(i) {
+			f.next(i)
+		}
+		return runtime.NULL
+
+	case "getcurrentline":
+		return i.callSplFileObjectMethod(f, "fgets", args)
+
+	case "setflags":
+		if len(args) >= 1 {
+			f.flags = args[0].ToInt()
+		}
+		return runtime.NULL
+
+	case "getflags":
+		return runtime.NewInt(f.flags)
+
+	case "setcsvcontrol":
+		if len(args) >= 1 && args[0].ToString() != "" {
+			f.csvDelimiter = args[0].ToString()[:1]
+		}
+		if len(args) >= 2 && args[1].ToString() != "" {
+			f.csvEnclosure = args[1].ToString()[:1]
+		}
+		if len(args) >= 3 && args[2].ToString() != "" {
+			f.csvEscape = args[2].ToString()[:1]
+		}
+		return runtime.NULL
+
+	case "getcsvcontrol":
+		result := runtime.NewArray()
+		result.Set(nil, runtime.NewString(f.csvDelimiter))
+		result.Set(nil, runtime.NewString(f.csvEnclosure))
+		result.Set(nil, runtime.NewString(f.csvEscape))
+		return result
+
+	case "haschildren":
+		return runtime.FALSE
+
+	case "getchildren":
+		return runtime.NULL
+
+	case "__tostring":
+		return i.callSplFileObjectMethod(f, "fgets", args)
+	}
+
+	if result, ok := i.callSplFileInfoMethod(f.path, methodName, args); ok {
+		return result
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: SplFileObject::%s", methodName))
+}
+
+// ----------------------------------------------------------------------------
+// DirectoryIterator and FilesystemIterator
+
+// FilesystemIterator flags
+const (
+	fsCurrentAsPathname = 32
+	fsCurrentAsSelf     = 16
+	fsKeyAsFilename     = 256
+	fsFollowSymlinks    = 16384
+	fsSkipDots          = 4096
+)
+
+// DirectoryIteratorObject backs DirectoryIterator, FilesystemIterator,
+// RecursiveDirectoryIterator and GlobIterator. A plain DirectoryIterator
+// returns itself from current(), positioned on the entry, so SplFileInfo
+// methods apply to the current entry; the filesystem variants honor their
+// flags instead. A GlobIterator's entries are the full matched paths.
+type DirectoryIteratorObject struct {
+	className string
+	path      string
+	subPath   string
+	flags     int64
+	entries   []string
+	position  int
+}
+
+// newDirectoryIterator lists path, with "." and ".." first (unless skipped)
+// and the remaining entries sorted by name
+func (i *Interpreter) newDirectoryIterator(className, path string, flags int64) runtime.Value {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return i.splException("UnexpectedValueException", fmt.Sprintf("%s::__construct(%s): Failed to open directory: No such file or directory", className, path))
+	}
+
+	var names []string
+	if className == "DirectoryIterator" || flags&fsSkipDots == 0 {
+		names = append(names, ".", "..")
+	}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return &DirectoryIteratorObject{
+		className: className,
+		path:      strings.TrimRight(path, "/"),
+		flags:     flags,
+		entries:   names,
+	}
+}
+
+// newGlobIterator iterates over the paths matching pattern, sorted by name;
+// filepath.Glob checks each directory entry against it with filepath.Match
+func (i *Interpreter) newGlobIterator(pattern string, flags int64) runtime.Value {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return i.splException("LogicException", fmt.Sprintf("GlobIterator::__construct(): %s", err))
+	}
+	return &DirectoryIteratorObject{
+		className: "GlobIterator",
+		path:      filepath.Dir(pattern),
+		flags:     flags,
+		entries:   matches,
+	}
+}
+
+func (d *DirectoryIteratorObject) Type() string     { return "object" }
+func (d *DirectoryIteratorObject) ToBool() bool     { return true }
+func (d *DirectoryIteratorObject) ToInt() int64     { return 1 }
+func (d *DirectoryIteratorObject) ToFloat() float64 { return 1.0 }
+func (d *DirectoryIteratorObject) ToString() string { return d.filename() }
+func (d *DirectoryIteratorObject) Inspect() string {
+	return fmt.Sprintf("object(%s)#%p", d.className, d)
+}
+
+// filename returns the name of the current entry, or "" past the end
+func (d *DirectoryIteratorObject) filename() string {
+	if d.position < 0 || d.position >= len(d.entries) {
+		return ""
+	}
+	if d.className == "GlobIterator" {
+		return filepath.Base(d.entries[d.position])
+	}
+	return d.entries[d.position]
+}
+
+// pathname returns the path of the current entry
+func (d *DirectoryIteratorObject) pathname() string {
+	if d.className == "GlobIterator" && d.position < len(d.entries) {
+		return d.entries[d.position]
+	}
+	return d.path + "/" + d.filename()
+}
+
+// dirname returns the directory holding the current entry
+func (d *DirectoryIteratorObject) dirname() string {
+	if d.className == "GlobIterator" && d.position < len(d.entries) {
+		return filepath.Dir(d.entries[d.position])
+	}
+	return d.path
+}
+
+// isDot reports whether the current entry is "." or ".."
+func (d *DirectoryIteratorObject) isDot() bool {
+	name := d.filename()
+	return name == "." || name == ".."
+}
+
+func (d *DirectoryIteratorObject) rewind(i *Interpreter)     { d.position = 0 }
+func (d *DirectoryIteratorObject) valid(i *Interpreter) bool { return d.position < len(d.entries) }
+func (d *DirectoryIteratorObject) next(i *Interpreter)       { d.position++ }
+
+func (d *DirectoryIteratorObject) current(i *Interpreter) runtime.Value {
+	if d.className == "DirectoryIterator" || d.flags&fsCurrentAsSelf != 0 {
+		return d
+	}
+	if d.flags&fsCurrentAsPathname != 0 {
+		return runtime.NewString(d.pathname())
+	}
+	return NewSplFileInfo(d.pathname())
+}
+
+func (d *DirectoryIteratorObject) key(i *Interpreter) runtime.Value {
+	if d.className == "DirectoryIterator" {
+		return runtime.NewInt(int64(d.position))
+	}
+	if d.flags&fsKeyAsFilename != 0 {
+		return runtime.NewString(d.filename())
+	}
+	return runtime.NewString(d.pathname())
+}
+
+// hasChildren reports whether the current entry is a directory that a
+// RecursiveDirectoryIterator descends into
+func (d *DirectoryIteratorObject) hasChildren(i *Interpreter) bool {
+	if d.className != "RecursiveDirectoryIterator" || !d.valid(i) || d.isDot() {
+		return false
+	}
+	info, err := os.Lstat(d.pathname())
+	if err != nil {
+		return false
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if d.flags&fsFollowSymlinks == 0 {
+			return false
+		}
+		if info, err = os.Stat(d.pathname()); err != nil {
+			return false
+		}
+	}
+	return info.IsDir()
+}
+
+// getChildren returns an iterator over the current directory entry
+func (d *DirectoryIteratorObject) getChildren(i *Interpreter) splIterator {
+	child := i.newDirectoryIterator(d.className, d.pathname(), d.flags)
+	it, ok := child.(*DirectoryIteratorObject)
+	if !ok {
+		return nil
+	}
+	if d.subPath != "" {
+		it.subPath = d.subPath + "/" + d.filename()
+	} else {
+		it.subPath = d.filename()
+	}
+	return it
+}
+
+func (i *Interpreter) callDirectoryIteratorMethod(d *DirectoryIteratorObject, methodName string, args []runtime.Value) runtime.Value {
+	switch strings.ToLower(methodName) {
+	case "isdot":
+		return runtime.NewBool(d.isDot())
+
+	case "getfilename", "__tostring":
+		return runtime.NewString(d.filename())
+
+	case "getpath":
+		return runtime.NewString(d.dirname())
+
+	case "count":
+		if d.className == "GlobIterator" {
+			return runtime.NewInt(int64(len(d.entries)))
+		}
+
+	case "getflags":
+		return runtime.NewInt(d.flags)
+
+	case "setflags":
+		if len(args) >= 1 {
+			d.flags = args[0].ToInt()
+		}
+		return runtime.NULL
+
+	case "haschildren":
+		return runtime.NewBool(d.hasChildren(i))
+
+	case "getchildren":
+		if child := d.getChildren(i); child != nil {
+			return child
+		}
+		return runtime.NULL
+
+	case "getsubpath":
+		return runtime.NewString(d.subPath)
+
+	case "getsubpathname":
+		if d.subPath != "" {
+			return runtime.NewString(d.subPath + "/" + d.filename())
+		}
+		return runtime.NewString(d.filename())
+
+	case "seek":
+		if len(args) < 1 {
+			return runtime.NULL
+		}
+		pos := int(args[0].ToInt())
+		if pos < 0 || pos >= len(d.entries) {
+			return i.splException("OutOfBoundsException", fmt.Sprintf("Seek position %d is out of range", pos))
+		}
+		d.position = pos
+		return runtime.NULL
+	}
+
+	if result, ok := i.callSplFileInfoMethod(d.pathname(), methodName, args); ok {
+		return result
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: %s::%s", d.className, methodName))
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package interpreter
+
+import (
+	"os"
+	"syscall"
+)
+
+// sysFileStat fills in the fields of st that only the platform stat call
+// provides.
+func sysFileStat(info os.FileInfo, st *fileStat) {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	st.Dev = int64(sys.Dev)
+	st.Ino = int64(sys.Ino)
+	st.Mode = int64(sys.Mode)
+	st.Nlink = int64(sys.Nlink)
+	st.UID = int64(sys.Uid)
+	st.GID = int64(sys.Gid)
+	st.Rdev = int64(sys.Rdev)
+	st.Atime = int64(sys.Atim.Sec)
+	st.Ctime = int64(sys.Ctim.Sec)
+	st.Blksize = int64(sys.Blksize)
+	st.Blocks = int64(sys.Blocks)
+}
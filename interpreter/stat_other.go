@@ -0,0 +1,9 @@
+//go:build !linux
+
+package interpreter
+
+import "os"
+
+// sysFileStat is a no-op where syscall stat fields aren't mapped; the
+// portable values from os.FileInfo are used instead.
+func sysFileStat(info os.FileInfo, st *fileStat) {}
@@ -0,0 +1,429 @@
+package interpreter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alexisbouchez/phpgo/runtime"
+)
+
+// XMLReader node types
+const (
+	xmlNone                  = 0
+	xmlElement               = 1
+	xmlAttribute             = 2
+	xmlText                  = 3
+	xmlCDATA                 = 4
+	xmlPI                    = 7
+	xmlComment               = 8
+	xmlDocType               = 10
+	xmlSignificantWhitespace = 14
+	xmlEndElement            = 15
+)
+
+// xmlReaderNode is one node of the document as XMLReader reports it
+type xmlReaderNode struct {
+	nodeType int
+	name     string
+	value    string
+	depth    int
+	attrs    []xml.Attr
+	isEmpty  bool
+}
+
+// XMLReader is a forward-only cursor over the nodes of an XML document.
+// The document is tokenized with encoding/xml when it is opened, and read()
+// steps through the resulting nodes.
+type XMLReader struct {
+	filename string
+	nodes    []xmlReaderNode
+	position int // index of the current node; -1 before the first read
+	closed   bool
+}
+
+// newXMLReader returns a reader with no document open
+func newXMLReader() *XMLReader {
+	return &XMLReader{position: -1}
+}
+
+func (r *XMLReader) Type() string     { return "object" }
+func (r *XMLReader) ToBool() bool     { return true }
+func (r *XMLReader) ToInt() int64     { return 1 }
+func (r *XMLReader) ToFloat() float64 { return 1.0 }
+func (r *XMLReader) ToString() string { return "XMLReader" }
+func (r *XMLReader) Inspect() string {
+	return fmt.Sprintf("object(XMLReader)#%p", r)
+}
+
+// registerXMLReader registers the XMLReader class so its constants resolve
+func (i *Interpreter) registerXMLReader() {
+	xmlReader := &runtime.Class{
+		Name:        "XMLReader",
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	xmlReader.Constants["NONE"] = runtime.NewInt(xmlNone)
+	xmlReader.Constants["ELEMENT"] = runtime.NewInt(xmlElement)
+	xmlReader.Constants["ATTRIBUTE"] = runtime.NewInt(xmlAttribute)
+	xmlReader.Constants["TEXT"] = runtime.NewInt(xmlText)
+	xmlReader.Constants["CDATA"] = runtime.NewInt(xmlCDATA)
+	xmlReader.Constants["PI"] = runtime.NewInt(xmlPI)
+	xmlReader.Constants["COMMENT"] = runtime.NewInt(xmlComment)
+	xmlReader.Constants["DOC_TYPE"] = runtime.NewInt(xmlDocType)
+	xmlReader.Constants["SIGNIFICANT_WHITESPACE"] = runtime.NewInt(xmlSignificantWhitespace)
+	xmlReader.Constants["END_ELEMENT"] = runtime.NewInt(xmlEndElement)
+	i.env.DefineClass("XMLReader", xmlReader)
+}
+
+// parseXMLReaderNodes tokenizes an XML document into the nodes XMLReader
+// visits. Like libxml, a self-closing element is a single empty element
+// node with no matching END_ELEMENT, and the XML declaration is not a node.
+func parseXMLReaderNodes(data []byte) ([]xmlReaderNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var nodes []xmlReaderNode
+	depth := 0
+	for {
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := xmlReaderNode{nodeType: xmlElement, name: xmlQualifiedName(t.Name), depth: depth, attrs: t.Attr}
+			end := int(decoder.InputOffset())
+			if end >= 2 && string(data[end-2:end]) == "/>" {
+				// Consume the synthesized end element
+				if _, err := decoder.RawToken(); err != nil {
+					return nil, err
+				}
+				node.isEmpty = true
+			} else {
+				depth++
+			}
+			nodes = append(nodes, node)
+		case xml.EndElement:
+			depth--
+			nodes = append(nodes, xmlReaderNode{nodeType: xmlEndElement, name: xmlQualifiedName(t.Name), depth: depth})
+		case xml.CharData:
+			if depth == 0 {
+				// Whitespace around the root element is not reported
+				continue
+			}
+			text := string(t)
+			end := int(decoder.InputOffset())
+			switch {
+			case bytes.HasSuffix(data[:end], []byte("]]>")):
+				nodes = append(nodes, xmlReaderNode{nodeType: xmlCDATA, name: "#cdata-section", value: text, depth: depth})
+			case strings.TrimSpace(text) == "":
+				nodes = append(nodes, xmlReaderNode{nodeType: xmlSignificantWhitespace, name: "#text", value: text, depth: depth})
+			default:
+				nodes = append(nodes, xmlReaderNode{nodeType: xmlText, name: "#text", value: text, depth: depth})
+			}
+		case xml.Comment:
+			nodes = append(nodes, xmlReaderNode{nodeType: xmlComment, name: "#comment", value: string(t), depth: depth})
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				continue
+			}
+			nodes = append(nodes, xmlReaderNode{nodeType: xmlPI, name: t.Target, value: string(t.Inst), depth: depth})
+		case xml.Directive:
+			if fields := strings.Fields(string(t)); len(fields) >= 2 && fields[0] == "DOCTYPE" {
+				nodes = append(nodes, xmlReaderNode{nodeType: xmlDocType, name: fields[1], depth: depth})
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// xmlQualifiedName returns the prefixed name of a raw token
+func xmlQualifiedName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// load parses a document into the reader, rewinding it to before the first node
+func (r *XMLReader) load(data []byte) error {
+	nodes, err := parseXMLReaderNodes(data)
+	if err != nil {
+		return err
+	}
+	r.nodes = nodes
+	r.position = -1
+	r.closed = false
+	return nil
+}
+
+// node returns the current node, or nil if there is none
+func (r *XMLReader) node() *xmlReaderNode {
+	if r.closed || r.position < 0 || r.position >= len(r.nodes) {
+		return nil
+	}
+	return &r.nodes[r.position]
+}
+
+// read advances to the next node
+func (r *XMLReader) read() bool {
+	if r.closed || r.position >= len(r.nodes) {
+		return false
+	}
+	r.position++
+	return r.position < len(r.nodes)
+}
+
+// next advances to the next node after the current element's subtree,
+// or to the next element named name if given
+func (r *XMLReader) next(name string) bool {
+	if n := r.node(); n != nil && n.nodeType == xmlElement && !n.isEmpty {
+		for r.read() {
+			if end := r.node(); end.nodeType == xmlEndElement && end.depth == n.depth {
+				break
+			}
+		}
+	}
+	for r.read() {
+		if name == "" || (r.node().nodeType == xmlElement && r.node().name == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// getAttribute returns the value of the named attribute of the current
+// element, or nil if it has none
+func (r *XMLReader) getAttribute(name string) runtime.Value {
+	if n := r.node(); n != nil {
+		for _, attr := range n.attrs {
+			if xmlQualifiedName(attr.Name) == name {
+				return runtime.NewString(attr.Value)
+			}
+		}
+	}
+	return runtime.NULL
+}
+
+// readString returns the text content of the current node, including that
+// of all descendants of an element
+func (r *XMLReader) readString() string {
+	n := r.node()
+	if n == nil {
+		return ""
+	}
+	if n.nodeType != xmlElement {
+		return n.value
+	}
+	if n.isEmpty {
+		return ""
+	}
+	var sb strings.Builder
+	for _, child := range r.nodes[r.position+1:] {
+		if child.nodeType == xmlEndElement && child.depth == n.depth {
+			break
+		}
+		if child.nodeType == xmlText || child.nodeType == xmlCDATA || child.nodeType == xmlSignificantWhitespace {
+			sb.WriteString(child.value)
+		}
+	}
+	return sb.String()
+}
+
+func (i *Interpreter) getXMLReaderProperty(r *XMLReader, prop string) runtime.Value {
+	n := r.node()
+	if n == nil {
+		n = &xmlReaderNode{}
+	}
+	switch prop {
+	case "name":
+		return runtime.NewString(n.name)
+	case "localName":
+		if idx := strings.Index(n.name, ":"); idx >= 0 {
+			return runtime.NewString(n.name[idx+1:])
+		}
+		return runtime.NewString(n.name)
+	case "prefix":
+		if idx := strings.Index(n.name, ":"); idx >= 0 {
+			return runtime.NewString(n.name[:idx])
+		}
+		return runtime.NewString("")
+	case "nodeType":
+		return runtime.NewInt(int64(n.nodeType))
+	case "value":
+		return runtime.NewString(n.value)
+	case "hasValue":
+		return runtime.NewBool(n.value != "")
+	case "depth":
+		return runtime.NewInt(int64(n.depth))
+	case "isEmptyElement":
+		return runtime.NewBool(n.isEmpty)
+	case "attributeCount":
+		return runtime.NewInt(int64(len(n.attrs)))
+	case "hasAttributes":
+		return runtime.NewBool(len(n.attrs) > 0)
+	}
+	return runtime.NULL
+}
+
+// openXMLReaderFile loads the file at filename, warning on failure
+func (i *Interpreter) openXMLReaderFile(r *XMLReader, filename string) bool {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		i.writeOutput("PHP Warning: XMLReader::open(): Unable to open source data\n")
+		return false
+	}
+	if err := r.load(data); err != nil {
+		i.writeOutput(fmt.Sprintf("PHP Warning: XMLReader::open(): %s\n", err))
+		return false
+	}
+	r.filename = filename
+	return true
+}
+
+// loadXMLReaderString loads an XML document held in a string, warning on failure
+func (i *Interpreter) loadXMLReaderString(r *XMLReader, source string) bool {
+	if err := r.load([]byte(source)); err != nil {
+		i.writeOutput(fmt.Sprintf("PHP Warning: XMLReader::XML(): %s\n", err))
+		return false
+	}
+	return true
+}
+
+func (i *Interpreter) callXMLReaderMethod(r *XMLReader, methodName string, args []runtime.Value) runtime.Value {
+	switch strings.ToLower(methodName) {
+	case "open":
+		if len(args) < 1 {
+			return runtime.NewError("XMLReader::open() expects at least 1 argument, 0 given")
+		}
+		return runtime.NewBool(i.openXMLReaderFile(r, args[0].ToString()))
+	case "xml":
+		if len(args) < 1 {
+			return runtime.NewError("XMLReader::XML() expects at least 1 argument, 0 given")
+		}
+		return runtime.NewBool(i.loadXMLReaderString(r, args[0].ToString()))
+	case "read":
+		return runtime.NewBool(r.read())
+	case "next":
+		name := ""
+		if len(args) >= 1 {
+			name = args[0].ToString()
+		}
+		return runtime.NewBool(r.next(name))
+	case "getattribute":
+		if len(args) < 1 {
+			return runtime.NULL
+		}
+		return r.getAttribute(args[0].ToString())
+	case "readstring":
+		return runtime.NewString(r.readString())
+	case "setparserproperty":
+		return runtime.TRUE
+	case "close":
+		r.closed = true
+		return runtime.TRUE
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: XMLReader::%s", methodName))
+}
+
+// xmlReaderArg resolves an XMLReader passed to the procedural functions,
+// either as an object or as a resource ID
+func (i *Interpreter) xmlReaderArg(v runtime.Value) (*XMLReader, bool) {
+	if r, ok := nativeValue(v).(*XMLReader); ok {
+		return r, !r.closed
+	}
+	r, ok := i.xmlReaders[int(v.ToInt())]
+	return r, ok && !r.closed
+}
+
+// registerXMLReaderResource stores a reader under a new resource ID
+func (i *Interpreter) registerXMLReaderResource(r *XMLReader) runtime.Value {
+	readerID := len(i.xmlReaders) + 1
+	i.xmlReaders[readerID] = r
+	return runtime.NewInt(int64(readerID))
+}
+
+// XMLReader functions
+func (i *Interpreter) builtinXMLReaderOpen(args ...runtime.Value) runtime.Value {
+	// xmlreader_open(string $filename) : resource|false
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	reader := newXMLReader()
+	if !i.openXMLReaderFile(reader, args[0].ToString()) {
+		return runtime.FALSE
+	}
+	return i.registerXMLReaderResource(reader)
+}
+
+func (i *Interpreter) builtinXMLReaderXML(args ...runtime.Value) runtime.Value {
+	// xmlreader_xml(string $source) : resource|false
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	reader := newXMLReader()
+	if !i.loadXMLReaderString(reader, args[0].ToString()) {
+		return runtime.FALSE
+	}
+	return i.registerXMLReaderResource(reader)
+}
+
+func (i *Interpreter) builtinXMLReaderSetParserProperty(args ...runtime.Value) runtime.Value {
+	// xmlreader_set_parser_property(resource $xmlreader, int $property, bool $value) : bool
+	if len(args) < 3 {
+		return runtime.FALSE
+	}
+	_, ok := i.xmlReaderArg(args[0])
+	return runtime.NewBool(ok)
+}
+
+func (i *Interpreter) builtinXMLReaderRead(args ...runtime.Value) runtime.Value {
+	// xmlreader_read(resource $xmlreader) : bool
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	reader, ok := i.xmlReaderArg(args[0])
+	if !ok {
+		return runtime.FALSE
+	}
+	return runtime.NewBool(reader.read())
+}
+
+func (i *Interpreter) builtinXMLReaderGetAttribute(args ...runtime.Value) runtime.Value {
+	// xmlreader_get_attribute(resource $xmlreader, string $name) : ?string
+	if len(args) < 2 {
+		return runtime.NULL
+	}
+	reader, ok := i.xmlReaderArg(args[0])
+	if !ok {
+		return runtime.NULL
+	}
+	return reader.getAttribute(args[1].ToString())
+}
+
+func (i *Interpreter) builtinXMLReaderClose(args ...runtime.Value) runtime.Value {
+	// xmlreader_close(resource $xmlreader) : bool
+	if len(args) < 1 {
+		return runtime.FALSE
+	}
+	if reader, ok := nativeValue(args[0]).(*XMLReader); ok {
+		reader.closed = true
+		return runtime.TRUE
+	}
+	readerID := int(args[0].ToInt())
+	reader, ok := i.xmlReaders[readerID]
+	if !ok {
+		return runtime.FALSE
+	}
+	reader.closed = true
+	delete(i.xmlReaders, readerID)
+	return runtime.TRUE
+}
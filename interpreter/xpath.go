@@ -0,0 +1,361 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexisbouchez/phpgo/runtime"
+)
+
+// DOMXPathObject evaluates XPath expressions against a DOMDocument. It
+// supports location paths made of child (/) and descendant (//) steps with
+// name, *, . and .. tests, and predicates on position, attributes and
+// child text.
+type DOMXPathObject struct {
+	doc *DOMDocument
+}
+
+func (x *DOMXPathObject) Type() string     { return "object" }
+func (x *DOMXPathObject) ToBool() bool     { return true }
+func (x *DOMXPathObject) ToInt() int64     { return 1 }
+func (x *DOMXPathObject) ToFloat() float64 { return 1.0 }
+func (x *DOMXPathObject) ToString() string { return "DOMXPath" }
+func (x *DOMXPathObject) Inspect() string {
+	return fmt.Sprintf("object(DOMXPath)#%p", x)
+}
+
+// xpathStep is one step of a location path
+type xpathStep struct {
+	descendant bool // reached through "//"
+	test       string
+	predicates []string
+}
+
+// parseXPath splits a location path into steps. It reports whether the
+// path is absolute.
+func parseXPath(expr string) ([]xpathStep, bool, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, false, fmt.Errorf("empty expression")
+	}
+	absolute := strings.HasPrefix(expr, "/")
+
+	var steps []xpathStep
+	pos := 0
+	for pos < len(expr) {
+		step := xpathStep{}
+		if strings.HasPrefix(expr[pos:], "//") {
+			step.descendant = true
+			pos += 2
+		} else if expr[pos] == '/' {
+			pos++
+		}
+
+		// Read the node test up to the first predicate or separator
+		start := pos
+		for pos < len(expr) && expr[pos] != '/' && expr[pos] != '[' {
+			pos++
+		}
+		step.test = strings.TrimSpace(expr[start:pos])
+		if step.test == "" {
+			return nil, false, fmt.Errorf("missing node test")
+		}
+
+		for pos < len(expr) && expr[pos] == '[' {
+			end, err := xpathPredicateEnd(expr, pos)
+			if err != nil {
+				return nil, false, err
+			}
+			step.predicates = append(step.predicates, strings.TrimSpace(expr[pos+1:end]))
+			pos = end + 1
+		}
+		if pos < len(expr) && expr[pos] != '/' {
+			return nil, false, fmt.Errorf("unexpected %q", expr[pos:])
+		}
+		steps = append(steps, step)
+	}
+	return steps, absolute, nil
+}
+
+// xpathPredicateEnd returns the index of the ']' closing the predicate
+// opened at start, skipping quoted strings and nested brackets
+func xpathPredicateEnd(expr string, start int) (int, error) {
+	depth := 0
+	var quote byte
+	for pos := start; pos < len(expr); pos++ {
+		c := expr[pos]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return pos, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated predicate")
+}
+
+// evalXPath evaluates a location path from context, returning the
+// matching elements in document order
+func evalXPath(expr string, root, context *SimpleXMLElement) ([]*SimpleXMLElement, error) {
+	steps, absolute, err := parseXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The document node is the parent of the root element
+	document := &SimpleXMLElement{}
+	if root != nil {
+		document.Children = []*SimpleXMLElement{root}
+	}
+	nodes := []*SimpleXMLElement{context}
+	if absolute || context == nil {
+		nodes = []*SimpleXMLElement{document}
+	}
+
+	for _, step := range steps {
+		var next []*SimpleXMLElement
+		seen := make(map[*SimpleXMLElement]bool)
+		add := func(elems []*SimpleXMLElement) {
+			for _, elem := range elems {
+				if !seen[elem] {
+					seen[elem] = true
+					next = append(next, elem)
+				}
+			}
+		}
+		for _, node := range nodes {
+			parents := []*SimpleXMLElement{node}
+			if step.descendant {
+				parents = append(parents, domElementsByTagName(node, "*", nil)...)
+			}
+			for _, parent := range parents {
+				matched, err := xpathApplyStep(step, parent, document)
+				if err != nil {
+					return nil, err
+				}
+				add(matched)
+			}
+		}
+		nodes = next
+	}
+
+	// The document node itself is not an element
+	result := nodes[:0:0]
+	for _, node := range nodes {
+		if node != document {
+			result = append(result, node)
+		}
+	}
+
+	// Steps from several context nodes can interleave their matches, so
+	// put them back in document order
+	order := make(map[*SimpleXMLElement]int)
+	for idx, elem := range domElementsByTagName(document, "*", nil) {
+		order[elem] = idx
+	}
+	sort.SliceStable(result, func(a, b int) bool {
+		return order[result[a]] < order[result[b]]
+	})
+	return result, nil
+}
+
+// xpathApplyStep selects the nodes a step's test and predicates match,
+// relative to node
+func xpathApplyStep(step xpathStep, node, document *SimpleXMLElement) ([]*SimpleXMLElement, error) {
+	var candidates []*SimpleXMLElement
+	switch step.test {
+	case ".":
+		candidates = []*SimpleXMLElement{node}
+	case "..":
+		switch {
+		case node.Parent != nil:
+			candidates = []*SimpleXMLElement{node.Parent}
+		case node != document:
+			candidates = []*SimpleXMLElement{document}
+		}
+	default:
+		for _, child := range node.Children {
+			if step.test == "*" || child.Name == step.test {
+				candidates = append(candidates, child)
+			}
+		}
+	}
+
+	for _, pred := range step.predicates {
+		var filtered []*SimpleXMLElement
+		for idx, candidate := range candidates {
+			ok, err := xpathPredicate(pred, candidate, idx+1, len(candidates))
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				filtered = append(filtered, candidate)
+			}
+		}
+		candidates = filtered
+	}
+	return candidates, nil
+}
+
+// xpathPredicate evaluates a predicate for the node at position (1-based)
+// among size candidates
+func xpathPredicate(pred string, node *SimpleXMLElement, position, size int) (bool, error) {
+	if parts := xpathSplit(pred, " or "); len(parts) > 1 {
+		for _, part := range parts {
+			ok, err := xpathPredicate(strings.TrimSpace(part), node, position, size)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	if parts := xpathSplit(pred, " and "); len(parts) > 1 {
+		for _, part := range parts {
+			ok, err := xpathPredicate(strings.TrimSpace(part), node, position, size)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	if n, err := strconv.Atoi(pred); err == nil {
+		return position == n, nil
+	}
+	if pred == "last()" {
+		return position == size, nil
+	}
+	if strings.HasPrefix(pred, "contains(") && strings.HasSuffix(pred, ")") {
+		args := xpathSplit(pred[len("contains("):len(pred)-1], ",")
+		if len(args) != 2 {
+			return false, fmt.Errorf("invalid predicate %q", pred)
+		}
+		haystack, ok := xpathOperand(strings.TrimSpace(args[0]), node)
+		needle, _ := xpathOperand(strings.TrimSpace(args[1]), node)
+		return ok && strings.Contains(haystack, needle), nil
+	}
+
+	for _, op := range []string{"!=", "="} {
+		if idx := xpathIndex(pred, op); idx > 0 {
+			left, ok := xpathOperand(strings.TrimSpace(pred[:idx]), node)
+			right, _ := xpathOperand(strings.TrimSpace(pred[idx+len(op):]), node)
+			if !ok {
+				return false, nil
+			}
+			if op == "=" {
+				return left == right, nil
+			}
+			return left != right, nil
+		}
+	}
+
+	// A bare operand tests for existence
+	_, ok := xpathOperand(pred, node)
+	return ok, nil
+}
+
+// xpathIndex returns the index of the first occurrence of sep in s outside
+// quoted literals and brackets, or -1 if there is none
+func xpathIndex(s, sep string) int {
+	depth := 0
+	var quote byte
+	for pos := 0; pos < len(s); pos++ {
+		c := s[pos]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[pos:], sep):
+			return pos
+		}
+	}
+	return -1
+}
+
+// xpathSplit splits s around each occurrence of sep outside quoted
+// literals and brackets
+func xpathSplit(s, sep string) []string {
+	var parts []string
+	for {
+		idx := xpathIndex(s, sep)
+		if idx < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:idx])
+		s = s[idx+len(sep):]
+	}
+}
+
+// xpathOperand evaluates a predicate operand to a string: a quoted
+// literal, an attribute, text(), or the text of a child element. It
+// reports whether the operand exists.
+func xpathOperand(operand string, node *SimpleXMLElement) (string, bool) {
+	if len(operand) >= 2 && (operand[0] == '"' || operand[0] == '\'') && operand[len(operand)-1] == operand[0] {
+		return operand[1 : len(operand)-1], true
+	}
+	if strings.HasPrefix(operand, "@") {
+		val, ok := node.Attributes[operand[1:]]
+		return val, ok
+	}
+	if operand == "text()" || operand == "." {
+		return domTextContent(node), true
+	}
+	for _, child := range node.Children {
+		if child.Name == operand {
+			return domTextContent(child), true
+		}
+	}
+	return "", false
+}
+
+func (i *Interpreter) callDOMXPathMethod(x *DOMXPathObject, methodName string, args []runtime.Value) runtime.Value {
+	switch strings.ToLower(methodName) {
+	case "query", "evaluate":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		expr := strings.TrimSpace(args[0].ToString())
+		var context *SimpleXMLElement
+		if len(args) >= 2 {
+			context, _ = domElementArg(args[1])
+		}
+
+		// evaluate() also supports counting a node set
+		counting := false
+		if strings.EqualFold(methodName, "evaluate") && strings.HasPrefix(expr, "count(") && strings.HasSuffix(expr, ")") {
+			expr = expr[len("count(") : len(expr)-1]
+			counting = true
+		}
+
+		nodes, err := evalXPath(expr, x.doc.rootElement, context)
+		if err != nil {
+			i.writeOutput(fmt.Sprintf("PHP Warning: DOMXPath::%s(): Invalid expression\n", methodName))
+			return runtime.FALSE
+		}
+		if counting {
+			return runtime.NewFloat(float64(len(nodes)))
+		}
+		return &DOMNodeListObject{nodes: nodes}
+	case "registernamespace":
+		return runtime.TRUE
+	}
+	return runtime.NewError(fmt.Sprintf("undefined method: DOMXPath::%s", methodName))
+}
@@ -0,0 +1,408 @@
+package interpreter
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexisbouchez/phpgo/runtime"
+)
+
+// ZipArchive open flags and error codes
+const (
+	zipCreate    = 1
+	zipExcl      = 2
+	zipCheckcons = 4
+	zipOverwrite = 8
+	zipRdonly    = 16
+
+	zipErOK     = 0
+	zipErExists = 10
+	zipErInval  = 18
+	zipErNoent  = 9
+	zipErNozip  = 19
+	zipErOpen   = 11
+	zipErRead   = 5
+)
+
+// zipEntry is an archive member held in memory while the archive is open
+type zipEntry struct {
+	name     string
+	data     []byte
+	modified time.Time
+}
+
+// ZipArchiveObject represents a ZipArchive instance. The archive is loaded
+// into memory on open() and written back on close() if it was modified.
+type ZipArchiveObject struct {
+	filename string
+	entries  []*zipEntry
+	status   int
+	readOnly bool
+	opened   bool
+	modified bool
+}
+
+func (z *ZipArchiveObject) Type() string     { return "object" }
+func (z *ZipArchiveObject) ToBool() bool     { return true }
+func (z *ZipArchiveObject) ToInt() int64     { return 1 }
+func (z *ZipArchiveObject) ToFloat() float64 { return 1 }
+func (z *ZipArchiveObject) ToString() string { return "ZipArchive" }
+func (z *ZipArchiveObject) Inspect() string {
+	return fmt.Sprintf("object(ZipArchive)#%p", z)
+}
+
+// registerZipArchive registers the ZipArchive class so its constants resolve
+func (i *Interpreter) registerZipArchive() {
+	countable, _ := i.env.GetInterface("Countable")
+	zipArchive := &runtime.Class{
+		Name:        "ZipArchive",
+		Interfaces:  []*runtime.Interface{countable},
+		Properties:  make(map[string]*runtime.PropertyDef),
+		StaticProps: make(map[string]runtime.Value),
+		Methods:     make(map[string]*runtime.Method),
+		Constants:   make(map[string]runtime.Value),
+	}
+	zipArchive.Constants["CREATE"] = runtime.NewInt(zipCreate)
+	zipArchive.Constants["EXCL"] = runtime.NewInt(zipExcl)
+	zipArchive.Constants["CHECKCONS"] = runtime.NewInt(zipCheckcons)
+	zipArchive.Constants["OVERWRITE"] = runtime.NewInt(zipOverwrite)
+	zipArchive.Constants["RDONLY"] = runtime.NewInt(zipRdonly)
+	zipArchive.Constants["ER_OK"] = runtime.NewInt(zipErOK)
+	zipArchive.Constants["ER_EXISTS"] = runtime.NewInt(zipErExists)
+	zipArchive.Constants["ER_INVAL"] = runtime.NewInt(zipErInval)
+	zipArchive.Constants["ER_NOENT"] = runtime.NewInt(zipErNoent)
+	zipArchive.Constants["ER_NOZIP"] = runtime.NewInt(zipErNozip)
+	zipArchive.Constants["ER_OPEN"] = runtime.NewInt(zipErOpen)
+	zipArchive.Constants["ER_READ"] = runtime.NewInt(zipErRead)
+	i.env.DefineClass("ZipArchive", zipArchive)
+}
+
+// open loads the archive at filename according to the ZipArchive flags and
+// returns a ZipArchive::ER_* code
+func (z *ZipArchiveObject) open(filename string, flags int64) int {
+	z.filename = filename
+	z.entries = nil
+	z.modified = false
+	z.readOnly = flags&zipRdonly != 0
+
+	data, err := os.ReadFile(filename)
+	switch {
+	case err != nil && os.IsNotExist(err):
+		if flags&(zipCreate|zipOverwrite) == 0 {
+			return zipErNoent
+		}
+		z.opened = true
+		return zipErOK
+	case err != nil:
+		return zipErOpen
+	case flags&zipExcl != 0:
+		return zipErExists
+	case flags&zipOverwrite != 0:
+		z.opened = true
+		z.modified = true
+		return zipErOK
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return zipErNozip
+	}
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			return zipErRead
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return zipErRead
+		}
+		z.entries = append(z.entries, &zipEntry{name: f.Name, data: content, modified: f.Modified})
+	}
+	z.opened = true
+	return zipErOK
+}
+
+// locate returns the index of the entry called name, or -1
+func (z *ZipArchiveObject) locate(name string) int {
+	for idx, entry := range z.entries {
+		if entry.name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// put adds or replaces the entry called name
+func (z *ZipArchiveObject) put(name string, data []byte, modified time.Time) bool {
+	if !z.opened || z.readOnly || name == "" {
+		return false
+	}
+	if idx := z.locate(name); idx >= 0 {
+		z.entries[idx] = &zipEntry{name: name, data: data, modified: modified}
+	} else {
+		z.entries = append(z.entries, &zipEntry{name: name, data: data, modified: modified})
+	}
+	z.modified = true
+	return true
+}
+
+// close writes the archive back to disk if it was modified
+func (z *ZipArchiveObject) close() bool {
+	if !z.opened {
+		return false
+	}
+	z.opened = false
+	if !z.modified {
+		return true
+	}
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range z.entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: entry.modified}
+		if strings.HasSuffix(entry.name, "/") {
+			header.Method = zip.Store
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			return false
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			return false
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return false
+	}
+	return os.WriteFile(z.filename, buf.Bytes(), 0644) == nil
+}
+
+// extractTo writes the selected entries (all of them when names is nil)
+// below dest
+func (z *ZipArchiveObject) extractTo(dest string, names []string) bool {
+	if !z.opened {
+		return false
+	}
+	selected := z.entries
+	if names != nil {
+		selected = nil
+		for _, name := range names {
+			idx := z.locate(name)
+			if idx < 0 {
+				return false
+			}
+			selected = append(selected, z.entries[idx])
+		}
+	}
+
+	root := filepath.Clean(dest)
+	for _, entry := range selected {
+		target := filepath.Join(root, filepath.FromSlash(entry.name))
+		// Refuse entries that would escape the destination directory
+		if target != root && !strings.HasPrefix(target, root+string(filepath.Separator)) {
+			return false
+		}
+		if strings.HasSuffix(entry.name, "/") {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return false
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return false
+		}
+		if err := os.WriteFile(target, entry.data, 0644); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// callZipArchiveMethod handles method calls on ZipArchive objects
+func (i *Interpreter) callZipArchiveMethod(z *ZipArchiveObject, methodName string, args []runtime.Value) runtime.Value {
+	switch strings.ToLower(methodName) {
+	case "open":
+		if len(args) < 1 {
+			return runtime.NewInt(zipErInval)
+		}
+		var flags int64
+		if len(args) >= 2 {
+			flags = args[1].ToInt()
+		}
+		z.status = z.open(args[0].ToString(), flags)
+		if z.status != zipErOK {
+			return runtime.NewInt(int64(z.status))
+		}
+		return runtime.TRUE
+
+	case "close":
+		return runtime.NewBool(z.close())
+
+	case "count":
+		return runtime.NewInt(int64(len(z.entries)))
+
+	case "addfromstring":
+		if len(args) < 2 {
+			return runtime.FALSE
+		}
+		return runtime.NewBool(z.put(args[0].ToString(), []byte(args[1].ToString()), time.Now()))
+
+	case "addfile":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		path := args[0].ToString()
+		name := filepath.Base(path)
+		if len(args) >= 2 && args[1].ToString() != "" {
+			name = args[1].ToString()
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			return runtime.FALSE
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return runtime.FALSE
+		}
+		return runtime.NewBool(z.put(name, data, info.ModTime()))
+
+	case "addemptydir":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		name := strings.TrimSuffix(args[0].ToString(), "/") + "/"
+		return runtime.NewBool(z.put(name, nil, time.Now()))
+
+	case "getfromname":
+		if len(args) < 1 || !z.opened {
+			return runtime.FALSE
+		}
+		idx := z.locate(args[0].ToString())
+		if idx < 0 {
+			return runtime.FALSE
+		}
+		return zipEntryContents(z.entries[idx], args[1:])
+
+	case "getfromindex":
+		if len(args) < 1 || !z.opened {
+			return runtime.FALSE
+		}
+		idx := int(args[0].ToInt())
+		if idx < 0 || idx >= len(z.entries) {
+			return runtime.FALSE
+		}
+		return zipEntryContents(z.entries[idx], args[1:])
+
+	case "getnameindex":
+		if len(args) < 1 || !z.opened {
+			return runtime.FALSE
+		}
+		idx := int(args[0].ToInt())
+		if idx < 0 || idx >= len(z.entries) {
+			return runtime.FALSE
+		}
+		return runtime.NewString(z.entries[idx].name)
+
+	case "locatename":
+		if len(args) < 1 || !z.opened {
+			return runtime.FALSE
+		}
+		idx := z.locate(args[0].ToString())
+		if idx < 0 {
+			return runtime.FALSE
+		}
+		return runtime.NewInt(int64(idx))
+
+	case "statname", "statindex":
+		if len(args) < 1 || !z.opened {
+			return runtime.FALSE
+		}
+		var idx int
+		if strings.ToLower(methodName) == "statname" {
+			idx = z.locate(args[0].ToString())
+		} else {
+			idx = int(args[0].ToInt())
+		}
+		if idx < 0 || idx >= len(z.entries) {
+			return runtime.FALSE
+		}
+		entry := z.entries[idx]
+		stat := runtime.NewArray()
+		stat.Set(runtime.NewString("name"), runtime.NewString(entry.name))
+		stat.Set(runtime.NewString("index"), runtime.NewInt(int64(idx)))
+		stat.Set(runtime.NewString("size"), runtime.NewInt(int64(len(entry.data))))
+		stat.Set(runtime.NewString("mtime"), runtime.NewInt(entry.modified.Unix()))
+		return stat
+
+	case "deletename", "deleteindex":
+		if len(args) < 1 || !z.opened || z.readOnly {
+			return runtime.FALSE
+		}
+		var idx int
+		if strings.ToLower(methodName) == "deletename" {
+			idx = z.locate(args[0].ToString())
+		} else {
+			idx = int(args[0].ToInt())
+		}
+		if idx < 0 || idx >= len(z.entries) {
+			return runtime.FALSE
+		}
+		z.entries = append(z.entries[:idx], z.entries[idx+1:]...)
+		z.modified = true
+		return runtime.TRUE
+
+	case "extractto":
+		if len(args) < 1 {
+			return runtime.FALSE
+		}
+		var names []string
+		if len(args) >= 2 {
+			switch v := args[1].(type) {
+			case *runtime.Array:
+				names = []string{}
+				for _, k := range v.Keys {
+					names = append(names, v.Elements[k].ToString())
+				}
+			case *runtime.Null:
+			default:
+				names = []string{v.ToString()}
+			}
+		}
+		return runtime.NewBool(z.extractTo(args[0].ToString(), names))
+	}
+
+	return runtime.NewError(fmt.Sprintf("undefined method: ZipArchive::%s", methodName))
+}
+
+// zipEntryContents returns an entry's data, truncated to the optional length
+func zipEntryContents(entry *zipEntry, args []runtime.Value) runtime.Value {
+	data := entry.data
+	if len(args) >= 1 {
+		if length := int(args[0].ToInt()); length > 0 && length < len(data) {
+			data = data[:length]
+		}
+	}
+	return runtime.NewString(string(data))
+}
+
+// getZipArchiveProperty handles property reads on ZipArchive objects
+func (i *Interpreter) getZipArchiveProperty(z *ZipArchiveObject, prop string) runtime.Value {
+	switch prop {
+	case "numFiles":
+		return runtime.NewInt(int64(len(z.entries)))
+	case "filename":
+		return runtime.NewString(z.filename)
+	case "status":
+		return runtime.NewInt(int64(z.status))
+	case "comment":
+		return runtime.NewString("")
+	}
+	return runtime.NULL
+}
@@ -66,6 +66,7 @@ func handlePHPRequest(w http.ResponseWriter, r *http.Request) {
 
 	// Create a new PHPGo interpreter
 	i := interpreter.New()
+	defer i.Close()
 
 	// Set up HTTP context - SetHTTPContext will populate superglobals automatically
 
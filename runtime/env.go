@@ -51,6 +51,12 @@ func (e *Environment) Get(name string) (Value, bool) {
 	return val, ok
 }
 
+// GetLocal retrieves a variable from the current scope only.
+func (e *Environment) GetLocal(name string) (Value, bool) {
+	val, ok := e.store[name]
+	return val, ok
+}
+
 // Set sets a variable in the current scope.
 func (e *Environment) Set(name string, val Value) Value {
 	e.store[name] = val
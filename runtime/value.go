@@ -2,6 +2,8 @@ package runtime
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -134,13 +136,61 @@ func (s *String) Type() string { return "string" }
 func (s *String) ToBool() bool { return s.Value != "" && s.Value != "0" }
 func (s *String) ToInt() int64 {
 	// PHP string to int conversion: parse leading numeric part
-	v, _ := strconv.ParseInt(strings.TrimSpace(s.Value), 10, 64)
-	return v
+	prefix := NumericPrefix(s.Value)
+	if v, err := strconv.ParseInt(prefix, 10, 64); err == nil {
+		return v
+	}
+	// Fractions, exponents and out-of-range integers go through float
+	f, _ := strconv.ParseFloat(prefix, 64)
+	switch {
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	case f <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(f)
 }
 func (s *String) ToFloat() float64 {
-	v, _ := strconv.ParseFloat(strings.TrimSpace(s.Value), 64)
+	v, _ := strconv.ParseFloat(NumericPrefix(s.Value), 64)
 	return v
 }
+
+// NumericPrefix returns the leading number of s as PHP reads it: leading
+// whitespace is skipped, then an optional sign, digits with an optional
+// fraction, and an optional exponent. It is empty when s doesn't start
+// with a number.
+func NumericPrefix(s string) string {
+	s = strings.TrimLeft(s, " \t\n\r\v\f")
+	isDigit := func(idx int) bool { return idx < len(s) && s[idx] >= '0' && s[idx] <= '9' }
+
+	end := 0
+	if end < len(s) && (s[end] == '+' || s[end] == '-') {
+		end++
+	}
+	digits := 0
+	for ; isDigit(end); end++ {
+		digits++
+	}
+	if end < len(s) && s[end] == '.' && (digits > 0 || isDigit(end+1)) {
+		for end++; isDigit(end); end++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return ""
+	}
+	if end < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		exp := end + 1
+		if exp < len(s) && (s[exp] == '+' || s[exp] == '-') {
+			exp++
+		}
+		if isDigit(exp) {
+			for end = exp; isDigit(end); end++ {
+			}
+		}
+	}
+	return s[:end]
+}
 func (s *String) ToString() string { return s.Value }
 func (s *String) Inspect() string  { return fmt.Sprintf("string(%d) %q", len(s.Value), s.Value) }
 
@@ -152,6 +202,29 @@ type Array struct {
 	Keys      []Value // Maintain insertion order
 	NextIndex int64   // For auto-indexing
 	Pointer   int     // Internal pointer for iteration
+	bytes     int64   // Approximate size of the elements, kept by Swap and Unset
+}
+
+// Approximate sizes, in bytes, used to account the memory values hold
+const (
+	SlotBytes   = 16 // A value slot
+	BucketBytes = 32 // An array element or property entry besides its value
+)
+
+// SizeOf approximates the memory held by v without walking it. An array's
+// size is kept up to date as elements are set and unset, so an array
+// nested in another counts at the size it had when it was stored there.
+func SizeOf(v Value) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case *String:
+		return SlotBytes + int64(len(v.Value))
+	case *Array:
+		// Arrays filled without Set still count their buckets
+		return SlotBytes + max(v.bytes, int64(len(v.Elements))*BucketBytes)
+	}
+	return SlotBytes
 }
 
 func NewArray() *Array {
@@ -185,6 +258,7 @@ func (a *Array) Inspect() string {
 }
 
 func (a *Array) Get(key Value) Value {
+	key = NormalizeKey(key)
 	// Direct lookup first
 	if v, ok := a.Elements[key]; ok {
 		return v
@@ -198,6 +272,41 @@ func (a *Array) Get(key Value) Value {
 	return NULL
 }
 
+// NormalizeKey converts a value used as an array key the way PHP does:
+// decimal integer strings, bools and floats become int keys and NULL
+// becomes the empty string. Other strings such as "05" stay strings.
+func NormalizeKey(key Value) Value {
+	switch k := key.(type) {
+	case *String:
+		if n, ok := integerKey(k.Value); ok {
+			return NewInt(n)
+		}
+	case *Bool:
+		return NewInt(k.ToInt())
+	case *Float:
+		return NewInt(k.ToInt())
+	case *Null:
+		return NewString("")
+	}
+	return key
+}
+
+// integerKey reports whether s is the canonical decimal form of an int64,
+// with no leading zeros, plus sign or whitespace
+func integerKey(s string) (int64, bool) {
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || len(digits) > 19 || (digits[0] == '0' && len(s) > 1) {
+		return 0, false
+	}
+	for idx := 0; idx < len(digits); idx++ {
+		if digits[idx] < '0' || digits[idx] > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
 // keysEqual compares array keys by value
 func keysEqual(a, b Value) bool {
 	switch av := a.(type) {
@@ -214,17 +323,38 @@ func keysEqual(a, b Value) bool {
 }
 
 func (a *Array) Set(key Value, val Value) {
+	a.Swap(key, val)
+}
+
+// Swap sets key to val like Set and returns the value it replaced, or nil
+// when the key is new.
+func (a *Array) Swap(key Value, val Value) Value {
 	if key == nil {
 		// Auto-index
 		key = NewInt(a.NextIndex)
-		a.NextIndex++
+	} else {
+		key = NormalizeKey(key)
+	}
+
+	// Integer keys at or past NextIndex cannot exist yet, so sequential
+	// inserts skip the linear key search
+	if intKey, ok := key.(*Int); ok && intKey.Value >= a.NextIndex {
+		a.bytes += BucketBytes + SizeOf(val)
+		a.Keys = append(a.Keys, key)
+		a.Elements[key] = val
+		a.NextIndex = intKey.Value + 1
+		return nil
 	}
 
 	// Check if key already exists (by value)
+	var old Value
 	existingKey := a.findKey(key)
 	if existingKey != nil {
+		old = a.Elements[existingKey]
+		a.bytes += SizeOf(val) - SizeOf(old)
 		a.Elements[existingKey] = val
 	} else {
+		a.bytes += BucketBytes + SizeOf(val)
 		a.Keys = append(a.Keys, key)
 		a.Elements[key] = val
 	}
@@ -235,10 +365,12 @@ func (a *Array) Set(key Value, val Value) {
 			a.NextIndex = intKey.Value + 1
 		}
 	}
+	return old
 }
 
 // findKey finds an existing key that matches by value
 func (a *Array) findKey(key Value) Value {
+	key = NormalizeKey(key)
 	for _, k := range a.Keys {
 		if keysEqual(key, k) {
 			return k
@@ -247,6 +379,11 @@ func (a *Array) findKey(key Value) Value {
 	return nil
 }
 
+// Has reports whether key is present, matching keys by value.
+func (a *Array) Has(key Value) bool {
+	return a.findKey(key) != nil
+}
+
 func (a *Array) Len() int {
 	return len(a.Elements)
 }
@@ -257,6 +394,7 @@ func (a *Array) Unset(key Value) {
 	if existingKey == nil {
 		return
 	}
+	a.bytes -= BucketBytes + SizeOf(a.Elements[existingKey])
 	delete(a.Elements, existingKey)
 	// Remove from keys slice
 	for idx, k := range a.Keys {
@@ -285,6 +423,8 @@ type Object struct {
 	Class      *Class
 	Properties map[string]Value
 	toStringFn func(*Object) string // Callback for __toString, set by interpreter
+	order      []string             // Property names in the order SetProperty added them
+	Internal   interface{}          // Native state of a built-in class the object's class extends
 }
 
 func NewObject(class *Class) *Object {
@@ -328,9 +468,38 @@ func (o *Object) GetProperty(name string) Value {
 }
 
 func (o *Object) SetProperty(name string, val Value) {
+	if _, exists := o.Properties[name]; !exists {
+		o.order = append(o.order, name)
+	}
 	o.Properties[name] = val
 }
 
+// PropertyNames returns the names of the set properties in the order they
+// were added. Properties written to the map directly follow, sorted.
+func (o *Object) PropertyNames() []string {
+	return orderedNames(o.order, o.Properties)
+}
+
+// orderedNames lists the keys of m present in order, then any others sorted
+func orderedNames[V any](order []string, m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	listed := make(map[string]bool, len(order))
+	for _, name := range order {
+		if _, ok := m[name]; ok && !listed[name] {
+			listed[name] = true
+			names = append(names, name)
+		}
+	}
+	var rest []string
+	for name := range m {
+		if !listed[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
 // ----------------------------------------------------------------------------
 // Class (for object creation)
 
@@ -345,6 +514,7 @@ type Class struct {
 	Parent      *Class
 	Interfaces  []*Interface
 	Properties  map[string]*PropertyDef
+	PropOrder   []string         // Property names in declaration order, inherited ones first
 	StaticProps map[string]Value // Runtime values for static properties
 	Methods     map[string]*Method
 	Constants   map[string]Value
@@ -353,6 +523,20 @@ type Class struct {
 	Attributes  []*AttributeInstance
 }
 
+// AddProperty declares a property, keeping its place in declaration order
+// when it replaces an existing one
+func (c *Class) AddProperty(name string, def *PropertyDef) {
+	if _, exists := c.Properties[name]; !exists {
+		c.PropOrder = append(c.PropOrder, name)
+	}
+	c.Properties[name] = def
+}
+
+// PropertyNames returns the declared property names in declaration order
+func (c *Class) PropertyNames() []string {
+	return orderedNames(c.PropOrder, c.Properties)
+}
+
 type PropertyDef struct {
 	Name       string
 	Default    Value
@@ -394,17 +578,46 @@ type Method struct {
 }
 
 type Interface struct {
-	Name    string
-	Methods map[string]*Method
+	Name      string
+	Methods   map[string]*Method
+	Constants map[string]Value
+	Extends   []*Interface
+}
+
+// IsA reports whether the interface is name or extends it
+func (iface *Interface) IsA(name string) bool {
+	if iface.Name == name {
+		return true
+	}
+	for _, parent := range iface.Extends {
+		if parent.IsA(name) {
+			return true
+		}
+	}
+	return false
 }
 
 // Trait represents a PHP trait
 type Trait struct {
 	Name       string
 	Properties map[string]*PropertyDef
+	PropOrder  []string // Property names in declaration order
 	Methods    map[string]*Method
 }
 
+// AddProperty declares a property on the trait
+func (t *Trait) AddProperty(name string, def *PropertyDef) {
+	if _, exists := t.Properties[name]; !exists {
+		t.PropOrder = append(t.PropOrder, name)
+	}
+	t.Properties[name] = def
+}
+
+// PropertyNames returns the declared property names in declaration order
+func (t *Trait) PropertyNames() []string {
+	return orderedNames(t.PropOrder, t.Properties)
+}
+
 // ----------------------------------------------------------------------------
 // Function
 
@@ -709,8 +922,10 @@ func IsEqual(a, b Value) bool {
 		if len(av.Elements) != len(bArr.Elements) {
 			return false
 		}
+		// Keys are matched by value; order doesn't matter for ==
 		for k, v := range av.Elements {
-			if bv, exists := bArr.Elements[k]; !exists || !IsEqual(v, bv) {
+			bk := bArr.findKey(k)
+			if bk == nil || !IsEqual(v, bArr.Elements[bk]) {
 				return false
 			}
 		}